@@ -44,6 +44,16 @@ var (
 					fmt.Println("Error while printing the user information")
 					return
 				}
+				// Keep the config in line with two-factor authentication set from elsewhere
+				twoFactorEnabled, _ := data["two_factor_enabled"].(bool)
+				if twoFactorEnabled != configData.TwoFactorEnabled {
+					configData.TwoFactorEnabled = twoFactorEnabled
+					err = config.UpdateConfig(configData)
+					if err != nil {
+						fmt.Println("Error while updating the config file")
+						return
+					}
+				}
 			} else {
 				fmt.Println("Error while getting the user information")
 				return
@@ -51,6 +61,25 @@ var (
 		},
 	}
 
+	configVerifyEmailCmd = &cobra.Command{
+		Use:   "verify-email",
+		Short: "Verify the email of the user",
+		Long:  `Verify the email of the user with the code sent to it. Orders can be created and taken once the email is verified`,
+		Run: func(cmd *cobra.Command, args []string) {
+			configData, err := config.ReadConfig()
+			if err != nil {
+				fmt.Println("Error while reading a config file")
+				return
+			}
+			err = config.VerifyEmail(configData.AccessToken)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			fmt.Println("Your email has been verified.")
+		},
+	}
+
 	ConfigSetCmd = &cobra.Command{
 		Use:   "set",
 		Short: "Set the application configuration",
@@ -73,6 +102,7 @@ var (
 func init() {
 	ConfigCmd.AddCommand(configGetCmd)
 	ConfigCmd.AddCommand(ConfigSetCmd)
+	ConfigCmd.AddCommand(configVerifyEmailCmd)
 }
 
 func parseAndPrintUserInfo(data map[string]interface{}) error {
@@ -110,6 +140,10 @@ func parseAndPrintUserInfo(data map[string]interface{}) error {
 	fmt.Printf("Session Lifetime: %s\n", sessionLifeTime.String())
 	fmt.Println("----[Info]-----")
 	fmt.Println("Registration Datetime:", registrationDateTimeInLocalTimeStr)
+	emailVerified, _ := data["email_verified"].(bool)
+	fmt.Println("Email Verified:", emailVerified)
+	twoFactorEnabled, _ := data["two_factor_enabled"].(bool)
+	fmt.Println("Two-Factor Authentication:", twoFactorEnabled)
 	fmt.Println("This session is still valid for:", sessionLifeTime)
 
 	return nil
@@ -18,6 +18,8 @@ import (
 
 const (
 	minimumExpirationTime = 60
+	twoFactorEnable       = "enable"
+	twoFactorDisable      = "disable"
 )
 
 var (
@@ -48,9 +50,20 @@ var (
 				fmt.Printf("Invalid new expiration time input. Please enter a number greater than %d seconds.\n", minimumExpirationTime)
 				return
 			}
+			configData, err := config.ReadConfig()
+			if err != nil {
+				fmt.Println("Error while reading a config file")
+				return
+			}
+			otpCode, err := config.InputTwoFactorCode(configData)
+			if err != nil {
+				fmt.Println("Error while getting the two-factor authentication code")
+				return
+			}
 			data := config.UpdateTokenExpirationRequest{
 				ConfigRequest: &config.ConfigRequest{
 					Password: userPassword,
+					OTPCode:  otpCode,
 				},
 				AccessTokenExpirationTimeInSeconds:  newExpirationTimeInSeconds,
 				RefreshTokenExpirationTimeInSeconds: newExpirationTimeInSeconds * 2, // for refresh token, twice of access token expiration time. can be updated later
@@ -65,11 +78,6 @@ var (
 				fmt.Println("Error while updating the new access token expiration time")
 				return
 			}
-			configData, err := config.ReadConfig()
-			if err != nil {
-				fmt.Println("Error while reading a config file")
-				return
-			}
 			// Add the Bearer token to the Authorization header
 			req.Header.Set("Authorization", "Bearer "+configData.AccessToken)
 			req.Header.Set("Content-Type", "application/json")
@@ -133,9 +141,20 @@ var (
 				fmt.Println("Error while getting the new user password")
 				return
 			}
+			configData, err := config.ReadConfig()
+			if err != nil {
+				fmt.Println("Error while reading a config file")
+				return
+			}
+			otpCode, err := config.InputTwoFactorCode(configData)
+			if err != nil {
+				fmt.Println("Error while getting the two-factor authentication code")
+				return
+			}
 			data := config.PasswordUpdateRequest{
 				ConfigRequest: &config.ConfigRequest{
 					Password: currentUserPassword,
+					OTPCode:  otpCode,
 				},
 				NewPassword: newUserPassword,
 			}
@@ -150,11 +169,6 @@ var (
 				return
 			}
 			// Add the Bearer token to the Authorization header
-			configData, err := config.ReadConfig()
-			if err != nil {
-				fmt.Println("Error while reading a config file")
-				return
-			}
 			req.Header.Set("Authorization", "Bearer "+configData.AccessToken)
 			req.Header.Set("Content-Type", "application/json")
 			response, err := utils.GetHttpResponse(req)
@@ -169,9 +183,49 @@ var (
 			}
 		},
 	}
+
+	configTwoFactorSetCmd = &cobra.Command{
+		Use:       "2fa <enable|disable>",
+		Short:     "Enable or disable two-factor authentication",
+		Long:      `Enable or disable two-factor authentication with an authenticator app. Once enabled, a code of the app is asked for the orders, the password and the tokens`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{twoFactorEnable, twoFactorDisable},
+		Run: func(cmd *cobra.Command, args []string) {
+			if args[0] != twoFactorEnable && args[0] != twoFactorDisable {
+				cmd.Help()
+				return
+			}
+			configData, err := config.ReadConfig()
+			if err != nil {
+				fmt.Println("Error while reading a config file")
+				return
+			}
+			userPassword, err := utils.InputPassword("Enter password: ")
+			if err != nil {
+				fmt.Println("Error while getting the user password")
+				return
+			}
+			if args[0] == twoFactorEnable {
+				err = config.EnableTwoFactor(configData, userPassword)
+				if err != nil {
+					fmt.Println(err.Error())
+					return
+				}
+				fmt.Println("Two-factor authentication has been enabled")
+			} else {
+				err = config.DisableTwoFactor(configData, userPassword)
+				if err != nil {
+					fmt.Println(err.Error())
+					return
+				}
+				fmt.Println("Two-factor authentication has been disabled")
+			}
+		},
+	}
 )
 
 func init() {
 	ConfigSetCmd.AddCommand(configSetAccesstokenExpirationSetCmd)
 	ConfigSetCmd.AddCommand(configPasswordSetCmd)
+	ConfigSetCmd.AddCommand(configTwoFactorSetCmd)
 }
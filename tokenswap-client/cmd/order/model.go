@@ -1,41 +1,94 @@
 package order
 
+import (
+	"github.com/rocky2015aaa/tokenswap-client/utils"
+)
+
 type OrderRequest struct {
 	*Order
 	OrdererWalletAddress string `json:"orderer_wallet_address"`
+	PayoutWalletAddress  string `json:"payout_wallet_address"`
 	Password             string `json:"password"`
+	OTPCode              string `json:"otp_code,omitempty"`
 }
 
 type Order struct {
-	Type         string  `json:"type"`
-	Pair         string  `json:"pair"`
-	Amount       float64 `json:"amount"`
-	Price        float64 `json:"price"`
-	FeePayerType string  `json:"fee_payer_type"`
-	Chain        string  `json:"chain"`
-	Network      string  `json:"network"`
-	Visibility   string  `json:"visibility"`
-	Referral     string  `json:"referral"`
-	Status       string  `json:"status"`
+	Type         string       `json:"type"`
+	Pair         string       `json:"pair"`
+	Amount       utils.Amount `json:"amount"`
+	Price        utils.Amount `json:"price"`
+	FeePayerType string       `json:"fee_payer_type"`
+	Chain        string       `json:"chain"`
+	Network      string       `json:"network"`
+	Visibility   string       `json:"visibility"`
+	Referral     string       `json:"referral"`
+	Status       string       `json:"status"`
 }
 
 type OrderDetail struct {
-	ID string
+	ID       string
+	ParentID string
 	*Order
-	CreateDateTime string
+	FilledAmount    utils.Amount
+	RemainingAmount utils.Amount
+	CreateDateTime  string
+	Refunds         []*Refund
+}
+
+type Refund struct {
+	Role   string
+	Token  string
+	Amount utils.Amount
+	Status string
+	TxHash string
+}
+
+type DepositTarget struct {
+	Token         string
+	Chain         string
+	WalletAddress string
+	Amount        utils.Amount
 }
 
 type OrderCommonInfo struct {
-	Fee             float64
-	Pairs           []string
-	Chains          []string
-	WalletAddresses map[string]string
-	DepositTimeout  int
-	FeePayerTypes   map[string]interface{}
-	Types           map[string]interface{}
-	Networks        map[string]interface{}
-	Visibilities    map[string]interface{}
-	Statuses        map[string]interface{}
+	Fee            utils.Amount
+	Pairs          []string
+	Chains         []string
+	Tokens         []*Token
+	DepositTimeout int
+	FeePayerTypes  map[string]interface{}
+	Types          map[string]interface{}
+	Networks       map[string]interface{}
+	Visibilities   map[string]interface{}
+	Statuses       map[string]interface{}
+}
+
+// Token is a token of the server registry, served with the order common info.
+type Token struct {
+	Symbol         string
+	Chain          string
+	Network        string
+	Decimals       int
+	AddressPattern string
+	WalletAddress  string
+}
+
+// FeeQuote is what each side of an order deposits and receives, as quoted by
+// the server before the order is created or taken.
+type FeeQuote struct {
+	FeeRate      utils.Amount
+	FeePayerType string
+	Maker        *FeeLeg
+	Taker        *FeeLeg
+}
+
+type FeeLeg struct {
+	DepositToken  string
+	TradeAmount   utils.Amount
+	Fee           utils.Amount
+	DepositAmount utils.Amount
+	PayoutToken   string
+	PayoutAmount  utils.Amount
 }
 
 type OrderTakeRequest struct {
@@ -1,9 +1,13 @@
 package order
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/rocky2015aaa/tokenswap-client/config"
@@ -22,6 +26,13 @@ const (
 	flagPair             = "pair"
 	flagMyOrder          = "myorder"
 	flagReferral         = "referral"
+	flagAmount           = "amount"
+	flagLimit            = "limit"
+	flagNext             = "next"
+	flagSort             = "sort"
+	flagOrder            = "order"
+	flagFrom             = "from"
+	flagTo               = "to"
 	flagTypeValuePrivate = "private"
 	flagTypeValueMainnet = "mainnet"
 
@@ -33,16 +44,20 @@ const (
 	fieldVisibilities  = "visibilities"
 	fieldStatuses      = "statuses"
 	fieldFee           = "fee"
+	fieldTokens        = "tokens"
 )
 
+const maxOrderListLimit = 200
+
 var (
+	orderListSorts = map[string]struct{}{"creation_time": {}, "price": {}, "amount": {}}
+
 	orderCommonInfo *OrderCommonInfo
 	orderListCmd    = &cobra.Command{
 		Use:   "list",
 		Short: "List a trading order lists in the condition",
 		Long:  `List a trading order lists in the condition`,
 		Run: func(cmd *cobra.Command, args []string) {
-			// TODO: pagination
 			if len(orderID) > 0 {
 				// Ensure no other flags or arguments are used
 				if len(args) > 0 || cmd.Flags().NFlag() > 1 {
@@ -136,6 +151,12 @@ var (
 			} else {
 				query += "&visibility=public"
 			}
+			pageQuery, err := orderListPageQuery(cmd)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			query += pageQuery
 			query = strings.Replace(query, "&", "?", 1)
 			req, err := http.NewRequest("GET", config.tokenswapServerUrl+"/order/list"+query, nil)
 			if err != nil {
@@ -150,27 +171,18 @@ var (
 				return
 			}
 			if response.Success && response.Error == "" {
-				dataList, ok := response.Data.([]interface{})
-				if !ok {
-					fmt.Println("Error while getting the order list")
+				orders, next, err := parseOrderListPage(response.Data)
+				if err != nil {
+					fmt.Println("Error while printing the order list")
 					return
 				}
-				orders := []*OrderDetail{}
-				for _, data := range dataList {
-					order, err := parseOrderInfo(data.(map[string]interface{}))
-					if err != nil {
-						fmt.Println("Error while printing the order list")
-						return
-					}
-					orders = append(orders, order)
-				}
 				fmt.Println("----[Order Information]-----")
 				printOrderCommonInfo(orderCommonInfo)
 				fmt.Println("--------[Order List]--------")
 				if len(orders) == 0 {
 					fmt.Println("There is no order.")
 				} else {
-					printOrders(orders)
+					printOrders(orders, next)
 				}
 			} else if response.Error == utils.NoDocumentMessage {
 				fmt.Println("There is no order list")
@@ -192,7 +204,8 @@ var (
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			orderCommonInfo, err = getOrderCommonInfo()
+			network, _ := cmd.Flags().GetString(flagNetwork)
+			orderCommonInfo, err = getOrderCommonInfo(network)
 			if err != nil {
 				fmt.Println("Error while getting the order common information")
 				os.Exit(1)
@@ -204,6 +217,74 @@ var (
 	}
 )
 
+// orderListPageQuery returns the query parameters of the page of the order
+// list to get. A page is continued from the next cursor printed with the
+// previous page, with the same sort and order.
+func orderListPageQuery(cmd *cobra.Command) (string, error) {
+	query := ""
+	limit, _ := cmd.Flags().GetInt(flagLimit)
+	if limit < 0 || limit > maxOrderListLimit {
+		return "", fmt.Errorf("The limit must be between 1 and %d", maxOrderListLimit)
+	}
+	if limit > 0 {
+		query += fmt.Sprintf("&%s=%d", flagLimit, limit)
+	}
+	next, _ := cmd.Flags().GetString(flagNext)
+	if len(next) > 0 {
+		query += "&after=" + url.QueryEscape(next)
+	}
+	sort, _ := cmd.Flags().GetString(flagSort)
+	if len(sort) > 0 {
+		if _, ok := orderListSorts[sort]; !ok {
+			return "", fmt.Errorf("Not a valid sort, use one of creation_time, price or amount")
+		}
+		query += fmt.Sprintf("&%s=%s", flagSort, sort)
+	}
+	order, _ := cmd.Flags().GetString(flagOrder)
+	if len(order) > 0 {
+		if order != "asc" && order != "desc" {
+			return "", fmt.Errorf("Not a valid order, use asc or desc")
+		}
+		query += fmt.Sprintf("&%s=%s", flagOrder, order)
+	}
+	from, _ := cmd.Flags().GetString(flagFrom)
+	if len(from) > 0 {
+		query += fmt.Sprintf("&%s=%s", flagFrom, url.QueryEscape(from))
+	}
+	to, _ := cmd.Flags().GetString(flagTo)
+	if len(to) > 0 {
+		query += fmt.Sprintf("&%s=%s", flagTo, url.QueryEscape(to))
+	}
+	return query, nil
+}
+
+// parseOrderListPage reads the orders of a page of the order list and the
+// cursor of the next page, empty on the last page.
+func parseOrderListPage(data interface{}) ([]*OrderDetail, string, error) {
+	page, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("invalid order list page in the response")
+	}
+	dataList, ok := page["orders"].([]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("missing or invalid 'orders' in the order list response")
+	}
+	orders := []*OrderDetail{}
+	for _, orderData := range dataList {
+		orderDataMap, ok := orderData.(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("invalid order in the order list response")
+		}
+		order, err := parseOrderInfo(orderDataMap)
+		if err != nil {
+			return nil, "", err
+		}
+		orders = append(orders, order)
+	}
+	next, _ := page["next"].(string)
+	return orders, next, nil
+}
+
 func parseOrderInfo(data map[string]interface{}) (*OrderDetail, error) {
 	order := Order{}
 	orderdetail := OrderDetail{}
@@ -222,13 +303,13 @@ func parseOrderInfo(data map[string]interface{}) (*OrderDetail, error) {
 		return nil, fmt.Errorf("missing or invalid 'pair' in the order data response")
 	}
 	order.Pair = orderPair
-	orderAmount, ok := data["amount"].(float64)
-	if !ok {
+	orderAmount, err := utils.AmountFromJSON(data["amount"])
+	if err != nil {
 		return nil, fmt.Errorf("missing or invalid 'amount' in the order data response")
 	}
 	order.Amount = orderAmount
-	orderPrice, ok := data["price"].(float64)
-	if !ok {
+	orderPrice, err := utils.AmountFromJSON(data["price"])
+	if err != nil {
 		return nil, fmt.Errorf("missing or invalid 'price' in the order data response")
 	}
 	order.Price = orderPrice
@@ -262,20 +343,151 @@ func parseOrderInfo(data map[string]interface{}) (*OrderDetail, error) {
 		return nil, fmt.Errorf("missing or invalid 'create_date_time' in the order data response")
 	}
 	orderdetail.CreateDateTime = createDateTime
+	// Orders stored before partial fills have no filled and remaining amounts
+	orderdetail.ParentID, _ = data["parent_id"].(string)
+	orderdetail.FilledAmount, _ = utils.AmountFromJSON(data["filled_amount"])
+	orderdetail.RemainingAmount, _ = utils.AmountFromJSON(data["remaining_amount"])
+	// Refunds are only listed for the orders of the user
+	if refundList, ok := data["refunds"].([]interface{}); ok {
+		for _, refundData := range refundList {
+			refundData, ok := refundData.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid 'refunds' in the order data response")
+			}
+			refund := Refund{}
+			refund.Role, _ = refundData["role"].(string)
+			refund.Token, _ = refundData["token"].(string)
+			refund.Amount, _ = utils.AmountFromJSON(refundData["amount"])
+			refund.Status, _ = refundData["status"].(string)
+			refund.TxHash, _ = refundData["tx_hash"].(string)
+			orderdetail.Refunds = append(orderdetail.Refunds, &refund)
+		}
+	}
 	orderdetail.Order = &order
 	return &orderdetail, nil
 }
 
+func parseDepositTarget(data map[string]interface{}) (*DepositTarget, error) {
+	targetData, ok := data["deposit_target"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'deposit_target' in the order data response")
+	}
+	depositTarget := DepositTarget{}
+	token, ok := targetData["token"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'token' in the deposit target response")
+	}
+	depositTarget.Token = token
+	chain, ok := targetData["chain"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'chain' in the deposit target response")
+	}
+	depositTarget.Chain = chain
+	walletAddress, ok := targetData["wallet_address"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'wallet_address' in the deposit target response")
+	}
+	depositTarget.WalletAddress = walletAddress
+	amount, err := utils.AmountFromJSON(targetData["amount"])
+	if err != nil {
+		return nil, fmt.Errorf("missing or invalid 'amount' in the deposit target response")
+	}
+	depositTarget.Amount = amount
+	return &depositTarget, nil
+}
+
+func printDepositTarget(depositTarget *DepositTarget, fromAddress string) {
+	fmt.Printf("Please send exactly %s %s from your wallet %s to the deposit address of this order on chain %s:\n",
+		depositTarget.Amount, depositTarget.Token, fromAddress, depositTarget.Chain)
+	fmt.Println(depositTarget.WalletAddress)
+}
+
+// getOrderFee asks the server for the fee quote of a new order, or of taking an
+// amount of an order.
+func getOrderFee(feeReq interface{}) (*FeeQuote, error) {
+	configData, err := config.ReadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading a config data: %s", err)
+	}
+	jsonData, err := json.Marshal(feeReq)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the order fee: %s", err)
+	}
+	req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/order/fee", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the order fee: %s", err)
+	}
+	// Add the Bearer token to the Authorization header
+	req.Header.Set("Authorization", "Bearer "+configData.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	response, err := utils.GetHttpResponse(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the order fee: %s", err)
+	}
+	if !(response.Success && response.Error == "") {
+		return nil, fmt.Errorf("error while getting the order fee: %s", response.Error)
+	}
+	quoteData, ok := response.Data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error while getting the order fee")
+	}
+	feeQuote := FeeQuote{}
+	feeQuote.FeeRate, _ = utils.AmountFromJSON(quoteData["fee_rate"])
+	feeQuote.FeePayerType, _ = quoteData["fee_payer_type"].(string)
+	feeQuote.Maker, err = parseFeeLeg(quoteData["maker"])
+	if err != nil {
+		return nil, err
+	}
+	feeQuote.Taker, err = parseFeeLeg(quoteData["taker"])
+	if err != nil {
+		return nil, err
+	}
+	return &feeQuote, nil
+}
+
+func parseFeeLeg(data interface{}) (*FeeLeg, error) {
+	legData, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid leg in the order fee response")
+	}
+	feeLeg := FeeLeg{}
+	feeLeg.DepositToken, _ = legData["deposit_token"].(string)
+	feeLeg.PayoutToken, _ = legData["payout_token"].(string)
+	var err error
+	if feeLeg.TradeAmount, err = utils.AmountFromJSON(legData["trade_amount"]); err != nil {
+		return nil, fmt.Errorf("missing or invalid 'trade_amount' in the order fee response")
+	}
+	if feeLeg.Fee, err = utils.AmountFromJSON(legData["fee"]); err != nil {
+		return nil, fmt.Errorf("missing or invalid 'fee' in the order fee response")
+	}
+	if feeLeg.DepositAmount, err = utils.AmountFromJSON(legData["deposit_amount"]); err != nil {
+		return nil, fmt.Errorf("missing or invalid 'deposit_amount' in the order fee response")
+	}
+	if feeLeg.PayoutAmount, err = utils.AmountFromJSON(legData["payout_amount"]); err != nil {
+		return nil, fmt.Errorf("missing or invalid 'payout_amount' in the order fee response")
+	}
+	return &feeLeg, nil
+}
+
+// printFeeLeg shows what one side sends and receives.
+func printFeeLeg(feeLeg *FeeLeg, chain string) {
+	fmt.Printf("you will need to send a total of %s %s (%s + a fee of %s) on chain %s\n",
+		feeLeg.DepositAmount, feeLeg.DepositToken, feeLeg.TradeAmount, feeLeg.Fee, chain)
+	fmt.Printf("you will receive %s %s\n", feeLeg.PayoutAmount, feeLeg.PayoutToken)
+}
+
 func printOrderCommonInfo(orderCommonInfo *OrderCommonInfo) {
-	fmt.Printf("Order Fee: %.2f%%\n", orderCommonInfo.Fee)
+	fmt.Printf("Order Fee: %s%%\n", orderCommonInfo.Fee)
 }
 
-func printOrders(orders []*OrderDetail) {
+// printOrders prints a page of orders. When there are more orders, it prints
+// how to get the next page.
+func printOrders(orders []*OrderDetail, next string) {
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{"ID", "Type", "Chain",
 		"Network", "Pair", "Fee Payer Type",
-		"Price", "Amount", "Referral",
-		"Status", "Create Date Time"})
+		"Price", "Amount", "Filled", "Referral",
+		"Status", "Create Date Time", "Refund"})
 
 	// Customizing table appearance
 	table.SetBorder(false)
@@ -285,19 +497,85 @@ func printOrders(orders []*OrderDetail) {
 	for _, order := range orders {
 		table.Append([]string{order.ID, order.Type, order.Chain,
 			order.Network, order.Pair, order.FeePayerType,
-			fmt.Sprintf("%.2f", order.Price), fmt.Sprintf("%.2f", order.Amount), order.Referral,
-			order.Status, order.CreateDateTime})
+			order.Price.String(), order.Amount.String(), formatFill(order), order.Referral,
+			order.Status, order.CreateDateTime, formatRefunds(order.Refunds)})
 	}
 
 	table.Render()
+	if len(next) > 0 {
+		fmt.Printf("More orders: --%s %s\n", flagNext, next)
+	}
+}
+
+// formatFill shows how much of an order is filled, or which order a fill is of.
+func formatFill(order *OrderDetail) string {
+	if len(order.ParentID) > 0 {
+		return "fill of " + order.ParentID
+	}
+	return fmt.Sprintf("%s/%s (%d%%)", order.FilledAmount, order.Amount, order.FilledAmount.Percent(order.Amount))
+}
+
+// remainingAmount returns what is left to take of an order.
+func remainingAmount(order *OrderDetail) utils.Amount {
+	if order.RemainingAmount.Sign() == 0 && order.FilledAmount.Sign() == 0 &&
+		(order.Status == orderStatusType1 || order.Status == orderStatusType2) {
+		return order.Amount
+	}
+	return order.RemainingAmount
+}
+
+func formatRefunds(refunds []*Refund) string {
+	refundLines := []string{}
+	for _, refund := range refunds {
+		refundLine := fmt.Sprintf("%s %s %s: %s", refund.Role,
+			refund.Amount, refund.Token, refund.Status)
+		if len(refund.TxHash) > 0 {
+			refundLine += " " + refund.TxHash
+		}
+		refundLines = append(refundLines, refundLine)
+	}
+	return strings.Join(refundLines, "\n")
 }
 
-func getOrderCommonInfo() (*OrderCommonInfo, error) {
+// validateTokenAddress reports whether address is a wallet address of the token
+// on the order chain and network, as described by the server token registry.
+// An empty chain or network matches any.
+func (info *OrderCommonInfo) validateTokenAddress(symbol, chain, network, address string) bool {
+	candidates := []*Token{}
+	for _, token := range info.Tokens {
+		if token.Symbol != symbol || (network != "" && token.Network != network) {
+			continue
+		}
+		if token.Chain == chain {
+			candidates = []*Token{token}
+			break
+		}
+		candidates = append(candidates, token)
+	}
+	for _, token := range candidates {
+		addressPattern, err := regexp.Compile(token.AddressPattern)
+		if err != nil || token.AddressPattern == "" {
+			continue
+		}
+		if addressPattern.MatchString(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// getOrderCommonInfo gets the order common info. When network is set, only the
+// tokens and service wallets of that network are returned.
+func getOrderCommonInfo(network string) (*OrderCommonInfo, error) {
 	configData, err := config.ReadConfig()
 	if err != nil {
 		return nil, fmt.Errorf("error while reading a config data: %s", err)
 	}
-	req, err := http.NewRequest("GET", config.tokenswapServerUrl+"/order/common", nil)
+	url := config.tokenswapServerUrl + "/order/common"
+	if len(network) > 0 {
+		url += fmt.Sprintf("?%s=%s", flagNetwork, network)
+	}
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error while getting the order common info: %s", err)
 	}
@@ -311,8 +589,8 @@ func getOrderCommonInfo() (*OrderCommonInfo, error) {
 	if !ok {
 		return nil, fmt.Errorf("error while getting the order common info")
 	}
-	orderFee, ok := commonData[fieldFee].(float64)
-	if !ok {
+	orderFee, err := utils.AmountFromJSON(commonData[fieldFee])
+	if err != nil {
 		return nil, fmt.Errorf("error while getting fee data")
 	}
 
@@ -321,40 +599,28 @@ func getOrderCommonInfo() (*OrderCommonInfo, error) {
 		return nil, fmt.Errorf("error while getting pairs data")
 	}
 	orderPairs := []string{}
-	walletAddressList := map[string]string{}
 	for _, orderPair := range orderPairList {
-		orderPair := orderPair.(string)
-		orderPairs = append(orderPairs, orderPair)
-		availableTokens := strings.Split(orderPair, "/")
-		for _, availableToken := range availableTokens {
-			if availableToken == "XEL" {
-				xelisWalletAddress, ok := commonData["xelis_wallet_address"].(string)
-				if !ok {
-					return nil, fmt.Errorf("error while getting xelis_wallet_address data")
-				}
-				if _, ok := walletAddressList[availableToken]; !ok {
-					walletAddressList[availableToken] = xelisWalletAddress
-				}
-			}
-			if availableToken == "USDT" {
-				usdtWalletAddress, ok := commonData["usdt_wallet_address"].(string)
-				if !ok {
-					return nil, fmt.Errorf("error while getting xelis_wallet_address data")
-				}
-				if _, ok := walletAddressList[availableToken]; !ok {
-					walletAddressList[availableToken] = usdtWalletAddress
-				}
-			}
-			if availableToken == "USDC" {
-				usdcWalletAddress, ok := commonData["usdc_wallet_address"].(string)
-				if !ok {
-					return nil, fmt.Errorf("error while getting xelis_wallet_address data")
-				}
-				if _, ok := walletAddressList[availableToken]; !ok {
-					walletAddressList[availableToken] = usdcWalletAddress
-				}
-			}
+		orderPairs = append(orderPairs, orderPair.(string))
+	}
+	tokenList, ok := commonData[fieldTokens].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error while getting tokens data")
+	}
+	tokens := []*Token{}
+	for _, tokenData := range tokenList {
+		tokenData, ok := tokenData.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error while getting tokens data")
 		}
+		token := Token{}
+		token.Symbol, _ = tokenData["symbol"].(string)
+		token.Chain, _ = tokenData["chain"].(string)
+		token.Network, _ = tokenData["network"].(string)
+		decimals, _ := tokenData["decimals"].(float64)
+		token.Decimals = int(decimals)
+		token.AddressPattern, _ = tokenData["address_pattern"].(string)
+		token.WalletAddress, _ = tokenData["wallet_address"].(string)
+		tokens = append(tokens, &token)
 	}
 	orderChainList, ok := commonData[fieldChains].([]interface{})
 	if !ok {
@@ -390,16 +656,16 @@ func getOrderCommonInfo() (*OrderCommonInfo, error) {
 		return nil, fmt.Errorf("error while getting statuses data")
 	}
 	orderCommonInfo := OrderCommonInfo{
-		Fee:             orderFee,
-		Pairs:           orderPairs,
-		Chains:          orderChains,
-		WalletAddresses: walletAddressList,
-		DepositTimeout:  int(depositTimeout),
-		FeePayerTypes:   feePayerTypeList,
-		Types:           typeList,
-		Networks:        networkList,
-		Visibilities:    visibilityList,
-		Statuses:        statusList,
+		Fee:            orderFee,
+		Pairs:          orderPairs,
+		Chains:         orderChains,
+		Tokens:         tokens,
+		DepositTimeout: int(depositTimeout),
+		FeePayerTypes:  feePayerTypeList,
+		Types:          typeList,
+		Networks:       networkList,
+		Visibilities:   visibilityList,
+		Statuses:       statusList,
 	}
 
 	return &orderCommonInfo, nil
@@ -419,4 +685,10 @@ func init() {
 	orderListCmd.Flags().StringVar(&orderID, flagID, "", "List the order by order_id")
 	orderListCmd.Flags().Bool(flagMyOrder, false, "List all my order in condition")
 	orderListCmd.Flags().Bool(flagTypeValuePrivate, false, "Show the list orders(public/private)") // can be another name for boolean?
+	orderListCmd.Flags().Int(flagLimit, 0, "List up to this number of orders per page (server default 50)")
+	orderListCmd.Flags().String(flagNext, "", "List the next page from the cursor printed with the previous page")
+	orderListCmd.Flags().String(flagSort, "", "Sort the orders by creation_time, price or amount")
+	orderListCmd.Flags().String(flagOrder, "", "Sort the orders in asc or desc order (default desc)")
+	orderListCmd.Flags().String(flagFrom, "", "List the orders created from this date (2006-01-02 or RFC 3339)")
+	orderListCmd.Flags().String(flagTo, "", "List the orders created up to this date (2006-01-02 or RFC 3339)")
 }
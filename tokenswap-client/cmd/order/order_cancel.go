@@ -25,7 +25,7 @@ var (
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			orderCommonInfo, err = getOrderCommonInfo()
+			orderCommonInfo, err = getOrderCommonInfo("")
 			if err != nil {
 				fmt.Println("Error while getting the order common information")
 				os.Exit(1)
@@ -59,7 +59,7 @@ var (
 				fmt.Println("Error while verifying the user")
 				return
 			}
-			req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/user/verfication", bytes.NewBuffer(jsonData))
+			req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/user/verification", bytes.NewBuffer(jsonData))
 			if err != nil {
 				fmt.Println("Error while verifying the user")
 				return
@@ -90,24 +90,15 @@ var (
 				return
 			}
 			if response.Success && response.Error == "" {
-				dataList, ok := response.Data.([]interface{})
-				if !ok {
-					fmt.Println("Error while getting the order list")
+				orders, _, err := parseOrderListPage(response.Data)
+				if err != nil {
+					fmt.Println("Error while printing the order list")
 					return
 				}
-				orders := []*OrderDetail{}
-				for _, data := range dataList {
-					order, err := parseOrderInfo(data.(map[string]interface{}))
-					if err != nil {
-						fmt.Println("Error while printing the order list")
-						return
-					}
-					orders = append(orders, order)
-				}
 				if len(orders) == 0 {
 					fmt.Println("There is no order.")
 				} else {
-					printOrders(orders)
+					printOrders(orders, "")
 					fmt.Println("Confirm cancel the order ([yes/no]):")
 					reader := bufio.NewReader(os.Stdin)
 					confirmOrderTake, err := reader.ReadString('\n')
@@ -117,13 +108,20 @@ var (
 					}
 					confirmOrderTake = strings.TrimSpace(confirmOrderTake)
 					if confirmOrderTake == "yes" {
+						otpCode, err := config.InputTwoFactorCode(configData)
+						if err != nil {
+							fmt.Println("Error while getting the two-factor authentication code")
+							return
+						}
 						// TODO: handle token transaction
 						orderCancelReq := struct {
 							OrderID  string `json:"order_id"`
 							Password string `json:"password"`
+							OTPCode  string `json:"otp_code,omitempty"`
 						}{
 							OrderID:  orderID,
 							Password: userPassword,
+							OTPCode:  otpCode,
 						}
 						jsonData, err := json.Marshal(orderCancelReq)
 						if err != nil {
@@ -20,11 +20,12 @@ import (
 )
 
 const (
-	orderTypeBuy     = "buy"
-	orderTypeSell    = "sell"
-	orderStatusType1 = "waitingForDeposit"
-	orderStatusType2 = "active"
-	orderStatusType3 = "takeInProgress"
+	orderTypeBuy      = "buy"
+	orderTypeSell     = "sell"
+	orderStatusType1  = "waitingForDeposit"
+	orderStatusType2  = "active"
+	orderStatusType3  = "takeInProgress"
+	orderStatusType10 = "partially_filled"
 
 	floatDecialmalregex = `^-?\d+(\.\d{1,2})?$`
 
@@ -42,7 +43,8 @@ var (
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			orderCommonInfo, err = getOrderCommonInfo()
+			network, _ := cmd.Flags().GetString(flagNetwork)
+			orderCommonInfo, err = getOrderCommonInfo(network)
 			if err != nil {
 				fmt.Println("Error while getting the order common information")
 				os.Exit(1)
@@ -50,15 +52,27 @@ var (
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			if listPairs {
-				// Ensure no other flags or arguments are used
-				if len(args) > 0 || cmd.Flags().NFlag() > 1 {
-					fmt.Println("the --list-pairs flag must be used alone")
+				// Ensure no other flags than the network or arguments are used
+				otherFlags := cmd.Flags().NFlag() - 1
+				if cmd.Flags().Changed(flagNetwork) {
+					otherFlags--
+				}
+				if len(args) > 0 || otherFlags > 0 {
+					fmt.Println("the --list-pairs flag must be used alone or with --network")
 					return
 				}
 				fmt.Println("Order Pair:")
 				for _, pair := range orderCommonInfo.Pairs {
 					fmt.Printf("- %s\n", pair)
 				}
+				network, _ := cmd.Flags().GetString(flagNetwork)
+				fmt.Printf("Service Wallet (%s):\n", network)
+				for _, token := range orderCommonInfo.Tokens {
+					if token.WalletAddress == "" {
+						continue
+					}
+					fmt.Printf("- %s on %s: %s\n", token.Symbol, token.Chain, token.WalletAddress)
+				}
 				return
 			}
 			configData, err := config.ReadConfig()
@@ -81,7 +95,7 @@ var (
 				fmt.Println("Error while verifying the user")
 				return
 			}
-			req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/user/verfication", bytes.NewBuffer(jsonData))
+			req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/user/verification", bytes.NewBuffer(jsonData))
 			if err != nil {
 				fmt.Println("Error while verifying the user")
 				return
@@ -173,14 +187,14 @@ var (
 				fmt.Println("Invalid order amount format. Please enter a number with exactly 2 decimal places.")
 				return
 			}
-			// Convert to float64 after validation
-			orderAmountNumber, err := strconv.ParseFloat(orderAmount, 64)
+			// Convert to an exact decimal after validation
+			orderAmountNumber, err := utils.ParseAmount(orderAmount)
 			if err != nil {
-				fmt.Printf("The order amount is not a float number: %s\n", orderAmount)
+				fmt.Printf("The order amount is not a decimal number: %s\n", orderAmount)
 				return
 			}
-			if orderAmountNumber < orderMinimumAmount {
-				fmt.Printf("The order amount is must be bigger than %d: %.2f\n", orderMinimumAmount, orderAmountNumber)
+			if orderAmountNumber.Cmp(utils.NewAmountFromInt(orderMinimumAmount)) < 0 {
+				fmt.Printf("The order amount is must be bigger than %d: %s\n", orderMinimumAmount, orderAmountNumber)
 				return
 			}
 			orderReq.Amount = orderAmountNumber
@@ -197,14 +211,14 @@ var (
 				fmt.Println("Invalid order price format. Please enter a number with exactly 2 decimal places.")
 				return
 			}
-			// Convert to float64 after validation
-			orderPriceNumber, err := strconv.ParseFloat(orderPrice, 64)
+			// Convert to an exact decimal after validation
+			orderPriceNumber, err := utils.ParseAmount(orderPrice)
 			if err != nil {
-				fmt.Printf("The order price is not a float number: %s\n", orderPrice)
+				fmt.Printf("The order price is not a decimal number: %s\n", orderPrice)
 				return
 			}
-			if orderPriceNumber <= 0 {
-				fmt.Printf("The order price is must be bigger than 0: %f\n", orderPriceNumber)
+			if orderPriceNumber.Sign() <= 0 {
+				fmt.Printf("The order price is must be bigger than 0: %s\n", orderPriceNumber)
 				return
 			}
 			orderReq.Price = orderPriceNumber
@@ -253,17 +267,30 @@ var (
 			}
 			ordererWalletAddress = strings.TrimSpace(ordererWalletAddress)
 			availableTokens := strings.Split(orderReq.Pair, "/")
-			orderTokenName := ""
+			orderTokenName, payoutTokenName := "", ""
 			if orderReq.Type == orderTypeBuy {
-				orderTokenName = availableTokens[1]
+				orderTokenName, payoutTokenName = availableTokens[1], availableTokens[0]
 			} else if orderReq.Type == orderTypeSell {
-				orderTokenName = availableTokens[0]
+				orderTokenName, payoutTokenName = availableTokens[0], availableTokens[1]
 			}
-			if !utils.ValidateTokenAddress(orderTokenName, ordererWalletAddress) {
+			if !orderCommonInfo.validateTokenAddress(orderTokenName, orderReq.Chain, orderReq.Network, ordererWalletAddress) {
 				fmt.Println("Your token address is not valid")
 				return
 			}
 			orderReq.OrdererWalletAddress = ordererWalletAddress
+			fmt.Printf("* Your payout address (%s):\n", payoutTokenName)
+			reader = bufio.NewReader(os.Stdin)
+			payoutWalletAddress, err := reader.ReadString('\n')
+			if err != nil {
+				fmt.Println("Error while getting the your payout address")
+				return
+			}
+			payoutWalletAddress = strings.TrimSpace(payoutWalletAddress)
+			if !orderCommonInfo.validateTokenAddress(payoutTokenName, orderReq.Chain, orderReq.Network, payoutWalletAddress) {
+				fmt.Println("Your payout token address is not valid")
+				return
+			}
+			orderReq.PayoutWalletAddress = payoutWalletAddress
 			orderReq.Status = orderStatusType1
 			fmt.Println("----[Order Summary]-----")
 			printOrderCommonInfo(orderCommonInfo)
@@ -278,16 +305,14 @@ var (
 			fmt.Println("Fees Payer:", orderReq.Visibility)
 			fmt.Println("Referral:", orderReq.Referral)
 			fmt.Println("Your wallet address:", orderReq.OrdererWalletAddress)
-			walletAddress, exists := orderCommonInfo.WalletAddresses[orderTokenName]
-			if !exists {
-				fmt.Println("Your token is not valid to order")
+			fmt.Println("Your payout wallet address:", orderReq.PayoutWalletAddress)
+			feeQuote, err := getOrderFee(orderReq.Order)
+			if err != nil {
+				fmt.Println("Error while getting the order fee")
 				return
 			}
-			if orderReq.Type == orderTypeBuy {
-				fmt.Printf("you will need to send a total of %.2f %s (including fees) to wallet %s on chain %s\n", orderReq.Amount, orderTokenName, walletAddress, orderReq.Chain)
-			} else if orderReq.Type == orderTypeSell {
-				fmt.Printf("you will need to send a total of %.2f %s to the wallet %s\n", orderReq.Amount, orderTokenName, walletAddress)
-			}
+			printFeeLeg(feeQuote.Maker, orderReq.Chain)
+			fmt.Println("A deposit address for this order is issued once the order is created.")
 			fmt.Println("Confirm order  ([yes/no]):")
 			reader = bufio.NewReader(os.Stdin)
 			confirmOrder, err := reader.ReadString('\n')
@@ -298,6 +323,11 @@ var (
 			confirmOrder = strings.TrimSpace(confirmOrder)
 			if confirmOrder == "yes" {
 				orderReq.Password = userPassword
+				orderReq.OTPCode, err = config.InputTwoFactorCode(configData)
+				if err != nil {
+					fmt.Println("Error while getting the two-factor authentication code")
+					return
+				}
 				jsonData, err := json.Marshal(orderReq)
 				if err != nil {
 					fmt.Println("Error while creating the order")
@@ -322,12 +352,16 @@ var (
 					return
 				}
 				if response.Success && response.Error == "" {
-					if _, ok := response.Data.(map[string]interface{}); ok {
+					if data, ok := response.Data.(map[string]interface{}); ok {
+						depositTarget, err := parseDepositTarget(data)
+						if err != nil {
+							fmt.Println("Error while getting the deposit address of the order")
+							return
+						}
 						fmt.Println("Creating an order has succeeded")
 						fmt.Println("Order created in waitingForDeposit state!")
 						fmt.Printf("You now have %d min to deposit funds.\n", orderCommonInfo.DepositTimeout)
-						fmt.Printf("Please send  ## XEL from your wallet %s to the tokenswap wallet %s\n",
-							orderReq.OrdererWalletAddress, walletAddress)
+						printDepositTarget(depositTarget, orderReq.OrdererWalletAddress)
 						// TODO: SHOW QRCODE of tokenswap wallet"
 					} else {
 						fmt.Println("Error while creating the order")
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/rocky2015aaa/tokenswap-client/config"
@@ -25,7 +26,7 @@ var (
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			orderCommonInfo, err = getOrderCommonInfo()
+			orderCommonInfo, err = getOrderCommonInfo("")
 			if err != nil {
 				fmt.Println("Error while getting the order common information")
 				os.Exit(1)
@@ -39,6 +40,20 @@ var (
 			}
 			orderID := args[0]
 			// TODO: id formation verification
+			takeAmount := utils.Amount{}
+			takeAmountValue, _ := cmd.Flags().GetString(flagAmount)
+			if len(takeAmountValue) > 0 {
+				if !regexp.MustCompile(floatDecialmalregex).MatchString(takeAmountValue) {
+					fmt.Println("The take amount must have at most 2 decimal places")
+					return
+				}
+				amount, err := utils.ParseAmount(takeAmountValue)
+				if err != nil || amount.Sign() <= 0 {
+					fmt.Printf("The take amount is not a positive decimal number: %s\n", takeAmountValue)
+					return
+				}
+				takeAmount = amount
+			}
 			configData, err := config.ReadConfig()
 			if err != nil {
 				fmt.Println("Error while reading a config data")
@@ -59,7 +74,7 @@ var (
 				fmt.Println("Error while verifying the user")
 				return
 			}
-			req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/user/verfication", bytes.NewBuffer(jsonData))
+			req, err := http.NewRequest("POST", config.tokenswapServerUrl+"/user/verification", bytes.NewBuffer(jsonData))
 			if err != nil {
 				fmt.Println("Error while verifying the user")
 				return
@@ -76,7 +91,7 @@ var (
 				fmt.Println("Error while verifying the user")
 				return
 			}
-			query := fmt.Sprintf("?order_id=%s&status=%s", orderID, orderStatusType2)
+			query := fmt.Sprintf("?order_id=%s", orderID)
 			req, err = http.NewRequest("GET", config.tokenswapServerUrl+"/order/list"+query, nil)
 			if err != nil {
 				fmt.Println("Error while getting the order list")
@@ -90,24 +105,31 @@ var (
 				return
 			}
 			if response.Success && response.Error == "" {
-				dataList, ok := response.Data.([]interface{})
-				if !ok {
-					fmt.Println("Error while getting the order list")
+				orders, _, err := parseOrderListPage(response.Data)
+				if err != nil {
+					fmt.Println("Error while printing the order list")
 					return
 				}
-				orders := []*OrderDetail{}
-				for _, data := range dataList {
-					order, err := parseOrderInfo(data.(map[string]interface{}))
-					if err != nil {
-						fmt.Println("Error while printing the order list")
-						return
-					}
-					orders = append(orders, order)
+				// Partially filled orders can be taken for what is left
+				if len(orders) > 0 && orders[0].Status != orderStatusType2 && orders[0].Status != orderStatusType10 {
+					orders = []*OrderDetail{}
 				}
 				if len(orders) == 0 {
 					fmt.Println("There is no order.")
 				} else {
-					printOrders(orders)
+					printOrders(orders, "")
+					orderRemainingAmount := remainingAmount(orders[0])
+					if takeAmount.Sign() == 0 {
+						takeAmount = orderRemainingAmount
+					}
+					if takeAmount.Cmp(orderRemainingAmount) > 0 {
+						fmt.Printf("The take amount is more than what is left of the order: %s\n", orderRemainingAmount)
+						return
+					}
+					if takeAmount.Cmp(utils.NewAmountFromInt(orderMinimumAmount)) < 0 && takeAmount.Cmp(orderRemainingAmount) != 0 {
+						fmt.Printf("The take amount must be at least %d unless it is all that is left: %s\n", orderMinimumAmount, takeAmount)
+						return
+					}
 					fmt.Println("* Your addess:")
 					reader := bufio.NewReader(os.Stdin)
 					orderTakerWalletAddress, err := reader.ReadString('\n')
@@ -117,16 +139,43 @@ var (
 					}
 					orderTakerWalletAddress = strings.TrimSpace(orderTakerWalletAddress)
 					availableTokens := strings.Split(orders[0].Pair, "/")
-					orderTokenName := ""
+					// The taker deposits the token the order maker receives
+					orderTokenName, payoutTokenName := "", ""
 					if orders[0].Type == orderTypeBuy {
-						orderTokenName = availableTokens[1]
+						orderTokenName, payoutTokenName = availableTokens[0], availableTokens[1]
 					} else if orders[0].Type == orderTypeSell {
-						orderTokenName = availableTokens[0]
+						orderTokenName, payoutTokenName = availableTokens[1], availableTokens[0]
 					}
-					if !utils.ValidateTokenAddress(orderTokenName, orderTakerWalletAddress) {
+					if !orderCommonInfo.validateTokenAddress(orderTokenName, orders[0].Chain, orders[0].Network, orderTakerWalletAddress) {
 						fmt.Println("Your token address is not valid")
 						return
 					}
+					fmt.Printf("* Your payout address (%s):\n", payoutTokenName)
+					reader = bufio.NewReader(os.Stdin)
+					orderTakerPayoutAddress, err := reader.ReadString('\n')
+					if err != nil {
+						fmt.Println("Error while getting the your payout address")
+						return
+					}
+					orderTakerPayoutAddress = strings.TrimSpace(orderTakerPayoutAddress)
+					if !orderCommonInfo.validateTokenAddress(payoutTokenName, orders[0].Chain, orders[0].Network, orderTakerPayoutAddress) {
+						fmt.Println("Your payout token address is not valid")
+						return
+					}
+					fmt.Printf("Amount to take: %s of %s left\n", takeAmount, orderRemainingAmount)
+					feeReq := struct {
+						OrderID string       `json:"order_id"`
+						Amount  utils.Amount `json:"amount"`
+					}{
+						OrderID: orderID,
+						Amount:  takeAmount,
+					}
+					feeQuote, err := getOrderFee(feeReq)
+					if err != nil {
+						fmt.Println("Error while getting the order fee")
+						return
+					}
+					printFeeLeg(feeQuote.Taker, orders[0].Chain)
 					fmt.Println("Confirm order take ([yes/no]):")
 					reader = bufio.NewReader(os.Stdin)
 					confirmOrderTake, err := reader.ReadString('\n')
@@ -136,15 +185,26 @@ var (
 					}
 					confirmOrderTake = strings.TrimSpace(confirmOrderTake)
 					if confirmOrderTake == "yes" {
+						otpCode, err := config.InputTwoFactorCode(configData)
+						if err != nil {
+							fmt.Println("Error while getting the two-factor authentication code")
+							return
+						}
 						// TODO: handle token transaction
 						orderTakeReq := struct {
-							OrderID           string `json:"order_id"`
-							OrderTakerAddress string `json:"ordertaker_address"`
-							Password          string `json:"password"`
+							OrderID                 string       `json:"order_id"`
+							OrderTakerAddress       string       `json:"ordertaker_address"`
+							OrderTakerPayoutAddress string       `json:"ordertaker_payout_address"`
+							Amount                  utils.Amount `json:"amount"`
+							Password                string       `json:"password"`
+							OTPCode                 string       `json:"otp_code,omitempty"`
 						}{
-							OrderID:           orderID,
-							OrderTakerAddress: orderTakerWalletAddress,
-							Password:          userPassword,
+							OrderID:                 orderID,
+							OrderTakerAddress:       orderTakerWalletAddress,
+							OrderTakerPayoutAddress: orderTakerPayoutAddress,
+							Amount:                  takeAmount,
+							Password:                userPassword,
+							OTPCode:                 otpCode,
 						}
 						jsonData, err := json.Marshal(orderTakeReq)
 						if err != nil {
@@ -165,16 +225,21 @@ var (
 							return
 						}
 						if response.Success && response.Error == "" {
-							walletAddress, exists := orderCommonInfo.WalletAddresses[orderTokenName]
-							if !exists {
-								fmt.Println("Your token is not valid to order")
+							data, ok := response.Data.(map[string]interface{})
+							if !ok {
+								fmt.Println("Error while getting the deposit address of the order")
 								return
 							}
+							depositTarget, err := parseDepositTarget(data)
+							if err != nil {
+								fmt.Println("Error while getting the deposit address of the order")
+								return
+							}
+							fillID, _ := data["fill_id"].(string)
 							fmt.Println("Taking an order has succeeded")
-							fmt.Println("Order updated in takeInProgress state!")
+							fmt.Printf("Fill %s of %s created in takeInProgress state!\n", fillID, takeAmount)
 							fmt.Printf("You now have %d min to deposit funds.\n", orderCommonInfo.DepositTimeout)
-							fmt.Printf("Please send  ## %s from your wallet %s:%s to the tokenswap wallet %s:%s\n",
-								orderTokenName, orderTokenName, orderTakeReq.OrderTakerAddress, orderTokenName, walletAddress)
+							printDepositTarget(depositTarget, orderTakeReq.OrderTakerAddress)
 							// TODO: SHOW QRCODE of tokenswap wallet"
 						} else {
 							fmt.Println("Error while taking the order")
@@ -190,6 +255,8 @@ var (
 func init() {
 	OrderCmd.AddCommand(orderTakeCmd)
 
+	orderTakeCmd.Flags().String(flagAmount, "", "Set the amount to take, all that is left of the order by default")
+
 	// orderTakeCmd.Flags().String(flagChain, "", "get the order with a valid blockchain")
 	// orderTakeCmd.Flags().String(flagNetwork, "mainnet", "get the order with a valid network")
 }
@@ -0,0 +1,132 @@
+package order
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rocky2015aaa/tokenswap-client/config"
+	"github.com/rocky2015aaa/tokenswap-client/utils"
+	"github.com/spf13/cobra"
+)
+
+const (
+	streamEventPing = "ping"
+)
+
+// OrderEvent is a change of an order streamed by the server.
+type OrderEvent struct {
+	Type      string       `json:"type"`
+	OrderID   string       `json:"order_id"`
+	ParentID  string       `json:"parent_id"`
+	Pair      string       `json:"pair"`
+	Network   string       `json:"network"`
+	OrderType string       `json:"order_type"`
+	Amount    utils.Amount `json:"amount"`
+	Price     utils.Amount `json:"price"`
+	Status    string       `json:"status"`
+	DateTime  string       `json:"date_time"`
+}
+
+var (
+	orderWatchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Watch the trading orders being created, taken, funded, completed and cancelled",
+		Long:  `Watch the trading orders being created, taken, funded, completed and cancelled until interrupted`,
+		Run: func(cmd *cobra.Command, args []string) {
+			configData, err := config.ReadConfig()
+			if err != nil {
+				fmt.Println("Error while reading a config data")
+				return
+			}
+			query := url.Values{}
+			pair, _ := cmd.Flags().GetString(flagPair)
+			if len(pair) > 0 {
+				query.Set(flagPair, pair)
+			}
+			network, _ := cmd.Flags().GetString(flagNetwork)
+			if len(network) > 0 {
+				if _, ok := orderCommonInfo.Networks[network]; !ok {
+					fmt.Println("Not a valid network name")
+					return
+				}
+				query.Set(flagNetwork, network)
+			}
+			myOrder, _ := cmd.Flags().GetBool(flagMyOrder)
+			if myOrder {
+				query.Set("my_orders", "true")
+			}
+			req, err := http.NewRequest("GET", config.tokenswapServerUrl+"/stream?"+query.Encode(), nil)
+			if err != nil {
+				fmt.Println("Error while watching the orders")
+				return
+			}
+			// Add the Bearer token to the Authorization header
+			req.Header.Set("Authorization", "Bearer "+configData.AccessToken)
+			req.Header.Set("Accept", "text/event-stream")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				fmt.Println("Error while watching the orders")
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				fmt.Printf("Error while watching the orders: %s\n", resp.Status)
+				return
+			}
+			fmt.Println("Watching the orders, press Ctrl+C to stop.")
+			err = readOrderEvents(bufio.NewScanner(resp.Body), printOrderEvent)
+			if err != nil {
+				fmt.Println("Error while watching the orders")
+				return
+			}
+			fmt.Println("The server has closed the order stream")
+		},
+	}
+)
+
+// readOrderEvents reads the server-sent events of the stream and hands the
+// order events to handle, skipping the pings.
+func readOrderEvents(scanner *bufio.Scanner, handle func(*OrderEvent)) error {
+	eventType, data := "", ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case len(line) == 0:
+			if len(data) > 0 && eventType != streamEventPing {
+				event := OrderEvent{}
+				err := json.Unmarshal([]byte(data), &event)
+				if err != nil {
+					return err
+				}
+				handle(&event)
+			}
+			eventType, data = "", ""
+		}
+	}
+	return scanner.Err()
+}
+
+func printOrderEvent(event *OrderEvent) {
+	orderID := event.OrderID
+	if len(event.ParentID) > 0 {
+		orderID += " (fill of " + event.ParentID + ")"
+	}
+	fmt.Printf("[%s] %s %s: %s %s %s at %s on %s, %s\n", event.DateTime, event.Type, orderID,
+		event.OrderType, event.Amount, event.Pair, event.Price, event.Network, event.Status)
+}
+
+func init() {
+	OrderCmd.AddCommand(orderWatchCmd)
+
+	orderWatchCmd.Flags().String(flagPair, "", "Watch the orders with the pair")
+	orderWatchCmd.Flags().String(flagNetwork, "", "Watch the orders with a valid network")
+	orderWatchCmd.Flags().Bool(flagMyOrder, false, "Watch my orders only")
+}
@@ -48,6 +48,18 @@ var (
 					return
 				}
 				fmt.Println("A config file created successfully.")
+				configData, err := config.ReadConfig()
+				if err != nil {
+					fmt.Println("Error while reading a config file")
+					return
+				}
+				err = config.VerifyEmail(configData.AccessToken)
+				if err != nil {
+					fmt.Println(err.Error())
+					fmt.Println("Your email is not verified yet. Run the config verify-email command to verify it before trading.")
+					return
+				}
+				fmt.Println("Your email has been verified.")
 			} else {
 				cmd.Help()
 			}
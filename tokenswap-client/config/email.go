@@ -0,0 +1,69 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rocky2015aaa/tokenswap-client/utils"
+)
+
+const (
+	maxVerificationCodeEntries       = 3
+	incorrectVerificationCodeMessage = "not a correct verification code"
+)
+
+// VerifyEmail prompts for the verification code emailed to the user and
+// sends it to the server. An empty code requests a new one.
+func VerifyEmail(accessToken string) error {
+	for entries := 0; entries < maxVerificationCodeEntries; {
+		code, err := utils.InputVerificationCode()
+		if err != nil {
+			return fmt.Errorf("error while entering the verification code. %s", err)
+		}
+		if len(code) == 0 {
+			response, err := postEmailVerification(accessToken, "/user/email/resend", nil)
+			if err != nil {
+				return err
+			}
+			if !response.Success {
+				return fmt.Errorf("error while requesting a new verification code. %s", response.Error)
+			}
+			fmt.Println("A new verification code has been sent to your email.")
+			continue
+		}
+		response, err := postEmailVerification(accessToken, "/user/email/verify", &EmailVerificationRequest{Code: code})
+		if err != nil {
+			return err
+		}
+		if response.Success {
+			return nil
+		}
+		if response.Error != incorrectVerificationCodeMessage {
+			return fmt.Errorf("error while verifying the email. %s", response.Error)
+		}
+		fmt.Println("The verification code is not correct. Please try again.")
+		entries++
+	}
+	return fmt.Errorf("the verification code was not correct %d times", maxVerificationCodeEntries)
+}
+
+func postEmailVerification(accessToken, path string, data *EmailVerificationRequest) (*utils.Response, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshaling an email verification data. %s", err)
+	}
+	req, err := http.NewRequest("POST", tokenswapServerUrl+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error while creating an email verification request. %s", err)
+	}
+	// Add the Bearer token to the Authorization header
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	response, err := utils.GetHttpResponse(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting a http response. %s", err)
+	}
+	return response, nil
+}
@@ -3,6 +3,7 @@ package config
 type ConfigRequest struct {
 	Email    string `json:"email,omitempty"`
 	Password string `json:"password"`
+	OTPCode  string `json:"otp_code,omitempty"`
 }
 
 type UpdateTokenExpirationRequest struct {
@@ -21,8 +22,17 @@ type PasswordUpdateRequest struct {
 	NewPassword string `json:"new_password"`
 }
 
+type EmailVerificationRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorConfirmationRequest struct {
+	Code string `json:"code"`
+}
+
 type Config struct {
-	Email        string `json:"email"`
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	Email            string `json:"email"`
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled,omitempty"`
 }
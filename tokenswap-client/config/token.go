@@ -71,6 +71,10 @@ func ManageUserTokens() error {
 			// If the refresh token also has expired, proceed renew tokens process
 		} else if response.Error == tokenExpirationMessage {
 			fmt.Println("The refresh token is also expired. The access token and the refresh token will be updated.")
+			req.OTPCode, err = InputTwoFactorCode(configData)
+			if err != nil {
+				return fmt.Errorf("Error while getting the two-factor authentication code")
+			}
 			response, err = renewTokens(&req)
 			if err != nil {
 				return fmt.Errorf("Error while updating the access token and the refresh token")
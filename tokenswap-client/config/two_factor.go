@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rocky2015aaa/tokenswap-client/utils"
+)
+
+// InputTwoFactorCode prompts for the two-factor authentication code when the
+// user enabled it, and returns an empty code otherwise.
+func InputTwoFactorCode(configData *Config) (string, error) {
+	if !configData.TwoFactorEnabled {
+		return "", nil
+	}
+	return utils.InputTwoFactorCode()
+}
+
+// EnableTwoFactor enrols the user to two-factor authentication, prompts for a
+// code of the authenticator app to enable it and prints the recovery codes.
+func EnableTwoFactor(configData *Config, password string) error {
+	response, err := postTwoFactor(configData.AccessToken, "/user/2fa/enroll", &ConfigRequest{Password: password})
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("error while enrolling two-factor authentication. %s", response.Error)
+	}
+	data, ok := response.Data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("error while enrolling two-factor authentication")
+	}
+	secret, _ := data["secret"].(string)
+	otpauthURI, _ := data["otpauth_uri"].(string)
+	fmt.Println("Add this account to your authenticator app with the secret or the URI:")
+	fmt.Println("Secret:", secret)
+	fmt.Println("URI:", otpauthURI)
+	code, err := utils.InputTwoFactorCode()
+	if err != nil {
+		return fmt.Errorf("error while entering the two-factor authentication code. %s", err)
+	}
+	response, err = postTwoFactor(configData.AccessToken, "/user/2fa/confirm", &TwoFactorConfirmationRequest{Code: code})
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("error while enabling two-factor authentication. %s", response.Error)
+	}
+	data, ok = response.Data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("error while enabling two-factor authentication")
+	}
+	recoveryCodes, _ := data["recovery_codes"].([]interface{})
+	fmt.Println("Keep these recovery codes somewhere safe. Each of them can be used once instead of a code of your authenticator app:")
+	for _, recoveryCode := range recoveryCodes {
+		fmt.Println(recoveryCode)
+	}
+	configData.TwoFactorEnabled = true
+	return UpdateConfig(configData)
+}
+
+func DisableTwoFactor(configData *Config, password string) error {
+	code, err := utils.InputTwoFactorCode()
+	if err != nil {
+		return fmt.Errorf("error while entering the two-factor authentication code. %s", err)
+	}
+	response, err := postTwoFactor(configData.AccessToken, "/user/2fa/disable", &ConfigRequest{Password: password, OTPCode: code})
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("error while disabling two-factor authentication. %s", response.Error)
+	}
+	configData.TwoFactorEnabled = false
+	return UpdateConfig(configData)
+}
+
+func postTwoFactor(accessToken, path string, data interface{}) (*utils.Response, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshaling a two-factor authentication data. %s", err)
+	}
+	req, err := http.NewRequest("POST", tokenswapServerUrl+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error while creating a two-factor authentication request. %s", err)
+	}
+	// Add the Bearer token to the Authorization header
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	response, err := utils.GetHttpResponse(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting a http response. %s", err)
+	}
+	return response, nil
+}
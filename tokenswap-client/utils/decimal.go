@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// amountScale is the number of decimal places an Amount keeps, as on the server.
+	amountScale = 18
+)
+
+var amountScaleFactor = new(big.Int).Exp(big.NewInt(10), big.NewInt(amountScale), nil)
+
+// Amount is an exact fixed-point decimal number. The server sends amounts as
+// strings so that they are not rounded through float64. The zero value is 0.
+type Amount struct {
+	units *big.Int
+}
+
+// ParseAmount reads a decimal number like "12", "-0.5", "1234.56789" or
+// "1.5e-7", accepting the same values as the server.
+func ParseAmount(value string) (Amount, error) {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "eE") {
+		// big.Rat also reads a leading '+' and hexadecimal numbers like "0x1e3"
+		mantissa, exponent, _ := strings.Cut(strings.ToLower(value), "e")
+		mantissa = strings.Replace(strings.TrimPrefix(mantissa, "-"), ".", "", 1)
+		exponent = strings.TrimLeft(exponent, "+-")
+		if len(mantissa) == 0 || len(exponent) == 0 || !isDigits(mantissa) || !isDigits(exponent) {
+			return Amount{}, fmt.Errorf("invalid amount: %q", value)
+		}
+		number, ok := new(big.Rat).SetString(value)
+		if !ok {
+			return Amount{}, fmt.Errorf("invalid amount: %q", value)
+		}
+		number.Mul(number, new(big.Rat).SetInt(amountScaleFactor))
+		if !number.IsInt() {
+			return Amount{}, fmt.Errorf("the amount has too many decimal places: %q", value)
+		}
+		return Amount{units: new(big.Int).Set(number.Num())}, nil
+	}
+	digits := strings.TrimPrefix(value, "-")
+	integerPart, fractionPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i > -1 {
+		integerPart, fractionPart = digits[:i], digits[i+1:]
+	}
+	if len(integerPart) == 0 && len(fractionPart) == 0 || !isDigits(integerPart) || !isDigits(fractionPart) {
+		return Amount{}, fmt.Errorf("invalid amount: %q", value)
+	}
+	fractionPart = strings.TrimRight(fractionPart, "0")
+	if len(fractionPart) > amountScale {
+		return Amount{}, fmt.Errorf("the amount has too many decimal places: %q", value)
+	}
+	units, ok := new(big.Int).SetString(integerPart+fractionPart+strings.Repeat("0", amountScale-len(fractionPart)), 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount: %q", value)
+	}
+	if strings.HasPrefix(value, "-") {
+		units.Neg(units)
+	}
+	return Amount{units: units}, nil
+}
+
+// AmountFromJSON converts an amount decoded into an interface{}. The server
+// sends amounts as strings, a number would already have been rounded through
+// float64.
+func AmountFromJSON(value interface{}) (Amount, error) {
+	if value, ok := value.(string); ok {
+		return ParseAmount(value)
+	}
+	return Amount{}, fmt.Errorf("invalid amount: %v", value)
+}
+
+func NewAmountFromInt(value int64) Amount {
+	return Amount{units: new(big.Int).Mul(big.NewInt(value), amountScaleFactor)}
+}
+
+// Mul multiplies two amounts. Decimal places beyond the scale are truncated.
+func (a Amount) Mul(b Amount) Amount {
+	units := new(big.Int).Mul(a.bigInt(), b.bigInt())
+	return Amount{units: units.Quo(units, amountScaleFactor)}
+}
+
+// Percent returns how many whole percent of total the amount is.
+func (a Amount) Percent(total Amount) int64 {
+	if total.Sign() == 0 {
+		return 0
+	}
+	units := new(big.Int).Mul(a.bigInt(), big.NewInt(100))
+	return units.Quo(units, total.bigInt()).Int64()
+}
+
+func (a Amount) Cmp(b Amount) int {
+	return a.bigInt().Cmp(b.bigInt())
+}
+
+func (a Amount) Sign() int {
+	return a.bigInt().Sign()
+}
+
+// String formats the amount without trailing zeros, like "1234.5".
+func (a Amount) String() string {
+	units := new(big.Int).Abs(a.bigInt())
+	digits := units.String()
+	if len(digits) <= amountScale {
+		digits = strings.Repeat("0", amountScale-len(digits)+1) + digits
+	}
+	integerPart := digits[:len(digits)-amountScale]
+	fractionPart := strings.TrimRight(digits[len(digits)-amountScale:], "0")
+	sign := ""
+	if a.Sign() < 0 {
+		sign = "-"
+	}
+	if len(fractionPart) == 0 {
+		return sign + integerPart
+	}
+	return sign + integerPart + "." + fractionPart
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+	amount, err := AmountFromJSON(value)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func (a Amount) bigInt() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
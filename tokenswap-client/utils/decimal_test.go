@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  string
+	}{
+		{value: "12", want: "12"},
+		{value: " 1234.56789 ", want: "1234.56789"},
+		{value: "-0.5", want: "-0.5"},
+		{value: ".5", want: "0.5"},
+		{value: "5.", want: "5"},
+		{value: "1.5e-7", want: "0.00000015"},
+		{value: "1e+2", want: "100"},
+		{value: "0.000000000000000001", want: "0.000000000000000001"},
+	} {
+		amount, err := ParseAmount(test.value)
+		if err != nil {
+			t.Errorf("ParseAmount(%q) error = %v", test.value, err)
+			continue
+		}
+		if amount.String() != test.want {
+			t.Errorf("ParseAmount(%q) = %s, want %s", test.value, amount, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "-", ".", "+5", "+1e2", "--5", "-+5", "1.2.3", "1,5", "0x1e3", "1e", "0.0000000000000000001"} {
+		amount, err := ParseAmount(value)
+		if err == nil {
+			t.Errorf("ParseAmount(%q) = %s, want an error", value, amount)
+		}
+	}
+}
+
+func TestAmountFromJSON(t *testing.T) {
+	var decoded map[string]interface{}
+	err := json.Unmarshal([]byte(`{"string": "0.1", "number": 0.1, "bool": true}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount, err := AmountFromJSON(decoded["string"])
+	if err != nil || amount.String() != "0.1" {
+		t.Errorf("AmountFromJSON(%q) = %s, %v, want 0.1", decoded["string"], amount, err)
+	}
+	for _, key := range []string{"number", "bool", "missing"} {
+		amount, err := AmountFromJSON(decoded[key])
+		if err == nil {
+			t.Errorf("AmountFromJSON(%v) = %s, want an error", decoded[key], amount)
+		}
+	}
+
+	var document struct {
+		Amount Amount `json:"amount"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 0.1}`), &document); err == nil {
+		t.Errorf("unmarshal a number = %s, want an error", document.Amount)
+	}
+}
@@ -31,6 +31,26 @@ func InputUserEmail() (string, error) {
 	return strings.TrimSpace(email), nil
 }
 
+func InputVerificationCode() (string, error) {
+	fmt.Print("Enter the verification code sent to your email (leave empty to get a new one): ")
+	reader := bufio.NewReader(os.Stdin)
+	code, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("error reading verification code: %w", err)
+	}
+	return strings.TrimSpace(code), nil
+}
+
+func InputTwoFactorCode() (string, error) {
+	fmt.Print("Enter the code of your authenticator app or a recovery code: ")
+	reader := bufio.NewReader(os.Stdin)
+	code, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("error reading two-factor authentication code: %w", err)
+	}
+	return strings.TrimSpace(code), nil
+}
+
 func GetPassword() (string, error) {
 	password, err := InputPassword("Enter new password: ")
 	if err != nil {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/config"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/migration"
+)
+
+const (
+	commandMigrate = "migrate"
+	commandSeed    = "seed"
+)
+
+// runCommand runs the subcommand setting up the database, see usage.
+func runCommand(command string, args []string) error {
+	switch command {
+	case commandMigrate:
+		flags := flag.NewFlagSet(commandMigrate, flag.ExitOnError)
+		seedFile := flags.String("seed", "", "seed the order common info from this YAML file once migrated, unless it is already seeded")
+		flags.Parse(args)
+		err := migrate()
+		if err != nil || len(*seedFile) == 0 {
+			return err
+		}
+		err = seed(*seedFile, false)
+		if err == migration.ErrAlreadySeeded {
+			log.Info(err)
+			return nil
+		}
+		return err
+	case commandSeed:
+		flags := flag.NewFlagSet(commandSeed, flag.ExitOnError)
+		seedFile := flags.String("file", "seed_dev.yaml", "YAML file of the order common info")
+		overwrite := flags.Bool("force", false, "replace the order common info already stored")
+		flags.Parse(args)
+		return seed(*seedFile, *overwrite)
+	}
+	usage()
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  stsvr                          run the server
+  stsvr migrate [-seed FILE]     apply the database migrations, and seed a new database from FILE
+  stsvr seed [-file FILE] [-force]
+                                 seed the order common info from FILE
+`)
+}
+
+// migrate applies the migrations to MongoDB. The in-memory storage needs none.
+func migrate() error {
+	if os.Getenv(config.EnvStsvrStorage) == config.StorageMemory {
+		return fmt.Errorf("the in-memory storage has no migrations")
+	}
+	client, err := database.NewMongoDB(os.Getenv(config.EnvStsvrMongodbUri))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+	applied, err := migration.Migrate(context.Background(), database.MongoDatabase(client))
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Info("The database is up to date.")
+		return nil
+	}
+	for _, appliedMigration := range applied {
+		log.Infof("Applied migration %d %s", appliedMigration.Version, appliedMigration.Name)
+	}
+	return nil
+}
+
+func seed(seedFile string, overwrite bool) error {
+	if os.Getenv(config.EnvStsvrStorage) == config.StorageMemory {
+		return fmt.Errorf("the in-memory storage is seeded when the server starts")
+	}
+	orderCommonInfo, err := migration.LoadSeedFile(seedFile)
+	if err != nil {
+		return err
+	}
+	client, err := database.NewMongoDB(os.Getenv(config.EnvStsvrMongodbUri))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+	return migration.Seed(context.Background(), database.NewMongoStore(client), orderCommonInfo, overwrite)
+}
@@ -14,6 +14,13 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 	signalReceived := make(chan os.Signal, 1)
 	svr := api.NewApp(signalReceived)
 	go func() {
@@ -6,65 +6,117 @@ import (
 	"context"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/rocky2015aaa/tokenswap-server/internal/api/handlers"
 	"github.com/rocky2015aaa/tokenswap-server/internal/config"
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/matching"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reconciliation"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/refund"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/scheduler"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/settlement"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/watcher"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type TokenMonitor struct {
-	TargetAddress string
-	Monitor       func(ctx context.Context, db *mongo.Client, tokenswapAddress string, sig chan os.Signal)
+const (
+	xelisMainnetAddressPattern = `^xel:[a-z0-9]{59}$`
+	xelisTestnetAddressPattern = `^xet:[a-z0-9]{59}$`
+	evmAddressPattern          = `^0x[0-9a-fA-F]{40}$`
+)
+
+// demoOrderCommonInfo seeds the in-memory storage so the API is usable without MongoDB.
+var demoOrderCommonInfo = database.OrderCommonInfo{
+	Fee:    decimal.MustParse("0.1"),
+	Pairs:  []string{"XEL/USDT", "XEL/USDC"},
+	Chains: []string{"ethereum"},
+	Tokens: []*database.Token{
+		{Symbol: "XEL", Chain: "xelis", Network: "mainnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: xelisMainnetAddressPattern, Confirmations: 8},
+		{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: xelisTestnetAddressPattern, Confirmations: 8},
+		{Symbol: "USDT", Chain: "ethereum", Network: "mainnet", Standard: registry.StandardERC20, Decimals: 6, Contract: "0xdAC17F958D2ee523a2206206994597C13D831ec7", AddressPattern: evmAddressPattern, Confirmations: 12},
+		{Symbol: "USDC", Chain: "ethereum", Network: "mainnet", Standard: registry.StandardERC20, Decimals: 6, Contract: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", AddressPattern: evmAddressPattern, Confirmations: 12},
+		{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, Contract: "0xAA0d26EF9bCFD7536604017D5796109B1A12f844", AddressPattern: evmAddressPattern, Confirmations: 3},
+		{Symbol: "USDC", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, Contract: "0xb2619b4cDB731d32997f052BB432E46339e5e1C9", AddressPattern: evmAddressPattern, Confirmations: 3},
+	},
+	DepositTimeout: 10,
 }
 
 func NewApp(sig chan os.Signal) *http.Server {
-	ctx := context.Background()
-	db, err := database.NewMongoDB(os.Getenv(config.EnvStsvrMongodbUri))
+	// Background workers stop once the shutdown signal is received
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sig
+		cancel()
+	}()
+	store, err := newStore(ctx)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	orderCommonInfo := database.OrderCommonInfo{}
-	options := options.FindOneOptions{}
-	collection := db.Database(database.tokenswapDatabase).Collection(database.OrderCommonInfoCollection)
-	err = collection.FindOne(context.TODO(), bson.D{{}}, &options).Decode(&orderCommonInfo)
+	orderCommonInfo, err := store.CommonInfo.Get(ctx)
 	if err != nil {
+		if err == database.ErrNoDocuments {
+			log.Fatalln("The order common info is missing, run stsvr migrate -seed FILE to set up the database.")
+		}
 		log.Fatalln(err)
 	}
 
-	tokenList := map[string]*TokenMonitor{}
-	for _, tokenPair := range orderCommonInfo.Pairs {
-		availabletokens := strings.Split(tokenPair, "/")
-		for _, token := range availabletokens {
-			if _, exists := tokenList[token]; !exists {
-				// if token == "XEL" {
-				// 	tokenList[token] = &TokenMonitor{
-				// 		TargetAddress: orderCommonInfo.XelisWalletAddress,
-				// 		Monitor:       monitorXeltokenswapTranscations,
-				// 	}
-				// } else
-				if token == "USDT" {
-					tokenList[token] = &TokenMonitor{
-						TargetAddress: orderCommonInfo.UsdtWalletAddress,
-						Monitor:       monitorUSDTtokenswapTransactions,
-					}
-				}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-			}
+	// The order changes of the handlers and the workers are streamed to the
+	// clients, and sent to the webhooks of the users from the deliveries
+	// recorded with them
+	bus := events.NewBus()
+	webhooks := webhook.NewDispatcher(store)
+	go webhooks.Run(ctx)
+	watchers, walletReaders := newChainWatchers(ctx, store, orderCommonInfo, tokens)
+	for _, chainWatcher := range watchers {
+		err := chainWatcher.Start(ctx)
+		if err != nil {
+			log.Fatalln(err)
 		}
 	}
-	for _, monitorToken := range tokenList {
-		go func(monitorTransactions *TokenMonitor) {
-			monitorTransactions.Monitor(ctx, db, monitorTransactions.TargetAddress, sig)
-		}(monitorToken)
+	go consumeDeposits(ctx, store, tokens, bus, watcher.Merge(ctx, watchers...))
+	senders := newWalletSenders(ctx, tokens)
+	settlementEngine := settlement.NewEngine(store, senders)
+	go settlementEngine.Run(ctx)
+	refundEngine := refund.NewEngine(store, senders)
+	go refundEngine.Run(ctx)
+	timeoutScheduler := scheduler.NewTimeoutScheduler(store, bus)
+	go timeoutScheduler.Run(ctx)
+	reconciler := reconciliation.NewReconciler(store, walletReaders)
+	go reconciler.Run(ctx)
+	matchingEngine := os.Getenv(config.EnvStsvrMatchingEngine) == "true"
+	if matchingEngine {
+		go matching.NewEngine(store, bus).Run(ctx)
 	}
 
 	return &http.Server{
 		Addr:    ":" + os.Getenv(config.EnvStsvrPort),
-		Handler: NewRouter(handlers.NewHandler(db)),
+		Handler: NewRouter(handlers.NewHandler(store, reference.NewIssuer(store, newXelisWallets(ctx, tokens)), reconciler, bus, webhooks, newMailer(), matchingEngine)),
+	}
+}
+
+func newStore(ctx context.Context) (*database.Store, error) {
+	if os.Getenv(config.EnvStsvrStorage) == config.StorageMemory {
+		log.Warn("Using in-memory storage. Data will be lost on shutdown.")
+		store := database.NewMemoryStore()
+		err := store.CommonInfo.Set(ctx, &demoOrderCommonInfo)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	db, err := database.NewMongoDB(os.Getenv(config.EnvStsvrMongodbUri))
+	if err != nil {
+		return nil, err
 	}
+	return database.NewMongoStore(db), nil
 }
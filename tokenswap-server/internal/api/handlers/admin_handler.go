@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/refund"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/utils"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
+)
+
+const (
+	defaultReportLimit = 20
+	maxReportLimit     = 100
+)
+
+var (
+	ErrFillSettlementFailed = errors.New("the order has fills whose settlement failed")
+	ErrNoPayoutsToResolve   = errors.New("the order has no payouts to resolve")
+	ErrPayoutTxHashMissing  = errors.New("a failed payout has no tx_hash")
+	ErrOwnRoleChange        = errors.New("an admin cannot change their own role")
+)
+
+// GetReconciliationReports lists the latest reconciliation reports, up to the
+// limit query parameter.
+func (h *Handler) GetReconciliationReports(ctx *gin.Context) {
+	limit := defaultReportLimit
+	if limitParam := ctx.Query("limit"); len(limitParam) > 0 {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxReportLimit {
+			err := fmt.Errorf("the limit must be between 1 and %d", maxReportLimit)
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	reports, err := h.Store.Reconciliations.FindLatest(ctx, limit)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the reconciliation reports has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, reports, "", "Getting the reconciliation reports has succeeded"))
+}
+
+func (h *Handler) GetReconciliationReport(ctx *gin.Context) {
+	report, err := h.Store.Reconciliations.FindOne(ctx, ctx.Param("id"))
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, err.Error(), "The reconciliation report is not found"))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the reconciliation report has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, report, "", "Getting the reconciliation report has succeeded"))
+}
+
+// RunReconciliation reconciles the service wallets right away instead of
+// waiting for the next periodic run.
+func (h *Handler) RunReconciliation(ctx *gin.Context) {
+	report, err := h.Reconciler.Reconcile(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Reconciling the service wallets has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, report, "", "Reconciling the service wallets has succeeded"))
+}
+
+// UpdateOrderCommonInfo changes the fee, the pairs, the chains, the deposit
+// timeout and the service wallet addresses of the tokens. New orders use them
+// right away, but the chain watchers and the wallet senders keep the wallet
+// addresses they were started with until the server is restarted.
+func (h *Handler) UpdateOrderCommonInfo(ctx *gin.Context) {
+	req := OrderCommonInfoUpdate{}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	var orderCommonInfo *database.OrderCommonInfo
+	err = h.Store.WithTransaction(ctx, func(txCtx context.Context) error {
+		orderCommonInfo, err = h.getOrderCommonInfo(txCtx)
+		if err != nil {
+			return err
+		}
+		err = applyOrderCommonInfoUpdate(orderCommonInfo, &req)
+		if err != nil {
+			return err
+		}
+		return h.Store.CommonInfo.Set(txCtx, orderCommonInfo)
+	})
+	if err != nil {
+		log.Error(err)
+		var validationErr *orderCommonInfoError
+		if errors.As(err, &validationErr) {
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Updating the order common data has failed"))
+		return
+	}
+	log.Infof("The order common data has been updated by %s", ctx.GetString("uuid"))
+	ctx.JSON(http.StatusOK, getResponse(true, orderCommonInfo, "", "Updating the order common data has succeeded"))
+}
+
+// orderCommonInfoError is an update of the order common info which does not validate.
+type orderCommonInfoError struct {
+	msg string
+}
+
+func (e *orderCommonInfoError) Error() string {
+	return e.msg
+}
+
+func newOrderCommonInfoError(format string, args ...interface{}) error {
+	return &orderCommonInfoError{msg: fmt.Sprintf(format, args...)}
+}
+
+// applyOrderCommonInfoUpdate applies the fields set in the update to the
+// order common info and checks the result.
+func applyOrderCommonInfoUpdate(orderCommonInfo *database.OrderCommonInfo, update *OrderCommonInfoUpdate) error {
+	if update.Fee != nil {
+		orderCommonInfo.Fee = *update.Fee
+	}
+	if update.DepositTimeout != nil {
+		orderCommonInfo.DepositTimeout = *update.DepositTimeout
+	}
+	if update.Chains != nil {
+		orderCommonInfo.Chains = update.Chains
+	}
+	if update.Pairs != nil {
+		orderCommonInfo.Pairs = update.Pairs
+	}
+	for _, walletAddress := range update.WalletAddresses {
+		token := findToken(orderCommonInfo.Tokens, walletAddress.Symbol, walletAddress.Chain, walletAddress.Network)
+		if token == nil {
+			return newOrderCommonInfoError("%s: %s on %s %s", registry.ErrUnknownToken, walletAddress.Symbol, walletAddress.Chain, walletAddress.Network)
+		}
+		if len(walletAddress.WalletAddress) == 0 {
+			return newOrderCommonInfoError("the wallet address of %s on %s %s is empty", token.Symbol, token.Chain, token.Network)
+		}
+		token.WalletAddress = utils.NormalizeWalletAddress(walletAddress.WalletAddress)
+	}
+	_, err := registry.ValidateOrderCommonInfo(orderCommonInfo)
+	if err != nil {
+		return newOrderCommonInfoError("%s", err.Error())
+	}
+	return nil
+}
+
+func findToken(tokens []*database.Token, symbol, chain, network string) *database.Token {
+	for _, token := range tokens {
+		if token.Symbol == symbol && token.Chain == chain && token.Network == network {
+			return token
+		}
+	}
+	return nil
+}
+
+// ForceCancelOrder cancels an order on behalf of its user and sends back the
+// deposits which arrived for it. Unlike CancelOrder, it also cancels a fill
+// waiting for its taker deposit, which gives the fill amount back to its order.
+// An order whose fills are in progress cannot be cancelled, and a partially
+// filled order only gets back what is left of it.
+func (h *Handler) ForceCancelOrder(ctx *gin.Context) {
+	req := struct {
+		OrderID string `json:"order_id"`
+	}{}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	cancelledEvents := []*events.Event{}
+	err = h.Store.WithTransaction(ctx, func(txCtx context.Context) error {
+		cancelledEvents = []*events.Event{}
+		orderData, err := h.Store.Orders.FindOne(txCtx, &database.OrderFilter{ID: req.OrderID})
+		if err != nil {
+			return err
+		}
+		if orderData.Order == nil {
+			return fmt.Errorf("order %s has no order data", orderData.ID)
+		}
+		// The maker deposit is still needed by the fills in progress
+		if len(orderData.ParentID) == 0 && database.FillsInProgress(orderData) {
+			return ErrOrderFillInProgress
+		}
+		cancelledData, err := database.TransitionOrder(txCtx, h.Store.Orders, &database.OrderFilter{ID: orderData.ID, Status: orderData.Status}, database.OrderStatusType5)
+		if err != nil {
+			return err
+		}
+		err = h.Store.Orders.SetDepositDeadline(txCtx, orderData.ID, nil)
+		if err != nil {
+			return err
+		}
+		parentUsers := []string{}
+		if len(orderData.ParentID) > 0 {
+			parentData, err := database.FillOrder(txCtx, h.Store.Orders, &database.OrderFilter{ID: orderData.ParentID}, decimal.Zero(), orderData.Amount)
+			if err != nil {
+				return err
+			}
+			parentUsers = append(parentUsers, parentData.UserUUID)
+		}
+		cancelledEvents = append(cancelledEvents, events.NewOrderEvent(events.OrderCancelled, cancelledData, parentUsers...))
+		err = refund.QueueOrderRefunds(txCtx, h.Store, orderData.ID, "")
+		if err != nil {
+			return err
+		}
+		return webhook.QueueEvents(txCtx, h.Store, cancelledEvents...)
+	})
+	if err != nil {
+		log.Error(err)
+		if errors.Is(err, database.ErrInvalidTransition) || err == ErrOrderFillInProgress {
+			ctx.JSON(http.StatusConflict,
+				getResponse(false, nil, err.Error(), "The order cannot be cancelled"))
+			return
+		}
+		if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound,
+				getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Failed to update the order status"))
+		return
+	}
+	h.Events.Publish(cancelledEvents...)
+	log.Infof("Order %s has been cancelled by %s", req.OrderID, ctx.GetString("uuid"))
+	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Cancelling the order has succeeded"))
+}
+
+// ResolveOrder settles an order whose settlement failed once its failed
+// payouts were sent by hand. Every failed payout needs the transaction it was
+// sent with, and an order taken in fills can only be resolved once none of its
+// fills has failed anymore.
+func (h *Handler) ResolveOrder(ctx *gin.Context) {
+	req := struct {
+		OrderID string              `json:"order_id"`
+		Payouts []*PayoutResolution `json:"payouts"`
+	}{}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	var orderData *database.OrderData
+	err = h.Store.WithTransaction(ctx, func(txCtx context.Context) error {
+		orderData, err = h.Store.Orders.FindOne(txCtx, &database.OrderFilter{ID: req.OrderID, Status: database.OrderStatusType9})
+		if err != nil {
+			return err
+		}
+		fills, err := h.Store.Orders.Find(txCtx, &database.OrderFilter{ParentID: orderData.ID})
+		if err != nil {
+			return err
+		}
+		for _, fill := range fills {
+			if fill.Status == database.OrderStatusType9 {
+				return ErrFillSettlementFailed
+			}
+		}
+		if len(orderData.Payouts) == 0 && len(fills) == 0 {
+			return ErrNoPayoutsToResolve
+		}
+		resolvedPayouts, err := resolvePayouts(orderData.Payouts, req.Payouts)
+		if err != nil {
+			return err
+		}
+		err = h.Store.Orders.UpdatePayouts(txCtx, orderData.ID, database.OrderStatusType9, orderData.Payouts, orderData.FeeRevenue, database.OrderStatusType8)
+		if err != nil {
+			return err
+		}
+		return ledger.RecordPayoutResolution(txCtx, h.Store.Journal, orderData, resolvedPayouts)
+	})
+	if err != nil {
+		log.Error(err)
+		if err == ErrFillSettlementFailed || err == ErrNoPayoutsToResolve {
+			ctx.JSON(http.StatusConflict,
+				getResponse(false, nil, err.Error(), "The order cannot be resolved"))
+			return
+		}
+		if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound,
+				getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		if errors.Is(err, ErrPayoutTxHashMissing) {
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Failed to update the order status"))
+		return
+	}
+	log.Infof("Order %s has been resolved by %s", orderData.ID, ctx.GetString("uuid"))
+	ctx.JSON(http.StatusOK, getResponse(true, orderData.Payouts, "", "Resolving the order has succeeded"))
+}
+
+// resolvePayouts sets the transaction of every payout without one from the
+// resolutions, and returns the payouts it set.
+func resolvePayouts(payouts []*database.Payout, resolutions []*PayoutResolution) ([]*database.Payout, error) {
+	resolvedPayouts := []*database.Payout{}
+	payoutDateTime := time.Now().Format(database.TimeFormat)
+	for _, payout := range payouts {
+		if len(payout.TxHash) > 0 {
+			continue
+		}
+		for _, resolution := range resolutions {
+			if resolution.Role == payout.Role && resolution.Token == payout.Token && len(resolution.TxHash) > 0 {
+				payout.TxHash = resolution.TxHash
+				payout.PayoutDateTime = payoutDateTime
+				break
+			}
+		}
+		if len(payout.TxHash) == 0 {
+			return nil, fmt.Errorf("%w: the %s payout of %s %s", ErrPayoutTxHashMissing, payout.Role, payout.Amount, payout.Token)
+		}
+		resolvedPayouts = append(resolvedPayouts, payout)
+	}
+	return resolvedPayouts, nil
+}
+
+// GetUsers lists the users, or the user with the email query parameter.
+func (h *Handler) GetUsers(ctx *gin.Context) {
+	users, err := h.Store.Users.Find(ctx, &database.UserFilter{Email: ctx.Query("email")})
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the users has failed"))
+		return
+	}
+	response := make([]*AdminUserInformation, 0, len(users))
+	for _, user := range users {
+		response = append(response, &AdminUserInformation{
+			UUID:                         user.UUID,
+			Email:                        user.Email,
+			Role:                         userRole(user),
+			TokenExpirationTimeInSeconds: user.TokenExpirationTimeInSeconds,
+			RegistrationDateTime:         user.RegistrationDateTime,
+			UpdateDateTime:               user.UpdateDateTime,
+		})
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, response, "", "Getting the users has succeeded"))
+}
+
+// UpdateUserRole changes the role of a user. The admin routes check the role
+// in the database, so it takes effect right away.
+func (h *Handler) UpdateUserRole(ctx *gin.Context) {
+	req := struct {
+		UUID string `json:"uuid"`
+		Role string `json:"role"`
+	}{}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	if req.Role != database.UserRoleUser && req.Role != database.UserRoleAdmin {
+		err := fmt.Errorf("the role value in the request is invalid")
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	// An admin demoting themselves could leave no admin
+	if req.UUID == ctx.GetString("uuid") {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrOwnRoleChange.Error(), ErrOwnRoleChange.Error()))
+		return
+	}
+	err = h.Store.Users.UpdateRole(ctx, req.UUID, req.Role, time.Now().Format(database.TimeFormat))
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Updating the user role has failed"))
+		return
+	}
+	log.Infof("User %s has been given the %s role by %s", req.UUID, req.Role, ctx.GetString("uuid"))
+	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Updating the user role has succeeded"))
+}
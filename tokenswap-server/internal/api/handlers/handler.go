@@ -4,7 +4,13 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/mailer"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reconciliation"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
 )
 
 const (
@@ -14,13 +20,28 @@ const (
 	responseDescription = "description"
 )
 
+// Handler serves the API. When MatchingEngine is set, public orders are
+// crossed by the matching engine and cannot be taken manually. The order
+// changes made by the handlers are published on Events.
 type Handler struct {
-	Database *mongo.Client
+	Store          *database.Store
+	References     *reference.Issuer
+	Reconciler     *reconciliation.Reconciler
+	Events         *events.Bus
+	Webhooks       *webhook.Dispatcher
+	Mailer         mailer.Mailer
+	MatchingEngine bool
 }
 
-func NewHandler(database *mongo.Client) *Handler {
+func NewHandler(store *database.Store, references *reference.Issuer, reconciler *reconciliation.Reconciler, bus *events.Bus, webhooks *webhook.Dispatcher, mailer mailer.Mailer, matchingEngine bool) *Handler {
 	return &Handler{
-		Database: database,
+		Store:          store,
+		References:     references,
+		Reconciler:     reconciler,
+		Events:         bus,
+		Webhooks:       webhooks,
+		Mailer:         mailer,
+		MatchingEngine: matchingEngine,
 	}
 }
 
@@ -2,20 +2,27 @@ package handlers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/rocky2015aaa/tokenswap-server/internal/config"
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/utils"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -28,13 +35,55 @@ func createUser(currentTime time.Time, email, password string) (*database.User,
 		UUID:                         uuid.New().String(),
 		Email:                        email,
 		Password:                     string(hashedPassword),
+		Role:                         registrationRole(email),
 		TokenExpirationTimeInSeconds: jwtAccessTokenExpiration,
 		RegistrationDateTime:         currentTime.Format(database.TimeFormat),
 		UpdateDateTime:               currentTime.Format(database.TimeFormat),
 	}, nil
 }
 
-func generateTokens(uuid string, generateRefreshToken bool, currentTime time.Time, accessTokenExpirationTime, refreshTokenExpirationTime int) (string, string, error) {
+// registrationRole returns the role of a user registering with the email.
+func registrationRole(email string) string {
+	for _, adminEmail := range strings.Split(os.Getenv(config.EnvStsvrAdminEmails), ",") {
+		if strings.EqualFold(strings.TrimSpace(adminEmail), email) {
+			return database.UserRoleAdmin
+		}
+	}
+	return database.UserRoleUser
+}
+
+// userRole returns the role of a user, users registered before roles have the
+// user role.
+func userRole(user *database.User) string {
+	if len(user.Role) == 0 {
+		return database.UserRoleUser
+	}
+	return user.Role
+}
+
+// newVerificationCode returns a random code of the digits.
+func newVerificationCode(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + digit.Int64())
+	}
+	return string(code), nil
+}
+
+func hashVerificationCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+func checkVerificationCode(codeHash, code string) bool {
+	return subtle.ConstantTimeCompare([]byte(codeHash), []byte(hashVerificationCode(strings.TrimSpace(code)))) == 1
+}
+
+func generateTokens(uuid, role string, generateRefreshToken bool, currentTime time.Time, accessTokenExpirationTime, refreshTokenExpirationTime int) (string, string, error) {
 	// Access Token
 	if accessTokenExpirationTime == 0 {
 		accessTokenExpirationTime = jwtAccessTokenExpiration
@@ -42,6 +91,7 @@ func generateTokens(uuid string, generateRefreshToken bool, currentTime time.Tim
 	accessTokenExpirationDateTime := currentTime.Add(time.Duration(accessTokenExpirationTime) * time.Second)
 	accessClaims := &Claims{
 		Username: uuid,
+		Role:     role,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: accessTokenExpirationDateTime.Unix(),
 		},
@@ -61,6 +111,7 @@ func generateTokens(uuid string, generateRefreshToken bool, currentTime time.Tim
 	refreshExpirationDateTime := currentTime.Add(time.Duration(refreshTokenExpirationTime) * time.Second)
 	refreshClaims := &Claims{
 		Username: uuid,
+		Role:     role,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: refreshExpirationDateTime.Unix(),
 		},
@@ -87,19 +138,12 @@ func getUUIDStrFromCtx(ctx *gin.Context) (string, error) {
 	return uuidStr, nil
 }
 
-func (h *Handler) getUserByFilter(filter primitive.M) (*database.User, error) {
-	user := database.User{}
-	options := options.FindOneOptions{}
-	collection := h.Database.Database(database.tokenswapDatabase).Collection(database.UserCollection)
-	err := collection.FindOne(context.TODO(), filter, &options).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+func (h *Handler) getUserByFilter(ctx context.Context, filter *database.UserFilter) (*database.User, error) {
+	return h.Store.Users.FindOne(ctx, filter)
 }
 
-func (h *Handler) getFilteredUserWithPassword(filter primitive.M, password string) (*database.User, error) {
-	user, err := h.getUserByFilter(filter)
+func (h *Handler) getFilteredUserWithPassword(ctx context.Context, filter *database.UserFilter, password string) (*database.User, error) {
+	user, err := h.getUserByFilter(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -110,73 +154,85 @@ func (h *Handler) getFilteredUserWithPassword(filter primitive.M, password strin
 	return user, nil
 }
 
-func (h *Handler) renewTokensAndUpdateExpirationTime(user *database.User, AccessTokenExpirationTimeInSeconds int, generateRefreshToken bool) (string, string, *mongo.UpdateResult, error) {
+func (h *Handler) renewTokensAndUpdateExpirationTime(ctx context.Context, user *database.User, AccessTokenExpirationTimeInSeconds int, generateRefreshToken bool) (string, string, error) {
 	currentTime := time.Now()
-	accessToken, refreshToken, err := generateTokens(user.UUID, generateRefreshToken, currentTime, AccessTokenExpirationTimeInSeconds, 2*AccessTokenExpirationTimeInSeconds)
+	accessToken, refreshToken, err := generateTokens(user.UUID, userRole(user), generateRefreshToken, currentTime, AccessTokenExpirationTimeInSeconds, 2*AccessTokenExpirationTimeInSeconds)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("failed to generate tokens. %s", err.Error())
+		return "", "", fmt.Errorf("failed to generate tokens. %s", err.Error())
 	}
 	// Update user token expiration in DB
-	updateData := bson.M{
-		"$set": bson.M{
-			"token_expiration_time_in_seconds": AccessTokenExpirationTimeInSeconds,
-			"update_date_time":                 currentTime.Format(database.TimeFormat),
-		},
+	err = h.Store.Users.UpdateTokenExpiration(ctx, user.UUID, AccessTokenExpirationTimeInSeconds, currentTime.Format(database.TimeFormat))
+	if err != nil {
+		if err == database.ErrNonUpdated {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("failed to Update user data with tokens failed. %s", err.Error())
 	}
-	updateResult, err := h.Database.Database(database.tokenswapDatabase).Collection(database.UserCollection).UpdateOne(context.TODO(), bson.M{"uuid": user.UUID}, updateData)
+	return accessToken, refreshToken, nil
+}
+
+func (h *Handler) getOrderCommonInfo(ctx context.Context) (*database.OrderCommonInfo, error) {
+	return h.Store.CommonInfo.Get(ctx)
+}
+
+func (h *Handler) tokenRegistry(ctx context.Context) (*registry.Registry, error) {
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("failed to Update user data with tokens failed. %s", err.Error())
+		return nil, err
 	}
-	return accessToken, refreshToken, updateResult, nil
+	return registry.New(orderCommonInfo.Tokens)
 }
 
-func (h *Handler) getOrderCommonInfo() (*database.OrderCommonInfo, error) {
-	orderCommonInfo := database.OrderCommonInfo{}
-	options := options.FindOneOptions{}
-	collection := h.Database.Database(database.tokenswapDatabase).Collection(database.OrderCommonInfoCollection)
-	err := collection.FindOne(context.TODO(), bson.D{{}}, &options).Decode(&orderCommonInfo)
+func (h *Handler) issueDepositTarget(ctx context.Context, orderID, role string, order *database.Order, feeRate decimal.Amount) (*reference.Target, error) {
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &orderCommonInfo, nil
-}
-
-func checkOrderTimeout(h *Handler, orderID, status string) {
-	go func(h *Handler, orderID, status string) {
-		timeoutCh := time.After(orderTimeout * time.Second)
-		for {
-			select {
-			case <-timeoutCh:
-				orderData := database.OrderData{}
-				filter := bson.M{"id": orderID, "order.status": status}
-				options := options.FindOneOptions{}
-				collection := h.Database.Database(database.tokenswapDatabase).Collection(database.OrderCollection)
-				err := collection.FindOne(context.TODO(), filter, &options).Decode(&orderData)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-				filter = bson.M{"id": orderData.ID}
-				err = database.UpdateOrderStatus(h.Database, filter, database.OrderStatusType4)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-				log.Infof("Order %s has timed out.", orderData.ID)
-				return
-			}
-		}
-	}(h, orderID, status)
+	return h.References.Issue(ctx, orderCommonInfo, orderID, role, order, feeRate)
 }
 
-func (h *Handler) orderRequestValidator(req *OrderRequest) error {
-	orderCommonInfo, err := h.getOrderCommonInfo()
+// depositDeadline returns when a deposit requested now has to arrive.
+func (h *Handler) depositDeadline(ctx context.Context) (*time.Time, error) {
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(time.Duration(orderCommonInfo.DepositTimeout) * time.Minute)
+	return &deadline, nil
+}
+
+func (h *Handler) orderRequestValidator(ctx context.Context, req *OrderRequest) error {
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
+	if err != nil {
+		return err
+	}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		return err
+	}
+	err = orderTermsValidator(orderCommonInfo, tokens, req.Order)
+	if err != nil {
+		return err
+	}
+	if _, ok := orderVisibilityTypes[req.Visibility]; !ok {
+		return fmt.Errorf("the visibility value in the request is invalid")
+	}
+	if _, ok := orderStatusTypes[req.Status]; !ok {
+		return fmt.Errorf("the status value in the request is invalid")
+	}
+	err = validateParticipantAddresses(tokens, req.Order, database.ParticipantRoleMaker, req.OrdererWalletAddress, req.PayoutWalletAddress)
 	if err != nil {
 		return err
 	}
+	// TODO: add referral validation
+	return nil
+}
+
+// orderTermsValidator checks what an order trades, which is all a fee quote needs.
+func orderTermsValidator(orderCommonInfo *database.OrderCommonInfo, tokens *registry.Registry, order *database.Order) error {
 	validPair := false
 	for _, pair := range orderCommonInfo.Pairs {
-		if req.Pair == pair {
+		if order.Pair == pair {
 			validPair = true
 			break
 		}
@@ -186,7 +242,7 @@ func (h *Handler) orderRequestValidator(req *OrderRequest) error {
 	}
 	validChain := false
 	for _, chain := range orderCommonInfo.Chains {
-		if req.Chain == chain {
+		if order.Chain == chain {
 			validChain = true
 			break
 		}
@@ -194,37 +250,166 @@ func (h *Handler) orderRequestValidator(req *OrderRequest) error {
 	if !validChain {
 		return fmt.Errorf("the chain value in the request is invalid")
 	}
-	if _, ok := orderTypes[req.Type]; !ok {
+	if _, ok := orderTypes[order.Type]; !ok {
 		return fmt.Errorf("the type value in the request is invalid")
 	}
-	if _, ok := orderNetworkTypes[req.Network]; !ok {
+	if _, ok := orderNetworkTypes[order.Network]; !ok {
 		return fmt.Errorf("the network value in the request is invalid")
 	}
-	if _, ok := orderfeePayerTypes[req.FeePayerType]; !ok {
+	if _, ok := orderfeePayerTypes[order.FeePayerType]; !ok {
 		return fmt.Errorf("the fee_payer_type value in the request is invalid")
 	}
-	if _, ok := orderVisibilityTypes[req.Visibility]; !ok {
-		return fmt.Errorf("the visibility value in the request is invalid")
-	}
-	if _, ok := orderStatusTypes[req.Status]; !ok {
-		return fmt.Errorf("the status value in the request is invalid")
-	}
-	if !(utils.ValidateDecimal1or2Places(req.Price) && req.Price > 0) {
+	if !(utils.ValidateDecimal1or2Places(order.Price) && order.Price.Sign() > 0) {
 		return fmt.Errorf("the price value in the request is invalid")
 	}
-	if !(utils.ValidateDecimal1or2Places(req.Amount) && req.Amount >= minimumOrderAmount) {
+	if !(utils.ValidateDecimal1or2Places(order.Amount) && order.Amount.Cmp(minimumOrderAmount) >= 0) {
 		return fmt.Errorf("the amount value in the request is invalid")
 	}
-	tokens := strings.Split(req.Pair, "/")
-	tokenName := ""
-	if req.Type == orderType1 {
-		tokenName = tokens[1]
-	} else if req.Type == orderType2 {
-		tokenName = tokens[0]
+	return validateDepositAmounts(tokens, order)
+}
+
+// takeAmountValidator checks the amount taken of an order. Less than the
+// minimum order amount can only be taken when it is all that is left.
+func takeAmountValidator(amount, remainingAmount decimal.Amount) bool {
+	if !utils.ValidateDecimal1or2Places(amount) || amount.Sign() <= 0 || amount.Cmp(remainingAmount) > 0 {
+		return false
+	}
+	return amount.Cmp(minimumOrderAmount) >= 0 || amount.Equal(remainingAmount)
+}
+
+// validateDepositAmounts checks that what each side trades can be transferred
+// exactly with the decimals of its token. Fees are rounded up to them.
+func validateDepositAmounts(tokens *registry.Registry, order *database.Order) error {
+	for _, role := range []string{database.ParticipantRoleMaker, database.ParticipantRoleTaker} {
+		depositToken, err := orderToken(tokens, order, role, fee.DepositToken)
+		if err != nil {
+			return err
+		}
+		orderLeg, err := fee.OrderLeg(order, role)
+		if err != nil {
+			return err
+		}
+		if orderLeg.TradeAmount.DecimalPlaces() > depositToken.Decimals {
+			return fmt.Errorf("the %s amount of the order has more than %d decimal places", depositToken.Symbol, depositToken.Decimals)
+		}
 	}
-	if !utils.ValidateTokenAddress(tokenName, req.OrdererWalletAddress) {
+	return nil
+}
+
+// orderToken looks up the token one side of the order deposits or receives.
+func orderToken(tokens *registry.Registry, order *database.Order, role string, legToken func(*database.Order, string) (string, error)) (*database.Token, error) {
+	symbol, err := legToken(order, role)
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokens.OrderToken(symbol, order.Chain, order.Network)
+	if err != nil {
+		return nil, fmt.Errorf("the %s token is not available on %s %s", symbol, order.Chain, order.Network)
+	}
+	return token, nil
+}
+
+func validateParticipantAddresses(tokens *registry.Registry, order *database.Order, role, depositAddress, payoutAddress string) error {
+	depositToken, err := orderToken(tokens, order, role, fee.DepositToken)
+	if err != nil {
+		return err
+	}
+	if !tokens.ValidateAddress(depositToken, depositAddress) {
 		return fmt.Errorf("the token address the request is invalid")
 	}
-	// TODO: add referral validation
+	payoutToken, err := orderToken(tokens, order, role, fee.PayoutToken)
+	if err != nil {
+		return err
+	}
+	if !tokens.ValidateAddress(payoutToken, payoutAddress) {
+		return fmt.Errorf("the payout token address the request is invalid")
+	}
 	return nil
 }
+
+// orderListItemFields are the fields of the order list added to the orders
+// which can be asked for besides the fields of the orders.
+var orderListItemFields = map[string]struct{}{"trades": {}, "fills": {}, "refunds": {}}
+
+// projectOrderListItems keeps the given fields of the order list items and
+// their ID.
+func projectOrderListItems(orderList []*OrderListItem, fields []string) ([]map[string]json.RawMessage, error) {
+	projectedList := []map[string]json.RawMessage{}
+	for _, orderListItem := range orderList {
+		data, err := json.Marshal(orderListItem)
+		if err != nil {
+			return nil, err
+		}
+		item := map[string]json.RawMessage{}
+		err = json.Unmarshal(data, &item)
+		if err != nil {
+			return nil, err
+		}
+		projectedItem := map[string]json.RawMessage{"id": item["id"]}
+		for _, field := range fields {
+			if value, ok := item[field]; ok {
+				projectedItem[field] = value
+			}
+		}
+		projectedList = append(projectedList, projectedItem)
+	}
+	return projectedList, nil
+}
+
+var refundDepositStatuses = map[string]struct{}{database.DepositStatusRefundPending: {}, database.DepositStatusRefunding: {},
+	database.DepositStatusRefunded: {}, database.DepositStatusRefundFailed: {}}
+
+// orderListItems adds their trades, their fills and the refunds of their
+// deposits to the orders of the user.
+func (h *Handler) orderListItems(ctx context.Context, userUUID string, orders []*database.OrderData) ([]*OrderListItem, error) {
+	orderList := []*OrderListItem{}
+	for _, order := range orders {
+		orderListItem := &OrderListItem{OrderData: order}
+		orderList = append(orderList, orderListItem)
+		if order.UserUUID != userUUID {
+			continue
+		}
+		trades, err := h.Store.Trades.Find(ctx, &database.TradeFilter{OrderID: order.ID})
+		if err != nil {
+			return nil, err
+		}
+		orderListItem.Trades = trades
+		fills, err := h.Store.Orders.Find(ctx, &database.OrderFilter{ParentID: order.ID})
+		if err != nil {
+			return nil, err
+		}
+		for _, fill := range fills {
+			if fill.Order == nil {
+				continue
+			}
+			orderListItem.Fills = append(orderListItem.Fills, &FillInfo{
+				ID:               fill.ID,
+				Amount:           fill.Amount,
+				Status:           fill.Status,
+				CreationDateTime: fill.CreationDateTime,
+			})
+		}
+		deposits, err := h.Store.Deposits.Find(ctx, &database.DepositFilter{OrderID: order.ID})
+		if err != nil {
+			return nil, err
+		}
+		for _, deposit := range deposits {
+			if _, ok := refundDepositStatuses[deposit.Status]; !ok {
+				continue
+			}
+			refundInfo := &RefundInfo{
+				Role:   deposit.Role,
+				Token:  deposit.Token,
+				Amount: deposit.Amount,
+				Status: deposit.Status,
+			}
+			if deposit.Refund != nil {
+				refundInfo.WalletAddress = deposit.Refund.WalletAddress
+				refundInfo.TxHash = deposit.Refund.TxHash
+				refundInfo.Error = deposit.Refund.Error
+			}
+			orderListItem.Refunds = append(orderListItem.Refunds, refundInfo)
+		}
+	}
+	return orderList, nil
+}
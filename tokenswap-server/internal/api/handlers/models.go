@@ -2,26 +2,31 @@ package handlers
 
 import (
 	"github.com/dgrijalva/jwt-go"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
 )
 
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role"`
 	jwt.StandardClaims
 }
 
 type UserConfigInformation struct {
 	UUID                         string `json:"uuid"`
+	Role                         string `json:"role"`
 	TokenExpirationTimeInSeconds int    `json:"token_expiration_time_in_seconds"`
 	TokenExpirationDateTime      string `json:"token_expiration_date_time"`
 	RegistrationDateTime         string `json:"registration_date_time"`
+	EmailVerified                bool   `json:"email_verified"`
+	TwoFactorEnabled             bool   `json:"two_factor_enabled"`
 }
 
 type RenewTokensParam struct {
 	GenerateRefreshToken               bool
-	Filter                             primitive.M
+	Filter                             *database.UserFilter
 	ReqPassword                        string
 	AccessTokenExpirationTimeInSeconds int
 }
@@ -29,5 +34,107 @@ type RenewTokensParam struct {
 type OrderRequest struct {
 	*database.Order
 	OrdererWalletAddress string `json:"orderer_wallet_address"`
+	PayoutWalletAddress  string `json:"payout_wallet_address"`
 	Password             string `json:"password"`
+	OTPCode              string `json:"otp_code"`
+}
+
+// OrderFeeRequest asks for the fee quote of a new order, or of taking an amount
+// of the order OrderID. Without an amount, what is left of the order is quoted.
+type OrderFeeRequest struct {
+	*database.Order
+	OrderID string `json:"order_id"`
+}
+
+type OrderCreationResponse struct {
+	*database.OrderData
+	DepositTarget *reference.Target `json:"deposit_target"`
+}
+
+type OrderTakeResponse struct {
+	OrderID       string            `json:"order_id"`
+	FillID        string            `json:"fill_id"`
+	Amount        decimal.Amount    `json:"amount"`
+	DepositTarget *reference.Target `json:"deposit_target"`
+}
+
+type OrderListItem struct {
+	*database.OrderData
+	Trades  []*database.Trade `json:"trades,omitempty"`
+	Fills   []*FillInfo       `json:"fills,omitempty"`
+	Refunds []*RefundInfo     `json:"refunds,omitempty"`
+}
+
+// OrderListPage is a page of the order list. Next is the cursor of the next
+// page, left out on the last page.
+type OrderListPage struct {
+	Orders interface{} `json:"orders"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// WebhookRequest registers a webhook. Events lists the order event types sent
+// to it, all of them when empty.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhookCreationResponse is the webhook registered with the secret its
+// payloads are signed with, which is not returned again.
+type WebhookCreationResponse struct {
+	*database.Webhook
+	Secret string `json:"secret"`
+}
+
+type FillInfo struct {
+	ID               string         `json:"id"`
+	Amount           decimal.Amount `json:"amount"`
+	Status           string         `json:"status"`
+	CreationDateTime string         `json:"create_date_time"`
+}
+
+type RefundInfo struct {
+	Role          string         `json:"role"`
+	Token         string         `json:"token"`
+	Amount        decimal.Amount `json:"amount"`
+	Status        string         `json:"status"`
+	WalletAddress string         `json:"wallet_address,omitempty"`
+	TxHash        string         `json:"tx_hash,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// OrderCommonInfoUpdate changes the order common info. Fields left out are
+// kept as they are.
+type OrderCommonInfoUpdate struct {
+	Fee             *decimal.Amount       `json:"fee"`
+	Pairs           []string              `json:"pairs"`
+	Chains          []string              `json:"chains"`
+	DepositTimeout  *int                  `json:"deposit_timeout"`
+	WalletAddresses []*TokenWalletAddress `json:"wallet_addresses"`
+}
+
+// TokenWalletAddress is the service wallet receiving the deposits of a token
+// on one chain and network.
+type TokenWalletAddress struct {
+	Symbol        string `json:"symbol"`
+	Chain         string `json:"chain"`
+	Network       string `json:"network"`
+	WalletAddress string `json:"wallet_address"`
+}
+
+// PayoutResolution is the transaction a failed payout of an order was sent
+// with by hand.
+type PayoutResolution struct {
+	Role   string `json:"role"`
+	Token  string `json:"token"`
+	TxHash string `json:"tx_hash"`
+}
+
+type AdminUserInformation struct {
+	UUID                         string `json:"uuid"`
+	Email                        string `json:"email"`
+	Role                         string `json:"role"`
+	TokenExpirationTimeInSeconds int    `json:"token_expiration_time_in_seconds"`
+	RegistrationDateTime         string `json:"registration_date_time"`
+	UpdateDateTime               string `json:"update_date_time"`
 }
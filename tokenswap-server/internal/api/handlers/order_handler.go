@@ -5,25 +5,37 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/refund"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/utils"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
-	ErrOrderNotFound = errors.New("order not found")
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrOrderMatchedByEngine = errors.New("public orders are matched by the matching engine")
+	ErrOrderFillInProgress  = errors.New("the order has fills waiting for the taker deposit")
 
 	orderTypes         = map[string]struct{}{orderType1: {}, orderType2: {}}
 	orderNetworkTypes  = map[string]struct{}{orderNetwork1: {}, orderNetwork2: {}}
 	orderfeePayerTypes = map[string]struct{}{orderfeePayerType1: {}, orderfeePayerType2: {}, orderfeePayerType3: {}}
 	orderStatusTypes   = map[string]struct{}{database.OrderStatusType1: {}, database.OrderStatusType2: {},
-		database.OrderStatusType3: {}, database.OrderStatusType4: {}, database.OrderStatusType5: {}, database.OrderStatusType6: {}}
+		database.OrderStatusType3: {}, database.OrderStatusType4: {}, database.OrderStatusType5: {}, database.OrderStatusType6: {},
+		database.OrderStatusType7: {}, database.OrderStatusType8: {}, database.OrderStatusType9: {}, database.OrderStatusType10: {}}
 	orderVisibilityTypes = map[string]struct{}{orderVisibilityTypes1: {}, orderVisibilityTypes2: {}}
+
+	minimumOrderAmount = decimal.NewFromInt(10)
 )
 
 const (
@@ -37,35 +49,53 @@ const (
 	orderVisibilityTypes1 = "public"
 	orderVisibilityTypes2 = "private"
 
-	minimumOrderAmount = 10
-	orderTimeout       = 120
+	defaultOrderListLimit = 50
+	maxOrderListLimit     = 200
 )
 
 func (h *Handler) GetOrderCommonInfo(ctx *gin.Context) {
-	// TODO: how to create order_common_info collection
 	uuidStr, err := getUUIDStrFromCtx(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
 		return
 	}
-	filter := bson.M{"uuid": uuidStr}
-	_, err = h.getUserByFilter(filter)
+	filter := &database.UserFilter{UUID: uuidStr}
+	_, err = h.getUserByFilter(ctx, filter)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		}
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	orderCommonInfo, err := h.getOrderCommonInfo()
+	// The tokens and their service wallets can be limited to one network
+	network := ctx.Query("network")
+	if len(network) > 0 {
+		if _, ok := orderNetworkTypes[network]; !ok {
+			err := fmt.Errorf("the network value in the request is invalid")
+			log.Error(err)
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Getting the order common data has failed"))
 		return
 	}
+	if len(network) > 0 {
+		networkTokens := []*database.Token{}
+		for _, token := range orderCommonInfo.Tokens {
+			if token.Network == network {
+				networkTokens = append(networkTokens, token)
+			}
+		}
+		orderCommonInfo.Tokens = networkTokens
+	}
 	orderCommonInfo.Types = orderTypes
 	orderCommonInfo.Networks = orderNetworkTypes
 	orderCommonInfo.FeePayerTypes = orderfeePayerTypes
@@ -74,8 +104,28 @@ func (h *Handler) GetOrderCommonInfo(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, getResponse(true, &orderCommonInfo, "", "Getting the order common data has succeeded"))
 }
 
+// GetOrderList lists a page of the orders. The orders are sorted by the sort
+// query parameter, then by ID, and the next cursor of the response is passed
+// as the after query parameter to get the following page.
 func (h *Handler) GetOrderList(ctx *gin.Context) {
-	// TODO: pagination
+	page, err := orderListPage(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	createdFrom, err := orderListTime(ctx.Query("from"), false)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), "the from value in the request is invalid"))
+		return
+	}
+	createdTo, err := orderListTime(ctx.Query("to"), true)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), "the to value in the request is invalid"))
+		return
+	}
 	visibility := ctx.Query("visibility")
 	if len(visibility) == 0 {
 		visibility = " public"
@@ -87,7 +137,7 @@ func (h *Handler) GetOrderList(ctx *gin.Context) {
 	}
 	chain := ctx.Query("chain")
 	if len(chain) > 0 {
-		orderCommonInfo, err := h.getOrderCommonInfo()
+		orderCommonInfo, err := h.getOrderCommonInfo(ctx)
 		if err != nil {
 			log.Error(err)
 			ctx.JSON(http.StatusInternalServerError,
@@ -109,7 +159,7 @@ func (h *Handler) GetOrderList(ctx *gin.Context) {
 	}
 	pair := ctx.Query("pair")
 	if len(pair) > 0 {
-		orderCommonInfo, err := h.getOrderCommonInfo()
+		orderCommonInfo, err := h.getOrderCommonInfo(ctx)
 		if err != nil {
 			log.Error(err)
 			ctx.JSON(http.StatusInternalServerError,
@@ -173,50 +223,48 @@ func (h *Handler) GetOrderList(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
 		return
 	}
-	filter := bson.M{"uuid": uuidStr}
-	user, err := h.getUserByFilter(filter)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getUserByFilter(ctx, filter)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		}
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	filter = bson.M{"order.visibility": "public", "order.network": network}
-	if len(orderId) > 0 {
-		filter["id"] = orderId
-	}
+	orderFilter := &database.OrderFilter{
+		ID:           orderId,
+		Visibility:   "public",
+		Network:      network,
+		Chain:        chain,
+		Pair:         pair,
+		Type:         orderType,
+		FeePayerType: feePayerType,
+		Status:       status,
+		CreatedFrom:  createdFrom,
+		CreatedTo:    createdTo,
+		ExcludeFills: true,
+	}
+	// The fills of an order are listed to its maker with the order, and to
+	// their takers with their own orders
 	if len(email) > 0 {
 		if user.Email != email {
 			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, "Different user email", "Different user email"))
 			return
 		}
-		filter["user_uuid"] = uuidStr
-		filter["order.visibility"] = visibility
-	}
-	if len(chain) > 0 {
-		filter["order.chain"] = chain
-	}
-	if len(pair) > 0 {
-		filter["order.pair"] = pair
-	}
-	if len(orderType) > 0 {
-		filter["order.type"] = orderType
-	}
-	if len(feePayerType) > 0 {
-		filter["order.fee_payer_type"] = feePayerType
-	}
-	if len(status) > 0 {
-		filter["order.status"] = status
-	}
-	orders := []*database.OrderData{}
-	collection := h.Database.Database(database.tokenswapDatabase).Collection(database.OrderCollection)
-	cursor, err := collection.Find(context.TODO(), filter)
+		orderFilter.UserUUID = uuidStr
+		orderFilter.Visibility = visibility
+		orderFilter.ExcludeFills = false
+	}
+	// One more order than the limit is read to know whether there is a next page
+	limit := page.Limit
+	page.Limit++
+	orders, err := h.Store.Orders.FindPage(ctx, orderFilter, page)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrOrderNotFound.Error(), ErrOrderNotFound.Error()))
 			return
 		}
@@ -224,12 +272,110 @@ func (h *Handler) GetOrderList(ctx *gin.Context) {
 			getResponse(false, nil, err.Error(), "Getting the order list data has failed"))
 		return
 	}
-	if err = cursor.All(context.TODO(), &orders); err != nil {
+	orderListPage := &OrderListPage{}
+	if len(orders) > limit {
+		orders = orders[:limit]
+		orderListPage.Next = database.NewOrderCursor(page.Sort, orders[limit-1]).Encode()
+	}
+	orderList, err := h.orderListItems(ctx, uuidStr, orders)
+	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
-			getResponse(false, nil, err.Error(), "Getting the order list data has failed"))
+			getResponse(false, nil, err.Error(), "Getting the order refund data has failed"))
+		return
+	}
+	orderListPage.Orders = orderList
+	if len(page.Fields) > 0 {
+		orderListPage.Orders, err = projectOrderListItems(orderList, orderListFields(ctx))
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError,
+				getResponse(false, nil, err.Error(), "Getting the order list data has failed"))
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, orderListPage, "", "Getting the order list data has succeeded"))
+}
+
+// orderListPage reads the page of the order list from the limit, after, sort,
+// order and fields query parameters.
+func orderListPage(ctx *gin.Context) (*database.OrderPage, error) {
+	page := &database.OrderPage{
+		Sort:       database.OrderSortCreationTime,
+		Descending: true,
+		Limit:      defaultOrderListLimit,
+	}
+	if limitParam := ctx.Query("limit"); len(limitParam) > 0 {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxOrderListLimit {
+			return nil, fmt.Errorf("the limit must be between 1 and %d", maxOrderListLimit)
+		}
+		page.Limit = limit
+	}
+	if sortKey := ctx.Query("sort"); len(sortKey) > 0 {
+		if !database.IsOrderSort(sortKey) {
+			return nil, fmt.Errorf("the sort value in the request is invalid")
+		}
+		page.Sort = sortKey
+	}
+	switch ctx.Query("order") {
+	case "", "desc":
+	case "asc":
+		page.Descending = false
+	default:
+		return nil, fmt.Errorf("the order value in the request must be asc or desc")
+	}
+	if after := ctx.Query("after"); len(after) > 0 {
+		cursor, err := database.DecodeOrderCursor(after, page.Sort)
+		if err != nil {
+			return nil, err
+		}
+		page.After = cursor
+	}
+	for _, field := range orderListFields(ctx) {
+		if database.IsOrderField(field) {
+			page.Fields = append(page.Fields, field)
+			continue
+		}
+		if _, ok := orderListItemFields[field]; !ok {
+			return nil, fmt.Errorf("the field %s in the request is invalid", field)
+		}
 	}
-	ctx.JSON(http.StatusOK, getResponse(true, &orders, "", "Getting the order list data has succeeded"))
+	if len(page.Fields) == 0 && len(orderListFields(ctx)) > 0 {
+		page.Fields = []string{"id"}
+	}
+	return page, nil
+}
+
+// orderListFields returns the comma separated fields query parameter.
+func orderListFields(ctx *gin.Context) []string {
+	fields := []string{}
+	for _, field := range strings.Split(ctx.Query("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if len(field) > 0 {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// orderListTime reads the from and to query parameters, given in RFC 3339 or as
+// a date. A date as the end of the range includes the whole day.
+func orderListTime(value string, endOfRange bool) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+		if err != nil {
+			return "", err
+		}
+		if endOfRange {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+	}
+	return t.In(time.Local).Format(database.TimeFormat), nil
 }
 
 func (h *Handler) CreateOrder(ctx *gin.Context) {
@@ -247,11 +393,11 @@ func (h *Handler) CreateOrder(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuidStr}
-	_, err = h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -261,7 +407,14 @@ func (h *Handler) CreateOrder(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	err = h.orderRequestValidator(&req)
+	if !user.EmailVerified {
+		ctx.JSON(http.StatusForbidden, getResponse(false, nil, ErrEmailNotVerified.Error(), ErrEmailNotVerified.Error()))
+		return
+	}
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
+	}
+	err = h.orderRequestValidator(ctx, &req)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
@@ -277,47 +430,58 @@ func (h *Handler) CreateOrder(ctx *gin.Context) {
 			getResponse(false, nil, err.Error(), "Order creation has failed"))
 		return
 	}
-	orderData := database.OrderData{
-		ID:               orderID,
-		UserUUID:         uuidStr,
-		Order:            req.Order,
-		CreationDateTime: currentTime.Format(database.TimeFormat),
-		UpdateDateTime:   currentTime.Format(database.TimeFormat),
-	}
-	orderWallet := database.OrdererParticipantWallet{
-		OrderID:                         orderID,
-		OrdererParticipantWalletAddress: req.OrdererWalletAddress,
-	}
-	session, err := h.Database.StartSession()
+	depositDeadline, err := h.depositDeadline(ctx)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Order creation has failed"))
 		return
 	}
-	err = session.StartTransaction()
+	// The order keeps the fee rate it was created with
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Order creation has failed"))
 		return
 	}
-	err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
-		_, err := h.Database.Database(database.tokenswapDatabase).Collection(database.OrderCollection).InsertOne(context.TODO(), orderData)
-		if err != nil {
-			return err
-		}
-
-		_, err = h.Database.Database(database.tokenswapDatabase).Collection(database.OrderParticipantWalletCollection).InsertOne(context.TODO(), orderWallet)
+	orderData := database.OrderData{
+		ID:               orderID,
+		UserUUID:         uuidStr,
+		Order:            req.Order,
+		RemainingAmount:  req.Amount,
+		FeeRate:          orderCommonInfo.Fee,
+		DepositDeadline:  depositDeadline,
+		CreationDateTime: currentTime.Format(database.TimeFormat),
+		UpdateDateTime:   currentTime.Format(database.TimeFormat),
+	}
+	depositTarget, err := h.issueDepositTarget(ctx, orderID, database.ParticipantRoleMaker, req.Order, orderData.FeeRate)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Issuing the deposit address has failed"))
+		return
+	}
+	orderWallet := database.OrdererParticipantWallet{
+		OrderID:                         orderID,
+		Role:                            database.ParticipantRoleMaker,
+		OrdererParticipantWalletAddress: utils.NormalizeWalletAddress(req.OrdererWalletAddress),
+		PayoutWalletAddress:             utils.NormalizeWalletAddress(req.PayoutWalletAddress),
+		DepositWalletAddress:            depositTarget.WalletAddress,
+		DepositAmount:                   depositTarget.Amount,
+		DepositReference:                depositTarget.Reference,
+	}
+	createdEvent := events.NewOrderEvent(events.OrderCreated, &orderData)
+	err = h.Store.WithTransaction(ctx, func(txCtx context.Context) error {
+		err := h.Store.Orders.Insert(txCtx, &orderData)
 		if err != nil {
 			return err
 		}
-
-		err = session.CommitTransaction(sc)
+		err = h.Store.ParticipantWallets.Insert(txCtx, &orderWallet)
 		if err != nil {
 			return err
 		}
-		return nil
+		return webhook.QueueEvents(txCtx, h.Store, createdEvent)
 	})
 	if err != nil {
 		log.Error(err)
@@ -325,9 +489,8 @@ func (h *Handler) CreateOrder(ctx *gin.Context) {
 			getResponse(false, nil, err.Error(), "Order creation has failed"))
 		return
 	}
-	session.EndSession(ctx)
-	checkOrderTimeout(h, orderID, database.OrderStatusType1)
-	ctx.JSON(http.StatusOK, getResponse(true, &orderData, "", "Creating an order has succeeded"))
+	h.Events.Publish(createdEvent)
+	ctx.JSON(http.StatusOK, getResponse(true, &OrderCreationResponse{OrderData: &orderData, DepositTarget: depositTarget}, "", "Creating an order has succeeded"))
 }
 
 func (h *Handler) TakeOrder(ctx *gin.Context) {
@@ -337,9 +500,12 @@ func (h *Handler) TakeOrder(ctx *gin.Context) {
 		return
 	}
 	req := struct {
-		OrderID           string `json:"order_id"`
-		OrderTakerAddress string `json:"ordertaker_address"`
-		Password          string `json:"password"`
+		OrderID                 string         `json:"order_id"`
+		OrderTakerAddress       string         `json:"ordertaker_address"`
+		OrderTakerPayoutAddress string         `json:"ordertaker_payout_address"`
+		Amount                  decimal.Amount `json:"amount"`
+		Password                string         `json:"password"`
+		OTPCode                 string         `json:"otp_code"`
 	}{}
 	err = ctx.BindJSON(&req)
 	if err != nil {
@@ -349,11 +515,11 @@ func (h *Handler) TakeOrder(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuidStr}
-	_, err = h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -363,44 +529,133 @@ func (h *Handler) TakeOrder(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	orderTakerWallet := database.OrdererParticipantWallet{
-		OrderID:                         req.OrderID,
-		OrdererParticipantWalletAddress: req.OrderTakerAddress,
+	if !user.EmailVerified {
+		ctx.JSON(http.StatusForbidden, getResponse(false, nil, ErrEmailNotVerified.Error(), ErrEmailNotVerified.Error()))
+		return
+	}
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
 	}
-	session, err := h.Database.StartSession()
+	orderData, err := h.Store.Orders.FindOne(ctx, &database.OrderFilter{ID: req.OrderID})
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrOrderNotFound.Error(), ErrOrderNotFound.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the order"))
+		return
+	}
+	err = database.ValidateTransition(orderData.ID, orderData.Status, database.OrderStatusType3)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusConflict, getResponse(false, nil, err.Error(), "The order cannot be taken"))
+		return
+	}
+	if h.MatchingEngine && orderData.Visibility == orderVisibilityTypes1 {
+		err := ErrOrderMatchedByEngine
+		ctx.JSON(http.StatusConflict, getResponse(false, nil, err.Error(), "The order cannot be taken"))
+		return
+	}
+	// Without an amount the taker takes what is left of the order
+	remainingAmount := database.RemainingAmount(orderData)
+	fillAmount := req.Amount
+	if fillAmount.IsZero() {
+		fillAmount = remainingAmount
+	}
+	if !takeAmountValidator(fillAmount, remainingAmount) {
+		err := fmt.Errorf("the amount value in the request is invalid")
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	fillID := fmt.Sprintf("%s-%s", orderData.ID, uuid.New().String())
+	fillOrder := *orderData.Order
+	fillOrder.Amount = fillAmount
+	fillOrder.Status = database.OrderStatusType3
+	tokens, err := h.tokenRegistry(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Getting the order common data has failed"))
+		return
+	}
+	err = validateDepositAmounts(tokens, &fillOrder)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	err = validateParticipantAddresses(tokens, &fillOrder, database.ParticipantRoleTaker, req.OrderTakerAddress, req.OrderTakerPayoutAddress)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	depositTarget, err := h.issueDepositTarget(ctx, fillID, database.ParticipantRoleTaker, &fillOrder, orderData.FeeRate)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
-			getResponse(false, nil, err.Error(), "Taking the order has failed"))
+			getResponse(false, nil, err.Error(), "Issuing the deposit address has failed"))
 		return
 	}
-	err = session.StartTransaction()
+	depositDeadline, err := h.depositDeadline(ctx)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
-			getResponse(false, nil, err.Error(), "Taking the order has failed"))
+			getResponse(false, nil, err.Error(), "Failed to update the order status"))
 		return
 	}
-	err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
-		filter := bson.M{"id": req.OrderID}
-		err := database.UpdateOrderStatus(h.Database, filter, database.OrderStatusType3)
+	currentTime := time.Now()
+	fillData := database.OrderData{
+		ID:               fillID,
+		ParentID:         orderData.ID,
+		UserUUID:         uuidStr,
+		Order:            &fillOrder,
+		FeeRate:          orderData.FeeRate,
+		DepositDeadline:  depositDeadline,
+		CreationDateTime: currentTime.Format(database.TimeFormat),
+		UpdateDateTime:   currentTime.Format(database.TimeFormat),
+	}
+	orderTakerWallet := database.OrdererParticipantWallet{
+		OrderID:                         fillID,
+		Role:                            database.ParticipantRoleTaker,
+		OrdererParticipantWalletAddress: utils.NormalizeWalletAddress(req.OrderTakerAddress),
+		PayoutWalletAddress:             utils.NormalizeWalletAddress(req.OrderTakerPayoutAddress),
+		DepositWalletAddress:            depositTarget.WalletAddress,
+		DepositAmount:                   depositTarget.Amount,
+		DepositReference:                depositTarget.Reference,
+	}
+	// The fill reserves its amount of the order until the taker deposit arrives
+	var takenEvent *events.Event
+	err = h.Store.WithTransaction(ctx, func(txCtx context.Context) error {
+		parentData, err := database.FillOrder(txCtx, h.Store.Orders, &database.OrderFilter{ID: req.OrderID}, decimal.Zero(), decimal.Zero().Sub(fillAmount))
 		if err != nil {
 			return err
 		}
-
-		_, err = h.Database.Database(database.tokenswapDatabase).Collection(database.OrderParticipantWalletCollection).InsertOne(context.TODO(), orderTakerWallet)
+		err = h.Store.Orders.Insert(txCtx, &fillData)
 		if err != nil {
 			return err
 		}
-		err = session.CommitTransaction(sc)
+		err = h.Store.ParticipantWallets.Insert(txCtx, &orderTakerWallet)
 		if err != nil {
 			return err
 		}
-		return nil
+		takenEvent = events.NewOrderEvent(events.OrderTaken, &fillData, parentData.UserUUID)
+		return webhook.QueueEvents(txCtx, h.Store, takenEvent)
 	})
 	if err != nil {
 		log.Error(err)
-		if err == database.ErrNonUpdated {
+		if errors.Is(err, database.ErrInvalidTransition) {
+			ctx.JSON(http.StatusConflict,
+				getResponse(false, nil, err.Error(), "The order cannot be taken"))
+			return
+		}
+		if err == database.ErrFillAmount {
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound,
 				getResponse(false, nil, err.Error(), err.Error()))
 			return
@@ -409,9 +664,8 @@ func (h *Handler) TakeOrder(ctx *gin.Context) {
 			getResponse(false, nil, err.Error(), "Failed to update the order status"))
 		return
 	}
-	session.EndSession(ctx)
-	checkOrderTimeout(h, req.OrderID, database.OrderStatusType3)
-	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Takeing the order has succeeded"))
+	h.Events.Publish(takenEvent)
+	ctx.JSON(http.StatusOK, getResponse(true, &OrderTakeResponse{OrderID: req.OrderID, FillID: fillID, Amount: fillAmount, DepositTarget: depositTarget}, "", "Takeing the order has succeeded"))
 }
 
 func (h *Handler) CancelOrder(ctx *gin.Context) {
@@ -423,6 +677,7 @@ func (h *Handler) CancelOrder(ctx *gin.Context) {
 	req := struct {
 		OrderID  string `json:"order_id"`
 		Password string `json:"password"`
+		OTPCode  string `json:"otp_code"`
 	}{}
 	err = ctx.BindJSON(&req)
 	if err != nil {
@@ -432,11 +687,11 @@ func (h *Handler) CancelOrder(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuidStr}
-	_, err = h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -446,11 +701,40 @@ func (h *Handler) CancelOrder(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	filter = bson.M{"id": req.OrderID, "user_uuid": uuidStr}
-	err = database.UpdateOrderStatus(h.Database, filter, database.OrderStatusType5)
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
+	}
+	// Deposits which already arrived for the order are sent back
+	var cancelledEvent *events.Event
+	err = h.Store.WithTransaction(ctx, func(txCtx context.Context) error {
+		orderData, err := database.TransitionOrder(txCtx, h.Store.Orders, &database.OrderFilter{ID: req.OrderID, UserUUID: uuidStr}, database.OrderStatusType5)
+		if err != nil {
+			return err
+		}
+		// The maker deposit is still needed by the fills in progress
+		if database.FillsInProgress(orderData) {
+			return ErrOrderFillInProgress
+		}
+		err = refund.QueueOrderRefunds(txCtx, h.Store, req.OrderID, "")
+		if err != nil {
+			return err
+		}
+		cancelledEvent = events.NewOrderEvent(events.OrderCancelled, orderData)
+		return webhook.QueueEvents(txCtx, h.Store, cancelledEvent)
+	})
 	if err != nil {
 		log.Error(err)
-		if err == database.ErrNonUpdated {
+		if errors.Is(err, database.ErrInvalidTransition) {
+			ctx.JSON(http.StatusConflict,
+				getResponse(false, nil, err.Error(), "The order cannot be cancelled"))
+			return
+		}
+		if err == ErrOrderFillInProgress {
+			ctx.JSON(http.StatusConflict,
+				getResponse(false, nil, err.Error(), "The order cannot be cancelled"))
+			return
+		}
+		if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound,
 				getResponse(false, nil, err.Error(), err.Error()))
 			return
@@ -459,5 +743,97 @@ func (h *Handler) CancelOrder(ctx *gin.Context) {
 			getResponse(false, nil, err.Error(), "Failed to update the order status"))
 		return
 	}
+	h.Events.Publish(cancelledEvent)
 	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Updating an order status has succeeded"))
 }
+
+// PreviewOrderFee quotes what each side of an order deposits and receives, so
+// the client can show it before the order is created or taken. An order taken
+// keeps the fee rate it was created with.
+func (h *Handler) PreviewOrderFee(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	req := OrderFeeRequest{}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	filter := &database.UserFilter{UUID: uuidStr}
+	_, err = h.getUserByFilter(ctx, filter)
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
+		return
+	}
+	orderCommonInfo, err := h.getOrderCommonInfo(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Getting the order common data has failed"))
+		return
+	}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Getting the order common data has failed"))
+		return
+	}
+	order, feeRate := req.Order, orderCommonInfo.Fee
+	if len(req.OrderID) > 0 {
+		orderData, err := h.Store.Orders.FindOne(ctx, &database.OrderFilter{ID: req.OrderID})
+		if err == nil && orderData.Order == nil {
+			err = database.ErrNoDocuments
+		}
+		if err != nil {
+			log.Error(err)
+			if err == database.ErrNoDocuments {
+				ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrOrderNotFound.Error(), ErrOrderNotFound.Error()))
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the order"))
+			return
+		}
+		remainingAmount := database.RemainingAmount(orderData)
+		fillOrder := *orderData.Order
+		fillOrder.Amount = remainingAmount
+		if req.Order != nil && !req.Amount.IsZero() {
+			fillOrder.Amount = req.Amount
+		}
+		if !takeAmountValidator(fillOrder.Amount, remainingAmount) {
+			err := fmt.Errorf("the amount value in the request is invalid")
+			log.Error(err)
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		order, feeRate = &fillOrder, orderData.FeeRate
+	} else {
+		if order == nil {
+			order = &database.Order{}
+		}
+		err = orderTermsValidator(orderCommonInfo, tokens, order)
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	quote, err := fee.Compute(order, feeRate, tokens)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError,
+			getResponse(false, nil, err.Error(), "Computing the order fee has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, quote, "", "Computing the order fee has succeeded"))
+}
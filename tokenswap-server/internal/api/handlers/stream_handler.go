@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+)
+
+const (
+	streamHeartbeatSeconds = 15
+	streamEventPing        = "ping"
+)
+
+// StreamEvents streams the order events as server-sent events until the
+// client goes away. The events are filtered by the pair and network query
+// parameters, and with my_orders=true only the events of the orders of the
+// user are streamed. A ping event is sent while there are no events so that
+// the proxies keep the connection open.
+func (h *Handler) StreamEvents(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	pair := ctx.Query("pair")
+	if len(pair) > 0 {
+		orderCommonInfo, err := h.getOrderCommonInfo(ctx)
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError,
+				getResponse(false, nil, err.Error(), "Getting the order common data has failed"))
+			return
+		}
+		if !slices.Contains(orderCommonInfo.Pairs, pair) {
+			err := fmt.Errorf("the pair value in the request is invalid")
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	network := ctx.Query("network")
+	if len(network) > 0 {
+		if _, ok := orderNetworkTypes[network]; !ok {
+			err := fmt.Errorf("the network value in the request is invalid")
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	subscription := h.Events.Subscribe(&events.Filter{
+		UserUUID: uuidStr,
+		Pair:     pair,
+		Network:  network,
+		MyOrders: ctx.Query("my_orders") == "true",
+	})
+	defer subscription.Close()
+	heartbeat := time.NewTicker(streamHeartbeatSeconds * time.Second)
+	defer heartbeat.Stop()
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.SSEvent(streamEventPing, time.Now().Format(time.RFC3339))
+	ctx.Writer.Flush()
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			ctx.SSEvent(streamEventPing, time.Now().Format(time.RFC3339))
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -7,11 +7,10 @@ import (
 
 	"github.com/asaskevich/govalidator"
 	"github.com/rocky2015aaa/tokenswap-server/internal/config"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
@@ -29,6 +28,7 @@ func (h *Handler) RenewTokens(ctx *gin.Context) {
 	req := struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		OTPCode  string `json:"otp_code"`
 	}{}
 	err := ctx.BindJSON(&req)
 	if err != nil {
@@ -43,11 +43,11 @@ func (h *Handler) RenewTokens(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"email": req.Email}
-	user, err := h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{Email: req.Email}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -57,19 +57,22 @@ func (h *Handler) RenewTokens(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	accessToken, refreshToken, updateResult, err := h.renewTokensAndUpdateExpirationTime(user, user.TokenExpirationTimeInSeconds, true)
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
+	}
+	accessToken, refreshToken, err := h.renewTokensAndUpdateExpirationTime(ctx, user, user.TokenExpirationTimeInSeconds, true)
 	if err != nil {
 		log.Error(err)
+		// Check if any document was modified
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusNotFound,
+				getResponse(false, nil, "Update was not applied in the condition", "Update has failed"))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Failed to generate tokens"))
 		return
 	}
-	// Check if any document was modified
-	if updateResult.MatchedCount == 0 {
-		ctx.JSON(http.StatusNotFound,
-			getResponse(false, nil, "Update was not applied in the condition", "Update has failed"))
-		return
-	}
 	data := struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
@@ -89,6 +92,7 @@ func (h *Handler) RenewTokensWithCustomExpiration(ctx *gin.Context) {
 	req := struct {
 		Password                           string `json:"password"`
 		AccessTokenExpirationTimeInSeconds int    `json:"access_token_expiration_time_in_seconds,omitempty"`
+		OTPCode                            string `json:"otp_code"`
 	}{}
 	err = ctx.BindJSON(&req)
 	if err != nil {
@@ -105,11 +109,11 @@ func (h *Handler) RenewTokensWithCustomExpiration(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuidStr}
-	user, err := h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -119,19 +123,22 @@ func (h *Handler) RenewTokensWithCustomExpiration(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	accessToken, refreshToken, updateResult, err := h.renewTokensAndUpdateExpirationTime(user, req.AccessTokenExpirationTimeInSeconds, true)
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
+	}
+	accessToken, refreshToken, err := h.renewTokensAndUpdateExpirationTime(ctx, user, req.AccessTokenExpirationTimeInSeconds, true)
 	if err != nil {
 		log.Error(err)
+		// Check if any document was modified
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusNotFound,
+				getResponse(false, nil, "Update was not applied in the condition", "Update has failed"))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Failed to generate tokens"))
 		return
 	}
-	// Check if any document was modified
-	if updateResult.MatchedCount == 0 {
-		ctx.JSON(http.StatusNotFound,
-			getResponse(false, nil, "Update was not applied in the condition", "Update has failed"))
-		return
-	}
 	data := struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
@@ -189,11 +196,11 @@ func (h *Handler) Refresh(ctx *gin.Context) {
 		}
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuid}
-	user, err := h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuid}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -203,19 +210,19 @@ func (h *Handler) Refresh(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
-	accessToken, _, updateResult, err := h.renewTokensAndUpdateExpirationTime(user, user.TokenExpirationTimeInSeconds, false)
+	accessToken, _, err := h.renewTokensAndUpdateExpirationTime(ctx, user, user.TokenExpirationTimeInSeconds, false)
 	if err != nil {
 		log.Error(err)
+		// Check if any document was modified
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusNotFound,
+				getResponse(false, nil, "Update was not applied in the condition", "Update has failed"))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Failed to generate tokens"))
 		return
 	}
-	// Check if any document was modified
-	if updateResult.MatchedCount == 0 {
-		ctx.JSON(http.StatusNotFound,
-			getResponse(false, nil, "Update was not applied in the condition", "Update has failed"))
-		return
-	}
 	data := struct {
 		AccessToken string `json:"access_token"`
 	}{
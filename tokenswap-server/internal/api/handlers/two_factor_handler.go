@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/totp"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrTwoFactorRequired       = errors.New("a two-factor authentication code is required")
+	ErrIncorrectTwoFactorCode  = errors.New("not a correct two-factor authentication code")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication enrolment was not started")
+	ErrTwoFactorLocked         = fmt.Errorf("too many two-factor authentication codes were entered, try again in %s", twoFactorLockout)
+)
+
+const (
+	twoFactorIssuer = "tokenswap"
+	// recoveryCodeCount recovery codes of recoveryCodeLength bytes are given
+	// when two-factor authentication is enabled, each usable once.
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 5
+	// After maxTwoFactorAttempts codes without a correct one, one more code
+	// can be entered every twoFactorLockout.
+	maxTwoFactorAttempts = 5
+	twoFactorLockout     = 15 * time.Minute
+)
+
+// EnrollTwoFactor starts two-factor authentication of the user with a new
+// secret. It is enforced once a code of the secret is confirmed.
+func (h *Handler) EnrollTwoFactor(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	req := struct {
+		Password string `json:"password"`
+	}{}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	// Check if the user exists with a correct password
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
+			return
+		} else if err == ErrIncorrectUserPassword {
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
+		return
+	}
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		ctx.JSON(http.StatusConflict, getResponse(false, nil, ErrTwoFactorAlreadyEnabled.Error(), ErrTwoFactorAlreadyEnabled.Error()))
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to generate a secret"))
+		return
+	}
+	currentTime := time.Now().Format(database.TimeFormat)
+	twoFactor := &database.TwoFactor{
+		Secret:             secret,
+		EnrollmentDateTime: currentTime,
+	}
+	err = h.Store.Users.SetTwoFactor(ctx, uuidStr, twoFactor, currentTime)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Enrolling two-factor authentication has failed"))
+		return
+	}
+	data := struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OtpauthURI: totp.URI(twoFactorIssuer, user.Email, secret),
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, &data, "", "Enrolling two-factor authentication has succeeded"))
+}
+
+// ConfirmTwoFactor enables two-factor authentication with a code of the
+// secret given at enrolment, and returns the recovery codes. They are shown
+// only once.
+func (h *Handler) ConfirmTwoFactor(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	req := struct {
+		Code string `json:"code"`
+	}{}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	user, err := h.getUserByFilter(ctx, &database.UserFilter{UUID: uuidStr})
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
+		return
+	}
+	if user.TwoFactor == nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrTwoFactorNotEnrolled.Error(), ErrTwoFactorNotEnrolled.Error()))
+		return
+	}
+	if user.TwoFactor.Enabled {
+		ctx.JSON(http.StatusConflict, getResponse(false, nil, ErrTwoFactorAlreadyEnabled.Error(), ErrTwoFactorAlreadyEnabled.Error()))
+		return
+	}
+	step, ok := totp.Validate(user.TwoFactor.Secret, req.Code, time.Now())
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrIncorrectTwoFactorCode.Error(), ErrIncorrectTwoFactorCode.Error()))
+		return
+	}
+	recoveryCodes, recoveryCodeHashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to generate recovery codes"))
+		return
+	}
+	twoFactor := &database.TwoFactor{
+		Secret:             user.TwoFactor.Secret,
+		Enabled:            true,
+		LastUsedStep:       step,
+		RecoveryCodeHashes: recoveryCodeHashes,
+		EnrollmentDateTime: user.TwoFactor.EnrollmentDateTime,
+	}
+	err = h.Store.Users.SetTwoFactor(ctx, uuidStr, twoFactor, time.Now().Format(database.TimeFormat))
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Enabling two-factor authentication has failed"))
+		return
+	}
+	data := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: recoveryCodes,
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, &data, "", "Enabling two-factor authentication has succeeded"))
+}
+
+// DisableTwoFactor removes the second factor of the user, which takes the
+// password and a code like the other sensitive actions.
+func (h *Handler) DisableTwoFactor(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	req := struct {
+		Password string `json:"password"`
+		OTPCode  string `json:"otp_code"`
+	}{}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	// Check if the user exists with a correct password
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
+			return
+		} else if err == ErrIncorrectUserPassword {
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
+		return
+	}
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrTwoFactorNotEnabled.Error(), ErrTwoFactorNotEnabled.Error()))
+		return
+	}
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
+	}
+	err = h.Store.Users.SetTwoFactor(ctx, uuidStr, nil, time.Now().Format(database.TimeFormat))
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Disabling two-factor authentication has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Disabling two-factor authentication has succeeded"))
+}
+
+// checkTwoFactor checks the second factor of a user who enabled it, with a
+// code of the authenticator app or else a recovery code, and responds with
+// the error when it does not pass. A code is accepted only once, and the codes
+// entered are limited to maxTwoFactorAttempts until a correct one.
+func (h *Handler) checkTwoFactor(ctx *gin.Context, user *database.User, code string) bool {
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		return true
+	}
+	if strings.TrimSpace(code) == "" {
+		ctx.JSON(http.StatusUnauthorized, getResponse(false, nil, ErrTwoFactorRequired.Error(), ErrTwoFactorRequired.Error()))
+		return false
+	}
+	currentTime := time.Now()
+	err := h.Store.Users.AddTwoFactorAttempt(ctx, user.UUID, currentTime, maxTwoFactorAttempts, twoFactorLockout)
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusTooManyRequests, getResponse(false, nil, ErrTwoFactorLocked.Error(), ErrTwoFactorLocked.Error()))
+			return false
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to check the two-factor authentication code"))
+		return false
+	}
+	if step, ok := totp.Validate(user.TwoFactor.Secret, code, currentTime); ok {
+		err = h.Store.Users.UseTwoFactorStep(ctx, user.UUID, step)
+	} else {
+		err = h.Store.Users.UseRecoveryCode(ctx, user.UUID, hashVerificationCode(normalizeRecoveryCode(code)))
+	}
+	if err != nil {
+		log.Error(err)
+		// The code was used already
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrIncorrectTwoFactorCode.Error(), ErrIncorrectTwoFactorCode.Error()))
+			return false
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to check the two-factor authentication code"))
+		return false
+	}
+	return true
+}
+
+// newRecoveryCodes returns random recovery codes formatted as xxxxx-xxxxx and
+// the hashes to store of them.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	codeHashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := make([]byte, recoveryCodeLength)
+		_, err := rand.Read(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codeStr := hex.EncodeToString(code)
+		codes = append(codes, codeStr[:len(codeStr)/2]+"-"+codeStr[len(codeStr)/2:])
+		codeHashes = append(codeHashes, hashVerificationCode(codeStr))
+	}
+	return codes, codeHashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/totp"
+)
+
+func newTwoFactorTestHandler(t *testing.T) (*Handler, *database.User) {
+	t.Helper()
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &database.User{
+		UUID:  "user",
+		Email: "user@example.com",
+		TwoFactor: &database.TwoFactor{
+			Secret:             secret,
+			Enabled:            true,
+			RecoveryCodeHashes: []string{hashVerificationCode("0123456789")},
+		},
+	}
+	store := database.NewMemoryStore()
+	err = store.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{Store: store}, user
+}
+
+// checkCode checks the code for the user and returns the status responded, or
+// 200 when it passed.
+func checkCode(h *Handler, user *database.User, code string) int {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	if !h.checkTwoFactor(ctx, user, code) {
+		return recorder.Code
+	}
+	return http.StatusOK
+}
+
+func currentCode(t *testing.T, user *database.User) string {
+	t.Helper()
+	code, err := totp.Code(user.TwoFactor.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestCheckTwoFactorCodeReuse(t *testing.T) {
+	h, user := newTwoFactorTestHandler(t)
+	code := currentCode(t, user)
+	if status := checkCode(h, user, code); status != http.StatusOK {
+		t.Fatalf("code: status %d", status)
+	}
+	if status := checkCode(h, user, code); status != http.StatusBadRequest {
+		t.Fatalf("code used again: status %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := checkCode(h, user, "01234-56789"); status != http.StatusOK {
+		t.Fatalf("recovery code: status %d", status)
+	}
+	if status := checkCode(h, user, "01234-56789"); status != http.StatusBadRequest {
+		t.Fatalf("recovery code used again: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestCheckTwoFactorLockout(t *testing.T) {
+	h, user := newTwoFactorTestHandler(t)
+	// A correct code resets the count
+	for i := 0; i < maxTwoFactorAttempts-1; i++ {
+		if status := checkCode(h, user, "000000"); status != http.StatusBadRequest {
+			t.Fatalf("wrong code %d: status %d, want %d", i, status, http.StatusBadRequest)
+		}
+	}
+	if status := checkCode(h, user, currentCode(t, user)); status != http.StatusOK {
+		t.Fatalf("code: status %d", status)
+	}
+
+	for i := 0; i < maxTwoFactorAttempts; i++ {
+		if status := checkCode(h, user, "000000"); status != http.StatusBadRequest {
+			t.Fatalf("wrong code %d: status %d, want %d", i, status, http.StatusBadRequest)
+		}
+	}
+	if status := checkCode(h, user, "000000"); status != http.StatusTooManyRequests {
+		t.Fatalf("wrong code after the limit: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+	if status := checkCode(h, user, "01234-56789"); status != http.StatusTooManyRequests {
+		t.Fatalf("recovery code while locked: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+
+	// One more code can be entered once the lockout has passed
+	ctx := context.Background()
+	storedUser, err := h.Store.Users.FindOne(ctx, &database.UserFilter{UUID: user.UUID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastAttemptTime := time.Now().Add(-twoFactorLockout - time.Second)
+	storedUser.TwoFactor.LastAttemptTime = &lastAttemptTime
+	err = h.Store.Users.SetTwoFactor(ctx, user.UUID, storedUser.TwoFactor, storedUser.UpdateDateTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := checkCode(h, user, "000000"); status != http.StatusBadRequest {
+		t.Fatalf("wrong code after the lockout: status %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := checkCode(h, user, "000000"); status != http.StatusTooManyRequests {
+		t.Fatalf("second wrong code after the lockout: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
@@ -11,18 +11,27 @@ import (
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	ErrUserNotFound           = errors.New("user not found")
-	ErrInvalidUserEmailFormat = errors.New("not a valid email format")
-	ErrIncorrectUserPassword  = errors.New("not a correct user password")
+	ErrUserNotFound                  = errors.New("user not found")
+	ErrInvalidUserEmailFormat        = errors.New("not a valid email format")
+	ErrIncorrectUserPassword         = errors.New("not a correct user password")
+	ErrEmailNotVerified              = errors.New("the email of the user is not verified")
+	ErrEmailAlreadyVerified          = errors.New("the email of the user is already verified")
+	ErrIncorrectVerificationCode     = errors.New("not a correct verification code")
+	ErrVerificationCodeExpired       = errors.New("the verification code has expired, request a new one")
+	ErrVerificationCodeResentTooSoon = fmt.Errorf("a verification code can be requested once every %s", emailVerificationResendInterval)
 )
 
-const ()
+const (
+	emailVerificationCodeDigits     = 6
+	emailVerificationCodeExpiration = 15 * time.Minute
+	emailVerificationResendInterval = time.Minute
+	maxEmailVerificationAttempts    = 5
+	emailVerificationSubject        = "Your tokenswap verification code"
+)
 
 func (h *Handler) GetUserInfo(ctx *gin.Context) {
 	uuidStr, err := getUUIDStrFromCtx(ctx)
@@ -37,11 +46,11 @@ func (h *Handler) GetUserInfo(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
 		return
 	}
-	filter := bson.M{"uuid": uuidStr}
-	user, err := h.getUserByFilter(filter)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getUserByFilter(ctx, filter)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		}
@@ -50,9 +59,12 @@ func (h *Handler) GetUserInfo(ctx *gin.Context) {
 	}
 	response := UserConfigInformation{
 		UUID:                         user.UUID,
+		Role:                         userRole(user),
 		TokenExpirationTimeInSeconds: user.TokenExpirationTimeInSeconds,
 		TokenExpirationDateTime:      TokenExpirationDateTime.(string),
 		RegistrationDateTime:         user.RegistrationDateTime,
+		EmailVerified:                user.EmailVerified,
+		TwoFactorEnabled:             user.TwoFactor != nil && user.TwoFactor.Enabled,
 	}
 	ctx.JSON(http.StatusOK, getResponse(true, &response, "", "Getting the user data has succeeded"))
 }
@@ -74,11 +86,11 @@ func (h *Handler) Verification(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuidStr}
-	_, err = h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuidStr}
+	_, err = h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -109,9 +121,9 @@ func (h *Handler) Register(ctx *gin.Context) {
 		return
 	}
 	// Check if the user was already registered
-	filter := bson.M{"email": req.Email}
-	existingUser, err := h.getUserByFilter(filter)
-	if err != nil && err != mongo.ErrNoDocuments {
+	filter := &database.UserFilter{Email: req.Email}
+	existingUser, err := h.getUserByFilter(ctx, filter)
+	if err != nil && err != database.ErrNoDocuments {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to check for existing user"))
 		return
@@ -128,20 +140,31 @@ func (h *Handler) Register(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to create user"))
 		return
 	}
-	accessToken, refreshToken, err := generateTokens(user.UUID, true, currentTime, 0, 0)
+	accessToken, refreshToken, err := generateTokens(user.UUID, user.Role, true, currentTime, 0, 0)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Failed to generate a token"))
 		return
 	}
-	_, err = h.Database.Database(database.tokenswapDatabase).Collection(database.UserCollection).InsertOne(context.TODO(), user)
+	err = h.Store.Users.Insert(ctx, user)
+	// Registered by another request since the check above
+	if err == database.ErrDuplicateUser {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, "The user already exists", "The user already exists"))
+		return
+	}
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Registration has failed"))
 		return
 	}
+	// The user is registered even when the code cannot be sent, a new one can
+	// be requested
+	err = h.sendEmailVerification(ctx, user, currentTime)
+	if err != nil {
+		log.Error(err)
+	}
 	data := struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
@@ -152,10 +175,125 @@ func (h *Handler) Register(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, getResponse(true, &data, "", "Registration has succeeded"))
 }
 
-func (h *Handler) UpdatePassword(ctx *gin.Context) {
-	uuidStr, ok := ctx.Get("uuid")
+// VerifyEmail checks the code emailed to the user and marks the email
+// verified. A code is given up after maxEmailVerificationAttempts wrong ones.
+func (h *Handler) VerifyEmail(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	req := struct {
+		Code string `json:"code"`
+	}{}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	user, ok := h.unverifiedUser(ctx, uuidStr)
+	if !ok {
+		return
+	}
+	verification := user.EmailVerification
+	if verification == nil || time.Now().After(verification.ExpirationTime) || verification.Attempts >= maxEmailVerificationAttempts {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrVerificationCodeExpired.Error(), ErrVerificationCodeExpired.Error()))
+		return
+	}
+	if !checkVerificationCode(verification.CodeHash, req.Code) {
+		err := h.Store.Users.AddEmailVerificationAttempt(ctx, uuidStr)
+		if err != nil {
+			log.Error(err)
+		}
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, ErrIncorrectVerificationCode.Error(), ErrIncorrectVerificationCode.Error()))
+		return
+	}
+	err = h.Store.Users.VerifyEmail(ctx, uuidStr, time.Now().Format(database.TimeFormat))
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusConflict, getResponse(false, nil, ErrEmailAlreadyVerified.Error(), ErrEmailAlreadyVerified.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Verifying the email has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Verifying the email has succeeded"))
+}
+
+// ResendEmailVerification emails a new verification code to the user, which
+// replaces the previous one.
+func (h *Handler) ResendEmailVerification(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	user, ok := h.unverifiedUser(ctx, uuidStr)
 	if !ok {
-		err := fmt.Errorf("missing required field in ctx: uuid")
+		return
+	}
+	currentTime := time.Now()
+	verification := user.EmailVerification
+	if verification != nil && verification.ExpirationTime.Add(-emailVerificationCodeExpiration).Add(emailVerificationResendInterval).After(currentTime) {
+		ctx.JSON(http.StatusTooManyRequests,
+			getResponse(false, nil, ErrVerificationCodeResentTooSoon.Error(), ErrVerificationCodeResentTooSoon.Error()))
+		return
+	}
+	err = h.sendEmailVerification(ctx, user, currentTime)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Sending the verification code has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Sending the verification code has succeeded"))
+}
+
+// unverifiedUser returns the user when the email is not verified yet, and
+// responds with the error otherwise.
+func (h *Handler) unverifiedUser(ctx *gin.Context, uuidStr string) (*database.User, bool) {
+	user, err := h.getUserByFilter(ctx, &database.UserFilter{UUID: uuidStr})
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
+			return nil, false
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
+		return nil, false
+	}
+	if user.EmailVerified {
+		ctx.JSON(http.StatusConflict, getResponse(false, nil, ErrEmailAlreadyVerified.Error(), ErrEmailAlreadyVerified.Error()))
+		return nil, false
+	}
+	return user, true
+}
+
+// sendEmailVerification stores a new verification code of the user and
+// emails it.
+func (h *Handler) sendEmailVerification(ctx context.Context, user *database.User, currentTime time.Time) error {
+	code, err := newVerificationCode(emailVerificationCodeDigits)
+	if err != nil {
+		return err
+	}
+	verification := &database.EmailVerification{
+		CodeHash:       hashVerificationCode(code),
+		ExpirationTime: currentTime.Add(emailVerificationCodeExpiration),
+	}
+	err = h.Store.Users.SetEmailVerification(ctx, user.UUID, verification, currentTime.Format(database.TimeFormat))
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Your tokenswap verification code is %s.\n\nIt expires in %s. If you did not register to tokenswap, ignore this email.",
+		code, emailVerificationCodeExpiration)
+	return h.Mailer.Send(ctx, user.Email, emailVerificationSubject, body)
+}
+
+func (h *Handler) UpdatePassword(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
 		return
@@ -163,8 +301,9 @@ func (h *Handler) UpdatePassword(ctx *gin.Context) {
 	req := struct {
 		Password    string `json:"password"`
 		NewPassword string `json:"new_password"`
+		OTPCode     string `json:"otp_code"`
 	}{}
-	err := ctx.BindJSON(&req)
+	err = ctx.BindJSON(&req)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusBadRequest,
@@ -176,11 +315,11 @@ func (h *Handler) UpdatePassword(ctx *gin.Context) {
 		return
 	}
 	// Check if the user exists with a correct password
-	filter := bson.M{"uuid": uuidStr}
-	_, err = h.getFilteredUserWithPassword(filter, req.Password)
+	filter := &database.UserFilter{UUID: uuidStr}
+	user, err := h.getFilteredUserWithPassword(ctx, filter, req.Password)
 	if err != nil {
 		log.Error(err)
-		if err == mongo.ErrNoDocuments {
+		if err == database.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrUserNotFound.Error(), ErrUserNotFound.Error()))
 			return
 		} else if err == ErrIncorrectUserPassword {
@@ -190,6 +329,9 @@ func (h *Handler) UpdatePassword(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Failed to get the user"))
 		return
 	}
+	if !h.checkTwoFactor(ctx, user, req.OTPCode) {
+		return
+	}
 	// Create a new hashed password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -200,25 +342,18 @@ func (h *Handler) UpdatePassword(ctx *gin.Context) {
 	}
 	// Update user new password in DB
 	currentTime := time.Now()
-	updateData := bson.M{
-		"$set": bson.M{
-			"password":         string(hashedPassword),
-			"update_date_time": currentTime.Format(database.TimeFormat),
-		},
-	}
-	updateResult, err := h.Database.Database(database.tokenswapDatabase).Collection(database.UserCollection).UpdateOne(context.TODO(), filter, updateData)
+	err = h.Store.Users.UpdatePassword(ctx, uuidStr, string(hashedPassword), currentTime.Format(database.TimeFormat))
 	if err != nil {
 		log.Error(err)
+		// Check if any document was modified
+		if err == database.ErrNonUpdated {
+			ctx.JSON(http.StatusNotFound,
+				getResponse(false, nil, database.ErrNonUpdated.Error(), database.ErrNonUpdated.Error()))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError,
 			getResponse(false, nil, err.Error(), "Failed to Update user data with tokens failed"))
 		return
 	}
-	// Check if any document was modified
-	if updateResult.MatchedCount == 0 {
-		log.Error(database.ErrNonUpdated)
-		ctx.JSON(http.StatusNotFound,
-			getResponse(false, nil, database.ErrNonUpdated.Error(), database.ErrNonUpdated.Error()))
-		return
-	}
 	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Updating user's password has succeeded"))
 }
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
+)
+
+const (
+	maxWebhooksPerUser   = 10
+	defaultDeliveryLimit = 20
+	maxDeliveryLimit     = 100
+)
+
+var (
+	ErrWebhookNotFound     = errors.New("webhook not found")
+	ErrTooManyWebhooks     = fmt.Errorf("a user can register up to %d webhooks", maxWebhooksPerUser)
+	ErrInvalidWebhookEvent = errors.New("the webhook event is invalid")
+)
+
+// CreateWebhook registers a webhook of the user. The secret the payloads are
+// signed with is only returned here.
+func (h *Handler) CreateWebhook(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	req := WebhookRequest{}
+	err = ctx.BindJSON(&req)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest,
+			getResponse(false, nil, err.Error(), "Binding data has failed"))
+		return
+	}
+	err = webhook.ValidateURL(req.URL)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(events.OrderEventTypes, event) {
+			err := fmt.Errorf("%w: %s", ErrInvalidWebhookEvent, event)
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	webhooks, err := h.Store.Webhooks.Find(ctx, &database.WebhookFilter{UserUUID: uuidStr})
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the webhooks has failed"))
+		return
+	}
+	if len(webhooks) >= maxWebhooksPerUser {
+		ctx.JSON(http.StatusConflict, getResponse(false, nil, ErrTooManyWebhooks.Error(), ErrTooManyWebhooks.Error()))
+		return
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Webhook creation has failed"))
+		return
+	}
+	webhookData := &database.Webhook{
+		ID:               uuid.New().String(),
+		UserUUID:         uuidStr,
+		URL:              req.URL,
+		Secret:           secret,
+		Events:           req.Events,
+		CreationDateTime: time.Now().Format(database.TimeFormat),
+	}
+	err = h.Store.Webhooks.Insert(ctx, webhookData)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Webhook creation has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, &WebhookCreationResponse{Webhook: webhookData, Secret: secret}, "", "Creating a webhook has succeeded"))
+}
+
+func (h *Handler) GetWebhooks(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	webhooks, err := h.Store.Webhooks.Find(ctx, &database.WebhookFilter{UserUUID: uuidStr})
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the webhooks has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, webhooks, "", "Getting the webhooks has succeeded"))
+}
+
+// DeleteWebhook removes a webhook of the user. Its pending deliveries fail on
+// their next attempt.
+func (h *Handler) DeleteWebhook(ctx *gin.Context) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return
+	}
+	err = h.Store.Webhooks.Delete(ctx, &database.WebhookFilter{ID: ctx.Param("id"), UserUUID: uuidStr})
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrWebhookNotFound.Error(), ErrWebhookNotFound.Error()))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Deleting the webhook has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, nil, "", "Deleting the webhook has succeeded"))
+}
+
+// GetWebhookDeliveries lists the latest deliveries of a webhook of the user, up
+// to the limit query parameter.
+func (h *Handler) GetWebhookDeliveries(ctx *gin.Context) {
+	webhookData, ok := h.userWebhook(ctx)
+	if !ok {
+		return
+	}
+	limit := defaultDeliveryLimit
+	if limitParam := ctx.Query("limit"); len(limitParam) > 0 {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxDeliveryLimit {
+			err := fmt.Errorf("the limit must be between 1 and %d", maxDeliveryLimit)
+			ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+			return
+		}
+	}
+	deliveries, err := h.Store.WebhookDeliveries.FindLatest(ctx, webhookData.ID, limit)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the webhook deliveries has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, deliveries, "", "Getting the webhook deliveries has succeeded"))
+}
+
+// TestWebhook sends a test delivery to a webhook of the user right away and
+// returns its outcome. A test delivery which fails is retried like the others.
+func (h *Handler) TestWebhook(ctx *gin.Context) {
+	webhookData, ok := h.userWebhook(ctx)
+	if !ok {
+		return
+	}
+	delivery, err := h.Webhooks.TestFire(ctx, webhookData)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Testing the webhook has failed"))
+		return
+	}
+	ctx.JSON(http.StatusOK, getResponse(true, delivery, "", "Testing the webhook has succeeded"))
+}
+
+// userWebhook returns the webhook of the id path parameter when it belongs to
+// the user, and responds with the error otherwise.
+func (h *Handler) userWebhook(ctx *gin.Context) (*database.Webhook, bool) {
+	uuidStr, err := getUUIDStrFromCtx(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, getResponse(false, nil, err.Error(), err.Error()))
+		return nil, false
+	}
+	webhookData, err := h.Store.Webhooks.FindOne(ctx, &database.WebhookFilter{ID: ctx.Param("id"), UserUUID: uuidStr})
+	if err != nil {
+		log.Error(err)
+		if err == database.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, getResponse(false, nil, ErrWebhookNotFound.Error(), ErrWebhookNotFound.Error()))
+			return nil, false
+		}
+		ctx.JSON(http.StatusInternalServerError, getResponse(false, nil, err.Error(), "Getting the webhook has failed"))
+		return nil, false
+	}
+	return webhookData, true
+}
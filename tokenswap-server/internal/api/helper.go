@@ -5,176 +5,545 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/rocky2015aaa/tokenswap-server/internal/config"
 	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/mailer"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/settlement"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/watcher"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
 	log "github.com/sirupsen/logrus"
 	"github.com/xelis-project/xelis-go-sdk/wallet"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	ErrWrongAmount = errors.New("The amount is different")
+	ErrWrongAmount     = errors.New("The amount is different")
+	ErrDepositCredited = errors.New("The deposit is already credited")
+	ErrDepositRefund   = errors.New("The order does not wait for the deposit anymore")
 )
 
 const (
 	EnvStsvrXelisWalletRPC      = "STSVR_XELIS_WALLET_RPC"
 	EnvStsvrXelisWalletID       = "STSVR_XELIS_WALLET_ID"
 	EnvStsvrXelisWalletPassword = "STSVR_XELIS_WALLET_PASSWORD"
+	// The node of an EVM chain and the key of its service wallet are read from
+	// the chain name in upper case, like STSVR_ETHEREUM_RPC.
+	EnvStsvrChainRPC        = "STSVR_%s_RPC"
+	EnvStsvrChainPrivateKey = "STSVR_%s_PRIVATE_KEY"
+)
 
-	depositCheckTermSeconds = 10
+// serverNetworks returns the networks listed in STSVR_NETWORK, like
+// "mainnet,testnet", or nil when the server runs every network.
+func serverNetworks() []string {
+	networks := []string{}
+	for _, network := range strings.Split(os.Getenv(config.EnvStsvrNetwork), ",") {
+		network = strings.TrimSpace(network)
+		if len(network) > 0 {
+			networks = append(networks, network)
+		}
+	}
+	if len(networks) == 0 {
+		return nil
+	}
+	return networks
+}
 
-	infuraURL   = "https://rpc.sepolia.org/"
-	usdtAddress = "0xAA0d26EF9bCFD7536604017D5796109B1A12f844"
-	usdcAddress = "0xb2619b4cDB731d32997f052BB432E46339e5e1C9"
-)
+// serverTokens returns the registered tokens of the networks the server runs on.
+func serverTokens(tokens *registry.Registry) []*database.Token {
+	networks := serverNetworks()
+	networkTokens := []*database.Token{}
+	for _, token := range tokens.Tokens() {
+		if networks != nil && !slices.Contains(networks, token.Network) {
+			continue
+		}
+		networkTokens = append(networkTokens, token)
+	}
+	return networkTokens
+}
+
+// networkEnv reads the value of an environment variable for one network. The
+// name is prefixed with the network in upper case, so STSVR_XELIS_WALLET_RPC
+// becomes STSVR_TESTNET_XELIS_WALLET_RPC. The plain name is only used when the
+// server runs that single network, so a mainnet endpoint is never used for
+// testnet orders or the other way around.
+func networkEnv(name, network string) string {
+	value := os.Getenv(fmt.Sprintf("STSVR_%s_%s", strings.ToUpper(network), strings.TrimPrefix(name, "STSVR_")))
+	if len(value) > 0 {
+		return value
+	}
+	networks := serverNetworks()
+	if len(networks) == 1 && networks[0] == network {
+		return os.Getenv(name)
+	}
+	return ""
+}
+
+// newMailer returns the mailer selected by STSVR_MAILER, the file mailer unless
+// it is smtp.
+func newMailer() mailer.Mailer {
+	from := os.Getenv(config.EnvStsvrMailFrom)
+	if os.Getenv(config.EnvStsvrMailer) == config.MailerSMTP {
+		return mailer.NewSMTPMailer(os.Getenv(config.EnvStsvrSmtpHost), os.Getenv(config.EnvStsvrSmtpPort),
+			os.Getenv(config.EnvStsvrSmtpUsername), os.Getenv(config.EnvStsvrSmtpPassword), from)
+	}
+	log.Warn("Using the file mailer. Emails are not sent to the users.")
+	return mailer.NewFileMailer(os.Getenv(config.EnvStsvrMailFile), from)
+}
 
-func monitorXeltokenswapTranscations(ctx context.Context, db *mongo.Client, tokenswapAddress string, sig chan os.Signal) {
-	ticker := time.NewTicker(depositCheckTermSeconds * time.Second)
-	// TODO: config rpc uri and username/password
-	xelisWallet, err := wallet.NewRPC(ctx, os.Getenv(EnvStsvrXelisWalletRPC), os.Getenv(EnvStsvrXelisWalletID), os.Getenv(EnvStsvrXelisWalletPassword))
+func chainEnvName(format, chain string) string {
+	return fmt.Sprintf(format, strings.ToUpper(chain))
+}
+
+// newChainWatchers builds a watcher for every registered token of the order
+// pairs whose standard has one. The watchers which can read back their wallet
+// are also returned keyed by the asset they watch.
+func newChainWatchers(ctx context.Context, store *database.Store, orderCommonInfo *database.OrderCommonInfo, tokens *registry.Registry) ([]watcher.ChainWatcher, map[ledger.Asset]watcher.WalletReader) {
+	pairTokens := map[string]struct{}{}
+	for _, tokenPair := range orderCommonInfo.Pairs {
+		for _, symbol := range strings.Split(tokenPair, "/") {
+			pairTokens[symbol] = struct{}{}
+		}
+	}
+	watchers := []watcher.ChainWatcher{}
+	walletReaders := map[ledger.Asset]watcher.WalletReader{}
+	for _, token := range serverTokens(tokens) {
+		if _, exists := pairTokens[token.Symbol]; !exists {
+			continue
+		}
+		cfg := &watcher.Config{
+			Token:         token.Symbol,
+			Chain:         token.Chain,
+			Network:       token.Network,
+			Standard:      token.Standard,
+			TargetAddress: token.WalletAddress,
+			Contract:      token.Contract,
+			Decimals:      token.Decimals,
+			Confirmations: token.Confirmations,
+			Cursors:       store.ChainCursors,
+		}
+		if token.Standard == registry.StandardXelis {
+			cfg.RPC = networkEnv(EnvStsvrXelisWalletRPC, token.Network)
+			cfg.Username = networkEnv(EnvStsvrXelisWalletID, token.Network)
+			cfg.Password = networkEnv(EnvStsvrXelisWalletPassword, token.Network)
+		} else {
+			cfg.RPC = networkEnv(chainEnvName(EnvStsvrChainRPC, token.Chain), token.Network)
+		}
+		if len(cfg.RPC) == 0 {
+			log.Warnf("%s deposits on %s %s are not watched: no RPC endpoint is set for the network", token.Symbol, token.Chain, token.Network)
+			continue
+		}
+		chainWatcher, err := watcher.New(ctx, cfg)
+		if err != nil {
+			if errors.Is(err, watcher.ErrNoFactory) {
+				log.Warnf("%s deposits on %s %s are not watched: %s", token.Symbol, token.Chain, token.Network, err.Error())
+			} else {
+				log.Errorf("error while creating the %s chain watcher on %s %s: %s", token.Symbol, token.Chain, token.Network, err.Error())
+			}
+			continue
+		}
+		watchers = append(watchers, chainWatcher)
+		if walletReader, ok := chainWatcher.(watcher.WalletReader); ok {
+			walletReaders[ledger.Asset{Token: token.Symbol, Chain: token.Chain, Network: token.Network}] = walletReader
+		}
+	}
+	return watchers, walletReaders
+}
+
+// consumeDeposits matches every deposit from the chain watchers against the
+// orders waiting for it, and publishes the orders funded or completed.
+func consumeDeposits(ctx context.Context, store *database.Store, tokens *registry.Registry, bus *events.Bus, deposits <-chan *watcher.Deposit) {
+	for deposit := range deposits {
+		orderData, err := updateOrderStatus(ctx, store, tokens, bus, deposit)
+		// Reports the deposits which failed before they were recorded
+		deposit.Stored(err)
+		if err != nil {
+			if err == database.ErrNoDocuments {
+				log.Infof("no the order wallet transactions to update: %s", err.Error())
+			} else if err == ErrWrongAmount {
+				log.Infof("not a correct order to update: %s", err.Error())
+			} else if err == ErrDepositRefund {
+				log.Infof("deposit %s:%d is queued for a refund: %s", deposit.TxHash, deposit.Index, err.Error())
+			} else if err == ErrDepositCredited {
+				log.Infof("deposit %s:%d is skipped: %s", deposit.TxHash, deposit.Index, err.Error())
+			} else {
+				log.Errorf("error while finding the order wallet to update: %s", err.Error())
+			}
+			continue
+		}
+		log.Printf("TX Hash:%s, Token:%s, Chain:%s, From:%s, Amount:%s, User Pair:%s, User Amount:%s",
+			deposit.TxHash, deposit.Token, deposit.Chain, deposit.From, deposit.Amount, orderData.Pair, orderData.Amount)
+		log.Printf("order %s status has updated: %s", orderData.ID, orderData.Status)
+	}
+	log.Printf("Stopping deposit matching.")
+}
+
+// updateOrderStatus records the deposit in the deposits ledger and credits it
+// to the oldest order waiting for that token and amount, looked up by the
+// deposit reference and then by the sending wallet.
+// The order status and the deposit are updated together and only from the
+// states they were read in, so a deposit is credited at most once.
+func updateOrderStatus(ctx context.Context, store *database.Store, tokens *registry.Registry, bus *events.Bus, deposit *watcher.Deposit) (*database.OrderData, error) {
+	// Match on the order reference first and fall back to the sender address
+	depositReference := deposit.Reference
+	if len(depositReference) == 0 {
+		token, err := tokens.Find(deposit.Token, deposit.Chain, deposit.Network)
+		if err != nil {
+			return nil, err
+		}
+		if token.Standard == registry.StandardERC20 {
+			depositReference = reference.AmountReference(deposit.Token, deposit.Amount)
+		}
+	}
+	depositRecord, err := recordDeposit(ctx, store, deposit, depositReference)
+	// The watcher can move past the deposit once it is recorded, whatever it is
+	// matched with
+	deposit.Stored(err)
 	if err != nil {
-		log.Fatal(err)
-	}
-	for {
-		select {
-		case <-ticker.C:
-			txs, err := xelisWallet.ListTransactions(wallet.ListTransactionsParams{
-				AcceptOutgoing: false,
-				AcceptIncoming: true,
-				AcceptCoinbase: false,
-				AcceptBurn:     false,
-			})
-			fmt.Println("------- Xelis Transactions ------- ")
+		return nil, err
+	}
+	if depositRecord.Status != database.DepositStatusUnmatched {
+		return nil, ErrDepositCredited
+	}
+	referenceWallets := []*database.OrdererParticipantWallet{}
+	if len(depositReference) > 0 {
+		referenceWallets, err = store.ParticipantWallets.FindByDepositReference(ctx, depositReference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	candidates, err := findDepositCandidates(ctx, store, deposit, referenceWallets)
+	if err != nil {
+		return nil, err
+	}
+	addressWallets := []*database.OrdererParticipantWallet{}
+	if len(candidates) == 0 {
+		addressWallets, err = store.ParticipantWallets.FindByAddress(ctx, deposit.From)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err = findDepositCandidates(ctx, store, deposit, addressWallets)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(candidates) == 0 {
+		orderWallets := append(referenceWallets, addressWallets...)
+		if len(orderWallets) == 0 {
+			return nil, database.ErrNoDocuments
+		}
+		return nil, attributeDeposit(ctx, store, depositRecord, orderWallets)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].orderData.CreationDateTime < candidates[j].orderData.CreationDateTime
+	})
+	for _, candidate := range candidates {
+		creditedEvents := []*events.Event{}
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			creditedData, err := database.TransitionOrder(ctx, store.Orders, &database.OrderFilter{ID: candidate.orderData.ID, Status: candidate.fromStatus}, candidate.toStatus)
 			if err != nil {
-				log.Errorf("error while finding the order wallet transactions: %s", err.Error())
-				continue
+				return err
+			}
+			err = store.Orders.SetDepositDeadline(ctx, candidate.orderData.ID, nil)
+			if err != nil {
+				return err
 			}
-			// TODO: Error handling(including timeout)
-			for _, tx := range txs {
-				orderData, err := updateOrderStatus(db, (*tx.Incoming).From, float64((*tx.Incoming).Transfers[0].Amount))
+			creditedData.DepositDeadline = nil
+			// The taker deposit of a fill fills its amount of the order
+			var parentData *database.OrderData
+			if len(candidate.orderData.ParentID) > 0 && candidate.toStatus == database.OrderStatusType6 {
+				parentData, err = database.FillOrder(ctx, store.Orders, &database.OrderFilter{ID: candidate.orderData.ParentID}, candidate.orderData.Amount, decimal.Zero())
 				if err != nil {
-					if err == mongo.ErrNoDocuments {
-						log.Infof("no the order wallet transactions to update: %s", err.Error())
-					} else if err == ErrWrongAmount {
-						log.Infof("not a correct order to update: %s", err.Error())
-					} else {
-						log.Errorf("error while finding the order wallet to update: %s", err.Error())
-					}
-					continue
+					return err
 				}
-				log.Printf("TX Hash:%s, IncomingInfo: %+v, From:%s, Amount:%f, User Pair:%s, User Amount:%f", tx.Hash, (*tx.Incoming), (*tx.Incoming).From, float64((*tx.Incoming).Transfers[0].Amount)/10e7, orderData.Pair, orderData.Amount)
-				log.Printf("order %s status has updated: %s", orderData.ID, orderData.Status)
 			}
-		case <-sig:
-			log.Printf("Stopping XEL deposit checking.")
-			return
-		}
-	}
-}
-
-func monitorUSDTtokenswapTransactions(ctx context.Context, db *mongo.Client, tokenswapAddress string, sig chan os.Signal) {
-	// TODO: config rpc uri and username/password
-	// ticker := time.NewTicker(depositCheckTermSeconds * time.Second)
-	// client, err := ethclient.Dial(infuraURL)
-	// if err != nil {
-	// 	log.Fatalf("Failed to connect to the Ethereum client: %v", err)
-	// }
-
-	// usdtContractAddress := common.HexToAddress(usdtAddress)
-
-	// //address := common.HexToAddress(tokenswapAddress)
-
-	// header, err := client.HeaderByNumber(context.Background(), nil)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-
-	// for {
-	// 	select {
-	// 	case <-ticker.C:
-	// 		fmt.Println("------- USDT Transactions ------- ")
-	// 		for i := header.Number; i.BitLen() >= 0; i.Sub(i, big.NewInt(1)) {
-	// 			block, err := client.BlockByNumber(context.Background(), i)
-	// 			if err != nil {
-	// 				log.Fatal(err)
-	// 			}
-
-	// 			for _, tx := range block.Transactions() {
-	// 				fmt.Printf("Transaction hash: %s\n", tx.Hash().Hex())
-	// 				if tx.To() != nil && *tx.To() == tokenAddress {
-	// 					fmt.Printf("Transaction hash: %s\n", tx.Hash().Hex())
-	// 					fmt.Printf("To: %s\n", tx.To().Hex())
-	// 					fmt.Printf("Value: %s\n", tx.Value().String())
-	// 				}
-	// 			}
-	// 		}
-	// 	case <-sig:
-	// 		log.Printf("Stopping XEL deposit checking.")
-	// 		return
-	// 	}
-	// }
-}
-
-func updateOrderStatus(db *mongo.Client, fromAddress string, amount float64) (*database.OrderData, error) {
-	orderWallets := []*database.OrdererParticipantWallet{}
-	filter := bson.M{"order_participant_wallet_address": fromAddress}
-	options := options.FindOneOptions{}
-	// TODO: define the action when the same user send same amount(now just findOne)
-	collection := db.Database(database.tokenswapDatabase).Collection(database.OrderParticipantWalletCollection)
-	cursor, err := collection.Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
+			err = store.Deposits.UpdateStatus(ctx, depositRecord.ID, database.DepositStatusUnmatched, database.DepositStatusMatched, candidate.orderData.ID, candidate.role)
+			if err == database.ErrNonUpdated {
+				return ErrDepositCredited
+			}
+			if err != nil {
+				return err
+			}
+			err = ledger.RecordDepositMatch(ctx, store.Journal, depositRecord, candidate.orderData, candidate.role, tokens)
+			if err != nil {
+				return err
+			}
+			creditedEvents = depositEvents(creditedData, parentData)
+			return webhook.QueueEvents(ctx, store, creditedEvents...)
+		})
+		if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
+			// The order left the status in the meantime
+			continue
+		}
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		candidate.orderData.Status = candidate.toStatus
+		candidate.orderData.DepositDeadline = nil
+		bus.Publish(creditedEvents...)
+		return candidate.orderData, nil
 	}
-	if err = cursor.All(context.TODO(), &orderWallets); err != nil {
-		return nil, err
+	return nil, database.ErrNoDocuments
+}
+
+// depositEvents returns the events of an order credited with a deposit: the
+// maker deposit funds the order and the taker deposit completes it. A fill
+// completed by the taker deposit concerns the maker too, and completes the
+// order it fills once the order is filled whole.
+func depositEvents(orderData, parentData *database.OrderData) []*events.Event {
+	if orderData.Status == database.OrderStatusType2 {
+		return []*events.Event{events.NewOrderEvent(events.OrderFunded, orderData)}
+	}
+	if parentData == nil {
+		return []*events.Event{events.NewOrderEvent(events.OrderCompleted, orderData)}
 	}
-	if len(orderWallets) == 0 {
-		return nil, mongo.ErrNoDocuments
+	depositEvents := []*events.Event{events.NewOrderEvent(events.OrderCompleted, orderData, parentData.UserUUID)}
+	if parentData.Status == database.OrderStatusType6 {
+		depositEvents = append(depositEvents, events.NewOrderEvent(events.OrderCompleted, parentData))
 	}
-	found := false
-	orderData := database.OrderData{}
+	return depositEvents
+}
+
+// findDepositCandidates returns the orders of the wallets waiting for a deposit
+// of the token and amount.
+func findDepositCandidates(ctx context.Context, store *database.Store, deposit *watcher.Deposit, orderWallets []*database.OrdererParticipantWallet) ([]*depositCandidate, error) {
+	candidates := []*depositCandidate{}
 	// TODO: one from wallet must create one order(create/take) per 10 mins
 	for _, orderWallet := range orderWallets {
-		// order create type sell
-		filter = bson.M{"id": orderWallet.OrderID, "order.status": database.OrderStatusType1}
-		collection = db.Database(database.tokenswapDatabase).Collection(database.OrderCollection)
-		err = collection.FindOne(context.TODO(), filter, &options).Decode(&orderData)
-		if err == nil {
-			found = true
-			break
-		} else if err == mongo.ErrNoDocuments {
-			// order take type buy
-			filter = bson.M{"id": orderWallet.OrderID, "order.status": database.OrderStatusType3}
-			collection = db.Database(database.tokenswapDatabase).Collection(database.OrderCollection)
-			err = collection.FindOne(context.TODO(), filter, &options).Decode(&orderData)
-			if err == nil {
-				found = true
-				break
+		orderData, err := store.Orders.FindOne(ctx, &database.OrderFilter{ID: orderWallet.OrderID})
+		if err != nil {
+			if err == database.ErrNoDocuments {
+				continue
 			}
+			return nil, err
+		}
+		// A deposit on one network never completes an order of the other
+		if orderData.Order == nil || orderData.Network != deposit.Network {
+			continue
+		}
+		candidate := newDepositCandidate(orderData, orderWallet.Role)
+		if candidate == nil {
+			continue
 		}
+		orderLeg, err := fee.OrderLeg(orderData.Order, candidate.role)
+		if err != nil {
+			return nil, err
+		}
+		depositToken := orderLeg.DepositToken
+		depositAmount := orderWallet.DepositAmount
+		if depositAmount.IsZero() {
+			// Wallets stored before the deposit amounts were issued are of
+			// orders without fees
+			depositAmount = orderLeg.TradeAmount
+		}
+		if depositToken != deposit.Token || !depositAmount.Equal(deposit.Amount) {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
 
+// attributeDeposit links a deposit which cannot be credited to the order it was
+// sent for. When that order still waits for the deposit, as when the amount is
+// wrong, the deposit stays unmatched and is refunded if the order fails.
+// Otherwise the order does not need it anymore and it is queued for a refund.
+func attributeDeposit(ctx context.Context, store *database.Store, depositRecord *database.Deposit, orderWallets []*database.OrdererParticipantWallet) error {
+	var attributedOrder *database.OrderData
+	attributedRole := ""
+	for _, orderWallet := range orderWallets {
+		orderData, err := store.Orders.FindOne(ctx, &database.OrderFilter{ID: orderWallet.OrderID})
+		if err != nil {
+			if err == database.ErrNoDocuments {
+				continue
+			}
+			return err
+		}
+		if orderData.Order == nil || len(orderWallet.Role) == 0 || orderData.Network != depositRecord.Network {
+			continue
+		}
+		depositToken, err := fee.DepositToken(orderData.Order, orderWallet.Role)
+		if err != nil {
+			return err
+		}
+		if depositToken != depositRecord.Token {
+			continue
+		}
+		if newDepositCandidate(orderData, orderWallet.Role) != nil {
+			attributedOrder, attributedRole = orderData, orderWallet.Role
+			break
+		}
+		// Otherwise prefer the most recent order
+		if attributedOrder == nil || attributedOrder.CreationDateTime < orderData.CreationDateTime {
+			attributedOrder, attributedRole = orderData, orderWallet.Role
+		}
 	}
-	if !found {
-		return nil, mongo.ErrNoDocuments
+	if attributedOrder == nil {
+		return ErrWrongAmount
 	}
-	if orderData.Amount != amount/10e7 {
-		return nil, ErrWrongAmount
+	if newDepositCandidate(attributedOrder, attributedRole) != nil {
+		err := store.Deposits.UpdateStatus(ctx, depositRecord.ID, database.DepositStatusUnmatched, database.DepositStatusUnmatched, attributedOrder.ID, attributedRole)
+		if err != nil {
+			return err
+		}
+		return ErrWrongAmount
 	}
-	orderStatus := ""
-	if orderData.Status == database.OrderStatusType1 {
-		orderStatus = database.OrderStatusType2
-	} else if orderData.Status == database.OrderStatusType3 {
-		orderStatus = database.OrderStatusType6
+	depositRecord.OrderID, depositRecord.Role = attributedOrder.ID, attributedRole
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		err := store.Deposits.UpdateStatus(ctx, depositRecord.ID, database.DepositStatusUnmatched, database.DepositStatusRefundPending, attributedOrder.ID, attributedRole)
+		if err != nil {
+			return err
+		}
+		return ledger.RecordRefundQueued(ctx, store.Journal, depositRecord, database.DepositStatusUnmatched)
+	})
+	if err != nil {
+		return err
+	}
+	return ErrDepositRefund
+}
+
+// recordDeposit stores the deposit in the ledger as unmatched, along with its
+// journal entry. When the same transfer is already stored, the stored record
+// is returned instead.
+func recordDeposit(ctx context.Context, store *database.Store, deposit *watcher.Deposit, depositReference string) (*database.Deposit, error) {
+	currentDateTime := time.Now().Format(database.TimeFormat)
+	depositRecord := &database.Deposit{
+		ID:               fmt.Sprintf("%s:%d", deposit.TxHash, deposit.Index),
+		TxHash:           deposit.TxHash,
+		TransferIndex:    deposit.Index,
+		Token:            deposit.Token,
+		Chain:            deposit.Chain,
+		Network:          deposit.Network,
+		From:             deposit.From,
+		Amount:           deposit.Amount,
+		Reference:        depositReference,
+		Status:           database.DepositStatusUnmatched,
+		CreationDateTime: currentDateTime,
+		UpdateDateTime:   currentDateTime,
+	}
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		err := store.Deposits.Insert(ctx, depositRecord)
+		if err != nil {
+			return err
+		}
+		return ledger.RecordDeposit(ctx, store.Journal, depositRecord)
+	})
+	if err == database.ErrDuplicateDeposit {
+		return store.Deposits.FindOne(ctx, depositRecord.ID)
 	}
-	filter = bson.M{"id": orderData.ID}
-	err = database.UpdateOrderStatus(db, filter, orderStatus)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
-	orderData.Status = orderStatus
-	return &orderData, nil
+	return depositRecord, nil
+}
+
+// depositCandidate is an order a deposit can be credited to.
+type depositCandidate struct {
+	orderData  *database.OrderData
+	role       string
+	fromStatus string
+	toStatus   string
+}
+
+// newDepositCandidate returns the status change a deposit of the given side
+// makes on the order, or nil when the order is not waiting for that side.
+func newDepositCandidate(orderData *database.OrderData, role string) *depositCandidate {
+	if orderData.Order == nil {
+		return nil
+	}
+	if len(role) == 0 {
+		// Wallets stored before the participant roles existed
+		role = database.ParticipantRoleMaker
+		if orderData.Status == database.OrderStatusType3 {
+			role = database.ParticipantRoleTaker
+		}
+	}
+	if role == database.ParticipantRoleMaker && orderData.Status == database.OrderStatusType1 {
+		return &depositCandidate{orderData: orderData, role: role, fromStatus: database.OrderStatusType1, toStatus: database.OrderStatusType2}
+	}
+	if role == database.ParticipantRoleTaker && orderData.Status == database.OrderStatusType3 {
+		return &depositCandidate{orderData: orderData, role: role, fromStatus: database.OrderStatusType3, toStatus: database.OrderStatusType6}
+	}
+	return nil
+}
+
+// newXelisWallets connects the service wallet of every network with a Xelis
+// token, used to issue integrated addresses. Networks whose wallet is not
+// reachable are left out.
+func newXelisWallets(ctx context.Context, tokens *registry.Registry) map[string]*wallet.RPC {
+	xelisWallets := map[string]*wallet.RPC{}
+	for _, token := range serverTokens(tokens) {
+		if token.Standard != registry.StandardXelis {
+			continue
+		}
+		if _, exists := xelisWallets[token.Network]; exists {
+			continue
+		}
+		xelisWallet, err := wallet.NewRPC(ctx, networkEnv(EnvStsvrXelisWalletRPC, token.Network),
+			networkEnv(EnvStsvrXelisWalletID, token.Network), networkEnv(EnvStsvrXelisWalletPassword, token.Network))
+		if err != nil {
+			log.Errorf("error while connecting the %s XEL wallet: %s", token.Network, err.Error())
+			continue
+		}
+		xelisWallets[token.Network] = xelisWallet
+	}
+	return xelisWallets
+}
+
+// newWalletSenders returns the payout senders of the registered tokens keyed by
+// settlement.SenderKey, with one service wallet per chain and network.
+func newWalletSenders(ctx context.Context, tokens *registry.Registry) map[string]settlement.WalletSender {
+	senders := map[string]settlement.WalletSender{}
+	xelisChains := map[string][]string{}
+	erc20Tokens := map[string]map[string]settlement.ERC20Token{}
+	erc20Chains := map[string]*database.Token{}
+	for _, token := range serverTokens(tokens) {
+		switch token.Standard {
+		case registry.StandardXelis:
+			xelisChains[token.Network] = append(xelisChains[token.Network], token.Chain)
+		case registry.StandardERC20:
+			senderKey := settlement.SenderKey(token.Chain, token.Network)
+			if _, exists := erc20Tokens[senderKey]; !exists {
+				erc20Tokens[senderKey] = map[string]settlement.ERC20Token{}
+				erc20Chains[senderKey] = token
+			}
+			erc20Tokens[senderKey][token.Symbol] = settlement.ERC20Token{Contract: token.Contract, Decimals: token.Decimals}
+		}
+	}
+	for network, chains := range xelisChains {
+		xelisSender, err := settlement.NewXelisSender(ctx, networkEnv(EnvStsvrXelisWalletRPC, network),
+			networkEnv(EnvStsvrXelisWalletID, network), networkEnv(EnvStsvrXelisWalletPassword, network))
+		if err != nil {
+			log.Errorf("error while connecting the %s XEL wallet sender: %s", network, err.Error())
+			continue
+		}
+		for _, chain := range chains {
+			senders[settlement.SenderKey(chain, network)] = xelisSender
+		}
+	}
+	for senderKey, chainTokens := range erc20Tokens {
+		chain, network := erc20Chains[senderKey].Chain, erc20Chains[senderKey].Network
+		privateKey := networkEnv(chainEnvName(EnvStsvrChainPrivateKey, chain), network)
+		if len(privateKey) == 0 {
+			log.Warnf("no private key is set for %s %s. ERC-20 payouts on it are disabled", chain, network)
+			continue
+		}
+		erc20Sender, err := settlement.NewERC20Sender(ctx, networkEnv(chainEnvName(EnvStsvrChainRPC, chain), network), privateKey, chainTokens)
+		if err != nil {
+			log.Errorf("error while connecting the ERC-20 wallet sender on %s %s: %s", chain, network, err.Error())
+			continue
+		}
+		senders[senderKey] = erc20Sender
+	}
+	return senders
 }
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/watcher"
+)
+
+// insertWaitingOrder stores an order of the pair on testnet and the wallet of
+// its maker, who deposits amount from address.
+func insertWaitingOrder(t *testing.T, store *database.Store, id, orderType, status, address, depositReference string, amount decimal.Amount) {
+	t.Helper()
+	ctx := context.Background()
+	err := store.Orders.Insert(ctx, &database.OrderData{
+		ID: id,
+		Order: &database.Order{
+			Type:    orderType,
+			Pair:    "XEL/USDT",
+			Amount:  decimal.MustParse("10"),
+			Price:   decimal.MustParse("2"),
+			Chain:   "ethereum",
+			Network: "testnet",
+			Status:  status,
+		},
+		RemainingAmount:  decimal.MustParse("10"),
+		UserUUID:         "maker",
+		CreationDateTime: "2026-01-01 00:00:00 UTC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ParticipantWallets.Insert(ctx, &database.OrdererParticipantWallet{
+		OrderID:                         id,
+		Role:                            database.ParticipantRoleMaker,
+		OrdererParticipantWalletAddress: address,
+		DepositAmount:                   amount,
+		DepositReference:                depositReference,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	tokens, err := registry.New([]*database.Token{
+		{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: "^xel:"},
+		{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, AddressPattern: "^0x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usdtAmount := decimal.MustParse("20.000123")
+	insertWaitingOrder(t, store, "by-reference", "buy", database.OrderStatusType1, "0xmaker", reference.AmountReference("USDT", usdtAmount), usdtAmount)
+	insertWaitingOrder(t, store, "by-sender", "sell", database.OrderStatusType1, "xel:maker", "", decimal.MustParse("10"))
+	insertWaitingOrder(t, store, "cancelled", "sell", database.OrderStatusType5, "xel:cancelled", "", decimal.MustParse("10"))
+
+	// Sent from another wallet than the maker's, so only the amount matches
+	byReference := &watcher.Deposit{Token: "USDT", Chain: "ethereum", Network: "testnet", From: "0xexchange", Amount: usdtAmount, TxHash: "0x01"}
+	bySender := &watcher.Deposit{Token: "XEL", Chain: "xelis", Network: "testnet", From: "xel:maker", Amount: decimal.MustParse("10"), TxHash: "02"}
+	toCancelled := &watcher.Deposit{Token: "XEL", Chain: "xelis", Network: "testnet", From: "xel:cancelled", Amount: decimal.MustParse("10"), TxHash: "03"}
+	fakeWatcher := watcher.NewFakeWatcher(byReference, bySender, bySender, toCancelled)
+	err = fakeWatcher.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeWatcher.Stop()
+	bus := events.NewBus()
+	for _, step := range []struct {
+		name    string
+		orderID string
+		err     error
+	}{
+		{name: "match by reference", orderID: "by-reference"},
+		{name: "match by sender", orderID: "by-sender"},
+		{name: "duplicate deposit", err: ErrDepositCredited},
+		{name: "deposit to a cancelled order", err: ErrDepositRefund},
+	} {
+		orderData, err := updateOrderStatus(ctx, store, tokens, bus, <-fakeWatcher.Deposits())
+		if err != step.err {
+			t.Fatalf("%s: error = %v, want %v", step.name, err, step.err)
+		}
+		if err != nil {
+			continue
+		}
+		if orderData.ID != step.orderID || orderData.Status != database.OrderStatusType2 {
+			t.Fatalf("%s: order %s is %s, want %s %s", step.name, orderData.ID, orderData.Status, step.orderID, database.OrderStatusType2)
+		}
+	}
+
+	for _, orderID := range []string{"by-reference", "by-sender"} {
+		orderData, err := store.Orders.FindOne(ctx, &database.OrderFilter{ID: orderID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orderData.Status != database.OrderStatusType2 {
+			t.Errorf("order %s is %s, want %s", orderID, orderData.Status, database.OrderStatusType2)
+		}
+	}
+	// The deposit sent twice is credited once
+	matches, err := store.Journal.Find(ctx, &database.JournalFilter{DepositID: "02:0", Kind: ledger.EntryKindDepositMatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("%d deposit matches of the deposit sent twice, want 1", len(matches))
+	}
+	for depositID, want := range map[string]string{
+		"0x01:0": database.DepositStatusMatched,
+		"02:0":   database.DepositStatusMatched,
+		"03:0":   database.DepositStatusRefundPending,
+	} {
+		deposit, err := store.Deposits.FindOne(ctx, depositID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deposit.Status != want {
+			t.Errorf("deposit %s is %s, want %s", depositID, deposit.Status, want)
+		}
+	}
+	refunded, err := store.Deposits.FindOne(ctx, "03:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.OrderID != "cancelled" || refunded.Role != database.ParticipantRoleMaker {
+		t.Errorf("deposit to refund of %s %s, want the maker of the cancelled order", refunded.OrderID, refunded.Role)
+	}
+}
@@ -20,7 +20,10 @@ const (
 )
 
 var (
-	userRoles   = map[string]struct{}{} // To use free / paid account
+	userRoles = map[string]struct{}{
+		database.UserRoleUser:  {},
+		database.UserRoleAdmin: {},
+	}
 	publicPaths = map[string]struct{}{
 		"/api/v1/health":        {},
 		"/api/v1/user/register": {},
@@ -29,8 +32,6 @@ var (
 	}
 )
 
-// var userRole string
-
 func CORSMiddleware() gin.HandlerFunc {
 	return cors.New(cors.Config{
 		// Now for testing, no cors filter but in the future, will add preventing ones.
@@ -41,8 +42,35 @@ func CORSMiddleware() gin.HandlerFunc {
 	})
 }
 
-func UserRoleHandler() gin.HandlerFunc {
+// UserRoleHandler lets through the requests of the users with one of the roles.
+// The role is read from the database on every request rather than from the
+// token, so a role change takes effect right away.
+func UserRoleHandler(users database.UserStore, roles ...string) gin.HandlerFunc {
+	allowedRoles := map[string]struct{}{}
+	for _, role := range roles {
+		allowedRoles[role] = struct{}{}
+	}
 	return func(ctx *gin.Context) {
+		user, err := users.FindOne(ctx, &database.UserFilter{UUID: ctx.GetString("uuid")})
+		if err != nil {
+			log.Error(err)
+			if err == database.ErrNoDocuments {
+				handleResponse(ctx, http.StatusForbidden, "the user of the token does not exist")
+				return
+			}
+			handleResponse(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		role := user.Role
+		if len(role) == 0 {
+			role = database.UserRoleUser
+		}
+		if _, ok := allowedRoles[role]; !ok {
+			err := fmt.Errorf("the %s role is not allowed to access %s", role, ctx.FullPath())
+			log.Error(err)
+			handleResponse(ctx, http.StatusForbidden, err.Error())
+			return
+		}
 		ctx.Next()
 	}
 }
@@ -102,6 +130,18 @@ func UserAuthentication() gin.HandlerFunc {
 					return
 				}
 				ctx.Set("uuid", uuid)
+				// Tokens issued before roles carry none
+				role, _ := claims["role"].(string)
+				if len(role) == 0 {
+					role = database.UserRoleUser
+				}
+				if _, ok := userRoles[role]; !ok {
+					err := fmt.Errorf("token contains an unknown role: %s", role)
+					log.Error(err)
+					handleResponse(ctx, http.StatusUnauthorized, err.Error())
+					return
+				}
+				ctx.Set("role", role)
 			} else {
 				err := fmt.Errorf("jwt token is not valid")
 				log.Error(err)
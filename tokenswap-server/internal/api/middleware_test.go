@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+)
+
+func TestUserRoleHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	err := store.Users.Insert(ctx, &database.User{UUID: "admin", Email: "admin@example.com", Role: database.UserRoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/admin",
+		func(ctx *gin.Context) {
+			// The token of the user, issued while they were an admin
+			ctx.Set("uuid", ctx.GetHeader("X-Test-User"))
+			ctx.Set("role", database.UserRoleAdmin)
+		},
+		UserRoleHandler(store.Users, database.UserRoleAdmin),
+		func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+	request := func(user string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.Header.Set("X-Test-User", user)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if status := request("admin"); status != http.StatusOK {
+		t.Fatalf("admin: status %d, want %d", status, http.StatusOK)
+	}
+	err = store.Users.UpdateRole(ctx, "admin", database.UserRoleUser, time.Now().Format(database.TimeFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := request("admin"); status != http.StatusForbidden {
+		t.Fatalf("demoted admin: status %d, want %d", status, http.StatusForbidden)
+	}
+	if status := request("deleted"); status != http.StatusForbidden {
+		t.Fatalf("unknown user: status %d, want %d", status, http.StatusForbidden)
+	}
+}
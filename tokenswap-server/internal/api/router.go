@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/rocky2015aaa/tokenswap-server/internal/api/handlers"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,19 +17,33 @@ func NewRouter(handler *handlers.Handler) http.Handler {
 
 	v1.GET("/health", handler.Ping)
 	v1.GET("/auth/ping", handler.Ping)
+	v1.GET("/stream", handler.StreamEvents)
 
-	// // Admin routes
-	// admin := v1.Group("/admin")
-	// {
-	// 	// All admin management like update fee rate and so on
-	// }
+	// Admin routes
+	admin := v1.Group("/admin", UserRoleHandler(handler.Store.Users, database.UserRoleAdmin))
+	{
+		admin.GET("/reconciliation/reports", handler.GetReconciliationReports)
+		admin.GET("/reconciliation/reports/:id", handler.GetReconciliationReport)
+		admin.POST("/reconciliation/run", handler.RunReconciliation)
+		admin.PATCH("/order/common", handler.UpdateOrderCommonInfo)
+		admin.PATCH("/order/cancel", handler.ForceCancelOrder)
+		admin.PATCH("/order/resolve", handler.ResolveOrder)
+		admin.GET("/users", handler.GetUsers)
+		admin.PATCH("/user/role", handler.UpdateUserRole)
+	}
 
 	// User routes
 	user := v1.Group("/user")
 	{
 		user.GET("/", handler.GetUserInfo)
 		user.POST("/register", handler.Register)
-		user.POST("/verfication", handler.Verification)
+		user.POST("/verfication", handler.Verification) // kept for the clients before the verification route
+		user.POST("/verification", handler.Verification)
+		user.POST("/email/verify", handler.VerifyEmail)
+		user.POST("/email/resend", handler.ResendEmailVerification)
+		user.POST("/2fa/enroll", handler.EnrollTwoFactor)
+		user.POST("/2fa/confirm", handler.ConfirmTwoFactor)
+		user.POST("/2fa/disable", handler.DisableTwoFactor)
 		user.PATCH("/update-password", handler.UpdatePassword)
 	}
 
@@ -40,6 +55,16 @@ func NewRouter(handler *handlers.Handler) http.Handler {
 		token.POST("/renew-exp", handler.RenewTokensWithCustomExpiration)
 	}
 
+	// Webhook routes
+	webhook := v1.Group("/webhook")
+	{
+		webhook.POST("/", handler.CreateWebhook)
+		webhook.GET("/", handler.GetWebhooks)
+		webhook.DELETE("/:id", handler.DeleteWebhook)
+		webhook.GET("/:id/deliveries", handler.GetWebhookDeliveries)
+		webhook.POST("/:id/test", handler.TestWebhook)
+	}
+
 	// Order routes
 	order := v1.Group("/order")
 	{
@@ -48,6 +73,7 @@ func NewRouter(handler *handlers.Handler) http.Handler {
 		order.PATCH("/cancel", handler.CancelOrder)
 		order.GET("/list", handler.GetOrderList)         // for order list(public/private, user/orderbook, order_id)?
 		order.GET("/common", handler.GetOrderCommonInfo) // for order infor like fee rate or something?
+		order.POST("/fee", handler.PreviewOrderFee)
 	}
 
 	router.NoMethod(func(c *gin.Context) {
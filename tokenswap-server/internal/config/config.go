@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"testing"
 
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
@@ -21,6 +22,25 @@ const (
 	EnvStsvrMongodbUri = "STSVR_MONGODB_URI"
 	EnvStsvrGinMode    = "STSVR_GIN_MODE"
 	EnvStsvrProfile    = "STSVR_PROFILE"
+	EnvStsvrStorage    = "STSVR_STORAGE"
+	EnvStsvrNetwork    = "STSVR_NETWORK"
+	// EnvStsvrMatchingEngine turns on the matching engine of public orders when set to true.
+	EnvStsvrMatchingEngine = "STSVR_MATCHING_ENGINE"
+	// EnvStsvrAdminEmails lists the comma separated emails of the users who get
+	// the admin role when they register.
+	EnvStsvrAdminEmails = "STSVR_ADMIN_EMAILS"
+	// EnvStsvrMailer selects how the emails are sent, smtp or file. The file
+	// mailer writes them to STSVR_MAIL_FILE, or logs them when it is empty.
+	EnvStsvrMailer       = "STSVR_MAILER"
+	EnvStsvrMailFrom     = "STSVR_MAIL_FROM"
+	EnvStsvrMailFile     = "STSVR_MAIL_FILE"
+	EnvStsvrSmtpHost     = "STSVR_SMTP_HOST"
+	EnvStsvrSmtpPort     = "STSVR_SMTP_PORT"
+	EnvStsvrSmtpUsername = "STSVR_SMTP_USERNAME"
+	EnvStsvrSmtpPassword = "STSVR_SMTP_PASSWORD"
+
+	StorageMemory = "memory"
+	MailerSMTP    = "smtp"
 
 	EnvFile = ".env"
 )
@@ -32,7 +52,8 @@ func init() {
 		envProfile = fmt.Sprintf("_%s", envProfile)
 	}
 	err := godotenv.Load(EnvFile + envProfile)
-	if err != nil {
+	// Tests run in their package directory and set the environment they need
+	if err != nil && !testing.Testing() {
 		log.Fatalf("Error loading %s file: %v", EnvFile, err)
 	}
 	logLevel, err := log.ParseLevel(os.Getenv(EnvStsvrLogLevel))
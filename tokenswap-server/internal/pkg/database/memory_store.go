@@ -0,0 +1,1123 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+// memoryDB holds every collection of the in-memory backend behind one lock.
+type memoryDB struct {
+	mu                 sync.RWMutex
+	txMu               sync.Mutex
+	users              []*User
+	orders             []*OrderData
+	participantWallets []*OrdererParticipantWallet
+	commonInfo         *OrderCommonInfo
+	chainCursors       map[string]*ChainCursor
+	deposits           []*Deposit
+	trades             []*Trade
+	journal            []*JournalEntry
+	reconciliations    []*ReconciliationReport
+	webhooks           []*Webhook
+	webhookDeliveries  []*WebhookDelivery
+	amountReservations []*AmountReservation
+}
+
+// NewMemoryStore returns a Store that keeps everything in process memory. It is
+// meant for tests and local demos where no MongoDB is available.
+func NewMemoryStore() *Store {
+	db := &memoryDB{chainCursors: map[string]*ChainCursor{}}
+	return &Store{
+		Users:              &memoryUserStore{db: db},
+		Orders:             &memoryOrderStore{db: db},
+		ParticipantWallets: &memoryParticipantWalletStore{db: db},
+		CommonInfo:         &memoryCommonInfoStore{db: db},
+		ChainCursors:       &memoryChainCursorStore{db: db},
+		Deposits:           &memoryDepositStore{db: db},
+		Trades:             &memoryTradeStore{db: db},
+		Journal:            &memoryJournalStore{db: db},
+		Reconciliations:    &memoryReconciliationStore{db: db},
+		Webhooks:           &memoryWebhookStore{db: db},
+		WebhookDeliveries:  &memoryWebhookDeliveryStore{db: db},
+		AmountReservations: &memoryAmountReservationStore{db: db},
+		Transactor:         db,
+	}
+}
+
+// memoryTx is a transaction of the in-memory backend. The writes made in it
+// log how to revert them, so a rollback reverts only the writes of the
+// transaction and keeps those made outside of it meanwhile. Unlike MongoDB the
+// writes are seen outside the transaction before it commits.
+type memoryTx struct {
+	db   *memoryDB
+	undo []func()
+}
+
+type memoryTxKey struct{}
+
+// WithTransaction runs transactions one at a time and reverts the writes of fn
+// when it fails. A transaction started in fn runs as part of the outer one.
+func (db *memoryDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok && tx.db == db {
+		return fn(ctx)
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	tx := &memoryTx{db: db}
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, tx)); err != nil {
+		db.rollback(tx)
+		return err
+	}
+	return nil
+}
+
+// logUndo records how to revert a write when it is made in a transaction. It
+// is called with db.mu held.
+func (db *memoryDB) logUndo(ctx context.Context, undo func()) {
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok && tx.db == db {
+		tx.undo = append(tx.undo, undo)
+	}
+}
+
+func (db *memoryDB) rollback(tx *memoryTx) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+// removeItem removes the item stored at the pointer from a collection.
+func removeItem[T any](items []*T, item *T) []*T {
+	return slices.DeleteFunc(items, func(storedItem *T) bool {
+		return storedItem == item
+	})
+}
+
+type memoryUserStore struct {
+	db *memoryDB
+}
+
+func (s *memoryUserStore) FindOne(ctx context.Context, filter *UserFilter) (*User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, user := range s.db.users {
+		if matchUser(user, filter) {
+			return copyUser(user), nil
+		}
+	}
+	return nil, ErrNoDocuments
+}
+
+func (s *memoryUserStore) Find(ctx context.Context, filter *UserFilter) ([]*User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	users := []*User{}
+	for _, user := range s.db.users {
+		if matchUser(user, filter) {
+			users = append(users, copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) Insert(ctx context.Context, user *User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, storedUser := range s.db.users {
+		if storedUser.UUID == user.UUID || storedUser.Email == user.Email {
+			return ErrDuplicateUser
+		}
+	}
+	insertedUser := copyUser(user)
+	s.db.users = append(s.db.users, insertedUser)
+	s.db.logUndo(ctx, func() {
+		s.db.users = removeItem(s.db.users, insertedUser)
+	})
+	return nil
+}
+
+func (s *memoryUserStore) UpdateTokenExpiration(ctx context.Context, uuid string, expirationTimeInSeconds int, updateDateTime string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		user.TokenExpirationTimeInSeconds = expirationTimeInSeconds
+		user.UpdateDateTime = updateDateTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) UpdatePassword(ctx context.Context, uuid, hashedPassword, updateDateTime string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		user.Password = hashedPassword
+		user.UpdateDateTime = updateDateTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) UpdateRole(ctx context.Context, uuid, role, updateDateTime string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		user.Role = role
+		user.UpdateDateTime = updateDateTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) SetEmailVerification(ctx context.Context, uuid string, verification *EmailVerification, updateDateTime string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		if user.EmailVerified {
+			return false
+		}
+		verificationCopy := *verification
+		user.EmailVerification = &verificationCopy
+		user.UpdateDateTime = updateDateTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) AddEmailVerificationAttempt(ctx context.Context, uuid string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		if user.EmailVerification == nil {
+			return false
+		}
+		user.EmailVerification.Attempts++
+		return true
+	})
+}
+
+func (s *memoryUserStore) VerifyEmail(ctx context.Context, uuid, updateDateTime string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		if user.EmailVerified {
+			return false
+		}
+		user.EmailVerified = true
+		user.EmailVerification = nil
+		user.UpdateDateTime = updateDateTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) SetTwoFactor(ctx context.Context, uuid string, twoFactor *TwoFactor, updateDateTime string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		user.TwoFactor = copyTwoFactor(twoFactor)
+		user.UpdateDateTime = updateDateTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) AddTwoFactorAttempt(ctx context.Context, uuid string, attemptTime time.Time, maxAttempts int, lockout time.Duration) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		twoFactor := user.TwoFactor
+		if twoFactor == nil {
+			return false
+		}
+		if twoFactor.FailedAttempts >= maxAttempts && twoFactor.LastAttemptTime != nil && twoFactor.LastAttemptTime.After(attemptTime.Add(-lockout)) {
+			return false
+		}
+		twoFactor.FailedAttempts++
+		twoFactor.LastAttemptTime = &attemptTime
+		return true
+	})
+}
+
+func (s *memoryUserStore) UseTwoFactorStep(ctx context.Context, uuid string, step int64) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		if user.TwoFactor == nil || user.TwoFactor.LastUsedStep >= step {
+			return false
+		}
+		user.TwoFactor.LastUsedStep = step
+		user.TwoFactor.FailedAttempts = 0
+		return true
+	})
+}
+
+func (s *memoryUserStore) UseRecoveryCode(ctx context.Context, uuid, codeHash string) error {
+	return s.update(ctx, uuid, func(user *User) bool {
+		if user.TwoFactor == nil {
+			return false
+		}
+		index := slices.Index(user.TwoFactor.RecoveryCodeHashes, codeHash)
+		if index < 0 {
+			return false
+		}
+		user.TwoFactor.RecoveryCodeHashes = slices.Delete(user.TwoFactor.RecoveryCodeHashes, index, index+1)
+		user.TwoFactor.FailedAttempts = 0
+		return true
+	})
+}
+
+func (s *memoryUserStore) update(ctx context.Context, uuid string, apply func(user *User) bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, user := range s.db.users {
+		if user.UUID == uuid {
+			previous := copyUser(user)
+			if !apply(user) {
+				return ErrNonUpdated
+			}
+			s.db.logUndo(ctx, func() {
+				*user = *previous
+			})
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+type memoryOrderStore struct {
+	db *memoryDB
+}
+
+func (s *memoryOrderStore) FindOne(ctx context.Context, filter *OrderFilter) (*OrderData, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, order := range s.db.orders {
+		if matchOrder(order, filter) {
+			return copyOrderData(order), nil
+		}
+	}
+	return nil, ErrNoDocuments
+}
+
+func (s *memoryOrderStore) Find(ctx context.Context, filter *OrderFilter) ([]*OrderData, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	orders := []*OrderData{}
+	for _, order := range s.db.orders {
+		if matchOrder(order, filter) {
+			orders = append(orders, copyOrderData(order))
+		}
+	}
+	return orders, nil
+}
+
+// FindPage reads the whole orders, the projection of the page is left to the
+// MongoDB store.
+func (s *memoryOrderStore) FindPage(ctx context.Context, filter *OrderFilter, page *OrderPage) ([]*OrderData, error) {
+	orders, err := s.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return pageOrders(orders, page), nil
+}
+
+func (s *memoryOrderStore) Insert(ctx context.Context, order *OrderData) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	insertedOrder := copyOrderData(order)
+	s.db.orders = append(s.db.orders, insertedOrder)
+	s.db.logUndo(ctx, func() {
+		s.db.orders = removeItem(s.db.orders, insertedOrder)
+	})
+	return nil
+}
+
+func (s *memoryOrderStore) UpdateStatus(ctx context.Context, filter *OrderFilter, status string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, order := range s.db.orders {
+		if matchOrder(order, filter) {
+			s.logUndo(ctx, order)
+			order.Status = status
+			order.UpdateDateTime = currentDateTime()
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+func (s *memoryOrderStore) UpdatePayouts(ctx context.Context, id, fromStatus string, payouts []*Payout, feeRevenue []*FeeRevenue, status string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, order := range s.db.orders {
+		if order.ID == id && order.Order != nil && order.Status == fromStatus {
+			s.logUndo(ctx, order)
+			order.Payouts = copyPayouts(payouts)
+			order.FeeRevenue = copyFeeRevenue(feeRevenue)
+			order.Status = status
+			order.UpdateDateTime = currentDateTime()
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+func (s *memoryOrderStore) UpdateFill(ctx context.Context, from *OrderData, filledAmount, remainingAmount decimal.Amount, status string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, order := range s.db.orders {
+		if order.ID == from.ID && order.Order != nil && order.Status == from.Status &&
+			order.FilledAmount.Equal(from.FilledAmount) && order.RemainingAmount.Equal(from.RemainingAmount) {
+			s.logUndo(ctx, order)
+			order.FilledAmount = filledAmount
+			order.RemainingAmount = remainingAmount
+			order.Status = status
+			order.UpdateDateTime = currentDateTime()
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+func (s *memoryOrderStore) SetDepositDeadline(ctx context.Context, id string, deadline *time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, order := range s.db.orders {
+		if order.ID == id {
+			s.logUndo(ctx, order)
+			order.DepositDeadline = copyTime(deadline)
+			order.UpdateDateTime = currentDateTime()
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+// logUndo logs the order as it is before an update. It is called with db.mu
+// held.
+func (s *memoryOrderStore) logUndo(ctx context.Context, order *OrderData) {
+	previous := copyOrderData(order)
+	s.db.logUndo(ctx, func() {
+		*order = *previous
+	})
+}
+
+func (s *memoryOrderStore) FindExpired(ctx context.Context, now time.Time) ([]*OrderData, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	orders := []*OrderData{}
+	for _, order := range s.db.orders {
+		if order.Order == nil || order.DepositDeadline == nil || !order.DepositDeadline.Before(now) {
+			continue
+		}
+		if order.Status == OrderStatusType1 || order.Status == OrderStatusType3 {
+			orders = append(orders, copyOrderData(order))
+		}
+	}
+	return orders, nil
+}
+
+type memoryParticipantWalletStore struct {
+	db *memoryDB
+}
+
+func (s *memoryParticipantWalletStore) FindByAddress(ctx context.Context, address string) ([]*OrdererParticipantWallet, error) {
+	return s.find(func(wallet *OrdererParticipantWallet) bool {
+		return wallet.OrdererParticipantWalletAddress == address
+	}), nil
+}
+
+func (s *memoryParticipantWalletStore) FindByOrderID(ctx context.Context, orderID string) ([]*OrdererParticipantWallet, error) {
+	return s.find(func(wallet *OrdererParticipantWallet) bool {
+		return wallet.OrderID == orderID
+	}), nil
+}
+
+func (s *memoryParticipantWalletStore) FindByDepositReference(ctx context.Context, reference string) ([]*OrdererParticipantWallet, error) {
+	return s.find(func(wallet *OrdererParticipantWallet) bool {
+		return wallet.DepositReference == reference
+	}), nil
+}
+
+func (s *memoryParticipantWalletStore) find(match func(wallet *OrdererParticipantWallet) bool) []*OrdererParticipantWallet {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	orderWallets := []*OrdererParticipantWallet{}
+	for _, wallet := range s.db.participantWallets {
+		if match(wallet) {
+			orderWallets = append(orderWallets, copyParticipantWallet(wallet))
+		}
+	}
+	return orderWallets
+}
+
+func (s *memoryParticipantWalletStore) Insert(ctx context.Context, wallet *OrdererParticipantWallet) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	insertedWallet := copyParticipantWallet(wallet)
+	s.db.participantWallets = append(s.db.participantWallets, insertedWallet)
+	s.db.logUndo(ctx, func() {
+		s.db.participantWallets = removeItem(s.db.participantWallets, insertedWallet)
+	})
+	return nil
+}
+
+type memoryCommonInfoStore struct {
+	db *memoryDB
+}
+
+func (s *memoryCommonInfoStore) Get(ctx context.Context) (*OrderCommonInfo, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.db.commonInfo == nil {
+		return nil, ErrNoDocuments
+	}
+	return copyOrderCommonInfo(s.db.commonInfo), nil
+}
+
+func (s *memoryCommonInfoStore) Set(ctx context.Context, info *OrderCommonInfo) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	previous := s.db.commonInfo
+	s.db.commonInfo = copyOrderCommonInfo(info)
+	s.db.logUndo(ctx, func() {
+		s.db.commonInfo = previous
+	})
+	return nil
+}
+
+type memoryChainCursorStore struct {
+	db *memoryDB
+}
+
+func (s *memoryChainCursorStore) Get(ctx context.Context, id string) (*ChainCursor, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	cursor, exists := s.db.chainCursors[id]
+	if !exists {
+		return nil, ErrNoDocuments
+	}
+	return copyChainCursor(cursor), nil
+}
+
+func (s *memoryChainCursorStore) Save(ctx context.Context, cursor *ChainCursor) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	previous, exists := s.db.chainCursors[cursor.ID]
+	s.db.chainCursors[cursor.ID] = copyChainCursor(cursor)
+	s.db.logUndo(ctx, func() {
+		if exists {
+			s.db.chainCursors[cursor.ID] = previous
+		} else {
+			delete(s.db.chainCursors, cursor.ID)
+		}
+	})
+	return nil
+}
+
+type memoryDepositStore struct {
+	db *memoryDB
+}
+
+func (s *memoryDepositStore) Insert(ctx context.Context, deposit *Deposit) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, storedDeposit := range s.db.deposits {
+		if storedDeposit.ID == deposit.ID {
+			return ErrDuplicateDeposit
+		}
+	}
+	insertedDeposit := copyDeposit(deposit)
+	s.db.deposits = append(s.db.deposits, insertedDeposit)
+	s.db.logUndo(ctx, func() {
+		s.db.deposits = removeItem(s.db.deposits, insertedDeposit)
+	})
+	return nil
+}
+
+func (s *memoryDepositStore) FindOne(ctx context.Context, id string) (*Deposit, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, deposit := range s.db.deposits {
+		if deposit.ID == id {
+			return copyDeposit(deposit), nil
+		}
+	}
+	return nil, ErrNoDocuments
+}
+
+func (s *memoryDepositStore) Find(ctx context.Context, filter *DepositFilter) ([]*Deposit, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	deposits := []*Deposit{}
+	for _, deposit := range s.db.deposits {
+		if matchDeposit(deposit, filter) {
+			deposits = append(deposits, copyDeposit(deposit))
+		}
+	}
+	return deposits, nil
+}
+
+func (s *memoryDepositStore) UpdateStatus(ctx context.Context, id, fromStatus, status, orderID, role string) error {
+	return s.update(ctx, id, fromStatus, func(deposit *Deposit) {
+		deposit.OrderID = orderID
+		deposit.Role = role
+		deposit.Status = status
+	})
+}
+
+func (s *memoryDepositStore) UpdateRefund(ctx context.Context, id, fromStatus string, refund *Refund, status string) error {
+	return s.update(ctx, id, fromStatus, func(deposit *Deposit) {
+		deposit.Refund = copyRefund(refund)
+		deposit.Status = status
+	})
+}
+
+func (s *memoryDepositStore) update(ctx context.Context, id, fromStatus string, apply func(deposit *Deposit)) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, deposit := range s.db.deposits {
+		if deposit.ID == id && deposit.Status == fromStatus {
+			previous := copyDeposit(deposit)
+			s.db.logUndo(ctx, func() {
+				*deposit = *previous
+			})
+			apply(deposit)
+			deposit.UpdateDateTime = currentDateTime()
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+type memoryTradeStore struct {
+	db *memoryDB
+}
+
+func (s *memoryTradeStore) Insert(ctx context.Context, trade *Trade) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	insertedTrade := copyTrade(trade)
+	s.db.trades = append(s.db.trades, insertedTrade)
+	s.db.logUndo(ctx, func() {
+		s.db.trades = removeItem(s.db.trades, insertedTrade)
+	})
+	return nil
+}
+
+func (s *memoryTradeStore) Find(ctx context.Context, filter *TradeFilter) ([]*Trade, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	trades := []*Trade{}
+	for _, trade := range s.db.trades {
+		if matchTrade(trade, filter) {
+			trades = append(trades, copyTrade(trade))
+		}
+	}
+	return trades, nil
+}
+
+type memoryJournalStore struct {
+	db *memoryDB
+}
+
+func (s *memoryJournalStore) Insert(ctx context.Context, entry *JournalEntry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, storedEntry := range s.db.journal {
+		if storedEntry.ID == entry.ID {
+			return ErrDuplicateEntry
+		}
+	}
+	insertedEntry := copyJournalEntry(entry)
+	s.db.journal = append(s.db.journal, insertedEntry)
+	s.db.logUndo(ctx, func() {
+		s.db.journal = removeItem(s.db.journal, insertedEntry)
+	})
+	return nil
+}
+
+func (s *memoryJournalStore) FindOne(ctx context.Context, id string) (*JournalEntry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, entry := range s.db.journal {
+		if entry.ID == id {
+			return copyJournalEntry(entry), nil
+		}
+	}
+	return nil, ErrNoDocuments
+}
+
+func (s *memoryJournalStore) Find(ctx context.Context, filter *JournalFilter) ([]*JournalEntry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	entries := []*JournalEntry{}
+	for _, entry := range s.db.journal {
+		if matchJournalEntry(entry, filter) {
+			entries = append(entries, copyJournalEntry(entry))
+		}
+	}
+	return entries, nil
+}
+
+type memoryReconciliationStore struct {
+	db *memoryDB
+}
+
+func (s *memoryReconciliationStore) Insert(ctx context.Context, report *ReconciliationReport) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	insertedReport := copyReconciliationReport(report)
+	s.db.reconciliations = append(s.db.reconciliations, insertedReport)
+	s.db.logUndo(ctx, func() {
+		s.db.reconciliations = removeItem(s.db.reconciliations, insertedReport)
+	})
+	return nil
+}
+
+func (s *memoryReconciliationStore) FindOne(ctx context.Context, id string) (*ReconciliationReport, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, report := range s.db.reconciliations {
+		if report.ID == id {
+			return copyReconciliationReport(report), nil
+		}
+	}
+	return nil, ErrNoDocuments
+}
+
+func (s *memoryReconciliationStore) FindLatest(ctx context.Context, limit int) ([]*ReconciliationReport, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	reports := []*ReconciliationReport{}
+	for i := len(s.db.reconciliations) - 1; i >= 0 && len(reports) < limit; i-- {
+		reports = append(reports, copyReconciliationReport(s.db.reconciliations[i]))
+	}
+	return reports, nil
+}
+
+func matchUser(user *User, filter *UserFilter) bool {
+	if len(filter.UUID) > 0 && user.UUID != filter.UUID {
+		return false
+	}
+	if len(filter.Email) > 0 && user.Email != filter.Email {
+		return false
+	}
+	return true
+}
+
+func matchOrder(order *OrderData, filter *OrderFilter) bool {
+	if len(filter.ID) > 0 && order.ID != filter.ID {
+		return false
+	}
+	if len(filter.UserUUID) > 0 && order.UserUUID != filter.UserUUID {
+		return false
+	}
+	if len(filter.ParentID) > 0 && order.ParentID != filter.ParentID {
+		return false
+	}
+	if len(filter.ParentID) == 0 && filter.ExcludeFills && len(order.ParentID) > 0 {
+		return false
+	}
+	if order.Order == nil {
+		return len(filter.Visibility) == 0 && len(filter.Network) == 0 && len(filter.Chain) == 0 &&
+			len(filter.Pair) == 0 && len(filter.Type) == 0 && len(filter.FeePayerType) == 0 && len(filter.Status) == 0
+	}
+	if len(filter.Visibility) > 0 && order.Visibility != filter.Visibility {
+		return false
+	}
+	if len(filter.Network) > 0 && order.Network != filter.Network {
+		return false
+	}
+	if len(filter.Chain) > 0 && order.Chain != filter.Chain {
+		return false
+	}
+	if len(filter.Pair) > 0 && order.Pair != filter.Pair {
+		return false
+	}
+	if len(filter.Type) > 0 && order.Type != filter.Type {
+		return false
+	}
+	if len(filter.FeePayerType) > 0 && order.FeePayerType != filter.FeePayerType {
+		return false
+	}
+	if len(filter.Status) > 0 && order.Status != filter.Status {
+		return false
+	}
+	if len(filter.CreatedFrom) > 0 && order.CreationDateTime < filter.CreatedFrom {
+		return false
+	}
+	if len(filter.CreatedTo) > 0 && order.CreationDateTime > filter.CreatedTo {
+		return false
+	}
+	return true
+}
+
+type memoryWebhookStore struct {
+	db *memoryDB
+}
+
+func (s *memoryWebhookStore) Insert(ctx context.Context, webhook *Webhook) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	insertedWebhook := copyWebhook(webhook)
+	s.db.webhooks = append(s.db.webhooks, insertedWebhook)
+	s.db.logUndo(ctx, func() {
+		s.db.webhooks = removeItem(s.db.webhooks, insertedWebhook)
+	})
+	return nil
+}
+
+func (s *memoryWebhookStore) FindOne(ctx context.Context, filter *WebhookFilter) (*Webhook, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, webhook := range s.db.webhooks {
+		if matchWebhook(webhook, filter) {
+			return copyWebhook(webhook), nil
+		}
+	}
+	return nil, ErrNoDocuments
+}
+
+func (s *memoryWebhookStore) Find(ctx context.Context, filter *WebhookFilter) ([]*Webhook, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	webhooks := []*Webhook{}
+	for _, webhook := range s.db.webhooks {
+		if matchWebhook(webhook, filter) {
+			webhooks = append(webhooks, copyWebhook(webhook))
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *memoryWebhookStore) Delete(ctx context.Context, filter *WebhookFilter) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for i, webhook := range s.db.webhooks {
+		if matchWebhook(webhook, filter) {
+			s.db.webhooks = append(s.db.webhooks[:i:i], s.db.webhooks[i+1:]...)
+			s.db.logUndo(ctx, func() {
+				s.db.webhooks = slices.Insert(s.db.webhooks, min(i, len(s.db.webhooks)), webhook)
+			})
+			return nil
+		}
+	}
+	return ErrNoDocuments
+}
+
+type memoryAmountReservationStore struct {
+	db *memoryDB
+}
+
+func (s *memoryAmountReservationStore) Insert(ctx context.Context, reservation *AmountReservation) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.find(reservation.Token, reservation.Network, reservation.Amount) != nil {
+		return ErrDuplicateAmount
+	}
+	insertedReservation := copyAmountReservation(reservation)
+	s.db.amountReservations = append(s.db.amountReservations, insertedReservation)
+	s.db.logUndo(ctx, func() {
+		s.db.amountReservations = removeItem(s.db.amountReservations, insertedReservation)
+	})
+	return nil
+}
+
+func (s *memoryAmountReservationStore) FindOne(ctx context.Context, token, network string, amount decimal.Amount) (*AmountReservation, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	reservation := s.find(token, network, amount)
+	if reservation == nil {
+		return nil, ErrNoDocuments
+	}
+	return copyAmountReservation(reservation), nil
+}
+
+func (s *memoryAmountReservationStore) Replace(ctx context.Context, from, reservation *AmountReservation) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	held := s.find(from.Token, from.Network, from.Amount)
+	if held == nil || held.OrderID != from.OrderID || held.Role != from.Role {
+		return ErrNonUpdated
+	}
+	previous := copyAmountReservation(held)
+	s.db.logUndo(ctx, func() {
+		*held = *previous
+	})
+	*held = *copyAmountReservation(reservation)
+	return nil
+}
+
+func (s *memoryAmountReservationStore) find(token, network string, amount decimal.Amount) *AmountReservation {
+	for _, reservation := range s.db.amountReservations {
+		if reservation.Token == token && reservation.Network == network && reservation.Amount.Equal(amount) {
+			return reservation
+		}
+	}
+	return nil
+}
+
+type memoryWebhookDeliveryStore struct {
+	db *memoryDB
+}
+
+func (s *memoryWebhookDeliveryStore) Insert(ctx context.Context, delivery *WebhookDelivery) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	insertedDelivery := copyWebhookDelivery(delivery)
+	s.db.webhookDeliveries = append(s.db.webhookDeliveries, insertedDelivery)
+	s.db.logUndo(ctx, func() {
+		s.db.webhookDeliveries = removeItem(s.db.webhookDeliveries, insertedDelivery)
+	})
+	return nil
+}
+
+func (s *memoryWebhookDeliveryStore) FindLatest(ctx context.Context, webhookID string, limit int) ([]*WebhookDelivery, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	deliveries := []*WebhookDelivery{}
+	for i := len(s.db.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.db.webhookDeliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, copyWebhookDelivery(s.db.webhookDeliveries[i]))
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *memoryWebhookDeliveryStore) FindDue(ctx context.Context, now time.Time) ([]*WebhookDelivery, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	deliveries := []*WebhookDelivery{}
+	for _, delivery := range s.db.webhookDeliveries {
+		if delivery.Status == WebhookDeliveryStatusPending && delivery.NextAttemptTime != nil && !delivery.NextAttemptTime.After(now) {
+			deliveries = append(deliveries, copyWebhookDelivery(delivery))
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *memoryWebhookDeliveryStore) ClaimAttempt(ctx context.Context, id string, attempts int, nextAttemptTime time.Time) error {
+	return s.update(ctx, id, attempts, func(delivery *WebhookDelivery) {
+		delivery.Attempts = attempts + 1
+		delivery.NextAttemptTime = &nextAttemptTime
+	})
+}
+
+func (s *memoryWebhookDeliveryStore) RecordAttempt(ctx context.Context, id string, attempts int, status string, responseStatus int, attemptError string) error {
+	return s.update(ctx, id, attempts, func(delivery *WebhookDelivery) {
+		delivery.Status = status
+		delivery.ResponseStatus = responseStatus
+		delivery.Error = attemptError
+	})
+}
+
+func (s *memoryWebhookDeliveryStore) update(ctx context.Context, id string, attempts int, apply func(delivery *WebhookDelivery)) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, delivery := range s.db.webhookDeliveries {
+		if delivery.ID == id && delivery.Status == WebhookDeliveryStatusPending && delivery.Attempts == attempts {
+			previous := copyWebhookDelivery(delivery)
+			s.db.logUndo(ctx, func() {
+				*delivery = *previous
+			})
+			apply(delivery)
+			delivery.UpdateDateTime = currentDateTime()
+			return nil
+		}
+	}
+	return ErrNonUpdated
+}
+
+func matchWebhook(webhook *Webhook, filter *WebhookFilter) bool {
+	if len(filter.ID) > 0 && webhook.ID != filter.ID {
+		return false
+	}
+	if len(filter.UserUUID) > 0 && webhook.UserUUID != filter.UserUUID {
+		return false
+	}
+	return true
+}
+
+func matchDeposit(deposit *Deposit, filter *DepositFilter) bool {
+	if len(filter.OrderID) > 0 && deposit.OrderID != filter.OrderID {
+		return false
+	}
+	if len(filter.Role) > 0 && deposit.Role != filter.Role {
+		return false
+	}
+	if len(filter.Token) > 0 && deposit.Token != filter.Token {
+		return false
+	}
+	if len(filter.From) > 0 && deposit.From != filter.From {
+		return false
+	}
+	if len(filter.Status) > 0 && deposit.Status != filter.Status {
+		return false
+	}
+	return true
+}
+
+func matchTrade(trade *Trade, filter *TradeFilter) bool {
+	if len(filter.OrderID) > 0 && trade.BuyOrderID != filter.OrderID && trade.SellOrderID != filter.OrderID {
+		return false
+	}
+	if len(filter.Pair) > 0 && trade.Pair != filter.Pair {
+		return false
+	}
+	return true
+}
+
+func matchJournalEntry(entry *JournalEntry, filter *JournalFilter) bool {
+	if len(filter.OrderID) > 0 && entry.OrderID != filter.OrderID {
+		return false
+	}
+	if len(filter.DepositID) > 0 && entry.DepositID != filter.DepositID {
+		return false
+	}
+	if len(filter.Kind) > 0 && entry.Kind != filter.Kind {
+		return false
+	}
+	if len(filter.Account) > 0 {
+		for _, line := range entry.Lines {
+			if line.Account == filter.Account {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func copyUser(user *User) *User {
+	userCopy := *user
+	if user.EmailVerification != nil {
+		verificationCopy := *user.EmailVerification
+		userCopy.EmailVerification = &verificationCopy
+	}
+	userCopy.TwoFactor = copyTwoFactor(user.TwoFactor)
+	return &userCopy
+}
+
+func copyTwoFactor(twoFactor *TwoFactor) *TwoFactor {
+	if twoFactor == nil {
+		return nil
+	}
+	twoFactorCopy := *twoFactor
+	twoFactorCopy.RecoveryCodeHashes = slices.Clone(twoFactor.RecoveryCodeHashes)
+	twoFactorCopy.LastAttemptTime = copyTime(twoFactor.LastAttemptTime)
+	return &twoFactorCopy
+}
+
+func copyOrderData(orderData *OrderData) *OrderData {
+	orderDataCopy := *orderData
+	if orderData.Order != nil {
+		orderCopy := *orderData.Order
+		orderDataCopy.Order = &orderCopy
+	}
+	orderDataCopy.Payouts = copyPayouts(orderData.Payouts)
+	orderDataCopy.FeeRevenue = copyFeeRevenue(orderData.FeeRevenue)
+	orderDataCopy.DepositDeadline = copyTime(orderData.DepositDeadline)
+	return &orderDataCopy
+}
+
+func copyPayouts(payouts []*Payout) []*Payout {
+	if payouts == nil {
+		return nil
+	}
+	payoutsCopy := make([]*Payout, 0, len(payouts))
+	for _, payout := range payouts {
+		payoutCopy := *payout
+		payoutsCopy = append(payoutsCopy, &payoutCopy)
+	}
+	return payoutsCopy
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	timeCopy := *t
+	return &timeCopy
+}
+
+func copyParticipantWallet(wallet *OrdererParticipantWallet) *OrdererParticipantWallet {
+	walletCopy := *wallet
+	return &walletCopy
+}
+
+func copyFeeRevenue(feeRevenue []*FeeRevenue) []*FeeRevenue {
+	if feeRevenue == nil {
+		return nil
+	}
+	feeRevenueCopy := make([]*FeeRevenue, 0, len(feeRevenue))
+	for _, tokenRevenue := range feeRevenue {
+		tokenRevenueCopy := *tokenRevenue
+		feeRevenueCopy = append(feeRevenueCopy, &tokenRevenueCopy)
+	}
+	return feeRevenueCopy
+}
+
+func copyOrderCommonInfo(info *OrderCommonInfo) *OrderCommonInfo {
+	infoCopy := *info
+	infoCopy.Pairs = append([]string{}, info.Pairs...)
+	infoCopy.Chains = append([]string{}, info.Chains...)
+	infoCopy.Tokens = []*Token{}
+	for _, token := range info.Tokens {
+		tokenCopy := *token
+		infoCopy.Tokens = append(infoCopy.Tokens, &tokenCopy)
+	}
+	return &infoCopy
+}
+
+func copyChainCursor(cursor *ChainCursor) *ChainCursor {
+	cursorCopy := *cursor
+	cursorCopy.TxHashes = append([]string{}, cursor.TxHashes...)
+	return &cursorCopy
+}
+
+func copyDeposit(deposit *Deposit) *Deposit {
+	depositCopy := *deposit
+	depositCopy.Refund = copyRefund(deposit.Refund)
+	return &depositCopy
+}
+
+func copyRefund(refund *Refund) *Refund {
+	if refund == nil {
+		return nil
+	}
+	refundCopy := *refund
+	return &refundCopy
+}
+
+func copyTrade(trade *Trade) *Trade {
+	tradeCopy := *trade
+	return &tradeCopy
+}
+
+func copyJournalEntry(entry *JournalEntry) *JournalEntry {
+	entryCopy := *entry
+	entryCopy.Lines = make([]*JournalLine, 0, len(entry.Lines))
+	for _, line := range entry.Lines {
+		lineCopy := *line
+		entryCopy.Lines = append(entryCopy.Lines, &lineCopy)
+	}
+	return &entryCopy
+}
+
+func copyReconciliationReport(report *ReconciliationReport) *ReconciliationReport {
+	reportCopy := *report
+	reportCopy.Wallets = make([]*WalletReconciliation, 0, len(report.Wallets))
+	for _, wallet := range report.Wallets {
+		walletCopy := *wallet
+		reportCopy.Wallets = append(reportCopy.Wallets, &walletCopy)
+	}
+	reportCopy.Discrepancies = make([]*Discrepancy, 0, len(report.Discrepancies))
+	for _, discrepancy := range report.Discrepancies {
+		discrepancyCopy := *discrepancy
+		reportCopy.Discrepancies = append(reportCopy.Discrepancies, &discrepancyCopy)
+	}
+	return &reportCopy
+}
+
+func copyWebhook(webhook *Webhook) *Webhook {
+	webhookCopy := *webhook
+	webhookCopy.Events = append([]string{}, webhook.Events...)
+	return &webhookCopy
+}
+
+func copyAmountReservation(reservation *AmountReservation) *AmountReservation {
+	reservationCopy := *reservation
+	return &reservationCopy
+}
+
+func copyWebhookDelivery(delivery *WebhookDelivery) *WebhookDelivery {
+	deliveryCopy := *delivery
+	if delivery.NextAttemptTime != nil {
+		nextAttemptTime := *delivery.NextAttemptTime
+		deliveryCopy.NextAttemptTime = &nextAttemptTime
+	}
+	return &deliveryCopy
+}
@@ -1,51 +1,306 @@
 package database
 
+import (
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+// User is a registered user. Role is one of the UserRole values, users
+// registered before roles have none and are treated as UserRoleUser.
 type User struct {
 	UUID                         string `json:"uuid" bson:"uuid"`
 	Email                        string `json:"email" bson:"email"`
 	Password                     string `json:"password" bson:"password"`
+	Role                         string `json:"role" bson:"role,omitempty"`
 	TokenExpirationTimeInSeconds int    `json:"token_expiration_time_in_seconds" bson:"token_expiration_time_in_seconds"`
 	RegistrationDateTime         string `json:"registration_date_time" bson:"registration_date_time"`
 	UpdateDateTime               string `json:"update_date_time" bson:"update_date_time"`
+	EmailVerified                bool   `json:"email_verified" bson:"email_verified"`
+	// EmailVerification is the code sent to verify the email, until it is verified.
+	EmailVerification *EmailVerification `json:"-" bson:"email_verification,omitempty"`
+	// TwoFactor is the TOTP second factor of the user, from its enrolment.
+	TwoFactor *TwoFactor `json:"-" bson:"two_factor,omitempty"`
+}
+
+// TwoFactor is the TOTP secret of a user. It is enforced once Enabled, after
+// the user confirmed a first code. LastUsedStep is the time step of the last
+// code accepted, so a code is not accepted twice. Only the hashes of the
+// recovery codes are stored, and a recovery code is removed once used.
+// FailedAttempts counts the codes entered since the last correct one, the last
+// of them at LastAttemptTime, so the codes cannot be guessed.
+type TwoFactor struct {
+	Secret             string     `json:"-" bson:"secret"`
+	Enabled            bool       `json:"-" bson:"enabled"`
+	LastUsedStep       int64      `json:"-" bson:"last_used_step"`
+	RecoveryCodeHashes []string   `json:"-" bson:"recovery_code_hashes"`
+	EnrollmentDateTime string     `json:"-" bson:"enrollment_date_time"`
+	FailedAttempts     int        `json:"-" bson:"failed_attempts"`
+	LastAttemptTime    *time.Time `json:"-" bson:"last_attempt_time,omitempty"`
+}
+
+// EmailVerification is a one-time code emailed to a user. Only the hash of the
+// code is stored, and the code is given up after too many wrong attempts.
+type EmailVerification struct {
+	CodeHash       string    `json:"-" bson:"code_hash"`
+	ExpirationTime time.Time `json:"-" bson:"expiration_time"`
+	Attempts       int       `json:"-" bson:"attempts"`
 }
 
 type OrderCommonInfo struct {
-	Fee                float64             `json:"fee" bson:"fee"`
-	Pairs              []string            `json:"pairs" bson:"pairs"`
-	Chains             []string            `json:"chains" bson:"chains"`
-	XelisWalletAddress string              `json:"xelis_wallet_address" bson:"xelis_wallet_address"`
-	UsdtWalletAddress  string              `json:"usdt_wallet_address" bson:"usdt_wallet_address"`
-	UsdcWalletAddress  string              `json:"usdc_wallet_address" bson:"usdc_wallet_address"`
-	DepositTimeout     int                 `json:"deposit_timeout" bson:"deposit_timeout"`
-	FeePayerTypes      map[string]struct{} `json:"fee_payer_types"`
-	Types              map[string]struct{} `json:"types"`
-	Networks           map[string]struct{} `json:"networks"`
-	Visibility         map[string]struct{} `json:"visibilities"`
-	Statuses           map[string]struct{} `json:"statuses"`
+	Fee            decimal.Amount      `json:"fee" bson:"fee"`
+	Pairs          []string            `json:"pairs" bson:"pairs"`
+	Chains         []string            `json:"chains" bson:"chains"`
+	Tokens         []*Token            `json:"tokens" bson:"tokens"`
+	DepositTimeout int                 `json:"deposit_timeout" bson:"deposit_timeout"`
+	FeePayerTypes  map[string]struct{} `json:"fee_payer_types" bson:"-"`
+	Types          map[string]struct{} `json:"types" bson:"-"`
+	Networks       map[string]struct{} `json:"networks" bson:"-"`
+	Visibility     map[string]struct{} `json:"visibilities" bson:"-"`
+	Statuses       map[string]struct{} `json:"statuses" bson:"-"`
+}
+
+// Token is a token the service trades on one chain and network. Standard
+// tells how it is transferred, AddressPattern is the regular expression a
+// wallet address of the chain matches, WalletAddress is the service wallet
+// receiving its deposits and Confirmations is the depth a deposit needs
+// before it is credited.
+type Token struct {
+	Symbol         string `json:"symbol" bson:"symbol" yaml:"symbol"`
+	Chain          string `json:"chain" bson:"chain" yaml:"chain"`
+	Network        string `json:"network" bson:"network" yaml:"network"`
+	Standard       string `json:"standard" bson:"standard" yaml:"standard"`
+	Decimals       int    `json:"decimals" bson:"decimals" yaml:"decimals"`
+	Contract       string `json:"contract,omitempty" bson:"contract,omitempty" yaml:"contract,omitempty"`
+	AddressPattern string `json:"address_pattern" bson:"address_pattern" yaml:"address_pattern"`
+	WalletAddress  string `json:"wallet_address" bson:"wallet_address" yaml:"wallet_address"`
+	Confirmations  uint64 `json:"confirmations" bson:"confirmations" yaml:"confirmations"`
 }
 
+// OrderData is an order as stored. DepositDeadline is set while the order
+// waits for the deposit of one side and is cleared once it arrives.
+//
+// Every take of an order creates a fill, a child order for the taken amount
+// with ParentID set, which carries the taker deposit and is settled on its own.
+// FilledAmount is what the fills with a taker deposit add up to and
+// RemainingAmount is what is left to take. The rest of the amount is reserved by
+// the fills waiting for their taker deposit.
+//
+// FeeRate is the fee rate in effect when the order was created, and FeeRevenue
+// what the service kept of the order once it is settled.
 type OrderData struct {
 	ID string `json:"id,omitempty" bson:"id"`
 	*Order
-	UserUUID         string `json:"user_uuid" bson:"user_uuid"`
-	CreationDateTime string `json:"create_date_time" bson:"creation_date_time"`
-	UpdateDateTime   string `json:"update_date_time" bson:"update_date_time"`
+	ParentID         string         `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	FilledAmount     decimal.Amount `json:"filled_amount" bson:"filled_amount"`
+	RemainingAmount  decimal.Amount `json:"remaining_amount" bson:"remaining_amount"`
+	UserUUID         string         `json:"user_uuid" bson:"user_uuid"`
+	FeeRate          decimal.Amount `json:"fee_rate" bson:"fee_rate"`
+	Payouts          []*Payout      `json:"payouts,omitempty" bson:"payouts,omitempty"`
+	FeeRevenue       []*FeeRevenue  `json:"fee_revenue,omitempty" bson:"fee_revenue,omitempty"`
+	DepositDeadline  *time.Time     `json:"deposit_deadline,omitempty" bson:"deposit_deadline,omitempty"`
+	CreationDateTime string         `json:"create_date_time" bson:"creation_date_time"`
+	UpdateDateTime   string         `json:"update_date_time" bson:"update_date_time"`
 }
 
 type Order struct {
-	Type         string  `json:"type" bson:"type"`
-	Pair         string  `json:"pair" bson:"pair"`
-	Amount       float64 `json:"amount" bson:"amount"`
-	Price        float64 `json:"price" bson:"price"`
-	FeePayerType string  `json:"fee_payer_type" bson:"fee_payer_type"`
-	Chain        string  `json:"chain" bson:"chain"`
-	Network      string  `json:"network" bson:"network"`
-	Visibility   string  `json:"visibility" bson:"visibility"`
-	Referral     string  `json:"referral" bson:"referral"`
-	Status       string  `json:"status" bson:"status"`
+	Type         string         `json:"type" bson:"type"`
+	Pair         string         `json:"pair" bson:"pair"`
+	Amount       decimal.Amount `json:"amount" bson:"amount"`
+	Price        decimal.Amount `json:"price" bson:"price"`
+	FeePayerType string         `json:"fee_payer_type" bson:"fee_payer_type"`
+	Chain        string         `json:"chain" bson:"chain"`
+	Network      string         `json:"network" bson:"network"`
+	Visibility   string         `json:"visibility" bson:"visibility"`
+	Referral     string         `json:"referral" bson:"referral"`
+	Status       string         `json:"status" bson:"status"`
 }
 
 type OrdererParticipantWallet struct {
-	OrderID                         string `json:"order_id" bson:"order_id"`
-	OrdererParticipantWalletAddress string `json:"order_participant_wallet_address" bson:"order_participant_wallet_address"`
+	OrderID                         string         `json:"order_id" bson:"order_id"`
+	Role                            string         `json:"role" bson:"role"`
+	OrdererParticipantWalletAddress string         `json:"order_participant_wallet_address" bson:"order_participant_wallet_address"`
+	PayoutWalletAddress             string         `json:"payout_wallet_address" bson:"payout_wallet_address"`
+	DepositWalletAddress            string         `json:"deposit_wallet_address" bson:"deposit_wallet_address"`
+	DepositAmount                   decimal.Amount `json:"deposit_amount" bson:"deposit_amount"`
+	DepositReference                string         `json:"deposit_reference,omitempty" bson:"deposit_reference,omitempty"`
+}
+
+// Payout is a transfer sent to one side of a completed order.
+type Payout struct {
+	Role           string         `json:"role" bson:"role"`
+	Token          string         `json:"token" bson:"token"`
+	Chain          string         `json:"chain" bson:"chain"`
+	Network        string         `json:"network" bson:"network"`
+	WalletAddress  string         `json:"wallet_address" bson:"wallet_address"`
+	Amount         decimal.Amount `json:"amount" bson:"amount"`
+	Fee            decimal.Amount `json:"fee" bson:"fee"`
+	TxHash         string         `json:"tx_hash,omitempty" bson:"tx_hash,omitempty"`
+	Error          string         `json:"error,omitempty" bson:"error,omitempty"`
+	PayoutDateTime string         `json:"payout_date_time,omitempty" bson:"payout_date_time,omitempty"`
+}
+
+// FeeRevenue is the fee kept in one token of an order.
+type FeeRevenue struct {
+	Token  string         `json:"token" bson:"token"`
+	Amount decimal.Amount `json:"amount" bson:"amount"`
+}
+
+// ChainCursor is the last height a chain watcher has processed. TxHashes are the
+// transactions already processed at that height, so a scan resuming from it
+// skips them.
+type ChainCursor struct {
+	ID             string   `json:"id" bson:"id"`
+	Height         uint64   `json:"height" bson:"height"`
+	TxHashes       []string `json:"tx_hashes" bson:"tx_hashes"`
+	UpdateDateTime string   `json:"update_date_time" bson:"update_date_time"`
+}
+
+// Deposit is a transfer received on a service wallet. ID is the transaction
+// hash and the transfer index, so the same transfer is only stored once.
+// OrderID and Role are set once the deposit is credited to an order or known
+// to belong to one.
+type Deposit struct {
+	ID               string         `json:"id" bson:"id"`
+	TxHash           string         `json:"tx_hash" bson:"tx_hash"`
+	TransferIndex    uint           `json:"transfer_index" bson:"transfer_index"`
+	Token            string         `json:"token" bson:"token"`
+	Chain            string         `json:"chain" bson:"chain"`
+	Network          string         `json:"network" bson:"network"`
+	From             string         `json:"from" bson:"from"`
+	Amount           decimal.Amount `json:"amount" bson:"amount"`
+	Reference        string         `json:"reference,omitempty" bson:"reference,omitempty"`
+	OrderID          string         `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Role             string         `json:"role,omitempty" bson:"role,omitempty"`
+	Status           string         `json:"status" bson:"status"`
+	Refund           *Refund        `json:"refund,omitempty" bson:"refund,omitempty"`
+	CreationDateTime string         `json:"create_date_time" bson:"creation_date_time"`
+	UpdateDateTime   string         `json:"update_date_time" bson:"update_date_time"`
+}
+
+// Trade is a buy and a sell order of one pair crossed by the matching engine.
+// Price is the price of the order which was in the book first.
+type Trade struct {
+	ID               string         `json:"id" bson:"id"`
+	Pair             string         `json:"pair" bson:"pair"`
+	Chain            string         `json:"chain" bson:"chain"`
+	Network          string         `json:"network" bson:"network"`
+	BuyOrderID       string         `json:"buy_order_id" bson:"buy_order_id"`
+	SellOrderID      string         `json:"sell_order_id" bson:"sell_order_id"`
+	Amount           decimal.Amount `json:"amount" bson:"amount"`
+	Price            decimal.Amount `json:"price" bson:"price"`
+	CreationDateTime string         `json:"create_date_time" bson:"creation_date_time"`
+}
+
+// JournalEntry is one balanced movement of funds in the ledger. ID is derived
+// from what caused the movement, so the same movement is only recorded once.
+// The debits and the credits of the lines are equal for every asset.
+type JournalEntry struct {
+	ID               string         `json:"id" bson:"id"`
+	Kind             string         `json:"kind" bson:"kind"`
+	OrderID          string         `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	DepositID        string         `json:"deposit_id,omitempty" bson:"deposit_id,omitempty"`
+	Lines            []*JournalLine `json:"lines" bson:"lines"`
+	CreationDateTime string         `json:"create_date_time" bson:"creation_date_time"`
+}
+
+// JournalLine debits or credits one account in a token on one chain and network.
+type JournalLine struct {
+	Account string         `json:"account" bson:"account"`
+	Token   string         `json:"token" bson:"token"`
+	Chain   string         `json:"chain" bson:"chain"`
+	Network string         `json:"network" bson:"network"`
+	Debit   decimal.Amount `json:"debit" bson:"debit"`
+	Credit  decimal.Amount `json:"credit" bson:"credit"`
+}
+
+// ReconciliationReport compares the service wallets on chain with the ledger,
+// the deposits and the payouts the service recorded at one point in time.
+type ReconciliationReport struct {
+	ID               string                  `json:"id" bson:"id"`
+	Wallets          []*WalletReconciliation `json:"wallets" bson:"wallets"`
+	Discrepancies    []*Discrepancy          `json:"discrepancies" bson:"discrepancies"`
+	CreationDateTime string                  `json:"create_date_time" bson:"creation_date_time"`
+}
+
+// WalletReconciliation is the balance of a service wallet on chain and in the
+// ledger. Drift is the first minus the second. Error is set when the wallet
+// could not be read.
+type WalletReconciliation struct {
+	Token          string         `json:"token" bson:"token"`
+	Chain          string         `json:"chain" bson:"chain"`
+	Network        string         `json:"network" bson:"network"`
+	OnChainBalance decimal.Amount `json:"on_chain_balance" bson:"on_chain_balance"`
+	LedgerBalance  decimal.Amount `json:"ledger_balance" bson:"ledger_balance"`
+	Drift          decimal.Amount `json:"drift" bson:"drift"`
+	Error          string         `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Discrepancy is something found on chain or in the records which does not
+// agree with the rest.
+type Discrepancy struct {
+	Type      string         `json:"type" bson:"type"`
+	Token     string         `json:"token,omitempty" bson:"token,omitempty"`
+	Chain     string         `json:"chain,omitempty" bson:"chain,omitempty"`
+	Network   string         `json:"network,omitempty" bson:"network,omitempty"`
+	OrderID   string         `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	DepositID string         `json:"deposit_id,omitempty" bson:"deposit_id,omitempty"`
+	TxHash    string         `json:"tx_hash,omitempty" bson:"tx_hash,omitempty"`
+	Amount    decimal.Amount `json:"amount" bson:"amount"`
+	Detail    string         `json:"detail" bson:"detail"`
+}
+
+// Refund is a deposit sent back to the wallet it belongs to. Amount is what is
+// sent back, less than the deposit when the fills of its order used part of it.
+type Refund struct {
+	WalletAddress  string         `json:"wallet_address" bson:"wallet_address"`
+	Amount         decimal.Amount `json:"amount" bson:"amount,omitempty"`
+	TxHash         string         `json:"tx_hash,omitempty" bson:"tx_hash,omitempty"`
+	Error          string         `json:"error,omitempty" bson:"error,omitempty"`
+	RefundDateTime string         `json:"refund_date_time,omitempty" bson:"refund_date_time,omitempty"`
+}
+
+// Webhook is a URL a user is notified on of the events of their orders. The
+// payloads are signed with the secret. Events lists the event types sent, all
+// of them when empty.
+type Webhook struct {
+	ID               string   `json:"id" bson:"id"`
+	UserUUID         string   `json:"user_uuid" bson:"user_uuid"`
+	URL              string   `json:"url" bson:"url"`
+	Secret           string   `json:"-" bson:"secret"`
+	Events           []string `json:"events,omitempty" bson:"events,omitempty"`
+	CreationDateTime string   `json:"create_date_time" bson:"creation_date_time"`
+}
+
+// WebhookDelivery is an event sent to a webhook with the outcome of its last
+// attempt. A pending delivery is attempted again from NextAttemptTime until it
+// is delivered or has failed every attempt.
+type WebhookDelivery struct {
+	ID               string     `json:"id" bson:"id"`
+	WebhookID        string     `json:"webhook_id" bson:"webhook_id"`
+	UserUUID         string     `json:"user_uuid" bson:"user_uuid"`
+	Event            string     `json:"event" bson:"event"`
+	OrderID          string     `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Payload          string     `json:"payload" bson:"payload"`
+	Status           string     `json:"status" bson:"status"`
+	Attempts         int        `json:"attempts" bson:"attempts"`
+	NextAttemptTime  *time.Time `json:"next_attempt_time,omitempty" bson:"next_attempt_time,omitempty"`
+	ResponseStatus   int        `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	Error            string     `json:"error,omitempty" bson:"error,omitempty"`
+	CreationDateTime string     `json:"create_date_time" bson:"creation_date_time"`
+	UpdateDateTime   string     `json:"update_date_time" bson:"update_date_time"`
+}
+
+// AmountReservation holds the unique amount an ERC-20 deposit is matched by
+// for the leg of an order. An amount is held by one leg per token and network.
+// It is taken over by another leg once the order of its leg no longer waits
+// for the deposit, or once ExpireTime passed when that order is not stored.
+type AmountReservation struct {
+	Token            string         `json:"token" bson:"token"`
+	Network          string         `json:"network" bson:"network"`
+	Amount           decimal.Amount `json:"amount" bson:"amount"`
+	OrderID          string         `json:"order_id" bson:"order_id"`
+	Role             string         `json:"role" bson:"role"`
+	ExpireTime       time.Time      `json:"expire_time" bson:"expire_time"`
+	CreationDateTime string         `json:"create_date_time" bson:"creation_date_time"`
 }
@@ -0,0 +1,825 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+func NewMongoStore(client *mongo.Client) *Store {
+	return NewMongoStoreWithDatabase(MongoDatabase(client))
+}
+
+// NewMongoStoreWithDatabase returns a Store on another database than the one
+// of the service, like a test database.
+func NewMongoStoreWithDatabase(db *mongo.Database) *Store {
+	return &Store{
+		Users:              &mongoUserStore{collection: db.Collection(UserCollection)},
+		Orders:             &mongoOrderStore{collection: db.Collection(OrderCollection)},
+		ParticipantWallets: &mongoParticipantWalletStore{collection: db.Collection(OrderParticipantWalletCollection)},
+		CommonInfo:         &mongoCommonInfoStore{collection: db.Collection(OrderCommonInfoCollection)},
+		ChainCursors:       &mongoChainCursorStore{collection: db.Collection(ChainCursorCollection)},
+		Deposits:           &mongoDepositStore{collection: db.Collection(DepositCollection)},
+		Trades:             &mongoTradeStore{collection: db.Collection(TradeCollection)},
+		Journal:            &mongoJournalStore{collection: db.Collection(JournalCollection)},
+		Reconciliations:    &mongoReconciliationStore{collection: db.Collection(ReconciliationCollection)},
+		Webhooks:           &mongoWebhookStore{collection: db.Collection(WebhookCollection)},
+		WebhookDeliveries:  &mongoWebhookDeliveryStore{collection: db.Collection(WebhookDeliveryCollection)},
+		AmountReservations: &mongoAmountReservationStore{collection: db.Collection(AmountReservationCollection)},
+		Transactor:         &mongoTransactor{client: db.Client()},
+	}
+}
+
+type mongoTransactor struct {
+	client *mongo.Client
+}
+
+func (t *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+type mongoUserStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoUserStore) FindOne(ctx context.Context, filter *UserFilter) (*User, error) {
+	user := User{}
+	options := options.FindOneOptions{}
+	err := s.collection.FindOne(ctx, userFilterToBson(filter), &options).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *mongoUserStore) Find(ctx context.Context, filter *UserFilter) ([]*User, error) {
+	users := []*User{}
+	cursor, err := s.collection.Find(ctx, userFilterToBson(filter), options.Find().SetSort(bson.D{{Key: "registration_date_time", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *mongoUserStore) Insert(ctx context.Context, user *User) error {
+	_, err := s.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateUser
+	}
+	return err
+}
+
+func (s *mongoUserStore) UpdateTokenExpiration(ctx context.Context, uuid string, expirationTimeInSeconds int, updateDateTime string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"token_expiration_time_in_seconds": expirationTimeInSeconds,
+			"update_date_time":                 updateDateTime,
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid}, updateData)
+}
+
+func (s *mongoUserStore) UpdatePassword(ctx context.Context, uuid, hashedPassword, updateDateTime string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"password":         hashedPassword,
+			"update_date_time": updateDateTime,
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid}, updateData)
+}
+
+func (s *mongoUserStore) UpdateRole(ctx context.Context, uuid, role, updateDateTime string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"role":             role,
+			"update_date_time": updateDateTime,
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid}, updateData)
+}
+
+func (s *mongoUserStore) SetEmailVerification(ctx context.Context, uuid string, verification *EmailVerification, updateDateTime string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"email_verification": verification,
+			"update_date_time":   updateDateTime,
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid, "email_verified": bson.M{"$ne": true}}, updateData)
+}
+
+func (s *mongoUserStore) AddEmailVerificationAttempt(ctx context.Context, uuid string) error {
+	updateData := bson.M{
+		"$inc": bson.M{"email_verification.attempts": 1},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid, "email_verification": bson.M{"$exists": true}}, updateData)
+}
+
+func (s *mongoUserStore) VerifyEmail(ctx context.Context, uuid, updateDateTime string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"email_verified":   true,
+			"update_date_time": updateDateTime,
+		},
+		"$unset": bson.M{"email_verification": ""},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid, "email_verified": bson.M{"$ne": true}}, updateData)
+}
+
+func (s *mongoUserStore) SetTwoFactor(ctx context.Context, uuid string, twoFactor *TwoFactor, updateDateTime string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"two_factor":       twoFactor,
+			"update_date_time": updateDateTime,
+		},
+	}
+	if twoFactor == nil {
+		updateData = bson.M{
+			"$set":   bson.M{"update_date_time": updateDateTime},
+			"$unset": bson.M{"two_factor": ""},
+		}
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid}, updateData)
+}
+
+func (s *mongoUserStore) AddTwoFactorAttempt(ctx context.Context, uuid string, attemptTime time.Time, maxAttempts int, lockout time.Duration) error {
+	filter := bson.M{
+		"uuid":       uuid,
+		"two_factor": bson.M{"$exists": true},
+		"$or": bson.A{
+			bson.M{"two_factor.failed_attempts": bson.M{"$not": bson.M{"$gte": maxAttempts}}},
+			bson.M{"two_factor.last_attempt_time": bson.M{"$lte": attemptTime.Add(-lockout)}},
+		},
+	}
+	updateData := bson.M{
+		"$inc": bson.M{"two_factor.failed_attempts": 1},
+		"$set": bson.M{"two_factor.last_attempt_time": attemptTime},
+	}
+	return updateOne(ctx, s.collection, filter, updateData)
+}
+
+func (s *mongoUserStore) UseTwoFactorStep(ctx context.Context, uuid string, step int64) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"two_factor.last_used_step":  step,
+			"two_factor.failed_attempts": 0,
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid, "two_factor.last_used_step": bson.M{"$lt": step}}, updateData)
+}
+
+func (s *mongoUserStore) UseRecoveryCode(ctx context.Context, uuid, codeHash string) error {
+	updateData := bson.M{
+		"$pull": bson.M{"two_factor.recovery_code_hashes": codeHash},
+		"$set":  bson.M{"two_factor.failed_attempts": 0},
+	}
+	return updateOne(ctx, s.collection, bson.M{"uuid": uuid, "two_factor.recovery_code_hashes": codeHash}, updateData)
+}
+
+type mongoOrderStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoOrderStore) FindOne(ctx context.Context, filter *OrderFilter) (*OrderData, error) {
+	orderData := OrderData{}
+	options := options.FindOneOptions{}
+	err := s.collection.FindOne(ctx, orderFilterToBson(filter), &options).Decode(&orderData)
+	if err != nil {
+		return nil, err
+	}
+	return &orderData, nil
+}
+
+func (s *mongoOrderStore) Find(ctx context.Context, filter *OrderFilter) ([]*OrderData, error) {
+	orders := []*OrderData{}
+	cursor, err := s.collection.Find(ctx, orderFilterToBson(filter))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (s *mongoOrderStore) FindPage(ctx context.Context, filter *OrderFilter, page *OrderPage) ([]*OrderData, error) {
+	sortField := orderSortFields[page.Sort]
+	direction, comparison := 1, "$gt"
+	if page.Descending {
+		direction, comparison = -1, "$lt"
+	}
+	query := orderFilterToBson(filter)
+	if page.After != nil {
+		var afterValue interface{} = page.After.Value
+		if page.Sort != OrderSortCreationTime {
+			afterValue = decimal.MustParse(page.After.Value)
+		}
+		query = bson.M{"$and": bson.A{query, bson.M{"$or": bson.A{
+			bson.M{sortField: bson.M{comparison: afterValue}},
+			bson.M{sortField: afterValue, "id": bson.M{comparison: page.After.ID}},
+		}}}}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: sortField, Value: direction}, {Key: "id", Value: direction}})
+	if page.Limit > 0 {
+		findOptions.SetLimit(int64(page.Limit))
+	}
+	if len(page.Fields) > 0 {
+		projection := bson.M{"id": 1, "user_uuid": 1, sortField: 1}
+		for _, field := range page.Fields {
+			if storedField, ok := orderFields[field]; ok {
+				projection[storedField] = 1
+			}
+		}
+		findOptions.SetProjection(projection)
+	}
+	orders := []*OrderData{}
+	cursor, err := s.collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (s *mongoOrderStore) Insert(ctx context.Context, order *OrderData) error {
+	_, err := s.collection.InsertOne(ctx, order)
+	return err
+}
+
+func (s *mongoOrderStore) UpdateStatus(ctx context.Context, filter *OrderFilter, status string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"order.status":     status,
+			"update_date_time": currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, orderFilterToBson(filter), updateData)
+}
+
+func (s *mongoOrderStore) UpdatePayouts(ctx context.Context, id, fromStatus string, payouts []*Payout, feeRevenue []*FeeRevenue, status string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"payouts":          payouts,
+			"fee_revenue":      feeRevenue,
+			"order.status":     status,
+			"update_date_time": currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"id": id, "order.status": fromStatus}, updateData)
+}
+
+func (s *mongoOrderStore) UpdateFill(ctx context.Context, from *OrderData, filledAmount, remainingAmount decimal.Amount, status string) error {
+	filter := bson.M{
+		"id":               from.ID,
+		"order.status":     from.Status,
+		"filled_amount":    amountCondition(from.FilledAmount),
+		"remaining_amount": amountCondition(from.RemainingAmount),
+	}
+	updateData := bson.M{
+		"$set": bson.M{
+			"filled_amount":    filledAmount,
+			"remaining_amount": remainingAmount,
+			"order.status":     status,
+			"update_date_time": currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, filter, updateData)
+}
+
+func (s *mongoOrderStore) SetDepositDeadline(ctx context.Context, id string, deadline *time.Time) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"update_date_time": currentDateTime(),
+		},
+	}
+	if deadline == nil {
+		updateData["$unset"] = bson.M{"deposit_deadline": ""}
+	} else {
+		updateData["$set"].(bson.M)["deposit_deadline"] = *deadline
+	}
+	return updateOne(ctx, s.collection, bson.M{"id": id}, updateData)
+}
+
+func (s *mongoOrderStore) FindExpired(ctx context.Context, now time.Time) ([]*OrderData, error) {
+	orders := []*OrderData{}
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"deposit_deadline": bson.M{"$lt": now},
+		"order.status":     bson.M{"$in": []string{OrderStatusType1, OrderStatusType3}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+type mongoParticipantWalletStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoParticipantWalletStore) FindByAddress(ctx context.Context, address string) ([]*OrdererParticipantWallet, error) {
+	return s.find(ctx, bson.M{"order_participant_wallet_address": address})
+}
+
+func (s *mongoParticipantWalletStore) FindByOrderID(ctx context.Context, orderID string) ([]*OrdererParticipantWallet, error) {
+	return s.find(ctx, bson.M{"order_id": orderID})
+}
+
+func (s *mongoParticipantWalletStore) FindByDepositReference(ctx context.Context, reference string) ([]*OrdererParticipantWallet, error) {
+	return s.find(ctx, bson.M{"deposit_reference": reference})
+}
+
+func (s *mongoParticipantWalletStore) find(ctx context.Context, filter primitive.M) ([]*OrdererParticipantWallet, error) {
+	orderWallets := []*OrdererParticipantWallet{}
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &orderWallets); err != nil {
+		return nil, err
+	}
+	return orderWallets, nil
+}
+
+func (s *mongoParticipantWalletStore) Insert(ctx context.Context, wallet *OrdererParticipantWallet) error {
+	_, err := s.collection.InsertOne(ctx, wallet)
+	return err
+}
+
+type mongoCommonInfoStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoCommonInfoStore) Get(ctx context.Context) (*OrderCommonInfo, error) {
+	orderCommonInfo := OrderCommonInfo{}
+	options := options.FindOneOptions{}
+	err := s.collection.FindOne(ctx, bson.D{{}}, &options).Decode(&orderCommonInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &orderCommonInfo, nil
+}
+
+func (s *mongoCommonInfoStore) Set(ctx context.Context, info *OrderCommonInfo) error {
+	_, err := s.collection.ReplaceOne(ctx, bson.D{{}}, info, options.Replace().SetUpsert(true))
+	return err
+}
+
+type mongoChainCursorStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoChainCursorStore) Get(ctx context.Context, id string) (*ChainCursor, error) {
+	cursor := ChainCursor{}
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func (s *mongoChainCursorStore) Save(ctx context.Context, cursor *ChainCursor) error {
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"id": cursor.ID}, cursor, options.Replace().SetUpsert(true))
+	return err
+}
+
+type mongoDepositStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoDepositStore) Insert(ctx context.Context, deposit *Deposit) error {
+	updateResult, err := s.collection.UpdateOne(ctx, bson.M{"id": deposit.ID}, bson.M{"$setOnInsert": deposit}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	if updateResult.UpsertedCount == 0 {
+		return ErrDuplicateDeposit
+	}
+	return nil
+}
+
+func (s *mongoDepositStore) FindOne(ctx context.Context, id string) (*Deposit, error) {
+	deposit := Deposit{}
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&deposit)
+	if err != nil {
+		return nil, err
+	}
+	return &deposit, nil
+}
+
+func (s *mongoDepositStore) Find(ctx context.Context, filter *DepositFilter) ([]*Deposit, error) {
+	deposits := []*Deposit{}
+	cursor, err := s.collection.Find(ctx, depositFilterToBson(filter))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &deposits); err != nil {
+		return nil, err
+	}
+	return deposits, nil
+}
+
+func (s *mongoDepositStore) UpdateStatus(ctx context.Context, id, fromStatus, status, orderID, role string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"order_id":         orderID,
+			"role":             role,
+			"status":           status,
+			"update_date_time": currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"id": id, "status": fromStatus}, updateData)
+}
+
+func (s *mongoDepositStore) UpdateRefund(ctx context.Context, id, fromStatus string, refund *Refund, status string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"refund":           refund,
+			"status":           status,
+			"update_date_time": currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"id": id, "status": fromStatus}, updateData)
+}
+
+type mongoTradeStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoTradeStore) Insert(ctx context.Context, trade *Trade) error {
+	_, err := s.collection.InsertOne(ctx, trade)
+	return err
+}
+
+func (s *mongoTradeStore) Find(ctx context.Context, filter *TradeFilter) ([]*Trade, error) {
+	trades := []*Trade{}
+	cursor, err := s.collection.Find(ctx, tradeFilterToBson(filter), options.Find().SetSort(bson.D{{Key: "creation_date_time", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &trades); err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+type mongoJournalStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoJournalStore) Insert(ctx context.Context, entry *JournalEntry) error {
+	updateResult, err := s.collection.UpdateOne(ctx, bson.M{"id": entry.ID}, bson.M{"$setOnInsert": entry}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	if updateResult.UpsertedCount == 0 {
+		return ErrDuplicateEntry
+	}
+	return nil
+}
+
+func (s *mongoJournalStore) FindOne(ctx context.Context, id string) (*JournalEntry, error) {
+	entry := JournalEntry{}
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *mongoJournalStore) Find(ctx context.Context, filter *JournalFilter) ([]*JournalEntry, error) {
+	entries := []*JournalEntry{}
+	cursor, err := s.collection.Find(ctx, journalFilterToBson(filter), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+type mongoReconciliationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoReconciliationStore) Insert(ctx context.Context, report *ReconciliationReport) error {
+	_, err := s.collection.InsertOne(ctx, report)
+	return err
+}
+
+func (s *mongoReconciliationStore) FindOne(ctx context.Context, id string) (*ReconciliationReport, error) {
+	report := ReconciliationReport{}
+	err := s.collection.FindOne(ctx, bson.M{"id": id}).Decode(&report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (s *mongoReconciliationStore) FindLatest(ctx context.Context, limit int) ([]*ReconciliationReport, error) {
+	reports := []*ReconciliationReport{}
+	cursor, err := s.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+type mongoWebhookStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoWebhookStore) Insert(ctx context.Context, webhook *Webhook) error {
+	_, err := s.collection.InsertOne(ctx, webhook)
+	return err
+}
+
+func (s *mongoWebhookStore) FindOne(ctx context.Context, filter *WebhookFilter) (*Webhook, error) {
+	webhook := Webhook{}
+	err := s.collection.FindOne(ctx, webhookFilterToBson(filter)).Decode(&webhook)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (s *mongoWebhookStore) Find(ctx context.Context, filter *WebhookFilter) ([]*Webhook, error) {
+	webhooks := []*Webhook{}
+	cursor, err := s.collection.Find(ctx, webhookFilterToBson(filter), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (s *mongoWebhookStore) Delete(ctx context.Context, filter *WebhookFilter) error {
+	deleteResult, err := s.collection.DeleteOne(ctx, webhookFilterToBson(filter))
+	if err != nil {
+		return err
+	}
+	if deleteResult.DeletedCount == 0 {
+		return ErrNoDocuments
+	}
+	return nil
+}
+
+type mongoAmountReservationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoAmountReservationStore) Insert(ctx context.Context, reservation *AmountReservation) error {
+	_, err := s.collection.InsertOne(ctx, reservation)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateAmount
+	}
+	return err
+}
+
+func (s *mongoAmountReservationStore) FindOne(ctx context.Context, token, network string, amount decimal.Amount) (*AmountReservation, error) {
+	reservation := AmountReservation{}
+	err := s.collection.FindOne(ctx, bson.M{"token": token, "network": network, "amount": amount}).Decode(&reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (s *mongoAmountReservationStore) Replace(ctx context.Context, from, reservation *AmountReservation) error {
+	filter := bson.M{
+		"token":    from.Token,
+		"network":  from.Network,
+		"amount":   from.Amount,
+		"order_id": from.OrderID,
+		"role":     from.Role,
+	}
+	updateResult, err := s.collection.ReplaceOne(ctx, filter, reservation)
+	if err != nil {
+		return err
+	}
+	if updateResult.MatchedCount == 0 {
+		return ErrNonUpdated
+	}
+	return nil
+}
+
+type mongoWebhookDeliveryStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoWebhookDeliveryStore) Insert(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := s.collection.InsertOne(ctx, delivery)
+	return err
+}
+
+func (s *mongoWebhookDeliveryStore) FindLatest(ctx context.Context, webhookID string, limit int) ([]*WebhookDelivery, error) {
+	return s.find(ctx, bson.M{"webhook_id": webhookID}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit)))
+}
+
+func (s *mongoWebhookDeliveryStore) FindDue(ctx context.Context, now time.Time) ([]*WebhookDelivery, error) {
+	return s.find(ctx, bson.M{
+		"status":            WebhookDeliveryStatusPending,
+		"next_attempt_time": bson.M{"$lte": now},
+	}, options.Find().SetSort(bson.D{{Key: "next_attempt_time", Value: 1}}))
+}
+
+func (s *mongoWebhookDeliveryStore) find(ctx context.Context, filter primitive.M, findOptions *options.FindOptions) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+	cursor, err := s.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (s *mongoWebhookDeliveryStore) ClaimAttempt(ctx context.Context, id string, attempts int, nextAttemptTime time.Time) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"attempts":          attempts + 1,
+			"next_attempt_time": nextAttemptTime,
+			"update_date_time":  currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"id": id, "status": WebhookDeliveryStatusPending, "attempts": attempts}, updateData)
+}
+
+func (s *mongoWebhookDeliveryStore) RecordAttempt(ctx context.Context, id string, attempts int, status string, responseStatus int, attemptError string) error {
+	updateData := bson.M{
+		"$set": bson.M{
+			"status":           status,
+			"response_status":  responseStatus,
+			"error":            attemptError,
+			"update_date_time": currentDateTime(),
+		},
+	}
+	return updateOne(ctx, s.collection, bson.M{"id": id, "status": WebhookDeliveryStatusPending, "attempts": attempts}, updateData)
+}
+
+func updateOne(ctx context.Context, collection *mongo.Collection, filter primitive.M, updateData primitive.M) error {
+	updateResult, err := collection.UpdateOne(ctx, filter, updateData)
+	if err != nil {
+		return err
+	}
+	// Check if any document was modified
+	if updateResult.MatchedCount == 0 {
+		return ErrNonUpdated
+	}
+	return nil
+}
+
+func userFilterToBson(filter *UserFilter) primitive.M {
+	query := bson.M{}
+	if len(filter.UUID) > 0 {
+		query["uuid"] = filter.UUID
+	}
+	if len(filter.Email) > 0 {
+		query["email"] = filter.Email
+	}
+	return query
+}
+
+func orderFilterToBson(filter *OrderFilter) primitive.M {
+	query := bson.M{}
+	if len(filter.ID) > 0 {
+		query["id"] = filter.ID
+	}
+	if len(filter.ParentID) > 0 {
+		query["parent_id"] = filter.ParentID
+	} else if filter.ExcludeFills {
+		query["parent_id"] = bson.M{"$exists": false}
+	}
+	if len(filter.UserUUID) > 0 {
+		query["user_uuid"] = filter.UserUUID
+	}
+	if len(filter.Visibility) > 0 {
+		query["order.visibility"] = filter.Visibility
+	}
+	if len(filter.Network) > 0 {
+		query["order.network"] = filter.Network
+	}
+	if len(filter.Chain) > 0 {
+		query["order.chain"] = filter.Chain
+	}
+	if len(filter.Pair) > 0 {
+		query["order.pair"] = filter.Pair
+	}
+	if len(filter.Type) > 0 {
+		query["order.type"] = filter.Type
+	}
+	if len(filter.FeePayerType) > 0 {
+		query["order.fee_payer_type"] = filter.FeePayerType
+	}
+	if len(filter.Status) > 0 {
+		query["order.status"] = filter.Status
+	}
+	if len(filter.CreatedFrom) > 0 || len(filter.CreatedTo) > 0 {
+		creationRange := bson.M{}
+		if len(filter.CreatedFrom) > 0 {
+			creationRange["$gte"] = filter.CreatedFrom
+		}
+		if len(filter.CreatedTo) > 0 {
+			creationRange["$lte"] = filter.CreatedTo
+		}
+		query["creation_date_time"] = creationRange
+	}
+	return query
+}
+
+// amountCondition matches an amount, a zero amount also matching the orders
+// stored before the field existed.
+func amountCondition(amount decimal.Amount) interface{} {
+	if amount.IsZero() {
+		return bson.M{"$in": bson.A{amount, nil}}
+	}
+	return amount
+}
+
+func webhookFilterToBson(filter *WebhookFilter) primitive.M {
+	query := bson.M{}
+	if len(filter.ID) > 0 {
+		query["id"] = filter.ID
+	}
+	if len(filter.UserUUID) > 0 {
+		query["user_uuid"] = filter.UserUUID
+	}
+	return query
+}
+
+func depositFilterToBson(filter *DepositFilter) primitive.M {
+	query := bson.M{}
+	if len(filter.OrderID) > 0 {
+		query["order_id"] = filter.OrderID
+	}
+	if len(filter.Role) > 0 {
+		query["role"] = filter.Role
+	}
+	if len(filter.Token) > 0 {
+		query["token"] = filter.Token
+	}
+	if len(filter.From) > 0 {
+		query["from"] = filter.From
+	}
+	if len(filter.Status) > 0 {
+		query["status"] = filter.Status
+	}
+	return query
+}
+
+func tradeFilterToBson(filter *TradeFilter) primitive.M {
+	query := bson.M{}
+	if len(filter.OrderID) > 0 {
+		query["$or"] = bson.A{bson.M{"buy_order_id": filter.OrderID}, bson.M{"sell_order_id": filter.OrderID}}
+	}
+	if len(filter.Pair) > 0 {
+		query["pair"] = filter.Pair
+	}
+	return query
+}
+
+func journalFilterToBson(filter *JournalFilter) primitive.M {
+	query := bson.M{}
+	if len(filter.OrderID) > 0 {
+		query["order_id"] = filter.OrderID
+	}
+	if len(filter.DepositID) > 0 {
+		query["deposit_id"] = filter.DepositID
+	}
+	if len(filter.Account) > 0 {
+		query["lines.account"] = filter.Account
+	}
+	if len(filter.Kind) > 0 {
+		query["kind"] = filter.Kind
+	}
+	return query
+}
@@ -5,15 +5,18 @@ import (
 	"errors"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	OrderIDdepositSig = make(chan string)
-	ErrNonUpdated     = errors.New("update was not applied in the condition")
+	OrderIDdepositSig   = make(chan string)
+	ErrNonUpdated       = errors.New("update was not applied in the condition")
+	ErrNoDocuments      = mongo.ErrNoDocuments
+	ErrDuplicateDeposit = errors.New("the deposit is already recorded")
+	ErrDuplicateEntry   = errors.New("the journal entry is already recorded")
+	ErrDuplicateAmount  = errors.New("the deposit amount is already reserved")
+	ErrDuplicateUser    = errors.New("a user with the same email or UUID already exists")
 )
 
 const (
@@ -24,13 +27,43 @@ const (
 	OrderParticipantWalletCollection = "order_participant_wallets"
 	ConfigCollection                 = "config"
 	UserCollection                   = "users"
+	ChainCursorCollection            = "chain_cursors"
+	DepositCollection                = "deposits"
+	TradeCollection                  = "trades"
+	JournalCollection                = "journal_entries"
+	ReconciliationCollection         = "reconciliation_reports"
+	WebhookCollection                = "webhooks"
+	WebhookDeliveryCollection        = "webhook_deliveries"
+	MigrationCollection              = "schema_migrations"
+	AmountReservationCollection      = "amount_reservations"
 
-	OrderStatusType1 = "waitingForDeposit"
-	OrderStatusType2 = "active"
-	OrderStatusType3 = "takeInProgress"
-	OrderStatusType4 = "timeout_cancelled"
-	OrderStatusType5 = "cancelled"
-	OrderStatusType6 = "completed"
+	OrderStatusType1  = "waitingForDeposit"
+	OrderStatusType2  = "active"
+	OrderStatusType3  = "takeInProgress"
+	OrderStatusType4  = "timeout_cancelled"
+	OrderStatusType5  = "cancelled"
+	OrderStatusType6  = "completed"
+	OrderStatusType7  = "settling"
+	OrderStatusType8  = "settled"
+	OrderStatusType9  = "settlement_failed"
+	OrderStatusType10 = "partially_filled"
+
+	DepositStatusUnmatched     = "unmatched"
+	DepositStatusMatched       = "matched"
+	DepositStatusRefundPending = "refund_pending"
+	DepositStatusRefunding     = "refunding"
+	DepositStatusRefunded      = "refunded"
+	DepositStatusRefundFailed  = "refund_failed"
+
+	ParticipantRoleMaker = "maker"
+	ParticipantRoleTaker = "taker"
+
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusDelivered = "delivered"
+	WebhookDeliveryStatusFailed    = "failed"
 
 	TimeFormat = "2006-01-02 15:04:05 MST"
 )
@@ -53,20 +86,11 @@ func NewMongoDB(uri string) (*mongo.Client, error) {
 	return client, nil
 }
 
-func UpdateOrderStatus(db *mongo.Client, filter primitive.M, status string) error {
-	updateData := bson.M{
-		"$set": bson.M{
-			"order.status":     status,
-			"update_date_time": time.Now().Format(TimeFormat),
-		},
-	}
-	updateResult, err := db.Database(tokenswapDatabase).Collection(OrderCollection).UpdateOne(context.TODO(), filter, updateData)
-	if err != nil {
-		return err
-	}
-	// Check if any document was modified
-	if updateResult.MatchedCount == 0 {
-		return ErrNonUpdated
-	}
-	return nil
+// MongoDatabase returns the database of the service.
+func MongoDatabase(client *mongo.Client) *mongo.Database {
+	return client.Database(tokenswapDatabase)
+}
+
+func currentDateTime() string {
+	return time.Now().Format(TimeFormat)
 }
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+var (
+	ErrInvalidCursor = errors.New("the order list cursor is invalid")
+)
+
+// The keys an order page can be sorted by. Creation times are stored in the
+// time zone of the server, so they sort as text like the matching engine does.
+const (
+	OrderSortCreationTime = "creation_time"
+	OrderSortPrice        = "price"
+	OrderSortAmount       = "amount"
+)
+
+// orderSortFields maps the sort keys to the fields of the stored orders.
+var orderSortFields = map[string]string{
+	OrderSortCreationTime: "creation_date_time",
+	OrderSortPrice:        "order.price",
+	OrderSortAmount:       "order.amount",
+}
+
+// orderFields maps the fields of an order as served to the fields of the stored
+// orders, for the projection of an order page.
+var orderFields = map[string]string{
+	"id":               "id",
+	"parent_id":        "parent_id",
+	"type":             "order.type",
+	"pair":             "order.pair",
+	"amount":           "order.amount",
+	"price":            "order.price",
+	"fee_payer_type":   "order.fee_payer_type",
+	"chain":            "order.chain",
+	"network":          "order.network",
+	"visibility":       "order.visibility",
+	"referral":         "order.referral",
+	"status":           "order.status",
+	"filled_amount":    "filled_amount",
+	"remaining_amount": "remaining_amount",
+	"user_uuid":        "user_uuid",
+	"fee_rate":         "fee_rate",
+	"payouts":          "payouts",
+	"fee_revenue":      "fee_revenue",
+	"deposit_deadline": "deposit_deadline",
+	"create_date_time": "creation_date_time",
+	"update_date_time": "update_date_time",
+}
+
+// OrderPage selects a page of orders sorted by Sort, then by ID so that orders
+// with the same sort key keep their order from one page to the next. After is
+// the cursor of the last order of the previous page. Fields limits the stored
+// fields read to the given fields of the served orders, the ID, the user and
+// the sort key are always read.
+type OrderPage struct {
+	Sort       string
+	Descending bool
+	Limit      int
+	After      *OrderCursor
+	Fields     []string
+}
+
+// OrderCursor is the position of an order in a page sorted by Sort.
+type OrderCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// IsOrderSort tells whether the key is one an order page can be sorted by.
+func IsOrderSort(sortKey string) bool {
+	_, ok := orderSortFields[sortKey]
+	return ok
+}
+
+// IsOrderField tells whether the field of a served order can be projected.
+func IsOrderField(field string) bool {
+	_, ok := orderFields[field]
+	return ok
+}
+
+// NewOrderCursor returns the cursor of an order in a page sorted by sortKey.
+func NewOrderCursor(sortKey string, orderData *OrderData) *OrderCursor {
+	return &OrderCursor{Sort: sortKey, Value: orderSortValue(sortKey, orderData), ID: orderData.ID}
+}
+
+// Encode returns the cursor as an opaque string for the clients.
+func (c *OrderCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeOrderCursor reads a cursor encoded by Encode for a page sorted by
+// sortKey. It returns ErrInvalidCursor for a cursor of another sort.
+func DecodeOrderCursor(encoded, sortKey string) (*OrderCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := OrderCursor{}
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sort != sortKey || len(cursor.ID) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	if sortKey != OrderSortCreationTime {
+		if _, err := decimal.Parse(cursor.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &cursor, nil
+}
+
+func orderSortValue(sortKey string, orderData *OrderData) string {
+	switch sortKey {
+	case OrderSortPrice:
+		if orderData.Order != nil {
+			return orderData.Price.String()
+		}
+	case OrderSortAmount:
+		if orderData.Order != nil {
+			return orderData.Amount.String()
+		}
+	case OrderSortCreationTime:
+		return orderData.CreationDateTime
+	}
+	return decimal.Zero().String()
+}
+
+// compareOrders compares two orders by the sort key and then by ID.
+func compareOrders(sortKey string, a, b *OrderData) int {
+	if result := compareOrderSortValues(sortKey, orderSortValue(sortKey, a), orderSortValue(sortKey, b)); result != 0 {
+		return result
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+func compareOrderSortValues(sortKey, a, b string) int {
+	if sortKey == OrderSortCreationTime {
+		return strings.Compare(a, b)
+	}
+	return decimal.MustParse(a).Cmp(decimal.MustParse(b))
+}
+
+// pageOrders sorts the orders, skips the ones up to the cursor and keeps the
+// page limit, the way the MongoDB store reads a page.
+func pageOrders(orders []*OrderData, page *OrderPage) []*OrderData {
+	direction := 1
+	if page.Descending {
+		direction = -1
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return direction*compareOrders(page.Sort, orders[i], orders[j]) < 0
+	})
+	pagedOrders := []*OrderData{}
+	for _, order := range orders {
+		if page.After != nil {
+			result := compareOrderSortValues(page.Sort, orderSortValue(page.Sort, order), page.After.Value)
+			if result == 0 {
+				result = strings.Compare(order.ID, page.After.ID)
+			}
+			if direction*result <= 0 {
+				continue
+			}
+		}
+		pagedOrders = append(pagedOrders, order)
+		if page.Limit > 0 && len(pagedOrders) == page.Limit {
+			break
+		}
+	}
+	return pagedOrders
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+var (
+	ErrInvalidTransition = errors.New("the order status transition is not allowed")
+	ErrFillAmount        = errors.New("the fill amount exceeds what is left of the order")
+)
+
+// orderTransitions lists the statuses an order can move to from each status.
+// Statuses without an entry are final. An active order is completed directly
+// when the matching engine crosses it with another order. An order is
+// partially filled once some of its fills have the taker deposit. It can still
+// be cancelled when none of its fills waits for a taker deposit, and only what
+// is left of it is refunded then. A fill waiting for its taker deposit can only be
+// cancelled by an admin, who also settles the orders whose failed payouts were
+// sent by hand.
+var orderTransitions = map[string][]string{
+	OrderStatusType1:  {OrderStatusType2, OrderStatusType4, OrderStatusType5},
+	OrderStatusType2:  {OrderStatusType3, OrderStatusType5, OrderStatusType6, OrderStatusType10},
+	OrderStatusType3:  {OrderStatusType2, OrderStatusType4, OrderStatusType5, OrderStatusType6, OrderStatusType10},
+	OrderStatusType6:  {OrderStatusType7},
+	OrderStatusType7:  {OrderStatusType8, OrderStatusType9},
+	OrderStatusType9:  {OrderStatusType8},
+	OrderStatusType10: {OrderStatusType3, OrderStatusType4, OrderStatusType5, OrderStatusType6},
+}
+
+// TransitionError is returned for a status change the order state machine
+// does not allow. It wraps ErrInvalidTransition.
+type TransitionError struct {
+	OrderID string
+	From    string
+	To      string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order %s cannot move from %s to %s", e.OrderID, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// CanTransition reports whether an order can move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, status := range orderTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransition returns a TransitionError when the order cannot move to status.
+func ValidateTransition(orderID, from, to string) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{OrderID: orderID, From: from, To: to}
+	}
+	return nil
+}
+
+// TransitionOrder moves the order selected by filter to status. When
+// filter.Status is set the order must be in that status. The update is
+// conditional on the status the order was read in, so it returns ErrNonUpdated
+// when the order changed in the meantime.
+func TransitionOrder(ctx context.Context, orders OrderStore, filter *OrderFilter, status string) (*OrderData, error) {
+	orderData, err := orders.FindOne(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if orderData.Order == nil {
+		return nil, fmt.Errorf("order %s has no order data", orderData.ID)
+	}
+	err = ValidateTransition(orderData.ID, orderData.Status, status)
+	if err != nil {
+		return nil, err
+	}
+	err = orders.UpdateStatus(ctx, &OrderFilter{ID: orderData.ID, Status: orderData.Status}, status)
+	if err != nil {
+		return nil, err
+	}
+	orderData.Status = status
+	return orderData, nil
+}
+
+// RemainingAmount returns what is left to take of an order.
+func RemainingAmount(orderData *OrderData) decimal.Amount {
+	if orderData.RemainingAmount.IsZero() && orderData.FilledAmount.IsZero() &&
+		(orderData.Status == OrderStatusType1 || orderData.Status == OrderStatusType2) {
+		// Orders stored before partial fills have no remaining amount
+		return orderData.Amount
+	}
+	return orderData.RemainingAmount
+}
+
+// FillsInProgress reports whether part of an order is reserved by fills
+// waiting for their taker deposit.
+func FillsInProgress(orderData *OrderData) bool {
+	return RemainingAmount(orderData).Add(orderData.FilledAmount).Cmp(orderData.Amount) < 0
+}
+
+// FillStatus returns the status of an order with the given filled and
+// remaining amounts. An order is taken in progress while fills waiting for the
+// taker deposit reserve all of what is left.
+func FillStatus(orderData *OrderData, filledAmount, remainingAmount decimal.Amount) string {
+	switch {
+	case filledAmount.Equal(orderData.Amount):
+		return OrderStatusType6
+	case remainingAmount.IsZero():
+		return OrderStatusType3
+	case filledAmount.Sign() > 0:
+		return OrderStatusType10
+	default:
+		return OrderStatusType2
+	}
+}
+
+// FillOrder adds filledDelta to the filled amount and remainingDelta to the
+// remaining amount of the order selected by filter, and moves it to the status
+// the amounts give. Taking an amount reserves it with a negative remainingDelta,
+// the taker deposit of a fill then adds it to the filled amount and a fill which
+// times out gives it back. It returns ErrFillAmount when the amounts would no
+// longer add up, and ErrNonUpdated when the order changed in the meantime.
+func FillOrder(ctx context.Context, orders OrderStore, filter *OrderFilter, filledDelta, remainingDelta decimal.Amount) (*OrderData, error) {
+	orderData, err := orders.FindOne(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if orderData.Order == nil {
+		return nil, fmt.Errorf("order %s has no order data", orderData.ID)
+	}
+	filledAmount := orderData.FilledAmount.Add(filledDelta)
+	remainingAmount := RemainingAmount(orderData).Add(remainingDelta)
+	if filledAmount.Sign() < 0 || remainingAmount.Sign() < 0 || filledAmount.Add(remainingAmount).Cmp(orderData.Amount) > 0 {
+		return nil, ErrFillAmount
+	}
+	status := FillStatus(orderData, filledAmount, remainingAmount)
+	if status != orderData.Status {
+		err = ValidateTransition(orderData.ID, orderData.Status, status)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = orders.UpdateFill(ctx, orderData, filledAmount, remainingAmount, status)
+	if err != nil {
+		return nil, err
+	}
+	orderData.FilledAmount = filledAmount
+	orderData.RemainingAmount = remainingAmount
+	orderData.Status = status
+	return orderData, nil
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+// Store groups the repositories the API works with. Each field can be backed
+// by MongoDB or by memory, see NewMongoStore and NewMemoryStore.
+type Store struct {
+	Users              UserStore
+	Orders             OrderStore
+	ParticipantWallets ParticipantWalletStore
+	CommonInfo         CommonInfoStore
+	ChainCursors       ChainCursorStore
+	Deposits           DepositStore
+	Trades             TradeStore
+	Journal            JournalStore
+	Reconciliations    ReconciliationStore
+	Webhooks           WebhookStore
+	WebhookDeliveries  WebhookDeliveryStore
+	AmountReservations AmountReservationStore
+	Transactor
+}
+
+// UserFilter selects a user. Empty fields are ignored.
+type UserFilter struct {
+	UUID  string
+	Email string
+}
+
+// OrderFilter selects orders. Empty fields are ignored. ExcludeFills leaves out
+// the fills of other orders. CreatedFrom and CreatedTo bound the creation time,
+// both included, and are written in TimeFormat.
+type OrderFilter struct {
+	ID           string
+	ParentID     string
+	ExcludeFills bool
+	UserUUID     string
+	Visibility   string
+	Network      string
+	Chain        string
+	Pair         string
+	Type         string
+	FeePayerType string
+	Status       string
+	CreatedFrom  string
+	CreatedTo    string
+}
+
+// DepositFilter selects deposits. Empty fields are ignored.
+type DepositFilter struct {
+	OrderID string
+	Role    string
+	Token   string
+	From    string
+	Status  string
+}
+
+// TradeFilter selects trades. OrderID matches both the buy and the sell order.
+// Empty fields are ignored.
+type TradeFilter struct {
+	OrderID string
+	Pair    string
+}
+
+// JournalFilter selects journal entries. Account matches the entries with a
+// line on the account. Empty fields are ignored.
+type JournalFilter struct {
+	OrderID   string
+	DepositID string
+	Account   string
+	Kind      string
+}
+
+// WebhookFilter selects webhooks. Empty fields are ignored.
+type WebhookFilter struct {
+	ID       string
+	UserUUID string
+}
+
+type UserStore interface {
+	FindOne(ctx context.Context, filter *UserFilter) (*User, error)
+	Find(ctx context.Context, filter *UserFilter) ([]*User, error)
+	// Insert returns ErrDuplicateUser when a user with the same email or UUID is
+	// already stored.
+	Insert(ctx context.Context, user *User) error
+	UpdateTokenExpiration(ctx context.Context, uuid string, expirationTimeInSeconds int, updateDateTime string) error
+	UpdatePassword(ctx context.Context, uuid, hashedPassword, updateDateTime string) error
+	UpdateRole(ctx context.Context, uuid, role, updateDateTime string) error
+	// SetEmailVerification replaces the email verification code of a user
+	// whose email is not verified yet.
+	SetEmailVerification(ctx context.Context, uuid string, verification *EmailVerification, updateDateTime string) error
+	// AddEmailVerificationAttempt counts a wrong code entered by a user.
+	AddEmailVerificationAttempt(ctx context.Context, uuid string) error
+	// VerifyEmail marks the email of a user verified and drops the code.
+	VerifyEmail(ctx context.Context, uuid, updateDateTime string) error
+	// SetTwoFactor replaces the second factor of a user, or removes it when
+	// twoFactor is nil.
+	SetTwoFactor(ctx context.Context, uuid string, twoFactor *TwoFactor, updateDateTime string) error
+	// AddTwoFactorAttempt counts a code entered by a user, before it is
+	// checked. It returns ErrNonUpdated when maxAttempts codes were entered
+	// since the last correct one, the last of them less than lockout ago.
+	AddTwoFactorAttempt(ctx context.Context, uuid string, attemptTime time.Time, maxAttempts int, lockout time.Duration) error
+	// UseTwoFactorStep records the time step of an accepted code and resets
+	// the attempts, unless a code of the step or a later one was accepted
+	// already.
+	UseTwoFactorStep(ctx context.Context, uuid string, step int64) error
+	// UseRecoveryCode removes a recovery code of a user and resets the
+	// attempts, unless the code was used already.
+	UseRecoveryCode(ctx context.Context, uuid, codeHash string) error
+}
+
+type OrderStore interface {
+	FindOne(ctx context.Context, filter *OrderFilter) (*OrderData, error)
+	Find(ctx context.Context, filter *OrderFilter) ([]*OrderData, error)
+	// FindPage returns a page of the orders selected by filter, see OrderPage.
+	FindPage(ctx context.Context, filter *OrderFilter, page *OrderPage) ([]*OrderData, error)
+	Insert(ctx context.Context, order *OrderData) error
+	// UpdateStatus sets the status without checking the state machine, see
+	// TransitionOrder.
+	UpdateStatus(ctx context.Context, filter *OrderFilter, status string) error
+	// SetDepositDeadline sets the deposit deadline of an order, or clears it when deadline is nil.
+	SetDepositDeadline(ctx context.Context, id string, deadline *time.Time) error
+	// FindExpired returns the orders waiting for a deposit whose deadline is before now.
+	FindExpired(ctx context.Context, now time.Time) ([]*OrderData, error)
+	// UpdatePayouts records the payouts and the fee revenue of an order in
+	// fromStatus and moves it to status.
+	UpdatePayouts(ctx context.Context, id, fromStatus string, payouts []*Payout, feeRevenue []*FeeRevenue, status string) error
+	// UpdateFill sets the filled and remaining amounts and the status of an
+	// order still in the status and with the amounts it was read with, see FillOrder.
+	UpdateFill(ctx context.Context, from *OrderData, filledAmount, remainingAmount decimal.Amount, status string) error
+}
+
+type ParticipantWalletStore interface {
+	FindByAddress(ctx context.Context, address string) ([]*OrdererParticipantWallet, error)
+	FindByOrderID(ctx context.Context, orderID string) ([]*OrdererParticipantWallet, error)
+	FindByDepositReference(ctx context.Context, reference string) ([]*OrdererParticipantWallet, error)
+	Insert(ctx context.Context, wallet *OrdererParticipantWallet) error
+}
+
+type CommonInfoStore interface {
+	Get(ctx context.Context) (*OrderCommonInfo, error)
+	Set(ctx context.Context, info *OrderCommonInfo) error
+}
+
+// ChainCursorStore keeps how far each chain watcher has scanned its chain.
+type ChainCursorStore interface {
+	Get(ctx context.Context, id string) (*ChainCursor, error)
+	Save(ctx context.Context, cursor *ChainCursor) error
+}
+
+// DepositStore is the ledger of every deposit seen by the chain watchers.
+type DepositStore interface {
+	// Insert returns ErrDuplicateDeposit when a deposit with the same ID is already stored.
+	Insert(ctx context.Context, deposit *Deposit) error
+	FindOne(ctx context.Context, id string) (*Deposit, error)
+	Find(ctx context.Context, filter *DepositFilter) ([]*Deposit, error)
+	// UpdateStatus moves a deposit from fromStatus to status and records the
+	// order and the side of it the deposit belongs to. It returns ErrNonUpdated
+	// when the deposit is not in fromStatus anymore.
+	UpdateStatus(ctx context.Context, id, fromStatus, status, orderID, role string) error
+	// UpdateRefund records the refund of a deposit in fromStatus and moves it to status.
+	UpdateRefund(ctx context.Context, id, fromStatus string, refund *Refund, status string) error
+}
+
+// TradeStore keeps the trades made by the matching engine.
+type TradeStore interface {
+	Insert(ctx context.Context, trade *Trade) error
+	Find(ctx context.Context, filter *TradeFilter) ([]*Trade, error)
+}
+
+// JournalStore keeps the journal entries of the ledger.
+type JournalStore interface {
+	// Insert returns ErrDuplicateEntry when an entry with the same ID is already stored.
+	Insert(ctx context.Context, entry *JournalEntry) error
+	FindOne(ctx context.Context, id string) (*JournalEntry, error)
+	// Find returns the entries in the order they were recorded.
+	Find(ctx context.Context, filter *JournalFilter) ([]*JournalEntry, error)
+}
+
+// ReconciliationStore keeps the reports of the reconciliation job.
+type ReconciliationStore interface {
+	Insert(ctx context.Context, report *ReconciliationReport) error
+	FindOne(ctx context.Context, id string) (*ReconciliationReport, error)
+	// FindLatest returns up to limit reports, the most recent first.
+	FindLatest(ctx context.Context, limit int) ([]*ReconciliationReport, error)
+}
+
+// WebhookStore keeps the webhooks of the users.
+type WebhookStore interface {
+	Insert(ctx context.Context, webhook *Webhook) error
+	FindOne(ctx context.Context, filter *WebhookFilter) (*Webhook, error)
+	// Find returns the webhooks in the order they were registered.
+	Find(ctx context.Context, filter *WebhookFilter) ([]*Webhook, error)
+	// Delete returns ErrNoDocuments when no webhook is selected by filter.
+	Delete(ctx context.Context, filter *WebhookFilter) error
+}
+
+// WebhookDeliveryStore is the log of the events sent to the webhooks.
+type WebhookDeliveryStore interface {
+	Insert(ctx context.Context, delivery *WebhookDelivery) error
+	// FindLatest returns up to limit deliveries of a webhook, the most recent first.
+	FindLatest(ctx context.Context, webhookID string, limit int) ([]*WebhookDelivery, error)
+	// FindDue returns the pending deliveries whose next attempt is before now.
+	FindDue(ctx context.Context, now time.Time) ([]*WebhookDelivery, error)
+	// ClaimAttempt counts one more attempt of a pending delivery still at
+	// attempts and moves its next attempt to nextAttemptTime, so that the
+	// delivery is not attempted twice at once. It returns ErrNonUpdated when
+	// the delivery changed in the meantime.
+	ClaimAttempt(ctx context.Context, id string, attempts int, nextAttemptTime time.Time) error
+	// RecordAttempt records the outcome of the attempt a delivery was claimed
+	// for, attempts being the attempts counted with it, and moves it to status.
+	RecordAttempt(ctx context.Context, id string, attempts int, status string, responseStatus int, attemptError string) error
+}
+
+// AmountReservationStore keeps the unique ERC-20 deposit amounts of the order
+// legs, one per token, network and amount.
+type AmountReservationStore interface {
+	// Insert returns ErrDuplicateAmount when the amount is already reserved.
+	Insert(ctx context.Context, reservation *AmountReservation) error
+	FindOne(ctx context.Context, token, network string, amount decimal.Amount) (*AmountReservation, error)
+	// Replace gives the amount held by the leg of from to the leg of
+	// reservation. It returns ErrNonUpdated when another leg holds it by then.
+	Replace(ctx context.Context, from, reservation *AmountReservation) error
+}
+
+// Transactor runs fn atomically. Stores called with the ctx passed to fn take
+// part in the transaction.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
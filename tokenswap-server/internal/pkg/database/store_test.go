@@ -0,0 +1,247 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/migration"
+)
+
+// envTestMongodbUri is the MongoDB the store tests also run against. It must
+// be a replica set, which transactions need. Each test uses a database of its
+// own, dropped when it is done.
+const envTestMongodbUri = "STSVR_TEST_MONGODB_URI"
+
+// forEachStore runs the test against the in-memory store and against MongoDB
+// when envTestMongodbUri is set.
+func forEachStore(t *testing.T, test func(t *testing.T, store *database.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, database.NewMemoryStore())
+	})
+	t.Run("mongo", func(t *testing.T) {
+		uri := os.Getenv(envTestMongodbUri)
+		if len(uri) == 0 {
+			t.Skipf("%s is not set", envTestMongodbUri)
+		}
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := client.Database("tokenswap_test_" + uuid.New().String()[:8])
+		t.Cleanup(func() {
+			_ = db.Drop(ctx)
+			_ = client.Disconnect(ctx)
+		})
+		_, err = migration.Migrate(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, database.NewMongoStoreWithDatabase(db))
+	})
+}
+
+func testOrder(id, status string) *database.OrderData {
+	return &database.OrderData{
+		ID: id,
+		Order: &database.Order{
+			Type:    "buy",
+			Pair:    "XEL/USDT",
+			Amount:  decimal.MustParse("10"),
+			Price:   decimal.MustParse("2"),
+			Chain:   "ethereum",
+			Network: "testnet",
+			Status:  status,
+		},
+		RemainingAmount: decimal.MustParse("10"),
+		UserUUID:        "maker",
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *database.Store) {
+		ctx := context.Background()
+		err := store.Orders.Insert(ctx, testOrder("funded", database.OrderStatusType1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := errors.New("the transaction failed")
+		err = store.WithTransaction(ctx, func(txCtx context.Context) error {
+			_, err := database.TransitionOrder(txCtx, store.Orders, &database.OrderFilter{ID: "funded"}, database.OrderStatusType2)
+			if err != nil {
+				return err
+			}
+			err = store.Orders.Insert(txCtx, testOrder("inserted", database.OrderStatusType1))
+			if err != nil {
+				return err
+			}
+			// A write outside the transaction, while it runs
+			written := make(chan error)
+			go func() {
+				written <- store.Users.Insert(ctx, &database.User{UUID: "outside", Email: "outside@example.com"})
+			}()
+			err = <-written
+			if err != nil {
+				return err
+			}
+			return failed
+		})
+		if err != failed {
+			t.Fatalf("transaction error = %v, want %v", err, failed)
+		}
+		orderData, err := store.Orders.FindOne(ctx, &database.OrderFilter{ID: "funded"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orderData.Status != database.OrderStatusType1 {
+			t.Errorf("status = %s, want the update of the transaction reverted", orderData.Status)
+		}
+		_, err = store.Orders.FindOne(ctx, &database.OrderFilter{ID: "inserted"})
+		if err != database.ErrNoDocuments {
+			t.Errorf("order inserted in the transaction: %v, want it removed", err)
+		}
+		_, err = store.Users.FindOne(ctx, &database.UserFilter{UUID: "outside"})
+		if err != nil {
+			t.Errorf("user inserted outside the transaction: %v, want it kept", err)
+		}
+	})
+}
+
+func TestTransactionCommit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *database.Store) {
+		ctx := context.Background()
+		err := store.WithTransaction(ctx, func(txCtx context.Context) error {
+			err := store.Orders.Insert(txCtx, testOrder("committed", database.OrderStatusType1))
+			if err != nil {
+				return err
+			}
+			return store.Deposits.Insert(txCtx, &database.Deposit{ID: "tx:0", Status: database.DepositStatusUnmatched})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Orders.FindOne(ctx, &database.OrderFilter{ID: "committed"})
+		if err != nil {
+			t.Errorf("order: %v", err)
+		}
+		_, err = store.Deposits.FindOne(ctx, "tx:0")
+		if err != nil {
+			t.Errorf("deposit: %v", err)
+		}
+	})
+}
+
+func TestConditionalUpdates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *database.Store) {
+		ctx := context.Background()
+		err := store.Orders.Insert(ctx, testOrder("order", database.OrderStatusType1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Orders.UpdateStatus(ctx, &database.OrderFilter{ID: "order", Status: database.OrderStatusType2}, database.OrderStatusType3)
+		if err != database.ErrNonUpdated {
+			t.Errorf("update from another status: %v, want %v", err, database.ErrNonUpdated)
+		}
+		_, err = database.TransitionOrder(ctx, store.Orders, &database.OrderFilter{ID: "order"}, database.OrderStatusType6)
+		if !errors.Is(err, database.ErrInvalidTransition) {
+			t.Errorf("transition to completed: %v, want %v", err, database.ErrInvalidTransition)
+		}
+
+		deposit := &database.Deposit{ID: "tx:0", Status: database.DepositStatusUnmatched}
+		err = store.Deposits.Insert(ctx, deposit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Deposits.Insert(ctx, deposit)
+		if err != database.ErrDuplicateDeposit {
+			t.Errorf("second insert: %v, want %v", err, database.ErrDuplicateDeposit)
+		}
+		err = store.Deposits.UpdateStatus(ctx, "tx:0", database.DepositStatusUnmatched, database.DepositStatusMatched, "order", database.ParticipantRoleMaker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Deposits.UpdateStatus(ctx, "tx:0", database.DepositStatusUnmatched, database.DepositStatusMatched, "other", database.ParticipantRoleMaker)
+		if err != database.ErrNonUpdated {
+			t.Errorf("second match: %v, want %v", err, database.ErrNonUpdated)
+		}
+
+		err = store.Users.Insert(ctx, &database.User{UUID: "user", Email: "user@example.com", TwoFactor: &database.TwoFactor{Enabled: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Users.UseTwoFactorStep(ctx, "user", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Users.UseTwoFactorStep(ctx, "user", 100)
+		if err != database.ErrNonUpdated {
+			t.Errorf("step used again: %v, want %v", err, database.ErrNonUpdated)
+		}
+	})
+}
+
+func TestDuplicateUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *database.Store) {
+		ctx := context.Background()
+		err := store.Users.Insert(ctx, &database.User{UUID: "first", Email: "user@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Users.Insert(ctx, &database.User{UUID: "second", Email: "user@example.com"})
+		if err != database.ErrDuplicateUser {
+			t.Errorf("same email: %v, want %v", err, database.ErrDuplicateUser)
+		}
+		users, err := store.Users.Find(ctx, &database.UserFilter{Email: "user@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].UUID != "first" {
+			t.Errorf("%d users with the email, want only the first one", len(users))
+		}
+	})
+}
+
+func TestAmountReservations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *database.Store) {
+		ctx := context.Background()
+		held := &database.AmountReservation{Token: "USDT", Network: "testnet", Amount: decimal.MustParse("20.000123"), OrderID: "held", Role: database.ParticipantRoleMaker}
+		err := store.AmountReservations.Insert(ctx, held)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The same amount written with another precision
+		taken := &database.AmountReservation{Token: "USDT", Network: "testnet", Amount: decimal.MustParse("20.0001230"), OrderID: "taken", Role: database.ParticipantRoleTaker}
+		err = store.AmountReservations.Insert(ctx, taken)
+		if err != database.ErrDuplicateAmount {
+			t.Fatalf("second insert: %v, want %v", err, database.ErrDuplicateAmount)
+		}
+		mainnet := &database.AmountReservation{Token: "USDT", Network: "mainnet", Amount: decimal.MustParse("20.000123"), OrderID: "mainnet", Role: database.ParticipantRoleMaker}
+		err = store.AmountReservations.Insert(ctx, mainnet)
+		if err != nil {
+			t.Fatalf("insert on another network: %v", err)
+		}
+		err = store.AmountReservations.Replace(ctx, held, taken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.AmountReservations.Replace(ctx, held, held)
+		if err != database.ErrNonUpdated {
+			t.Errorf("replace of a reservation taken over: %v, want %v", err, database.ErrNonUpdated)
+		}
+		reservation, err := store.AmountReservations.FindOne(ctx, "USDT", "testnet", decimal.MustParse("20.000123"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reservation.OrderID != "taken" {
+			t.Errorf("amount held by %s, want taken", reservation.OrderID)
+		}
+	})
+}
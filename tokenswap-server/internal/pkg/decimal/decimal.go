@@ -0,0 +1,321 @@
+package decimal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Scale is the number of decimal places an Amount keeps. It covers the
+	// decimals of every supported token.
+	Scale = 18
+)
+
+var (
+	ErrInvalidAmount = errors.New("invalid decimal amount")
+	ErrPrecision     = errors.New("the amount has more decimal places than allowed")
+
+	scaleFactor = pow10(Scale)
+)
+
+// Amount is an exact fixed-point decimal number. The zero value is 0.
+//
+// Amounts are encoded as strings in JSON and as Decimal128 in BSON so that no
+// precision is lost on the way to the client or to the database.
+type Amount struct {
+	units *big.Int
+}
+
+// Zero returns an amount of 0.
+func Zero() Amount {
+	return Amount{}
+}
+
+// NewFromInt returns the amount of a whole number.
+func NewFromInt(value int64) Amount {
+	return Amount{units: new(big.Int).Mul(big.NewInt(value), scaleFactor)}
+}
+
+// Parse reads a decimal number like "12", "-0.5", "1234.56789" or "1.5e-7".
+func Parse(value string) (Amount, error) {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "eE") {
+		return parseExponent(value)
+	}
+	digits := strings.TrimPrefix(value, "-")
+	if len(digits) == 0 {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	integerPart, fractionPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i > -1 {
+		integerPart, fractionPart = digits[:i], digits[i+1:]
+	}
+	if len(integerPart) == 0 && len(fractionPart) == 0 || !isDigits(integerPart) || !isDigits(fractionPart) {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	fractionPart = strings.TrimRight(fractionPart, "0")
+	if len(fractionPart) > Scale {
+		return Amount{}, fmt.Errorf("%w: %q", ErrPrecision, value)
+	}
+	units, ok := new(big.Int).SetString(integerPart+fractionPart+strings.Repeat("0", Scale-len(fractionPart)), 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	if strings.HasPrefix(value, "-") {
+		units.Neg(units)
+	}
+	return Amount{units: units}, nil
+}
+
+// MustParse is like Parse but panics on an invalid value. It is meant for constants.
+func MustParse(value string) Amount {
+	amount, err := Parse(value)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// FromUnits converts an amount in the smallest unit of a token with the given
+// decimals, as transferred on chain, to whole tokens.
+func FromUnits(units *big.Int, decimals int) Amount {
+	if decimals > Scale {
+		return Amount{units: new(big.Int).Quo(units, pow10(decimals-Scale))}
+	}
+	return Amount{units: new(big.Int).Mul(units, pow10(Scale-decimals))}
+}
+
+// Units converts the amount to the smallest unit of a token with the given
+// decimals. It fails when the amount cannot be represented exactly.
+func (a Amount) Units(decimals int) (*big.Int, error) {
+	if decimals >= Scale {
+		return new(big.Int).Mul(a.bigInt(), pow10(decimals-Scale)), nil
+	}
+	units, remainder := new(big.Int).QuoRem(a.bigInt(), pow10(Scale-decimals), new(big.Int))
+	if remainder.Sign() != 0 {
+		return nil, fmt.Errorf("%w: %s has more than %d decimal places", ErrPrecision, a, decimals)
+	}
+	return units, nil
+}
+
+// Truncate drops the decimal places beyond the given ones, rounding towards zero.
+func (a Amount) Truncate(decimals int) Amount {
+	if decimals >= Scale {
+		return a
+	}
+	factor := pow10(Scale - decimals)
+	units := new(big.Int).Quo(a.bigInt(), factor)
+	return Amount{units: units.Mul(units, factor)}
+}
+
+// RoundUp drops the decimal places beyond the given ones, rounding away from zero.
+func (a Amount) RoundUp(decimals int) Amount {
+	truncated := a.Truncate(decimals)
+	if truncated.Equal(a) {
+		return truncated
+	}
+	step := Amount{units: pow10(Scale - decimals)}
+	if a.Sign() < 0 {
+		return truncated.Sub(step)
+	}
+	return truncated.Add(step)
+}
+
+// DecimalPlaces returns the number of significant decimal places of the amount.
+func (a Amount) DecimalPlaces() int {
+	units := new(big.Int).Abs(a.bigInt())
+	if units.Sign() == 0 {
+		return 0
+	}
+	ten := big.NewInt(10)
+	remainder := new(big.Int)
+	places := Scale
+	for places > 0 {
+		quotient, _ := new(big.Int).QuoRem(units, ten, remainder)
+		if remainder.Sign() != 0 {
+			break
+		}
+		units = quotient
+		places--
+	}
+	return places
+}
+
+func (a Amount) Add(b Amount) Amount {
+	return Amount{units: new(big.Int).Add(a.bigInt(), b.bigInt())}
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{units: new(big.Int).Sub(a.bigInt(), b.bigInt())}
+}
+
+// Mul multiplies two amounts. Decimal places beyond Scale are truncated.
+func (a Amount) Mul(b Amount) Amount {
+	units := new(big.Int).Mul(a.bigInt(), b.bigInt())
+	return Amount{units: units.Quo(units, scaleFactor)}
+}
+
+// Percent returns rate percent of the amount. Decimal places beyond Scale are truncated.
+func (a Amount) Percent(rate Amount) Amount {
+	units := a.Mul(rate).bigInt()
+	return Amount{units: new(big.Int).Quo(units, big.NewInt(100))}
+}
+
+// Cmp returns -1, 0 or +1 when a is less than, equal to or greater than b.
+func (a Amount) Cmp(b Amount) int {
+	return a.bigInt().Cmp(b.bigInt())
+}
+
+func (a Amount) Equal(b Amount) bool {
+	return a.Cmp(b) == 0
+}
+
+// Sign returns -1, 0 or +1 when the amount is negative, zero or positive.
+func (a Amount) Sign() int {
+	return a.bigInt().Sign()
+}
+
+func (a Amount) IsZero() bool {
+	return a.Sign() == 0
+}
+
+// String formats the amount without trailing zeros, like "1234.5".
+func (a Amount) String() string {
+	return a.StringFixed(a.DecimalPlaces())
+}
+
+// StringFixed formats the amount with exactly the given decimal places,
+// truncating the ones beyond.
+func (a Amount) StringFixed(decimals int) string {
+	if decimals > Scale {
+		decimals = Scale
+	}
+	units := new(big.Int).Abs(a.Truncate(decimals).bigInt())
+	digits := units.Quo(units, pow10(Scale-decimals)).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	sign := ""
+	if a.Truncate(decimals).Sign() < 0 {
+		sign = "-"
+	}
+	if decimals == 0 {
+		return sign + digits
+	}
+	return sign + digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts both a string and a number, so requests sending plain
+// JSON numbers keep working. Numbers are read from their text, not as float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+	if strings.HasPrefix(value, `"`) {
+		err := json.Unmarshal(data, &value)
+		if err != nil {
+			return err
+		}
+	}
+	amount, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	value, err := primitive.ParseDecimal128(a.String())
+	if err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(value)
+}
+
+// UnmarshalBSONValue reads Decimal128 values, as well as the doubles, integers
+// and strings amounts were stored as before.
+func (a *Amount) UnmarshalBSONValue(valueType bsontype.Type, data []byte) error {
+	rawValue := bson.RawValue{Type: valueType, Value: data}
+	var value string
+	switch valueType {
+	case bsontype.Decimal128:
+		coefficient, exponent, err := rawValue.Decimal128().BigInt()
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, err.Error())
+		}
+		*a = FromUnits(coefficient, -exponent)
+		return nil
+	case bsontype.Double:
+		value = strconv.FormatFloat(rawValue.Double(), 'f', -1, 64)
+	case bsontype.Int32:
+		value = strconv.FormatInt(int64(rawValue.Int32()), 10)
+	case bsontype.Int64:
+		value = strconv.FormatInt(rawValue.Int64(), 10)
+	case bsontype.String:
+		value = rawValue.StringValue()
+	case bsontype.Null:
+		*a = Amount{}
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot decode BSON %s", ErrInvalidAmount, valueType)
+	}
+	amount, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func parseExponent(value string) (Amount, error) {
+	// big.Rat also reads a leading '+' and hexadecimal numbers like "0x1e3"
+	mantissa, exponent, _ := strings.Cut(strings.ToLower(value), "e")
+	mantissa = strings.Replace(strings.TrimPrefix(mantissa, "-"), ".", "", 1)
+	exponent = strings.TrimLeft(exponent, "+-")
+	if len(mantissa) == 0 || len(exponent) == 0 || !isDigits(mantissa) || !isDigits(exponent) {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	number, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	number.Mul(number, new(big.Rat).SetInt(scaleFactor))
+	if !number.IsInt() {
+		return Amount{}, fmt.Errorf("%w: %q", ErrPrecision, value)
+	}
+	return Amount{units: new(big.Int).Set(number.Num())}, nil
+}
+
+func (a Amount) bigInt() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
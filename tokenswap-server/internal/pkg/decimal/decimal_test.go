@@ -0,0 +1,177 @@
+package decimal
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  string
+		err   error
+	}{
+		{value: "12", want: "12"},
+		{value: " 1234.56789 ", want: "1234.56789"},
+		{value: "-0.5", want: "-0.5"},
+		{value: "-0", want: "0"},
+		{value: ".5", want: "0.5"},
+		{value: "5.", want: "5"},
+		{value: "1.500", want: "1.5"},
+		{value: "1.5e-7", want: "0.00000015"},
+		{value: "-2E3", want: "-2000"},
+		{value: "1e+2", want: "100"},
+		{value: "0.000000000000000001", want: "0.000000000000000001"},
+		{value: "1.0000000000000000010", want: "1.000000000000000001"},
+		{value: "0.0000000000000000001", err: ErrPrecision},
+		{value: "1e-19", err: ErrPrecision},
+		{value: "", err: ErrInvalidAmount},
+		{value: "-", err: ErrInvalidAmount},
+		{value: ".", err: ErrInvalidAmount},
+		{value: "+5", err: ErrInvalidAmount},
+		{value: "+1e2", err: ErrInvalidAmount},
+		{value: "--5", err: ErrInvalidAmount},
+		{value: "1.2.3", err: ErrInvalidAmount},
+		{value: "1,5", err: ErrInvalidAmount},
+		{value: "0x1e3", err: ErrInvalidAmount},
+		{value: "1e", err: ErrInvalidAmount},
+		{value: "e5", err: ErrInvalidAmount},
+	} {
+		amount, err := Parse(test.value)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Parse(%q) error = %v, want %v", test.value, err, test.err)
+			continue
+		}
+		if err == nil && amount.String() != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.value, amount, test.want)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	for _, test := range []struct {
+		amount   string
+		decimals int
+		units    string
+		err      error
+	}{
+		{amount: "1.5", decimals: 6, units: "1500000"},
+		{amount: "-1.5", decimals: 6, units: "-1500000"},
+		{amount: "0.00000001", decimals: 8, units: "1"},
+		{amount: "12", decimals: 0, units: "12"},
+		{amount: "1.5", decimals: Scale, units: "1500000000000000000"},
+		{amount: "1.5", decimals: 24, units: "1500000000000000000000000"},
+		{amount: "0.0000001", decimals: 6, err: ErrPrecision},
+		{amount: "0.5", decimals: 0, err: ErrPrecision},
+	} {
+		units, err := MustParse(test.amount).Units(test.decimals)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s.Units(%d) error = %v, want %v", test.amount, test.decimals, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if units.String() != test.units {
+			t.Errorf("%s.Units(%d) = %s, want %s", test.amount, test.decimals, units, test.units)
+		}
+		// Back to the same amount
+		if amount := FromUnits(units, test.decimals); amount.String() != test.amount {
+			t.Errorf("FromUnits(%s, %d) = %s, want %s", units, test.decimals, amount, test.amount)
+		}
+	}
+
+	// Units below the precision of an Amount are dropped
+	units, _ := new(big.Int).SetString("1500000000000000000001234", 10)
+	if amount := FromUnits(units, 24); amount.String() != "1.5" {
+		t.Errorf("FromUnits(%s, 24) = %s, want 1.5", units, amount)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	for _, test := range []struct {
+		amount   string
+		decimals int
+		truncate string
+		roundUp  string
+	}{
+		{amount: "1.23456789", decimals: 6, truncate: "1.234567", roundUp: "1.234568"},
+		{amount: "-1.23456789", decimals: 6, truncate: "-1.234567", roundUp: "-1.234568"},
+		{amount: "-0.0000001", decimals: 6, truncate: "0", roundUp: "-0.000001"},
+		{amount: "-1.5", decimals: 0, truncate: "-1", roundUp: "-2"},
+		{amount: "-1.5", decimals: 6, truncate: "-1.5", roundUp: "-1.5"},
+		{amount: "2.000000000000000001", decimals: Scale, truncate: "2.000000000000000001", roundUp: "2.000000000000000001"},
+	} {
+		amount := MustParse(test.amount)
+		if truncated := amount.Truncate(test.decimals); truncated.String() != test.truncate {
+			t.Errorf("%s.Truncate(%d) = %s, want %s", test.amount, test.decimals, truncated, test.truncate)
+		}
+		if rounded := amount.RoundUp(test.decimals); rounded.String() != test.roundUp {
+			t.Errorf("%s.RoundUp(%d) = %s, want %s", test.amount, test.decimals, rounded, test.roundUp)
+		}
+	}
+}
+
+type bsonDocument struct {
+	Amount Amount `bson:"amount"`
+}
+
+func TestBSON(t *testing.T) {
+	for _, value := range []string{"0", "1234.5", "-0.000000000000000001", "123456789012345.123456789012345678"} {
+		data, err := bson.Marshal(bsonDocument{Amount: MustParse(value)})
+		if err != nil {
+			t.Fatalf("marshal %s: %s", value, err)
+		}
+		// Stored as Decimal128
+		if valueType := bson.Raw(data).Lookup("amount").Type; valueType != bson.TypeDecimal128 {
+			t.Errorf("%s stored as %s, want %s", value, valueType, bson.TypeDecimal128)
+		}
+		var document bsonDocument
+		err = bson.Unmarshal(data, &document)
+		if err != nil {
+			t.Fatalf("unmarshal %s: %s", value, err)
+		}
+		if document.Amount.String() != value {
+			t.Errorf("%s read back as %s", value, document.Amount)
+		}
+	}
+
+	// Amounts stored before Decimal128
+	for _, test := range []struct {
+		stored interface{}
+		want   string
+	}{
+		{stored: 0.1, want: "0.1"},
+		{stored: -2.5, want: "-2.5"},
+		{stored: int32(7), want: "7"},
+		{stored: int64(-9000000000), want: "-9000000000"},
+		{stored: "1.000000000000000001", want: "1.000000000000000001"},
+		{stored: nil, want: "0"},
+	} {
+		data, err := bson.Marshal(bson.M{"amount": test.stored})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var document bsonDocument
+		err = bson.Unmarshal(data, &document)
+		if err != nil {
+			t.Errorf("unmarshal %v: %s", test.stored, err)
+			continue
+		}
+		if document.Amount.String() != test.want {
+			t.Errorf("%#v read as %s, want %s", test.stored, document.Amount, test.want)
+		}
+	}
+
+	data, err := bson.Marshal(bson.M{"amount": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document bsonDocument
+	if err := bson.Unmarshal(data, &document); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("unmarshal a boolean error = %v, want %v", err, ErrInvalidAmount)
+	}
+}
@@ -0,0 +1,175 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+const (
+	OrderCreated   = "order.created"
+	OrderTaken     = "order.taken"
+	OrderFunded    = "order.funded"
+	OrderCompleted = "order.completed"
+	OrderCancelled = "order.cancelled"
+
+	subscriptionBufferSize = 64
+	orderVisibilityPrivate = "private"
+)
+
+// OrderEventTypes are the types of the order events.
+var OrderEventTypes = []string{OrderCreated, OrderTaken, OrderFunded, OrderCompleted, OrderCancelled}
+
+// Event is a change of an order. Users are the users the change concerns, the
+// user of the order and for a fill the user of the order it fills.
+type Event struct {
+	Type       string         `json:"type"`
+	OrderID    string         `json:"order_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Pair       string         `json:"pair"`
+	Chain      string         `json:"chain"`
+	Network    string         `json:"network"`
+	Visibility string         `json:"visibility"`
+	OrderType  string         `json:"order_type"`
+	Amount     decimal.Amount `json:"amount"`
+	Price      decimal.Amount `json:"price"`
+	Status     string         `json:"status"`
+	DateTime   string         `json:"date_time"`
+	Users      []string       `json:"-"`
+}
+
+// NewOrderEvent returns an event of the order. The users other than the user
+// of the order the event concerns are given in users.
+func NewOrderEvent(eventType string, orderData *database.OrderData, users ...string) *Event {
+	event := &Event{
+		Type:     eventType,
+		OrderID:  orderData.ID,
+		ParentID: orderData.ParentID,
+		Status:   orderData.Status,
+		DateTime: time.Now().Format(database.TimeFormat),
+		Users:    append([]string{orderData.UserUUID}, users...),
+	}
+	if orderData.Order != nil {
+		event.Pair = orderData.Pair
+		event.Chain = orderData.Chain
+		event.Network = orderData.Network
+		event.Visibility = orderData.Visibility
+		event.OrderType = orderData.Type
+		event.Amount = orderData.Amount
+		event.Price = orderData.Price
+	}
+	return event
+}
+
+// Filter selects the events of a subscription. Empty fields are ignored. The
+// events of private orders only reach the users they concern, and with
+// MyOrders only the events concerning the user are selected. AllUsers selects
+// the events of every user, for the workers acting on behalf of the users.
+type Filter struct {
+	UserUUID string
+	Pair     string
+	Network  string
+	MyOrders bool
+	AllUsers bool
+}
+
+func (f *Filter) Match(event *Event) bool {
+	if len(f.Pair) > 0 && event.Pair != f.Pair {
+		return false
+	}
+	if len(f.Network) > 0 && event.Network != f.Network {
+		return false
+	}
+	if f.AllUsers {
+		return true
+	}
+	if f.MyOrders || event.Visibility == orderVisibilityPrivate {
+		return event.concerns(f.UserUUID)
+	}
+	return true
+}
+
+func (e *Event) concerns(userUUID string) bool {
+	for _, user := range e.Users {
+		if user == userUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the events selected by its filter until it is closed.
+type Subscription struct {
+	Events <-chan *Event
+	events chan *Event
+	filter *Filter
+	bus    *Bus
+}
+
+// Close stops the subscription and closes its events channel.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus hands the events published by the handlers and the background workers
+// to the subscriptions. Publishing never blocks: a subscription which falls
+// behind misses the events its buffer has no room for. A nil Bus drops the
+// events, for the commands running without the API.
+type Bus struct {
+	mutex         sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: map[*Subscription]struct{}{},
+	}
+}
+
+func (b *Bus) Subscribe(filter *Filter) *Subscription {
+	events := make(chan *Event, subscriptionBufferSize)
+	subscription := &Subscription{
+		Events: events,
+		events: events,
+		filter: filter,
+		bus:    b,
+	}
+	b.mutex.Lock()
+	b.subscriptions[subscription] = struct{}{}
+	b.mutex.Unlock()
+	return subscription
+}
+
+func (b *Bus) unsubscribe(subscription *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.subscriptions[subscription]; !ok {
+		return
+	}
+	delete(b.subscriptions, subscription)
+	close(subscription.events)
+}
+
+func (b *Bus) Publish(events ...*Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, event := range events {
+		for subscription := range b.subscriptions {
+			if !subscription.filter.Match(event) {
+				continue
+			}
+			select {
+			case subscription.events <- event:
+			default:
+				log.Warnf("dropping the %s event of order %s for a slow subscriber", event.Type, event.OrderID)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package fee
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+const (
+	orderTypeBuy       = "buy"
+	orderTypeSell      = "sell"
+	feePayerTypeSplit  = "split"
+	feePayerTypeBuyer  = "buyer"
+	feePayerTypeSeller = "seller"
+)
+
+var (
+	shareFull = decimal.NewFromInt(1)
+	shareHalf = decimal.MustParse("0.5")
+)
+
+// Leg is what one side of an order deposits and receives. The side paying the
+// fee deposits it in its deposit token on top of what it trades, so the other
+// side receives the traded amount in full.
+type Leg struct {
+	Role          string         `json:"role"`
+	Buyer         bool           `json:"buyer"`
+	DepositToken  string         `json:"deposit_token"`
+	TradeAmount   decimal.Amount `json:"trade_amount"`
+	Fee           decimal.Amount `json:"fee"`
+	DepositAmount decimal.Amount `json:"deposit_amount"`
+	PayoutToken   string         `json:"payout_token"`
+	PayoutAmount  decimal.Amount `json:"payout_amount"`
+}
+
+// Quote holds both legs of an order at a fee rate.
+type Quote struct {
+	FeeRate      decimal.Amount `json:"fee_rate"`
+	FeePayerType string         `json:"fee_payer_type"`
+	Maker        *Leg           `json:"maker"`
+	Taker        *Leg           `json:"taker"`
+}
+
+// Leg returns the leg of the given side.
+func (q *Quote) Leg(role string) (*Leg, error) {
+	switch role {
+	case database.ParticipantRoleMaker:
+		return q.Maker, nil
+	case database.ParticipantRoleTaker:
+		return q.Taker, nil
+	}
+	return nil, fmt.Errorf("invalid participant role: %s", role)
+}
+
+// Revenue returns the fees of both legs by token.
+func (q *Quote) Revenue() []*database.FeeRevenue {
+	revenue := AddRevenue(nil, q.Maker.DepositToken, q.Maker.Fee)
+	return AddRevenue(revenue, q.Taker.DepositToken, q.Taker.Fee)
+}
+
+// Compute quotes an order. The pair is written as BASE/QUOTE, the amount is in
+// BASE and the price is in QUOTE. feeRate is a percentage, as stored in
+// OrderCommonInfo.Fee, and fees are rounded up to the decimals of their token.
+func Compute(order *database.Order, feeRate decimal.Amount, tokens *registry.Registry) (*Quote, error) {
+	maker, taker, err := orderLegs(order)
+	if err != nil {
+		return nil, err
+	}
+	for _, orderLeg := range []*Leg{maker, taker} {
+		depositToken, err := tokens.OrderToken(orderLeg.DepositToken, order.Chain, order.Network)
+		if err != nil {
+			return nil, err
+		}
+		orderLeg.Fee = orderLeg.TradeAmount.Mul(Share(order.FeePayerType, orderLeg.Buyer)).Percent(feeRate).RoundUp(depositToken.Decimals)
+		orderLeg.DepositAmount = orderLeg.TradeAmount.Add(orderLeg.Fee)
+	}
+	return &Quote{
+		FeeRate:      feeRate,
+		FeePayerType: order.FeePayerType,
+		Maker:        maker,
+		Taker:        taker,
+	}, nil
+}
+
+// orderLegs splits an order into the maker and the taker side, without fees.
+func orderLegs(order *database.Order) (*Leg, *Leg, error) {
+	tokens := strings.Split(order.Pair, "/")
+	if len(tokens) != 2 {
+		return nil, nil, fmt.Errorf("invalid order pair: %s", order.Pair)
+	}
+	baseToken, quoteToken := tokens[0], tokens[1]
+	baseAmount := order.Amount
+	quoteAmount := order.Amount.Mul(order.Price)
+	buyer := &Leg{Buyer: true, DepositToken: quoteToken, TradeAmount: quoteAmount, DepositAmount: quoteAmount, PayoutToken: baseToken, PayoutAmount: baseAmount}
+	seller := &Leg{DepositToken: baseToken, TradeAmount: baseAmount, DepositAmount: baseAmount, PayoutToken: quoteToken, PayoutAmount: quoteAmount}
+	switch order.Type {
+	case orderTypeBuy:
+		buyer.Role, seller.Role = database.ParticipantRoleMaker, database.ParticipantRoleTaker
+		return buyer, seller, nil
+	case orderTypeSell:
+		seller.Role, buyer.Role = database.ParticipantRoleMaker, database.ParticipantRoleTaker
+		return seller, buyer, nil
+	}
+	return nil, nil, fmt.Errorf("invalid order type: %s", order.Type)
+}
+
+// OrderLeg returns the given side of an order, without fees.
+func OrderLeg(order *database.Order, role string) (*Leg, error) {
+	maker, taker, err := orderLegs(order)
+	if err != nil {
+		return nil, err
+	}
+	quote := &Quote{Maker: maker, Taker: taker}
+	return quote.Leg(role)
+}
+
+// DepositToken returns the token the given side of the order has to deposit.
+func DepositToken(order *database.Order, role string) (string, error) {
+	orderLeg, err := OrderLeg(order, role)
+	if err != nil {
+		return "", err
+	}
+	return orderLeg.DepositToken, nil
+}
+
+// PayoutToken returns the token the given side of the order receives.
+func PayoutToken(order *database.Order, role string) (string, error) {
+	orderLeg, err := OrderLeg(order, role)
+	if err != nil {
+		return "", err
+	}
+	return orderLeg.PayoutToken, nil
+}
+
+// DepositAmount returns how much the given side of the order has to deposit,
+// fee included.
+func DepositAmount(order *database.Order, role string, feeRate decimal.Amount, tokens *registry.Registry) (decimal.Amount, error) {
+	quote, err := Compute(order, feeRate, tokens)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	orderLeg, err := quote.Leg(role)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	return orderLeg.DepositAmount, nil
+}
+
+// Share returns the part of the fee rate charged on the buyer or the seller.
+func Share(feePayerType string, buyer bool) decimal.Amount {
+	switch feePayerType {
+	case feePayerTypeBuyer:
+		if buyer {
+			return shareFull
+		}
+	case feePayerTypeSeller:
+		if !buyer {
+			return shareFull
+		}
+	case feePayerTypeSplit:
+		return shareHalf
+	}
+	return decimal.Zero()
+}
+
+// AddRevenue adds an amount of a token to the revenue. Zero amounts are left out.
+func AddRevenue(revenue []*database.FeeRevenue, token string, amount decimal.Amount) []*database.FeeRevenue {
+	if amount.Sign() <= 0 {
+		return revenue
+	}
+	for _, tokenRevenue := range revenue {
+		if tokenRevenue.Token == token {
+			tokenRevenue.Amount = tokenRevenue.Amount.Add(amount)
+			return revenue
+		}
+	}
+	return append(revenue, &database.FeeRevenue{Token: token, Amount: amount})
+}
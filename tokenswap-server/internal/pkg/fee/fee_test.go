@@ -0,0 +1,91 @@
+package fee
+
+import (
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+func TestCompute(t *testing.T) {
+	tokens, err := registry.New([]*database.Token{
+		{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: "^xel:"},
+		{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, AddressPattern: "^0x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The buyer trades 1.23456789 * 0.1234567 = 0.152415677625363 USDT, the
+	// seller 1.23456789 XEL, at a fee rate of 1%
+	for _, test := range []struct {
+		feePayerType string
+		buyerFee     string
+		sellerFee    string
+	}{
+		// 0.000762078388126815 USDT and 0.00617283945 XEL
+		{feePayerType: feePayerTypeSplit, buyerFee: "0.000763", sellerFee: "0.00617284"},
+		// 0.00152415677625363 USDT
+		{feePayerType: feePayerTypeBuyer, buyerFee: "0.001525", sellerFee: "0"},
+		// 0.0123456789 XEL
+		{feePayerType: feePayerTypeSeller, buyerFee: "0", sellerFee: "0.01234568"},
+	} {
+		t.Run(test.feePayerType, func(t *testing.T) {
+			order := &database.Order{
+				Type:         orderTypeBuy,
+				Pair:         "XEL/USDT",
+				Amount:       decimal.MustParse("1.23456789"),
+				Price:        decimal.MustParse("0.1234567"),
+				Chain:        "ethereum",
+				Network:      "testnet",
+				FeePayerType: test.feePayerType,
+			}
+			feeRate := decimal.NewFromInt(1)
+			quote, err := Compute(order, feeRate, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !quote.Maker.Buyer || quote.Taker.Buyer {
+				t.Fatalf("maker buyer = %t, taker buyer = %t, want the maker to buy", quote.Maker.Buyer, quote.Taker.Buyer)
+			}
+			for _, orderLeg := range []struct {
+				leg         *Leg
+				token       string
+				tradeAmount string
+				fee         string
+			}{
+				{leg: quote.Maker, token: "USDT", tradeAmount: "0.152415677625363", fee: test.buyerFee},
+				{leg: quote.Taker, token: "XEL", tradeAmount: "1.23456789", fee: test.sellerFee},
+			} {
+				leg := orderLeg.leg
+				if leg.DepositToken != orderLeg.token || leg.TradeAmount.String() != orderLeg.tradeAmount || leg.Fee.String() != orderLeg.fee {
+					t.Errorf("%s deposits %s %s with a fee of %s, want %s %s with a fee of %s", leg.Role, leg.TradeAmount, leg.DepositToken, leg.Fee, orderLeg.tradeAmount, orderLeg.token, orderLeg.fee)
+				}
+				if !leg.DepositAmount.Equal(leg.TradeAmount.Add(leg.Fee)) {
+					t.Errorf("%s deposit = %s, want %s + %s", leg.Role, leg.DepositAmount, leg.TradeAmount, leg.Fee)
+				}
+			}
+
+			// Each fill rounds its own fee up, so together they never charge less
+			for _, fillAmounts := range [][]string{
+				{"0.41152263", "0.41152263", "0.41152263"},
+				{"0.00000001", "1.23456788"},
+			} {
+				makerFees, takerFees := decimal.Zero(), decimal.Zero()
+				for _, fillAmount := range fillAmounts {
+					fill := *order
+					fill.Amount = decimal.MustParse(fillAmount)
+					fillQuote, err := Compute(&fill, feeRate, tokens)
+					if err != nil {
+						t.Fatal(err)
+					}
+					makerFees = makerFees.Add(fillQuote.Maker.Fee)
+					takerFees = takerFees.Add(fillQuote.Taker.Fee)
+				}
+				if makerFees.Cmp(quote.Maker.Fee) < 0 || takerFees.Cmp(quote.Taker.Fee) < 0 {
+					t.Errorf("fills of %v charge %s and %s, want at least %s and %s", fillAmounts, makerFees, takerFees, quote.Maker.Fee, quote.Taker.Fee)
+				}
+			}
+		})
+	}
+}
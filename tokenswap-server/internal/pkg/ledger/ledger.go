@@ -0,0 +1,386 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+var (
+	ErrUnbalanced = errors.New("the journal entry does not balance")
+)
+
+// The service wallets are the only asset account. Every other account is what
+// the service owes out of them: the deposits held for an order, the fees it
+// earned, the deposits to send back and the deposits not credited to any order
+// yet. So the service wallets always add up to the other accounts.
+const (
+	AccountServiceWallet  = "service_wallet"
+	AccountFeeRevenue     = "fee_revenue"
+	AccountRefundsPayable = "refunds_payable"
+	AccountUnallocated    = "unallocated_deposits"
+	escrowAccountPrefix   = "escrow:"
+
+	EntryKindDeposit      = "deposit"
+	EntryKindDepositMatch = "deposit_match"
+	EntryKindRefundQueued = "refund_queued"
+	EntryKindRefund       = "refund"
+	EntryKindTrade        = "trade"
+	EntryKindSettlement   = "settlement"
+	// EntryKindPayoutResolved records the failed payouts of an order which were
+	// sent by hand.
+	EntryKindPayoutResolved = "payout_resolved"
+)
+
+// Asset is a token on one chain and network.
+type Asset struct {
+	Token   string `json:"token"`
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+}
+
+// Balance is what an account holds of an asset. Amount is the debits minus the
+// credits for the service wallets and the credits minus the debits for the
+// other accounts, so it is positive in both cases.
+type Balance struct {
+	Account string `json:"account"`
+	Asset
+	Amount decimal.Amount `json:"amount"`
+}
+
+// Position sums the accounts of an asset. Balanced tells whether the service
+// wallets equal the escrow, the fees, the refunds payable and the unallocated
+// deposits together.
+type Position struct {
+	Asset
+	ServiceWallet  decimal.Amount `json:"service_wallet"`
+	Escrow         decimal.Amount `json:"escrow"`
+	FeeRevenue     decimal.Amount `json:"fee_revenue"`
+	RefundsPayable decimal.Amount `json:"refunds_payable"`
+	Unallocated    decimal.Amount `json:"unallocated_deposits"`
+	Balanced       bool           `json:"balanced"`
+}
+
+// EscrowAccount is the account of the deposits held for an order.
+func EscrowAccount(orderID string) string {
+	return escrowAccountPrefix + orderID
+}
+
+// IsEscrowAccount tells whether the account holds the deposits of an order.
+func IsEscrowAccount(account string) bool {
+	return strings.HasPrefix(account, escrowAccountPrefix)
+}
+
+// EntryID is the ID of the entry of a kind recorded for the deposit, order or
+// trade with the given ID.
+func EntryID(kind, id string) string {
+	return fmt.Sprintf("%s:%s", kind, id)
+}
+
+// Record checks that the entry balances for every asset and stores it. Entries
+// without lines are not stored.
+func Record(ctx context.Context, journal database.JournalStore, entry *database.JournalEntry) error {
+	if len(entry.Lines) == 0 {
+		return nil
+	}
+	totals := map[Asset]decimal.Amount{}
+	for _, line := range entry.Lines {
+		asset := lineAsset(line)
+		totals[asset] = totals[asset].Add(line.Debit).Sub(line.Credit)
+	}
+	for asset, total := range totals {
+		if !total.IsZero() {
+			return fmt.Errorf("%w: %s is off by %s %s on %s %s", ErrUnbalanced, entry.ID, total, asset.Token, asset.Chain, asset.Network)
+		}
+	}
+	return journal.Insert(ctx, entry)
+}
+
+// RecordDeposit records a deposit received on a service wallet, owed to no
+// order until it is matched.
+func RecordDeposit(ctx context.Context, journal database.JournalStore, deposit *database.Deposit) error {
+	entry := newEntry(EntryKindDeposit, deposit.ID, "", deposit.ID)
+	post(entry, AccountServiceWallet, AccountUnallocated, depositAsset(deposit), deposit.Amount)
+	return Record(ctx, journal, entry)
+}
+
+// RecordDepositMatch moves a deposit credited to the given side of an order to
+// the escrow of the order. The escrow holds what the side has to deposit, fee
+// included. What was sent on top of it, as the suffix making an ERC-20 deposit
+// amount unique, is kept as fee.
+func RecordDepositMatch(ctx context.Context, journal database.JournalStore, deposit *database.Deposit, orderData *database.OrderData, role string, tokens *registry.Registry) error {
+	quote, err := fee.Compute(orderData.Order, orderData.FeeRate, tokens)
+	if err != nil {
+		return err
+	}
+	orderLeg, err := quote.Leg(role)
+	if err != nil {
+		return err
+	}
+	escrowAmount := orderLeg.DepositAmount
+	if deposit.Amount.Cmp(escrowAmount) < 0 {
+		escrowAmount = deposit.Amount
+	}
+	asset := depositAsset(deposit)
+	entry := newEntry(EntryKindDepositMatch, deposit.ID, orderData.ID, deposit.ID)
+	post(entry, AccountUnallocated, EscrowAccount(orderData.ID), asset, escrowAmount)
+	post(entry, AccountUnallocated, AccountFeeRevenue, asset, deposit.Amount.Sub(escrowAmount))
+	return Record(ctx, journal, entry)
+}
+
+// RecordRefundQueued records that a deposit in fromStatus is owed back. A
+// matched deposit is taken back from the accounts it was matched to, or from
+// its escrow when only part of it is refunded.
+func RecordRefundQueued(ctx context.Context, journal database.JournalStore, deposit *database.Deposit, fromStatus string) error {
+	asset := depositAsset(deposit)
+	entry := newEntry(EntryKindRefundQueued, deposit.ID, deposit.OrderID, deposit.ID)
+	if fromStatus != database.DepositStatusMatched {
+		post(entry, AccountUnallocated, AccountRefundsPayable, asset, deposit.Amount)
+		return Record(ctx, journal, entry)
+	}
+	// Only what the fills of the order did not use is taken back of its escrow
+	if deposit.Refund != nil && deposit.Refund.Amount.Sign() > 0 {
+		post(entry, EscrowAccount(deposit.OrderID), AccountRefundsPayable, asset, deposit.Refund.Amount)
+		return Record(ctx, journal, entry)
+	}
+	matchEntry, err := journal.FindOne(ctx, EntryID(EntryKindDepositMatch, deposit.ID))
+	if err == database.ErrNoDocuments {
+		post(entry, EscrowAccount(deposit.OrderID), AccountRefundsPayable, asset, deposit.Amount)
+		return Record(ctx, journal, entry)
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range matchEntry.Lines {
+		post(entry, line.Account, AccountRefundsPayable, lineAsset(line), line.Credit)
+	}
+	return Record(ctx, journal, entry)
+}
+
+// RecordRefund records a refund sent out of the service wallet.
+func RecordRefund(ctx context.Context, journal database.JournalStore, deposit *database.Deposit) error {
+	entry := newEntry(EntryKindRefund, deposit.ID, deposit.OrderID, deposit.ID)
+	post(entry, AccountRefundsPayable, AccountServiceWallet, depositAsset(deposit), RefundAmount(deposit))
+	return Record(ctx, journal, entry)
+}
+
+// RefundAmount returns what is sent back of a deposit: all of it, unless its
+// refund was queued for a part only.
+func RefundAmount(deposit *database.Deposit) decimal.Amount {
+	if deposit.Refund != nil && deposit.Refund.Amount.Sign() > 0 {
+		return deposit.Refund.Amount
+	}
+	return deposit.Amount
+}
+
+// RecordTrade swaps the traded amounts between the escrows of the crossed
+// orders, so each order then holds what it pays out to its maker. A fill
+// trades the maker deposit held by the escrow of its order.
+func RecordTrade(ctx context.Context, journal database.JournalStore, trade *database.Trade, buyOrder, sellOrder *database.OrderData, tokens *registry.Registry) error {
+	symbols := strings.Split(trade.Pair, "/")
+	if len(symbols) != 2 {
+		return fmt.Errorf("invalid trade pair: %s", trade.Pair)
+	}
+	baseAsset, err := orderAsset(symbols[0], trade.Chain, trade.Network, tokens)
+	if err != nil {
+		return err
+	}
+	quoteAsset, err := orderAsset(symbols[1], trade.Chain, trade.Network, tokens)
+	if err != nil {
+		return err
+	}
+	entry := newEntry(EntryKindTrade, trade.ID, "", "")
+	post(entry, depositEscrow(sellOrder), EscrowAccount(buyOrder.ID), baseAsset, trade.Amount)
+	post(entry, depositEscrow(buyOrder), EscrowAccount(sellOrder.ID), quoteAsset, trade.Amount.Mul(trade.Price))
+	return Record(ctx, journal, entry)
+}
+
+// RecordSettlement records the payouts sent and the fee revenue kept of an
+// order. Payouts which failed stay in the escrow, as they are still owed. A
+// fill pays out of its own escrow, except for the token deposited by the maker
+// which is held by the escrow of its order.
+func RecordSettlement(ctx context.Context, journal database.JournalStore, orderData *database.OrderData, payouts []*database.Payout, feeRevenue []*database.FeeRevenue, tokens *registry.Registry) error {
+	if orderData.Order == nil {
+		return fmt.Errorf("order %s has no order data", orderData.ID)
+	}
+	escrowAccount, err := settlementEscrow(orderData)
+	if err != nil {
+		return err
+	}
+	entry := newEntry(EntryKindSettlement, orderData.ID, orderData.ID, "")
+	postPayouts(entry, escrowAccount, payouts)
+	for _, tokenRevenue := range feeRevenue {
+		asset, err := orderAsset(tokenRevenue.Token, orderData.Chain, orderData.Network, tokens)
+		if err != nil {
+			return err
+		}
+		post(entry, escrowAccount(tokenRevenue.Token), AccountFeeRevenue, asset, tokenRevenue.Amount)
+	}
+	return Record(ctx, journal, entry)
+}
+
+// RecordPayoutResolution records the payouts of an order which failed and
+// were then sent by hand, so they leave the escrow of the order.
+func RecordPayoutResolution(ctx context.Context, journal database.JournalStore, orderData *database.OrderData, payouts []*database.Payout) error {
+	if orderData.Order == nil {
+		return fmt.Errorf("order %s has no order data", orderData.ID)
+	}
+	escrowAccount, err := settlementEscrow(orderData)
+	if err != nil {
+		return err
+	}
+	entry := newEntry(EntryKindPayoutResolved, orderData.ID, orderData.ID, "")
+	postPayouts(entry, escrowAccount, payouts)
+	return Record(ctx, journal, entry)
+}
+
+// Balances returns the balance of every account and asset of the entries.
+func Balances(entries []*database.JournalEntry) []*Balance {
+	balances := []*Balance{}
+	balanceIndex := map[string]*Balance{}
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			asset := lineAsset(line)
+			key := fmt.Sprintf("%s|%s|%s|%s", line.Account, asset.Token, asset.Chain, asset.Network)
+			balance, exists := balanceIndex[key]
+			if !exists {
+				balance = &Balance{Account: line.Account, Asset: asset}
+				balanceIndex[key] = balance
+				balances = append(balances, balance)
+			}
+			if line.Account == AccountServiceWallet {
+				balance.Amount = balance.Amount.Add(line.Debit).Sub(line.Credit)
+			} else {
+				balance.Amount = balance.Amount.Add(line.Credit).Sub(line.Debit)
+			}
+		}
+	}
+	sort.SliceStable(balances, func(i, j int) bool {
+		return balances[i].Account < balances[j].Account
+	})
+	return balances
+}
+
+// Positions sums the balances of the entries by asset.
+func Positions(entries []*database.JournalEntry) []*Position {
+	positions := []*Position{}
+	positionIndex := map[Asset]*Position{}
+	for _, balance := range Balances(entries) {
+		position, exists := positionIndex[balance.Asset]
+		if !exists {
+			position = &Position{Asset: balance.Asset}
+			positionIndex[balance.Asset] = position
+			positions = append(positions, position)
+		}
+		switch {
+		case balance.Account == AccountServiceWallet:
+			position.ServiceWallet = position.ServiceWallet.Add(balance.Amount)
+		case balance.Account == AccountFeeRevenue:
+			position.FeeRevenue = position.FeeRevenue.Add(balance.Amount)
+		case balance.Account == AccountRefundsPayable:
+			position.RefundsPayable = position.RefundsPayable.Add(balance.Amount)
+		case balance.Account == AccountUnallocated:
+			position.Unallocated = position.Unallocated.Add(balance.Amount)
+		case IsEscrowAccount(balance.Account):
+			position.Escrow = position.Escrow.Add(balance.Amount)
+		}
+	}
+	for _, position := range positions {
+		liabilities := position.Escrow.Add(position.FeeRevenue).Add(position.RefundsPayable).Add(position.Unallocated)
+		position.Balanced = position.ServiceWallet.Equal(liabilities)
+	}
+	sort.SliceStable(positions, func(i, j int) bool {
+		if positions[i].Network != positions[j].Network {
+			return positions[i].Network < positions[j].Network
+		}
+		if positions[i].Chain != positions[j].Chain {
+			return positions[i].Chain < positions[j].Chain
+		}
+		return positions[i].Token < positions[j].Token
+	})
+	return positions
+}
+
+func newEntry(kind, id, orderID, depositID string) *database.JournalEntry {
+	return &database.JournalEntry{
+		ID:               EntryID(kind, id),
+		Kind:             kind,
+		OrderID:          orderID,
+		DepositID:        depositID,
+		CreationDateTime: time.Now().Format(database.TimeFormat),
+	}
+}
+
+// post moves a positive amount of the asset by debiting one account and
+// crediting the other.
+func post(entry *database.JournalEntry, debitAccount, creditAccount string, asset Asset, amount decimal.Amount) {
+	if amount.Sign() <= 0 {
+		return
+	}
+	entry.Lines = append(entry.Lines,
+		&database.JournalLine{Account: debitAccount, Token: asset.Token, Chain: asset.Chain, Network: asset.Network, Debit: amount},
+		&database.JournalLine{Account: creditAccount, Token: asset.Token, Chain: asset.Chain, Network: asset.Network, Credit: amount},
+	)
+}
+
+// settlementEscrow returns the escrow account an order pays a token out of. A
+// fill pays out of its own escrow, except for the token deposited by the maker
+// which is held by the escrow of its order.
+func settlementEscrow(orderData *database.OrderData) (func(token string) string, error) {
+	makerToken, err := fee.DepositToken(orderData.Order, database.ParticipantRoleMaker)
+	if err != nil {
+		return nil, err
+	}
+	return func(token string) string {
+		if len(orderData.ParentID) > 0 && token == makerToken {
+			return EscrowAccount(orderData.ParentID)
+		}
+		return EscrowAccount(orderData.ID)
+	}, nil
+}
+
+// depositEscrow returns the escrow account holding the maker deposit of an
+// order, which is the one of its order for a fill.
+func depositEscrow(orderData *database.OrderData) string {
+	if len(orderData.ParentID) > 0 {
+		return EscrowAccount(orderData.ParentID)
+	}
+	return EscrowAccount(orderData.ID)
+}
+
+// postPayouts moves the payouts which were sent out of the escrow and the
+// service wallet.
+func postPayouts(entry *database.JournalEntry, escrowAccount func(token string) string, payouts []*database.Payout) {
+	for _, payout := range payouts {
+		if len(payout.TxHash) == 0 {
+			continue
+		}
+		post(entry, escrowAccount(payout.Token), AccountServiceWallet, Asset{Token: payout.Token, Chain: payout.Chain, Network: payout.Network}, payout.Amount)
+	}
+}
+
+func depositAsset(deposit *database.Deposit) Asset {
+	return Asset{Token: deposit.Token, Chain: deposit.Chain, Network: deposit.Network}
+}
+
+func lineAsset(line *database.JournalLine) Asset {
+	return Asset{Token: line.Token, Chain: line.Chain, Network: line.Network}
+}
+
+// orderAsset returns the asset of a token traded in an order on the given
+// chain and network.
+func orderAsset(symbol, chain, network string, tokens *registry.Registry) (Asset, error) {
+	token, err := tokens.OrderToken(symbol, chain, network)
+	if err != nil {
+		return Asset{}, err
+	}
+	return Asset{Token: token.Symbol, Chain: token.Chain, Network: token.Network}, nil
+}
@@ -0,0 +1,147 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+func testOrder(id string) *database.OrderData {
+	return &database.OrderData{
+		ID: id,
+		Order: &database.Order{
+			Type:         "sell",
+			Pair:         "XEL/USDT",
+			Amount:       decimal.MustParse("10"),
+			Price:        decimal.MustParse("2"),
+			Chain:        "ethereum",
+			Network:      "testnet",
+			FeePayerType: "seller",
+		},
+		FeeRate: decimal.NewFromInt(1),
+	}
+}
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	tokens, err := registry.New([]*database.Token{
+		{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: "^xel:"},
+		{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, AddressPattern: "^0x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The seller deposits 10 XEL and its fee of 0.1 XEL, with 0.00000001 XEL on
+	// top, the buyer 20 USDT. The seller of the cancelled order gets its deposit back.
+	settled, cancelled := testOrder("settled"), testOrder("cancelled")
+	deposits := []struct {
+		deposit   *database.Deposit
+		orderData *database.OrderData
+		role      string
+	}{
+		{
+			deposit:   &database.Deposit{ID: "01:0", Token: "XEL", Chain: "xelis", Network: "testnet", Amount: decimal.MustParse("10.10000001"), OrderID: "settled"},
+			orderData: settled,
+			role:      database.ParticipantRoleMaker,
+		},
+		{
+			deposit:   &database.Deposit{ID: "0x02:0", Token: "USDT", Chain: "ethereum", Network: "testnet", Amount: decimal.MustParse("20"), OrderID: "settled"},
+			orderData: settled,
+			role:      database.ParticipantRoleTaker,
+		},
+		{
+			deposit:   &database.Deposit{ID: "03:0", Token: "XEL", Chain: "xelis", Network: "testnet", Amount: decimal.MustParse("10.1"), OrderID: "cancelled"},
+			orderData: cancelled,
+			role:      database.ParticipantRoleMaker,
+		},
+	}
+	for _, matched := range deposits {
+		err := RecordDeposit(ctx, store.Journal, matched.deposit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = RecordDepositMatch(ctx, store.Journal, matched.deposit, matched.orderData, matched.role, tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	payouts := []*database.Payout{
+		{Role: database.ParticipantRoleMaker, Token: "USDT", Chain: "ethereum", Network: "testnet", Amount: decimal.MustParse("20"), TxHash: "0x04"},
+		{Role: database.ParticipantRoleTaker, Token: "XEL", Chain: "xelis", Network: "testnet", Amount: decimal.MustParse("10"), TxHash: "05"},
+	}
+	feeRevenue := []*database.FeeRevenue{{Token: "XEL", Amount: decimal.MustParse("0.1")}}
+	err = RecordSettlement(ctx, store.Journal, settled, payouts, feeRevenue, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refunded := deposits[2].deposit
+	err = RecordRefundQueued(ctx, store.Journal, refunded, database.DepositStatusMatched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RecordRefund(ctx, store.Journal, refunded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the same movement again posts nothing
+	for _, record := range []struct {
+		id     string
+		record func() error
+	}{
+		{id: EntryID(EntryKindDeposit, "01:0"), record: func() error {
+			return RecordDeposit(ctx, store.Journal, deposits[0].deposit)
+		}},
+		{id: EntryID(EntryKindDepositMatch, "01:0"), record: func() error {
+			return RecordDepositMatch(ctx, store.Journal, deposits[0].deposit, settled, database.ParticipantRoleMaker, tokens)
+		}},
+		{id: EntryID(EntryKindSettlement, "settled"), record: func() error {
+			return RecordSettlement(ctx, store.Journal, settled, payouts, feeRevenue, tokens)
+		}},
+		{id: EntryID(EntryKindRefund, "03:0"), record: func() error {
+			return RecordRefund(ctx, store.Journal, refunded)
+		}},
+	} {
+		if err := record.record(); err != database.ErrDuplicateEntry {
+			t.Errorf("recording %s again error = %v, want %v", record.id, err, database.ErrDuplicateEntry)
+		}
+	}
+
+	entries, err := store.Journal.Find(ctx, &database.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 9 {
+		t.Fatalf("%d journal entries, want 9", len(entries))
+	}
+	for _, entry := range entries {
+		totals := map[Asset]decimal.Amount{}
+		for _, line := range entry.Lines {
+			totals[lineAsset(line)] = totals[lineAsset(line)].Add(line.Debit).Sub(line.Credit)
+		}
+		for asset, total := range totals {
+			if !total.IsZero() {
+				t.Errorf("entry %s is off by %s %s", entry.ID, total, asset.Token)
+			}
+		}
+	}
+
+	// Only the fee and what was sent on top of the deposit stay in the wallets
+	positions := Positions(entries)
+	if len(positions) != 2 {
+		t.Fatalf("%d positions, want 2", len(positions))
+	}
+	want := map[string]string{"XEL": "0.10000001", "USDT": "0"}
+	for _, position := range positions {
+		if !position.Balanced || !position.Escrow.IsZero() || !position.RefundsPayable.IsZero() || !position.Unallocated.IsZero() {
+			t.Errorf("%s position %+v, want balanced with nothing in escrow, to refund or unallocated", position.Token, position)
+		}
+		if position.ServiceWallet.String() != want[position.Token] || position.FeeRevenue.String() != want[position.Token] {
+			t.Errorf("%s service wallet = %s, fee revenue = %s, want %s", position.Token, position.ServiceWallet, position.FeeRevenue, want[position.Token])
+		}
+	}
+}
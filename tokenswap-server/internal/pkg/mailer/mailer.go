@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mailer sends the emails of the service to the users.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// SMTPMailer sends the emails through an SMTP server. The server is
+// authenticated with PLAIN auth when a username is set.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+	if len(username) > 0 {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, message(m.from, to, subject, body))
+	if err != nil {
+		return fmt.Errorf("error while sending the email to %s: %w", to, err)
+	}
+	return nil
+}
+
+// FileMailer writes the emails to a file instead of sending them, for local
+// use. The emails are logged when no file is set.
+type FileMailer struct {
+	mutex sync.Mutex
+	path  string
+	from  string
+}
+
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{
+		path: path,
+		from: from,
+	}
+}
+
+func (m *FileMailer) Send(ctx context.Context, to, subject, body string) error {
+	if len(m.path) == 0 {
+		log.Infof("Email to %s: %s\n%s", to, subject, body)
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(message(m.from, to, subject, body), "\r\n"...))
+	return err
+}
+
+func message(from, to, subject, body string) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n") + "\r\n")
+}
@@ -0,0 +1,268 @@
+package matching
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
+)
+
+const (
+	matchingCheckTermSeconds = 10
+
+	orderTypeBuy          = "buy"
+	orderTypeSell         = "sell"
+	orderVisibilityPublic = "public"
+)
+
+// Book holds the active public orders of one pair on a chain and network. Bids
+// are sorted from the highest price and asks from the lowest, the oldest order
+// first at the same price.
+type Book struct {
+	Pair    string
+	Chain   string
+	Network string
+	Bids    []*database.OrderData
+	Asks    []*database.OrderData
+}
+
+// Engine crosses the buy and sell orders of the books by price-time priority.
+// Both orders of a trade have their maker deposit already, so each of them gets
+// a fill of the traded amount, completed right away and paid out by the
+// settlement engine. What is left of an order stays in the book. Private orders
+// are left to be taken manually. The orders completed are published on the bus
+// and queued for the webhooks.
+type Engine struct {
+	store *database.Store
+	bus   *events.Bus
+}
+
+func NewEngine(store *database.Store, bus *events.Bus) *Engine {
+	return &Engine{
+		store: store,
+		bus:   bus,
+	}
+}
+
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(matchingCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.MatchOrders(ctx)
+		case <-ctx.Done():
+			log.Printf("Stopping order matching.")
+			return
+		}
+	}
+}
+
+// MatchOrders builds the books from the stored orders and crosses them.
+func (e *Engine) MatchOrders(ctx context.Context) {
+	orders := []*database.OrderData{}
+	for _, status := range []string{database.OrderStatusType2, database.OrderStatusType10} {
+		statusOrders, err := e.store.Orders.Find(ctx, &database.OrderFilter{Visibility: orderVisibilityPublic, Status: status})
+		if err != nil {
+			log.Errorf("error while finding %s orders: %s", status, err.Error())
+			return
+		}
+		orders = append(orders, statusOrders...)
+	}
+	orderCommonInfo, err := e.store.CommonInfo.Get(ctx)
+	if err != nil {
+		log.Errorf("error while getting the order common info: %s", err.Error())
+		return
+	}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		log.Errorf("error while loading the token registry: %s", err.Error())
+		return
+	}
+	for _, book := range BuildBooks(orders) {
+		e.matchBook(ctx, book, tokens)
+	}
+}
+
+// BuildBooks groups orders into the book of their pair, chain and network.
+// Orders are in the book for what is left of them.
+func BuildBooks(orders []*database.OrderData) []*Book {
+	books := []*Book{}
+	bookIndex := map[[3]string]*Book{}
+	for _, order := range orders {
+		if order.Order == nil || database.RemainingAmount(order).Sign() <= 0 {
+			continue
+		}
+		key := [3]string{order.Pair, order.Chain, order.Network}
+		book, exists := bookIndex[key]
+		if !exists {
+			book = &Book{Pair: order.Pair, Chain: order.Chain, Network: order.Network}
+			bookIndex[key] = book
+			books = append(books, book)
+		}
+		switch order.Type {
+		case orderTypeBuy:
+			book.Bids = append(book.Bids, order)
+		case orderTypeSell:
+			book.Asks = append(book.Asks, order)
+		}
+	}
+	for _, book := range books {
+		sortByPriority(book.Bids, -1)
+		sortByPriority(book.Asks, 1)
+	}
+	return books
+}
+
+// sortByPriority sorts orders by price in the given direction, 1 for the
+// lowest price first and -1 for the highest, and then by age.
+func sortByPriority(orders []*database.OrderData, direction int) {
+	sort.SliceStable(orders, func(i, j int) bool {
+		if priceOrder := orders[i].Price.Cmp(orders[j].Price) * direction; priceOrder != 0 {
+			return priceOrder < 0
+		}
+		return orders[i].CreationDateTime < orders[j].CreationDateTime
+	})
+}
+
+// matchBook crosses the best bid with the best ask it can trade with for what
+// is left of the smaller one, until the book no longer crosses. An order never
+// trades with an order of the same user, so a bid skips the asks of its user.
+// When a trade fails the book is left as it is, to be built again on the next
+// check.
+func (e *Engine) matchBook(ctx context.Context, book *Book, tokens *registry.Registry) {
+	bids := append([]*database.OrderData{}, book.Bids...)
+	asks := append([]*database.OrderData{}, book.Asks...)
+	for len(bids) > 0 && len(asks) > 0 {
+		bid := bids[0]
+		askIndex := -1
+		for i, ask := range asks {
+			if ask.Price.Cmp(bid.Price) > 0 {
+				// The remaining asks are all above the bid
+				break
+			}
+			if ask.UserUUID != bid.UserUUID {
+				askIndex = i
+				break
+			}
+		}
+		if askIndex < 0 {
+			bids = bids[1:]
+			continue
+		}
+		ask := asks[askIndex]
+		trade, err := e.executeTrade(ctx, bid, ask, tokens)
+		if err != nil {
+			if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
+				log.Infof("order %s or %s has changed in the meantime", bid.ID, ask.ID)
+			} else {
+				log.Errorf("error while crossing orders %s and %s: %s", bid.ID, ask.ID, err.Error())
+			}
+			return
+		}
+		log.Infof("trade %s: orders %s and %s crossed %s %s at %s", trade.ID, bid.ID, ask.ID, trade.Amount, trade.Pair, trade.Price)
+		if database.RemainingAmount(bid).Sign() <= 0 {
+			bids = bids[1:]
+		}
+		if database.RemainingAmount(ask).Sign() <= 0 {
+			asks = append(asks[:askIndex], asks[askIndex+1:]...)
+		}
+	}
+}
+
+// executeTrade crosses a bid and an ask for what is left of the smaller one.
+// Each order gets a completed fill of the traded amount, and the trade is
+// recorded between the fills. The trade runs at the price of the order which
+// was in the book first. The bid and the ask are updated with their new
+// amounts and status once the trade is recorded.
+func (e *Engine) executeTrade(ctx context.Context, bid, ask *database.OrderData, tokens *registry.Registry) (*database.Trade, error) {
+	price := ask.Price
+	if bid.CreationDateTime < ask.CreationDateTime {
+		price = bid.Price
+	}
+	amount := database.RemainingAmount(bid)
+	if askAmount := database.RemainingAmount(ask); askAmount.Cmp(amount) < 0 {
+		amount = askAmount
+	}
+	currentDateTime := time.Now().Format(database.TimeFormat)
+	bidFill := newTradeFill(bid, ask, amount, currentDateTime)
+	askFill := newTradeFill(ask, bid, amount, currentDateTime)
+	trade := &database.Trade{
+		ID:               uuid.New().String(),
+		Pair:             bid.Pair,
+		Chain:            bid.Chain,
+		Network:          bid.Network,
+		BuyOrderID:       bidFill.ID,
+		SellOrderID:      askFill.ID,
+		Amount:           amount,
+		Price:            price,
+		CreationDateTime: currentDateTime,
+	}
+	completedEvents := []*events.Event{}
+	filledOrders := []*database.OrderData{}
+	err := e.store.WithTransaction(ctx, func(txCtx context.Context) error {
+		completedEvents = []*events.Event{}
+		filledOrders = []*database.OrderData{}
+		for _, crossed := range []struct{ order, fill *database.OrderData }{{bid, bidFill}, {ask, askFill}} {
+			filledData, err := database.FillOrder(txCtx, e.store.Orders, &database.OrderFilter{ID: crossed.order.ID, Status: crossed.order.Status}, amount, decimal.Zero().Sub(amount))
+			if err != nil {
+				return err
+			}
+			err = e.store.Orders.Insert(txCtx, crossed.fill)
+			if err != nil {
+				return err
+			}
+			filledOrders = append(filledOrders, filledData)
+			completedEvents = append(completedEvents, events.NewOrderEvent(events.OrderCompleted, crossed.fill, filledData.UserUUID))
+			if filledData.Status == database.OrderStatusType6 {
+				completedEvents = append(completedEvents, events.NewOrderEvent(events.OrderCompleted, filledData))
+			}
+		}
+		err := e.store.Trades.Insert(txCtx, trade)
+		if err != nil {
+			return err
+		}
+		err = ledger.RecordTrade(txCtx, e.store.Journal, trade, bidFill, askFill, tokens)
+		if err != nil {
+			return err
+		}
+		return webhook.QueueEvents(txCtx, e.store, completedEvents...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, order := range []*database.OrderData{bid, ask} {
+		order.FilledAmount = filledOrders[i].FilledAmount
+		order.RemainingAmount = filledOrders[i].RemainingAmount
+		order.Status = filledOrders[i].Status
+	}
+	e.bus.Publish(completedEvents...)
+	return trade, nil
+}
+
+// newTradeFill returns the completed fill of an order crossed with another for
+// amount. Like the fill of a take, it belongs to the user of the other order.
+func newTradeFill(order, other *database.OrderData, amount decimal.Amount, currentDateTime string) *database.OrderData {
+	fillOrder := *order.Order
+	fillOrder.Amount = amount
+	fillOrder.Status = database.OrderStatusType6
+	return &database.OrderData{
+		ID:               fmt.Sprintf("%s-%s", order.ID, uuid.New().String()),
+		ParentID:         order.ID,
+		UserUUID:         other.UserUUID,
+		Order:            &fillOrder,
+		FeeRate:          order.FeeRate,
+		CreationDateTime: currentDateTime,
+		UpdateDateTime:   currentDateTime,
+	}
+}
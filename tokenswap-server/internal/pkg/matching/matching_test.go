@@ -0,0 +1,316 @@
+package matching
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+var testTokens = []*database.Token{
+	{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: "^xel:"},
+	{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, AddressPattern: "^0x"},
+}
+
+// testOrder is an active public XEL/USDT order created at the given minute.
+func testOrder(id, user, orderType, amount, price, minute string) *database.OrderData {
+	return &database.OrderData{
+		ID: id,
+		Order: &database.Order{
+			Type:       orderType,
+			Pair:       "XEL/USDT",
+			Amount:     decimal.MustParse(amount),
+			Price:      decimal.MustParse(price),
+			Chain:      "ethereum",
+			Network:    "testnet",
+			Visibility: orderVisibilityPublic,
+			Status:     database.OrderStatusType2,
+		},
+		RemainingAmount:  decimal.MustParse(amount),
+		UserUUID:         user,
+		FeeRate:          decimal.Zero(),
+		CreationDateTime: "2026-01-01 00:" + minute + ":00 UTC",
+	}
+}
+
+func orderIDs(orders []*database.OrderData) []string {
+	ids := []string{}
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+	return ids
+}
+
+func equalIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBuildBooks(t *testing.T) {
+	partiallyFilled := testOrder("bid-partial", "b", orderTypeBuy, "10", "3", "05")
+	partiallyFilled.Status = database.OrderStatusType10
+	partiallyFilled.FilledAmount = decimal.MustParse("4")
+	partiallyFilled.RemainingAmount = decimal.MustParse("6")
+	reserved := testOrder("ask-reserved", "c", orderTypeSell, "10", "1", "00")
+	reserved.RemainingAmount = decimal.Zero()
+	reserved.Status = database.OrderStatusType3
+	otherNetwork := testOrder("ask-mainnet", "c", orderTypeSell, "10", "1", "00")
+	otherNetwork.Network = "mainnet"
+
+	books := BuildBooks([]*database.OrderData{
+		testOrder("bid-low", "a", orderTypeBuy, "10", "2", "00"),
+		testOrder("bid-high-new", "a", orderTypeBuy, "10", "3", "09"),
+		partiallyFilled,
+		testOrder("bid-high-old", "a", orderTypeBuy, "10", "3", "01"),
+		testOrder("ask-high", "c", orderTypeSell, "10", "4", "00"),
+		testOrder("ask-low-new", "c", orderTypeSell, "10", "2", "08"),
+		testOrder("ask-low-old", "c", orderTypeSell, "10", "2", "02"),
+		reserved,
+		otherNetwork,
+	})
+	if len(books) != 2 {
+		t.Fatalf("%d books, want one per network", len(books))
+	}
+	book := books[0]
+	if book.Network != "testnet" {
+		book = books[1]
+	}
+	// Price first, then the oldest order
+	wantBids := []string{"bid-high-old", "bid-partial", "bid-high-new", "bid-low"}
+	if got := orderIDs(book.Bids); !equalIDs(got, wantBids) {
+		t.Errorf("bids = %v, want %v", got, wantBids)
+	}
+	wantAsks := []string{"ask-low-old", "ask-low-new", "ask-high"}
+	if got := orderIDs(book.Asks); !equalIDs(got, wantAsks) {
+		t.Errorf("asks = %v, want %v", got, wantAsks)
+	}
+}
+
+// orderState is the status and what is left of an order after matching.
+type orderState struct {
+	status    string
+	remaining string
+}
+
+// tradeOf is a trade between two orders, by the IDs of the orders the fills of
+// the trade belong to.
+type tradeOf struct {
+	buyOrderID  string
+	sellOrderID string
+	amount      string
+	price       string
+}
+
+func TestMatchBook(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		orders []*database.OrderData
+		trades []tradeOf
+		states map[string]orderState
+	}{
+		{
+			name: "different amounts cross for the smaller one",
+			orders: []*database.OrderData{
+				testOrder("bid", "a", orderTypeBuy, "10", "3", "00"),
+				testOrder("ask", "b", orderTypeSell, "5", "2", "01"),
+			},
+			trades: []tradeOf{{"bid", "ask", "5", "3"}},
+			states: map[string]orderState{
+				"bid": {database.OrderStatusType10, "5"},
+				"ask": {database.OrderStatusType6, "0"},
+			},
+		},
+		{
+			name: "the trade runs at the price of the older order",
+			orders: []*database.OrderData{
+				testOrder("bid", "a", orderTypeBuy, "5", "3", "01"),
+				testOrder("ask", "b", orderTypeSell, "5", "2", "00"),
+			},
+			trades: []tradeOf{{"bid", "ask", "5", "2"}},
+			states: map[string]orderState{
+				"bid": {database.OrderStatusType6, "0"},
+				"ask": {database.OrderStatusType6, "0"},
+			},
+		},
+		{
+			name: "the best priced ask trades first whatever its amount",
+			orders: []*database.OrderData{
+				testOrder("bid", "a", orderTypeBuy, "5", "3", "00"),
+				testOrder("ask-same-amount", "b", orderTypeSell, "5", "2.5", "01"),
+				testOrder("ask-best", "c", orderTypeSell, "8", "2", "02"),
+			},
+			trades: []tradeOf{{"bid", "ask-best", "5", "3"}},
+			states: map[string]orderState{
+				"bid":             {database.OrderStatusType6, "0"},
+				"ask-best":        {database.OrderStatusType10, "3"},
+				"ask-same-amount": {database.OrderStatusType2, "5"},
+			},
+		},
+		{
+			name: "no self-trade",
+			orders: []*database.OrderData{
+				testOrder("bid", "a", orderTypeBuy, "5", "3", "00"),
+				testOrder("ask-own", "a", orderTypeSell, "5", "1", "01"),
+				testOrder("ask-other", "b", orderTypeSell, "5", "2", "02"),
+			},
+			trades: []tradeOf{{"bid", "ask-other", "5", "3"}},
+			states: map[string]orderState{
+				"bid":       {database.OrderStatusType6, "0"},
+				"ask-own":   {database.OrderStatusType2, "5"},
+				"ask-other": {database.OrderStatusType6, "0"},
+			},
+		},
+		{
+			name: "an ask is used up once",
+			orders: []*database.OrderData{
+				testOrder("bid-first", "a", orderTypeBuy, "5", "3", "00"),
+				testOrder("bid-second", "b", orderTypeBuy, "5", "3", "01"),
+				testOrder("ask", "c", orderTypeSell, "5", "2", "02"),
+			},
+			trades: []tradeOf{{"bid-first", "ask", "5", "3"}},
+			states: map[string]orderState{
+				"bid-first":  {database.OrderStatusType6, "0"},
+				"bid-second": {database.OrderStatusType2, "5"},
+				"ask":        {database.OrderStatusType6, "0"},
+			},
+		},
+		{
+			name: "a bid takes several asks",
+			orders: []*database.OrderData{
+				testOrder("bid", "a", orderTypeBuy, "10", "3", "00"),
+				testOrder("ask-first", "b", orderTypeSell, "4", "2", "01"),
+				testOrder("ask-second", "c", orderTypeSell, "4", "2", "02"),
+				testOrder("ask-above", "c", orderTypeSell, "4", "4", "03"),
+			},
+			trades: []tradeOf{{"bid", "ask-first", "4", "3"}, {"bid", "ask-second", "4", "3"}},
+			states: map[string]orderState{
+				"bid":        {database.OrderStatusType10, "2"},
+				"ask-first":  {database.OrderStatusType6, "0"},
+				"ask-second": {database.OrderStatusType6, "0"},
+				"ask-above":  {database.OrderStatusType2, "4"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newTestStore(t, test.orders)
+			NewEngine(store, events.NewBus()).MatchOrders(ctx)
+
+			trades, err := store.Trades.Find(ctx, &database.TradeFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trades) != len(test.trades) {
+				t.Fatalf("%d trades, want %d", len(trades), len(test.trades))
+			}
+			for i, trade := range trades {
+				want := test.trades[i]
+				buyFill := findOrder(t, store, trade.BuyOrderID)
+				sellFill := findOrder(t, store, trade.SellOrderID)
+				if buyFill.ParentID != want.buyOrderID || sellFill.ParentID != want.sellOrderID ||
+					trade.Amount.String() != want.amount || trade.Price.String() != want.price {
+					t.Errorf("trade %d of %s and %s: %s at %s, want %+v", i, buyFill.ParentID, sellFill.ParentID, trade.Amount, trade.Price, want)
+				}
+				for _, fill := range []*database.OrderData{buyFill, sellFill} {
+					if fill.Status != database.OrderStatusType6 || fill.Amount.String() != want.amount {
+						t.Errorf("fill %s is %s for %s, want completed for %s", fill.ID, fill.Status, fill.Amount, want.amount)
+					}
+				}
+			}
+			for orderID, want := range test.states {
+				orderData := findOrder(t, store, orderID)
+				if orderData.Status != want.status || database.RemainingAmount(orderData).String() != want.remaining {
+					t.Errorf("order %s is %s with %s left, want %s with %s", orderID, orderData.Status, database.RemainingAmount(orderData), want.status, want.remaining)
+				}
+			}
+		})
+	}
+}
+
+// racingOrders is an order store where the orders of raceID change between
+// being read and being filled.
+type racingOrders struct {
+	database.OrderStore
+	raceID string
+}
+
+func (s *racingOrders) UpdateFill(ctx context.Context, from *database.OrderData, filledAmount, remainingAmount decimal.Amount, status string) error {
+	if from.ID == s.raceID {
+		return database.ErrNonUpdated
+	}
+	return s.OrderStore.UpdateFill(ctx, from, filledAmount, remainingAmount, status)
+}
+
+func TestMatchBookRace(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, []*database.OrderData{
+		testOrder("bid", "a", orderTypeBuy, "5", "3", "00"),
+		testOrder("ask", "b", orderTypeSell, "5", "2", "01"),
+	})
+	store.Orders = &racingOrders{OrderStore: store.Orders, raceID: "ask"}
+	NewEngine(store, events.NewBus()).MatchOrders(ctx)
+
+	trades, err := store.Trades.Find(ctx, &database.TradeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 0 {
+		t.Fatalf("%d trades recorded by a failed cross", len(trades))
+	}
+	for _, orderID := range []string{"bid", "ask"} {
+		orderData := findOrder(t, store, orderID)
+		if orderData.Status != database.OrderStatusType2 || !orderData.FilledAmount.IsZero() || orderData.RemainingAmount.String() != "5" {
+			t.Errorf("order %s is %s with %s filled and %s left, want it untouched", orderID, orderData.Status, orderData.FilledAmount, orderData.RemainingAmount)
+		}
+		fills, err := store.Orders.Find(ctx, &database.OrderFilter{ParentID: orderID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fills) != 0 {
+			t.Errorf("order %s has %d fills of a failed cross", orderID, len(fills))
+		}
+	}
+	entries, err := store.Journal.Find(ctx, &database.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d journal entries of a failed cross", len(entries))
+	}
+}
+
+func newTestStore(t *testing.T, orders []*database.OrderData) *database.Store {
+	t.Helper()
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	err := store.CommonInfo.Set(ctx, &database.OrderCommonInfo{Tokens: testTokens, DepositTimeout: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range orders {
+		err := store.Orders.Insert(ctx, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func findOrder(t *testing.T, store *database.Store, id string) *database.OrderData {
+	t.Helper()
+	orderData, err := store.Orders.FindOne(context.Background(), &database.OrderFilter{ID: id})
+	if err != nil {
+		t.Fatalf("order %s: %s", id, err)
+	}
+	return orderData
+}
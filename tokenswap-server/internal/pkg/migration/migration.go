@@ -0,0 +1,266 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+// Migration is one versioned change of the MongoDB schema. Migrations are
+// applied in the order of their version and each of them only once, so a
+// migration must never change once released; a new version is added instead.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(ctx context.Context, db *mongo.Database) error
+}
+
+// AppliedMigration is the record of a migration applied to the database.
+type AppliedMigration struct {
+	Version         int    `json:"version" bson:"version"`
+	Name            string `json:"name" bson:"name"`
+	AppliedDateTime string `json:"applied_date_time" bson:"applied_date_time"`
+}
+
+var migrations = []*Migration{
+	{Version: 1, Name: "create_indexes", Apply: createIndexes},
+	{Version: 2, Name: "backfill_order_fill_amounts", Apply: backfillOrderFillAmounts},
+	{Version: 3, Name: "create_order_sort_indexes", Apply: createOrderSortIndexes},
+	{Version: 4, Name: "create_webhook_indexes", Apply: createWebhookIndexes},
+	{Version: 5, Name: "verify_registered_user_emails", Apply: verifyRegisteredUserEmails},
+	{Version: 6, Name: "create_amount_reservation_index", Apply: createAmountReservationIndex},
+}
+
+// Migrate applies the migrations which were not applied to the database yet
+// and returns them. It stops at the first migration which fails, leaving the
+// ones before it recorded.
+func Migrate(ctx context.Context, db *mongo.Database) ([]*AppliedMigration, error) {
+	collection := db.Collection(database.MigrationCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	appliedVersions, err := findAppliedVersions(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	applied := []*AppliedMigration{}
+	for _, migration := range migrations {
+		if _, ok := appliedVersions[migration.Version]; ok {
+			continue
+		}
+		log.Infof("Applying migration %d %s", migration.Version, migration.Name)
+		err := migration.Apply(ctx, db)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d %s has failed: %w", migration.Version, migration.Name, err)
+		}
+		appliedMigration := &AppliedMigration{
+			Version:         migration.Version,
+			Name:            migration.Name,
+			AppliedDateTime: time.Now().Format(database.TimeFormat),
+		}
+		_, err = collection.InsertOne(ctx, appliedMigration)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, appliedMigration)
+	}
+	return applied, nil
+}
+
+func findAppliedVersions(ctx context.Context, collection *mongo.Collection) (map[int]struct{}, error) {
+	appliedMigrations := []*AppliedMigration{}
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &appliedMigrations); err != nil {
+		return nil, err
+	}
+	appliedVersions := map[int]struct{}{}
+	for _, appliedMigration := range appliedMigrations {
+		appliedVersions[appliedMigration.Version] = struct{}{}
+	}
+	return appliedVersions, nil
+}
+
+// createIndexes creates the unique indexes the stores rely on to find a
+// document by its ID and to record it once, and the indexes of their lookups.
+func createIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		database.UserCollection: {
+			uniqueIndex("uuid"),
+			uniqueIndex("email"),
+		},
+		database.OrderCollection: {
+			uniqueIndex("id"),
+			index("parent_id"),
+			index("user_uuid"),
+			index("order.status", "order.pair"),
+			index("order.status", "deposit_deadline"),
+		},
+		database.OrderParticipantWalletCollection: {
+			index("order_id"),
+			index("order_participant_wallet_address"),
+			index("deposit_reference"),
+		},
+		database.ChainCursorCollection: {
+			uniqueIndex("id"),
+		},
+		database.DepositCollection: {
+			uniqueIndex("id"),
+			index("order_id", "role"),
+			index("status"),
+		},
+		database.TradeCollection: {
+			uniqueIndex("id"),
+			index("buy_order_id"),
+			index("sell_order_id"),
+		},
+		database.JournalCollection: {
+			uniqueIndex("id"),
+			index("order_id"),
+			index("deposit_id"),
+			index("lines.account"),
+		},
+		database.ReconciliationCollection: {
+			uniqueIndex("id"),
+		},
+	}
+	for collection, models := range indexes {
+		_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return fmt.Errorf("creating the indexes of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+func index(fields ...string) mongo.IndexModel {
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+	return mongo.IndexModel{Keys: keys}
+}
+
+func uniqueIndex(fields ...string) mongo.IndexModel {
+	model := index(fields...)
+	model.Options = options.Index().SetUnique(true)
+	return model
+}
+
+// createOrderSortIndexes creates the indexes of the order list pages, sorted
+// by a sort key and then by ID, for the public list and for the list of a user.
+func createOrderSortIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(database.OrderCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		index("creation_date_time", "id"),
+		index("order.price", "id"),
+		index("order.amount", "id"),
+		index("order.visibility", "order.network", "creation_date_time", "id"),
+		index("user_uuid", "creation_date_time", "id"),
+	})
+	if err != nil {
+		return fmt.Errorf("creating the sort indexes of %s: %w", database.OrderCollection, err)
+	}
+	return nil
+}
+
+// createWebhookIndexes creates the indexes of the webhooks of the users and of
+// the deliveries, looked up by webhook and by when they are due.
+func createWebhookIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		database.WebhookCollection: {
+			uniqueIndex("id"),
+			index("user_uuid"),
+		},
+		database.WebhookDeliveryCollection: {
+			uniqueIndex("id"),
+			index("webhook_id"),
+			index("status", "next_attempt_time"),
+		},
+	}
+	for collection, models := range indexes {
+		_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return fmt.Errorf("creating the indexes of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// verifyRegisteredUserEmails marks the emails of the users registered before
+// the email verification verified, so they can keep trading.
+func verifyRegisteredUserEmails(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(database.UserCollection).UpdateMany(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}})
+	return err
+}
+
+// createAmountReservationIndex creates the unique index which keeps two order
+// legs from being given the same ERC-20 deposit amount.
+func createAmountReservationIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(database.AmountReservationCollection).Indexes().CreateOne(ctx, uniqueIndex("token", "network", "amount"))
+	return err
+}
+
+// backfillOrderFillAmounts sets the filled and remaining amounts of the orders
+// stored before partial fills. What is left of an order not taken is its whole
+// amount, an order taken was taken whole and a completed one was filled whole.
+func backfillOrderFillAmounts(ctx context.Context, db *mongo.Database) error {
+	zero := decimal.Zero()
+	backfills := []struct {
+		statuses        []string
+		filledAmount    interface{}
+		remainingAmount interface{}
+	}{
+		{
+			statuses:        []string{database.OrderStatusType1, database.OrderStatusType2, database.OrderStatusType4, database.OrderStatusType5},
+			filledAmount:    zero,
+			remainingAmount: "$order.amount",
+		},
+		{
+			statuses:        []string{database.OrderStatusType3},
+			filledAmount:    zero,
+			remainingAmount: zero,
+		},
+		{
+			statuses:        []string{database.OrderStatusType6, database.OrderStatusType7, database.OrderStatusType8, database.OrderStatusType9},
+			filledAmount:    "$order.amount",
+			remainingAmount: zero,
+		},
+	}
+	collection := db.Collection(database.OrderCollection)
+	for _, backfill := range backfills {
+		filter := bson.M{
+			"remaining_amount": bson.M{"$exists": false},
+			"order.status":     bson.M{"$in": backfill.statuses},
+		}
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "filled_amount", Value: backfill.filledAmount},
+				{Key: "remaining_amount", Value: backfill.remainingAmount},
+			}}},
+		}
+		updateResult, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if updateResult.ModifiedCount > 0 {
+			log.Infof("Backfilled the fill amounts of %d orders in %v", updateResult.ModifiedCount, backfill.statuses)
+		}
+	}
+	return nil
+}
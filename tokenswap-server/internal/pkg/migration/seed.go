@@ -0,0 +1,82 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/utils"
+)
+
+var (
+	ErrAlreadySeeded = errors.New("the order common info is already seeded")
+)
+
+// SeedFile is the YAML file an environment is seeded from. The fee is written
+// as a string, like "0.1", so it is read without going through a float.
+type SeedFile struct {
+	OrderCommonInfo struct {
+		Fee            string            `yaml:"fee"`
+		Pairs          []string          `yaml:"pairs"`
+		Chains         []string          `yaml:"chains"`
+		DepositTimeout int               `yaml:"deposit_timeout"`
+		Tokens         []*database.Token `yaml:"tokens"`
+	} `yaml:"order_common_info"`
+}
+
+// LoadSeedFile reads the order common info of a seed file and validates it.
+func LoadSeedFile(path string) (*database.OrderCommonInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seedFile := SeedFile{}
+	err = yaml.Unmarshal(data, &seedFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed file %s: %w", path, err)
+	}
+	fee, err := decimal.Parse(seedFile.OrderCommonInfo.Fee)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fee in the seed file %s: %w", path, err)
+	}
+	orderCommonInfo := &database.OrderCommonInfo{
+		Fee:            fee,
+		Pairs:          seedFile.OrderCommonInfo.Pairs,
+		Chains:         seedFile.OrderCommonInfo.Chains,
+		Tokens:         seedFile.OrderCommonInfo.Tokens,
+		DepositTimeout: seedFile.OrderCommonInfo.DepositTimeout,
+	}
+	for _, token := range orderCommonInfo.Tokens {
+		token.WalletAddress = utils.NormalizeWalletAddress(token.WalletAddress)
+	}
+	_, err = registry.ValidateOrderCommonInfo(orderCommonInfo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid order common info in the seed file %s: %w", path, err)
+	}
+	return orderCommonInfo, nil
+}
+
+// Seed stores the order common info. An order common info already stored is
+// only replaced with overwrite, otherwise ErrAlreadySeeded is returned.
+func Seed(ctx context.Context, store *database.Store, orderCommonInfo *database.OrderCommonInfo, overwrite bool) error {
+	_, err := store.CommonInfo.Get(ctx)
+	if err == nil && !overwrite {
+		return ErrAlreadySeeded
+	}
+	if err != nil && err != database.ErrNoDocuments {
+		return err
+	}
+	err = store.CommonInfo.Set(ctx, orderCommonInfo)
+	if err != nil {
+		return err
+	}
+	log.Infof("Seeded the order common info with %d pairs and %d tokens", len(orderCommonInfo.Pairs), len(orderCommonInfo.Tokens))
+	return nil
+}
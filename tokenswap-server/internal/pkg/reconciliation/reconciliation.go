@@ -0,0 +1,400 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/utils"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/watcher"
+)
+
+const (
+	reconciliationCheckTermSeconds = 600
+	// recentTransferDepth is how many blocks back the transfers of the service
+	// wallets are read.
+	recentTransferDepth = 1000
+	// settlementGraceMinutes is how long a completed order can wait for its
+	// payouts before they are reported missing.
+	settlementGraceMinutes = 10
+
+	// DiscrepancyUnrecordedDeposit is a transfer received on chain which is not
+	// in the deposits.
+	DiscrepancyUnrecordedDeposit = "unrecorded_deposit"
+	// DiscrepancyUnknownDeposit is a recorded deposit which belongs to no order.
+	DiscrepancyUnknownDeposit = "unknown_deposit"
+	// DiscrepancyMissingDeposit is an order whose status says a side deposited
+	// without a deposit credited to it.
+	DiscrepancyMissingDeposit = "missing_deposit"
+	// DiscrepancyUnknownWithdrawal is a transfer sent on chain which is neither
+	// a payout nor a refund.
+	DiscrepancyUnknownWithdrawal = "unknown_withdrawal"
+	// DiscrepancyMissingPayout is a payout which failed, or a completed order
+	// not paid out in time.
+	DiscrepancyMissingPayout = "missing_payout"
+	// DiscrepancyBalanceDrift is a service wallet whose balance on chain is not
+	// the one of the ledger.
+	DiscrepancyBalanceDrift = "balance_drift"
+)
+
+// Reconciler compares the service wallets with what the service recorded and
+// stores what does not agree as a report. The wallets are read through the
+// chain watchers which implement watcher.WalletReader.
+//
+// The ledger only knows the funds moved since it was introduced and not the
+// network fees paid out of a wallet, so a steady drift is expected on wallets
+// which held funds before or which pay their fees in the token itself.
+type Reconciler struct {
+	store   *database.Store
+	wallets map[ledger.Asset]watcher.WalletReader
+}
+
+// NewReconciler returns a reconciler reading the given wallets, keyed by the
+// asset they hold.
+func NewReconciler(store *database.Store, wallets map[ledger.Asset]watcher.WalletReader) *Reconciler {
+	return &Reconciler{
+		store:   store,
+		wallets: wallets,
+	}
+}
+
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(reconciliationCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report, err := r.Reconcile(ctx)
+			if err != nil {
+				log.Errorf("error while reconciling the service wallets: %s", err.Error())
+				continue
+			}
+			if len(report.Discrepancies) > 0 {
+				log.Warnf("reconciliation report %s has %d discrepancies", report.ID, len(report.Discrepancies))
+			}
+		case <-ctx.Done():
+			log.Printf("Stopping wallet reconciliation.")
+			return
+		}
+	}
+}
+
+// Reconcile builds a report of the current state and stores it.
+func (r *Reconciler) Reconcile(ctx context.Context) (*database.ReconciliationReport, error) {
+	reportID, err := utils.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+	report := &database.ReconciliationReport{
+		ID:               reportID,
+		Wallets:          []*database.WalletReconciliation{},
+		Discrepancies:    []*database.Discrepancy{},
+		CreationDateTime: time.Now().Format(database.TimeFormat),
+	}
+	withdrawals, err := r.reconcileWallets(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range []func(ctx context.Context, report *database.ReconciliationReport) error{
+		r.checkUnknownDeposits,
+		r.checkOrderDeposits,
+		r.checkPayouts,
+	} {
+		err := check(ctx, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = r.checkWithdrawals(ctx, report, withdrawals)
+	if err != nil {
+		return nil, err
+	}
+	err = r.store.Reconciliations.Insert(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// reconcileWallets compares the balance of every wallet with the ledger and
+// looks up their recent deposits in the deposits. It returns the recent
+// transfers sent by the wallets.
+func (r *Reconciler) reconcileWallets(ctx context.Context, report *database.ReconciliationReport) ([]*watcher.Transfer, error) {
+	entries, err := r.store.Journal.Find(ctx, &database.JournalFilter{Account: ledger.AccountServiceWallet})
+	if err != nil {
+		return nil, err
+	}
+	ledgerBalances := map[ledger.Asset]*ledger.Position{}
+	for _, position := range ledger.Positions(entries) {
+		ledgerBalances[position.Asset] = position
+	}
+	assets := []ledger.Asset{}
+	for asset := range r.wallets {
+		assets = append(assets, asset)
+	}
+	for asset := range ledgerBalances {
+		if _, exists := r.wallets[asset]; !exists {
+			assets = append(assets, asset)
+		}
+	}
+	withdrawals := []*watcher.Transfer{}
+	for _, asset := range assets {
+		wallet := &database.WalletReconciliation{Token: asset.Token, Chain: asset.Chain, Network: asset.Network}
+		report.Wallets = append(report.Wallets, wallet)
+		if position, exists := ledgerBalances[asset]; exists {
+			wallet.LedgerBalance = position.ServiceWallet
+		}
+		walletReader, exists := r.wallets[asset]
+		if !exists {
+			wallet.Error = "the wallet is not watched"
+			continue
+		}
+		balance, err := walletReader.Balance(ctx)
+		if err != nil {
+			log.Errorf("error while reading the %s balance on %s %s: %s", asset.Token, asset.Chain, asset.Network, err.Error())
+			wallet.Error = err.Error()
+			continue
+		}
+		wallet.OnChainBalance = balance
+		wallet.Drift = balance.Sub(wallet.LedgerBalance)
+		if !wallet.Drift.IsZero() {
+			report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+				Type:    DiscrepancyBalanceDrift,
+				Token:   asset.Token,
+				Chain:   asset.Chain,
+				Network: asset.Network,
+				Amount:  wallet.Drift,
+				Detail:  fmt.Sprintf("the wallet holds %s on chain and %s in the ledger", balance, wallet.LedgerBalance),
+			})
+		}
+		transfers, err := walletReader.RecentTransfers(ctx, recentTransferDepth)
+		if err != nil {
+			log.Errorf("error while reading the %s transfers on %s %s: %s", asset.Token, asset.Chain, asset.Network, err.Error())
+			wallet.Error = err.Error()
+			continue
+		}
+		for _, transfer := range transfers {
+			if transfer.Outgoing {
+				withdrawals = append(withdrawals, transfer)
+				continue
+			}
+			depositID := fmt.Sprintf("%s:%d", transfer.TxHash, transfer.Index)
+			_, err := r.store.Deposits.FindOne(ctx, depositID)
+			if err == nil {
+				continue
+			}
+			if err != database.ErrNoDocuments {
+				return nil, err
+			}
+			report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+				Type:      DiscrepancyUnrecordedDeposit,
+				Token:     transfer.Token,
+				Chain:     transfer.Chain,
+				Network:   transfer.Network,
+				DepositID: depositID,
+				TxHash:    transfer.TxHash,
+				Amount:    transfer.Amount,
+				Detail:    fmt.Sprintf("the transfer from %s is not in the deposits", transfer.From),
+			})
+		}
+	}
+	return withdrawals, nil
+}
+
+// checkUnknownDeposits reports the deposits which could not be linked to any order.
+func (r *Reconciler) checkUnknownDeposits(ctx context.Context, report *database.ReconciliationReport) error {
+	deposits, err := r.store.Deposits.Find(ctx, &database.DepositFilter{Status: database.DepositStatusUnmatched})
+	if err != nil {
+		return err
+	}
+	for _, deposit := range deposits {
+		if len(deposit.OrderID) > 0 {
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+			Type:      DiscrepancyUnknownDeposit,
+			Token:     deposit.Token,
+			Chain:     deposit.Chain,
+			Network:   deposit.Network,
+			DepositID: deposit.ID,
+			TxHash:    deposit.TxHash,
+			Amount:    deposit.Amount,
+			Detail:    fmt.Sprintf("the deposit from %s belongs to no order", deposit.From),
+		})
+	}
+	return nil
+}
+
+// checkOrderDeposits reports the orders in active, partially_filled,
+// takeInProgress or completed state without the deposits that state implies.
+// The maker of a fill deposits on the order it was taken from, and an order
+// crossed by the matching engine has no taker.
+func (r *Reconciler) checkOrderDeposits(ctx context.Context, report *database.ReconciliationReport) error {
+	credited := map[string]bool{}
+	hasDeposit := func(orderID, role string) (bool, error) {
+		key := orderID + ":" + role
+		if found, checked := credited[key]; checked {
+			return found, nil
+		}
+		deposits, err := r.store.Deposits.Find(ctx, &database.DepositFilter{OrderID: orderID, Role: role, Status: database.DepositStatusMatched})
+		if err != nil {
+			return false, err
+		}
+		credited[key] = len(deposits) > 0
+		return credited[key], nil
+	}
+	for _, status := range []string{database.OrderStatusType2, database.OrderStatusType10, database.OrderStatusType3, database.OrderStatusType6} {
+		orders, err := r.store.Orders.Find(ctx, &database.OrderFilter{Status: status})
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			if order.Order == nil {
+				continue
+			}
+			makerOrderID := order.ID
+			if len(order.ParentID) > 0 {
+				makerOrderID = order.ParentID
+			}
+			sides := map[string]string{database.ParticipantRoleMaker: makerOrderID}
+			if status == database.OrderStatusType6 {
+				trades, err := r.store.Trades.Find(ctx, &database.TradeFilter{OrderID: order.ID})
+				if err != nil {
+					return err
+				}
+				fills, err := r.store.Orders.Find(ctx, &database.OrderFilter{ParentID: order.ID})
+				if err != nil {
+					return err
+				}
+				// The taker of an order taken in fills deposits on the fills
+				if len(trades) == 0 && len(fills) == 0 {
+					sides[database.ParticipantRoleTaker] = order.ID
+				}
+			}
+			for role, orderID := range sides {
+				found, err := hasDeposit(orderID, role)
+				if err != nil {
+					return err
+				}
+				if found {
+					continue
+				}
+				report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+					Type:    DiscrepancyMissingDeposit,
+					Chain:   order.Chain,
+					Network: order.Network,
+					OrderID: order.ID,
+					Detail:  fmt.Sprintf("the order is %s without a %s deposit credited to order %s", order.Status, role, orderID),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// checkPayouts reports the payouts which failed and the orders left completed
+// or settling for longer than the settlement takes.
+func (r *Reconciler) checkPayouts(ctx context.Context, report *database.ReconciliationReport) error {
+	failedOrders, err := r.store.Orders.Find(ctx, &database.OrderFilter{Status: database.OrderStatusType9})
+	if err != nil {
+		return err
+	}
+	for _, order := range failedOrders {
+		for _, payout := range order.Payouts {
+			if len(payout.Error) == 0 {
+				continue
+			}
+			report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+				Type:    DiscrepancyMissingPayout,
+				Token:   payout.Token,
+				Chain:   payout.Chain,
+				Network: payout.Network,
+				OrderID: order.ID,
+				Amount:  payout.Amount,
+				Detail:  fmt.Sprintf("the %s payout failed: %s", payout.Role, payout.Error),
+			})
+		}
+	}
+	deadline := time.Now().Add(-settlementGraceMinutes * time.Minute)
+	for _, status := range []string{database.OrderStatusType6, database.OrderStatusType7} {
+		orders, err := r.store.Orders.Find(ctx, &database.OrderFilter{Status: status})
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			if order.Order == nil {
+				continue
+			}
+			updateTime, err := time.Parse(database.TimeFormat, order.UpdateDateTime)
+			if err != nil || updateTime.After(deadline) {
+				continue
+			}
+			// An order taken in fills waits for its fills, which are reported on their own
+			fills, err := r.store.Orders.Find(ctx, &database.OrderFilter{ParentID: order.ID})
+			if err != nil {
+				return err
+			}
+			if len(fills) > 0 {
+				continue
+			}
+			report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+				Type:    DiscrepancyMissingPayout,
+				Chain:   order.Chain,
+				Network: order.Network,
+				OrderID: order.ID,
+				Detail:  fmt.Sprintf("the order is %s since %s", order.Status, order.UpdateDateTime),
+			})
+		}
+	}
+	return nil
+}
+
+// checkWithdrawals reports the transfers sent by the wallets which are not a
+// recorded payout or refund.
+func (r *Reconciler) checkWithdrawals(ctx context.Context, report *database.ReconciliationReport, withdrawals []*watcher.Transfer) error {
+	if len(withdrawals) == 0 {
+		return nil
+	}
+	recorded := map[string]struct{}{}
+	for _, status := range []string{database.OrderStatusType8, database.OrderStatusType9} {
+		orders, err := r.store.Orders.Find(ctx, &database.OrderFilter{Status: status})
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			for _, payout := range order.Payouts {
+				if len(payout.TxHash) > 0 {
+					recorded[payout.TxHash] = struct{}{}
+				}
+			}
+		}
+	}
+	refunds, err := r.store.Deposits.Find(ctx, &database.DepositFilter{Status: database.DepositStatusRefunded})
+	if err != nil {
+		return err
+	}
+	for _, deposit := range refunds {
+		if deposit.Refund != nil && len(deposit.Refund.TxHash) > 0 {
+			recorded[deposit.Refund.TxHash] = struct{}{}
+		}
+	}
+	for _, transfer := range withdrawals {
+		if _, exists := recorded[transfer.TxHash]; exists {
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, &database.Discrepancy{
+			Type:    DiscrepancyUnknownWithdrawal,
+			Token:   transfer.Token,
+			Chain:   transfer.Chain,
+			Network: transfer.Network,
+			TxHash:  transfer.TxHash,
+			Amount:  transfer.Amount,
+			Detail:  fmt.Sprintf("the transfer to %s is neither a payout nor a refund", transfer.To),
+		})
+	}
+	return nil
+}
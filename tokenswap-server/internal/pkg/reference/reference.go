@@ -0,0 +1,204 @@
+package reference
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xelis-project/xelis-go-sdk/wallet"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+const (
+	xelisReferenceKey = "reference"
+
+	// ERC-20 deposits get a suffix of up to amountSuffixMax units of
+	// 10^-amountSuffixDecimals added to their amount.
+	amountSuffixDecimals = 6
+	amountSuffixMax      = 999
+	amountSuffixAttempts = 20
+)
+
+// Target is where and how much one side of an order has to deposit.
+type Target struct {
+	Token         string         `json:"token"`
+	Chain         string         `json:"chain"`
+	WalletAddress string         `json:"wallet_address"`
+	Amount        decimal.Amount `json:"amount"`
+	Reference     string         `json:"-"`
+}
+
+// Issuer gives every order leg its own deposit reference, so deposits can be
+// matched without relying on the sender address.
+type Issuer struct {
+	store        *database.Store
+	xelisWallets map[string]*wallet.RPC
+}
+
+// NewIssuer returns an issuer using the Xelis wallets keyed by network. Without
+// a Xelis wallet on the order network, XEL legs are given the shared service
+// wallet address and are matched by sender address.
+func NewIssuer(store *database.Store, xelisWallets map[string]*wallet.RPC) *Issuer {
+	return &Issuer{
+		store:        store,
+		xelisWallets: xelisWallets,
+	}
+}
+
+// Issue returns the deposit target of the given side of the order, whose fee
+// is charged at feeRate.
+func (i *Issuer) Issue(ctx context.Context, orderCommonInfo *database.OrderCommonInfo, orderID, role string, order *database.Order, feeRate decimal.Amount) (*Target, error) {
+	symbol, err := fee.DepositToken(order, role)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := fee.DepositAmount(order, role, feeRate, tokens)
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokens.OrderToken(symbol, order.Chain, order.Network)
+	if err != nil {
+		return nil, err
+	}
+	target := &Target{
+		Token:         token.Symbol,
+		Chain:         token.Chain,
+		WalletAddress: token.WalletAddress,
+		Amount:        amount,
+	}
+	switch token.Standard {
+	case registry.StandardXelis:
+		xelisWallet, ok := i.xelisWallets[token.Network]
+		if !ok || xelisWallet == nil {
+			log.Warnf("no %s Xelis wallet to issue an integrated address for order %s", token.Network, orderID)
+			break
+		}
+		target.Reference = XelisReference(orderID, role)
+		var integratedData interface{} = map[string]interface{}{xelisReferenceKey: target.Reference}
+		target.WalletAddress, err = xelisWallet.GetAddress(wallet.GetAddressParams{IntegratedData: &integratedData})
+		if err != nil {
+			return nil, err
+		}
+	case registry.StandardERC20:
+		if token.Decimals < amountSuffixDecimals {
+			return nil, fmt.Errorf("token %s has less than %d decimals for a unique deposit amount", token.Symbol, amountSuffixDecimals)
+		}
+		expireTime := time.Now().Add(time.Duration(orderCommonInfo.DepositTimeout) * time.Minute)
+		target.Amount, err = i.uniqueAmount(ctx, token, orderID, role, amount, expireTime)
+		if err != nil {
+			return nil, err
+		}
+		target.Reference = AmountReference(token.Symbol, target.Amount)
+	default:
+		return nil, fmt.Errorf("token %s has an unsupported standard: %s", token.Symbol, token.Standard)
+	}
+	if len(target.WalletAddress) == 0 {
+		return nil, fmt.Errorf("token %s on %s %s has no deposit wallet", token.Symbol, token.Chain, token.Network)
+	}
+	return target, nil
+}
+
+// uniqueAmount adds a random suffix to amount and reserves it for the leg, so
+// that no other leg waiting for a deposit of the token expects the same amount.
+// The suffix is paid on top of the deposit amount and kept as fee. Amounts
+// another leg holds are retried with another suffix.
+func (i *Issuer) uniqueAmount(ctx context.Context, token *database.Token, orderID, role string, amount decimal.Amount, expireTime time.Time) (decimal.Amount, error) {
+	for attempt := 0; attempt < amountSuffixAttempts; attempt++ {
+		suffix, err := rand.Int(rand.Reader, big.NewInt(amountSuffixMax))
+		if err != nil {
+			return decimal.Zero(), err
+		}
+		uniqueAmount := amount.Truncate(amountSuffixDecimals).Add(decimal.FromUnits(suffix.Add(suffix, big.NewInt(1)), amountSuffixDecimals))
+		reserved, err := i.reserve(ctx, &database.AmountReservation{
+			Token:            token.Symbol,
+			Network:          token.Network,
+			Amount:           uniqueAmount,
+			OrderID:          orderID,
+			Role:             role,
+			ExpireTime:       expireTime,
+			CreationDateTime: time.Now().Format(database.TimeFormat),
+		})
+		if err != nil {
+			return decimal.Zero(), err
+		}
+		if reserved {
+			return uniqueAmount, nil
+		}
+	}
+	return decimal.Zero(), fmt.Errorf("no unique %s deposit amount is available for %s", token.Symbol, amount)
+}
+
+// reserve reserves the amount of the reservation, taking it over from a leg
+// which no longer waits for its deposit. It reports false when another leg
+// holds the amount.
+func (i *Issuer) reserve(ctx context.Context, reservation *database.AmountReservation) (bool, error) {
+	err := i.store.AmountReservations.Insert(ctx, reservation)
+	if err != database.ErrDuplicateAmount {
+		return err == nil, err
+	}
+	held, err := i.store.AmountReservations.FindOne(ctx, reservation.Token, reservation.Network, reservation.Amount)
+	if err != nil {
+		if err == database.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+	inUse, err := i.reservationInUse(ctx, held)
+	if err != nil || inUse {
+		return false, err
+	}
+	err = i.store.AmountReservations.Replace(ctx, held, reservation)
+	if err == database.ErrNonUpdated {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// reservationInUse reports whether the order of a reserved amount still waits
+// for its deposit. The order is stored after its amount is reserved, so one not
+// found yet holds the amount until the reservation expires.
+func (i *Issuer) reservationInUse(ctx context.Context, reservation *database.AmountReservation) (bool, error) {
+	orderData, err := i.store.Orders.FindOne(ctx, &database.OrderFilter{ID: reservation.OrderID})
+	if err != nil {
+		if err == database.ErrNoDocuments {
+			return time.Now().Before(reservation.ExpireTime), nil
+		}
+		return false, err
+	}
+	return orderData.Status == database.OrderStatusType1 || orderData.Status == database.OrderStatusType3, nil
+}
+
+// XelisReference is the reference carried by the integrated address of an order leg.
+func XelisReference(orderID, role string) string {
+	return fmt.Sprintf("%s:%s", orderID, role)
+}
+
+// AmountReference is the reference of an ERC-20 leg, made of its unique amount.
+func AmountReference(token string, amount decimal.Amount) string {
+	return fmt.Sprintf("%s:%s", token, amount.StringFixed(amountSuffixDecimals))
+}
+
+// FromExtraData returns the reference carried in the extra data of a Xelis
+// transfer sent to an integrated address, or an empty string.
+func FromExtraData(extraData interface{}) string {
+	switch data := extraData.(type) {
+	case string:
+		return data
+	case map[string]interface{}:
+		if reference, ok := data[xelisReferenceKey].(string); ok {
+			return reference
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package reference
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+func TestReserve(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	issuer := NewIssuer(store, nil)
+	err := store.Orders.Insert(ctx, &database.OrderData{ID: "waiting", Order: &database.Order{Status: database.OrderStatusType1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservation := func(orderID string, expireTime time.Time) *database.AmountReservation {
+		return &database.AmountReservation{Token: "USDT", Network: "testnet", Amount: decimal.MustParse("20.000123"), OrderID: orderID,
+			Role: database.ParticipantRoleMaker, ExpireTime: expireTime}
+	}
+	reserve := func(orderID string, expireTime time.Time) bool {
+		t.Helper()
+		reserved, err := issuer.reserve(ctx, reservation(orderID, expireTime))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reserved
+	}
+	later := time.Now().Add(time.Hour)
+
+	if !reserve("waiting", later) {
+		t.Fatal("a free amount is not reserved")
+	}
+	if reserve("other", later) {
+		t.Fatal("an amount held by an order waiting for the deposit is taken over")
+	}
+	err = store.Orders.UpdateStatus(ctx, &database.OrderFilter{ID: "waiting"}, database.OrderStatusType2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order of the new holder is not stored yet
+	if !reserve("unstored", time.Now().Add(-time.Minute)) {
+		t.Fatal("an amount of an order which got its deposit is not taken over")
+	}
+	if !reserve("other", later) {
+		t.Fatal("an expired amount of an order not stored is not taken over")
+	}
+	if reserve("another", later) {
+		t.Fatal("an amount of an order not stored yet is taken over")
+	}
+}
@@ -0,0 +1,238 @@
+package refund
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/settlement"
+)
+
+const (
+	refundCheckTermSeconds = 10
+)
+
+// Engine sends deposits in the refund_pending state back to the participant
+// wallet they belong to.
+type Engine struct {
+	store   *database.Store
+	senders map[string]settlement.WalletSender
+}
+
+// NewEngine returns an engine using the given senders keyed by settlement.SenderKey.
+func NewEngine(store *database.Store, senders map[string]settlement.WalletSender) *Engine {
+	return &Engine{
+		store:   store,
+		senders: senders,
+	}
+}
+
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(refundCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.RefundPendingDeposits(ctx)
+		case <-ctx.Done():
+			log.Printf("Stopping deposit refunds.")
+			return
+		}
+	}
+}
+
+func (e *Engine) RefundPendingDeposits(ctx context.Context) {
+	deposits, err := e.store.Deposits.Find(ctx, &database.DepositFilter{Status: database.DepositStatusRefundPending})
+	if err != nil {
+		log.Errorf("error while finding deposits to refund: %s", err.Error())
+		return
+	}
+	for _, deposit := range deposits {
+		err := e.RefundDeposit(ctx, deposit)
+		if err != nil {
+			if err == database.ErrNonUpdated {
+				log.Infof("deposit %s is already being refunded", deposit.ID)
+				continue
+			}
+			log.Errorf("error while refunding deposit %s: %s", deposit.ID, err.Error())
+		}
+	}
+}
+
+// RefundDeposit sends a deposit back and records the refund with the refunded
+// or refund_failed status. Failed refunds are not retried automatically to
+// avoid sending the same deposit back twice.
+func (e *Engine) RefundDeposit(ctx context.Context, deposit *database.Deposit) error {
+	wallet, err := e.participantWallet(ctx, deposit)
+	if err != nil {
+		return err
+	}
+	// Claim the deposit so that only one refund runs for it
+	err = e.store.Deposits.UpdateStatus(ctx, deposit.ID, database.DepositStatusRefundPending, database.DepositStatusRefunding, deposit.OrderID, deposit.Role)
+	if err != nil {
+		return err
+	}
+	refund := &database.Refund{WalletAddress: wallet.OrdererParticipantWalletAddress, Amount: ledger.RefundAmount(deposit)}
+	status := database.DepositStatusRefunded
+	txHash, err := e.send(ctx, deposit, refund.WalletAddress, refund.Amount)
+	if err != nil {
+		log.Errorf("error while sending the refund of deposit %s: %s", deposit.ID, err.Error())
+		refund.Error = err.Error()
+		status = database.DepositStatusRefundFailed
+	} else {
+		refund.TxHash = txHash
+		refund.RefundDateTime = time.Now().Format(database.TimeFormat)
+		log.Infof("deposit %s refund %s %s sent to %s: %s", deposit.ID, refund.Amount, deposit.Token, refund.WalletAddress, txHash)
+	}
+	return e.store.WithTransaction(ctx, func(ctx context.Context) error {
+		err := e.store.Deposits.UpdateRefund(ctx, deposit.ID, database.DepositStatusRefunding, refund, status)
+		if err != nil || status != database.DepositStatusRefunded {
+			return err
+		}
+		return ledger.RecordRefund(ctx, e.store.Journal, deposit)
+	})
+}
+
+// participantWallet returns the wallet of the order side the deposit belongs
+// to. When a side has several wallets, as after a take timed out and the order
+// was taken again, the one with the deposit reference or sender address wins.
+func (e *Engine) participantWallet(ctx context.Context, deposit *database.Deposit) (*database.OrdererParticipantWallet, error) {
+	if len(deposit.OrderID) == 0 {
+		return nil, fmt.Errorf("deposit %s does not belong to an order", deposit.ID)
+	}
+	orderWallets, err := e.store.ParticipantWallets.FindByOrderID(ctx, deposit.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	var roleWallet *database.OrdererParticipantWallet
+	for _, orderWallet := range orderWallets {
+		if orderWallet.Role != deposit.Role {
+			continue
+		}
+		if len(deposit.Reference) > 0 && orderWallet.DepositReference == deposit.Reference {
+			return orderWallet, nil
+		}
+		if orderWallet.OrdererParticipantWalletAddress == deposit.From {
+			return orderWallet, nil
+		}
+		roleWallet = orderWallet
+	}
+	if roleWallet == nil {
+		return nil, fmt.Errorf("order %s has no %s wallet to refund deposit %s", deposit.OrderID, deposit.Role, deposit.ID)
+	}
+	return roleWallet, nil
+}
+
+func (e *Engine) send(ctx context.Context, deposit *database.Deposit, toAddress string, amount decimal.Amount) (string, error) {
+	sender, ok := e.senders[settlement.SenderKey(deposit.Chain, deposit.Network)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s %s", settlement.ErrNoWalletSender, deposit.Chain, deposit.Network)
+	}
+	return sender.Send(ctx, deposit.Token, toAddress, amount)
+}
+
+// QueueOrderRefunds moves the deposits of an order that were credited or
+// attributed to it to refund_pending and records them as owed back. An empty
+// role selects both sides. It is meant to run in a transaction.
+//
+// When the order was partially filled, the maker deposit is only refunded for
+// what is left of the order. The rest stays in the escrow of the order, which
+// the fills are paid out of, and the maker fee of the filled part is kept.
+func QueueOrderRefunds(ctx context.Context, store *database.Store, orderID, role string) error {
+	for _, status := range []string{database.DepositStatusMatched, database.DepositStatusUnmatched} {
+		deposits, err := store.Deposits.Find(ctx, &database.DepositFilter{OrderID: orderID, Role: role, Status: status})
+		if err != nil {
+			return err
+		}
+		for _, deposit := range deposits {
+			if status == database.DepositStatusMatched && deposit.Role == database.ParticipantRoleMaker {
+				queued, err := queuePartialRefund(ctx, store, deposit)
+				if err != nil {
+					return err
+				}
+				if queued {
+					continue
+				}
+			}
+			err := store.Deposits.UpdateStatus(ctx, deposit.ID, status, database.DepositStatusRefundPending, deposit.OrderID, deposit.Role)
+			if err == database.ErrNonUpdated {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			err = ledger.RecordRefundQueued(ctx, store.Journal, deposit, status)
+			if err != nil {
+				return err
+			}
+			log.Infof("deposit %s of order %s is queued for a refund", deposit.ID, orderID)
+		}
+	}
+	return nil
+}
+
+// queuePartialRefund queues the refund of the part of a matched maker deposit
+// the fills of its order did not use. It reports false when the order has no
+// filled part, so the deposit is refunded in full.
+func queuePartialRefund(ctx context.Context, store *database.Store, deposit *database.Deposit) (bool, error) {
+	orderData, err := store.Orders.FindOne(ctx, &database.OrderFilter{ID: deposit.OrderID})
+	if err != nil {
+		return false, err
+	}
+	if orderData.Order == nil {
+		return false, fmt.Errorf("order %s has no order data", orderData.ID)
+	}
+	if orderData.FilledAmount.Sign() <= 0 || len(orderData.ParentID) > 0 {
+		return false, nil
+	}
+	orderCommonInfo, err := store.CommonInfo.Get(ctx)
+	if err != nil {
+		return false, err
+	}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		return false, err
+	}
+	quote, err := fee.Compute(orderData.Order, orderData.FeeRate, tokens)
+	if err != nil {
+		return false, err
+	}
+	filledOrder := *orderData.Order
+	filledOrder.Amount = orderData.FilledAmount
+	filledQuote, err := fee.Compute(&filledOrder, orderData.FeeRate, tokens)
+	if err != nil {
+		return false, err
+	}
+	// What was sent on top of the deposit amount was kept as fee when the
+	// deposit was matched
+	escrowAmount := quote.Maker.DepositAmount
+	if deposit.Amount.Cmp(escrowAmount) < 0 {
+		escrowAmount = deposit.Amount
+	}
+	refundAmount := escrowAmount.Sub(filledQuote.Maker.DepositAmount)
+	if refundAmount.Sign() > 0 {
+		refund := &database.Refund{Amount: refundAmount}
+		err = store.Deposits.UpdateRefund(ctx, deposit.ID, database.DepositStatusMatched, refund, database.DepositStatusRefundPending)
+		if err == database.ErrNonUpdated {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		deposit.Refund = refund
+		err = ledger.RecordRefundQueued(ctx, store.Journal, deposit, database.DepositStatusMatched)
+		if err != nil {
+			return false, err
+		}
+		log.Infof("deposit %s of order %s is queued for a refund of %s %s left unfilled", deposit.ID, orderData.ID, refundAmount, deposit.Token)
+	}
+	makerFee := fee.AddRevenue(nil, filledQuote.Maker.DepositToken, filledQuote.Maker.Fee)
+	return true, ledger.RecordSettlement(ctx, store.Journal, orderData, nil, makerFee, tokens)
+}
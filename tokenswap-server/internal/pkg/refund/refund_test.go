@@ -0,0 +1,132 @@
+package refund
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/settlement"
+)
+
+// testSender records what it is asked to send.
+type testSender struct {
+	sent []decimal.Amount
+}
+
+func (s *testSender) Send(ctx context.Context, token, toAddress string, amount decimal.Amount) (string, error) {
+	s.sent = append(s.sent, amount)
+	return "refund-tx", nil
+}
+
+func TestQueueOrderRefundsPartiallyFilled(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	testTokens := []*database.Token{
+		{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: "^xel:"},
+		{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, AddressPattern: "^0x"},
+	}
+	err := store.CommonInfo.Set(ctx, &database.OrderCommonInfo{Fee: decimal.NewFromInt(1), Tokens: testTokens, DepositTimeout: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := registry.New(testTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 of the 10 XEL sold are filled, the maker deposited 10.1 XEL with its fee
+	orderData := &database.OrderData{
+		ID: "order",
+		Order: &database.Order{
+			Type:         "sell",
+			Pair:         "XEL/USDT",
+			Amount:       decimal.MustParse("10"),
+			Price:        decimal.MustParse("2"),
+			Chain:        "ethereum",
+			Network:      "testnet",
+			FeePayerType: "seller",
+			Status:       database.OrderStatusType10,
+		},
+		FilledAmount:    decimal.MustParse("4"),
+		RemainingAmount: decimal.MustParse("6"),
+		FeeRate:         decimal.NewFromInt(1),
+		UserUUID:        "maker",
+	}
+	err = store.Orders.Insert(ctx, orderData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ParticipantWallets.Insert(ctx, &database.OrdererParticipantWallet{OrderID: "order", Role: database.ParticipantRoleMaker, OrdererParticipantWalletAddress: "xel:maker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposit := &database.Deposit{ID: "tx:0", Token: "XEL", Chain: "xelis", Network: "testnet", From: "xel:maker", Amount: decimal.MustParse("10.1"),
+		OrderID: "order", Role: database.ParticipantRoleMaker, Status: database.DepositStatusMatched}
+	err = store.Deposits.Insert(ctx, deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ledger.RecordDeposit(ctx, store.Journal, deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ledger.RecordDepositMatch(ctx, store.Journal, deposit, orderData, database.ParticipantRoleMaker, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := database.TransitionOrder(ctx, store.Orders, &database.OrderFilter{ID: "order"}, database.OrderStatusType5)
+		if err != nil {
+			return err
+		}
+		return QueueOrderRefunds(ctx, store, "order", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := store.Deposits.FindOne(ctx, "tx:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What is left of the order, 6 XEL and its 0.06 XEL fee
+	if queued.Status != database.DepositStatusRefundPending || ledger.RefundAmount(queued).String() != "6.06" {
+		t.Fatalf("deposit %s to refund %s, want refund_pending to refund 6.06", queued.Status, ledger.RefundAmount(queued))
+	}
+
+	sender := &testSender{}
+	engine := NewEngine(store, map[string]settlement.WalletSender{settlement.SenderKey("xelis", "testnet"): sender})
+	err = engine.RefundDeposit(ctx, queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].String() != "6.06" {
+		t.Fatalf("sent %v, want 6.06", sender.sent)
+	}
+	refunded, err := store.Deposits.FindOne(ctx, "tx:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Status != database.DepositStatusRefunded || refunded.Refund.Amount.String() != "6.06" {
+		t.Fatalf("deposit %s with refund %+v, want refunded 6.06", refunded.Status, refunded.Refund)
+	}
+
+	// The escrow keeps what the fills pay out, their maker fee is revenue
+	entries, err := store.Journal.Find(ctx, &database.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		ledger.EscrowAccount("order"): "4",
+		ledger.AccountFeeRevenue:      "0.04",
+		ledger.AccountRefundsPayable:  "0",
+		ledger.AccountServiceWallet:   "4.04",
+	}
+	for _, balance := range ledger.Balances(entries) {
+		if amount, ok := want[balance.Account]; ok && balance.Amount.String() != amount {
+			t.Errorf("%s balance = %s, want %s", balance.Account, balance.Amount, amount)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+const (
+	// StandardXelis is the native asset of a Xelis wallet.
+	StandardXelis = "xelis"
+	// StandardERC20 is an ERC-20 token contract on an EVM chain.
+	StandardERC20 = "erc20"
+)
+
+var (
+	ErrUnknownToken = errors.New("unknown token")
+
+	maximumFeeRate = decimal.NewFromInt(100)
+)
+
+// Registry describes the tokens the service trades, as configured in the
+// tokens of OrderCommonInfo. A symbol can be listed once per chain and network.
+type Registry struct {
+	tokens          []*database.Token
+	addressPatterns map[*database.Token]*regexp.Regexp
+}
+
+// New returns a registry of the given tokens. It fails when a token has no
+// symbol, chain, network or address pattern, or a pattern which does not compile.
+func New(tokens []*database.Token) (*Registry, error) {
+	r := &Registry{
+		tokens:          tokens,
+		addressPatterns: map[*database.Token]*regexp.Regexp{},
+	}
+	for _, token := range tokens {
+		if len(token.Symbol) == 0 || len(token.Chain) == 0 || len(token.Network) == 0 || len(token.AddressPattern) == 0 {
+			return nil, fmt.Errorf("token %s:%s:%s is missing its symbol, chain, network or address pattern", token.Symbol, token.Chain, token.Network)
+		}
+		addressPattern, err := regexp.Compile(token.AddressPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address pattern of token %s on %s %s: %w", token.Symbol, token.Chain, token.Network, err)
+		}
+		r.addressPatterns[token] = addressPattern
+	}
+	return r, nil
+}
+
+// Tokens returns every registered token.
+func (r *Registry) Tokens() []*database.Token {
+	return r.tokens
+}
+
+// Find returns the token with the given symbol on a chain and network.
+func (r *Registry) Find(symbol, chain, network string) (*database.Token, error) {
+	for _, token := range r.tokens {
+		if token.Symbol == symbol && token.Chain == chain && token.Network == network {
+			return token, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s on %s %s", ErrUnknownToken, symbol, chain, network)
+}
+
+// OrderToken returns a token of an order pair. The chain of an order is the
+// one its multi-chain tokens are transferred on, so a token listed on a single
+// chain of the network, like XEL, is returned whatever the order chain is.
+func (r *Registry) OrderToken(symbol, orderChain, network string) (*database.Token, error) {
+	candidates := []*database.Token{}
+	for _, token := range r.tokens {
+		if token.Symbol != symbol || token.Network != network {
+			continue
+		}
+		if token.Chain == orderChain {
+			return token, nil
+		}
+		candidates = append(candidates, token)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return nil, fmt.Errorf("%w: %s on %s %s", ErrUnknownToken, symbol, orderChain, network)
+}
+
+// ValidateAddress reports whether address is a valid wallet address for the token.
+func (r *Registry) ValidateAddress(token *database.Token, address string) bool {
+	addressPattern, ok := r.addressPatterns[token]
+	if !ok {
+		return false
+	}
+	return addressPattern.MatchString(address)
+}
+
+// ValidateOrderCommonInfo checks the order common info before it is stored and
+// returns the registry of its tokens. The fee is a percentage below 100, every
+// pair is written as BASE/QUOTE with registered tokens, every chain has a
+// registered token and the wallet addresses set match their token.
+func ValidateOrderCommonInfo(info *database.OrderCommonInfo) (*Registry, error) {
+	if info.Fee.Sign() < 0 || info.Fee.Cmp(maximumFeeRate) >= 0 {
+		return nil, fmt.Errorf("the fee must be a percentage from 0 to less than %s", maximumFeeRate)
+	}
+	if info.DepositTimeout <= 0 {
+		return nil, fmt.Errorf("the deposit_timeout must be a positive number of minutes")
+	}
+	r, err := New(info.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	listedTokens := map[string]struct{}{}
+	for _, token := range r.tokens {
+		tokenKey := fmt.Sprintf("%s:%s:%s", token.Symbol, token.Chain, token.Network)
+		if _, listed := listedTokens[tokenKey]; listed {
+			return nil, fmt.Errorf("token %s is listed more than once on %s %s", token.Symbol, token.Chain, token.Network)
+		}
+		listedTokens[tokenKey] = struct{}{}
+		switch token.Standard {
+		case StandardXelis:
+		case StandardERC20:
+			if len(token.Contract) == 0 {
+				return nil, fmt.Errorf("the ERC-20 token %s on %s %s has no contract", token.Symbol, token.Chain, token.Network)
+			}
+		default:
+			return nil, fmt.Errorf("token %s on %s %s has an unknown standard: %s", token.Symbol, token.Chain, token.Network, token.Standard)
+		}
+		if len(token.WalletAddress) > 0 && !r.ValidateAddress(token, token.WalletAddress) {
+			return nil, fmt.Errorf("the wallet address of %s on %s %s is invalid", token.Symbol, token.Chain, token.Network)
+		}
+	}
+	if len(info.Chains) == 0 {
+		return nil, fmt.Errorf("the chains cannot be empty")
+	}
+	for _, chain := range info.Chains {
+		if !r.hasToken(func(token *database.Token) bool { return token.Chain == chain }) {
+			return nil, fmt.Errorf("the chain %s has no registered token", chain)
+		}
+	}
+	if len(info.Pairs) == 0 {
+		return nil, fmt.Errorf("the pairs cannot be empty")
+	}
+	for _, pair := range info.Pairs {
+		symbols := strings.Split(pair, "/")
+		if len(symbols) != 2 || symbols[0] == symbols[1] {
+			return nil, fmt.Errorf("the pair %s is not written as BASE/QUOTE", pair)
+		}
+		for _, symbol := range symbols {
+			if !r.hasToken(func(token *database.Token) bool { return token.Symbol == symbol }) {
+				return nil, fmt.Errorf("the token %s of the pair %s is not registered", symbol, pair)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (r *Registry) hasToken(match func(token *database.Token) bool) bool {
+	for _, token := range r.tokens {
+		if match(token) {
+			return true
+		}
+	}
+	return false
+}
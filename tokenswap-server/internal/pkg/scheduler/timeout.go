@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/refund"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/webhook"
+)
+
+const (
+	timeoutCheckTermSeconds = 10
+)
+
+// TimeoutScheduler expires orders whose deposit deadline has passed. The
+// deadlines are stored on the orders, so pending timeouts survive a restart.
+// Every status change is conditional on the status the order was read in, so
+// several instances can sweep at the same time. The orders cancelled are
+// published on the bus and queued for the webhooks.
+type TimeoutScheduler struct {
+	store *database.Store
+	bus   *events.Bus
+}
+
+func NewTimeoutScheduler(store *database.Store, bus *events.Bus) *TimeoutScheduler {
+	return &TimeoutScheduler{
+		store: store,
+		bus:   bus,
+	}
+}
+
+// Run sweeps expired orders right away and then periodically until ctx is done.
+func (s *TimeoutScheduler) Run(ctx context.Context) {
+	s.SweepExpiredOrders(ctx)
+	ticker := time.NewTicker(timeoutCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.SweepExpiredOrders(ctx)
+		case <-ctx.Done():
+			log.Printf("Stopping order timeout checking.")
+			return
+		}
+	}
+}
+
+func (s *TimeoutScheduler) SweepExpiredOrders(ctx context.Context) {
+	orders, err := s.store.Orders.FindExpired(ctx, time.Now())
+	if err != nil {
+		log.Errorf("error while finding expired orders: %s", err.Error())
+		return
+	}
+	for _, order := range orders {
+		err := s.ExpireOrder(ctx, order)
+		if err != nil {
+			if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
+				log.Infof("order %s has already left %s", order.ID, order.Status)
+				continue
+			}
+			log.Errorf("error while expiring order %s: %s", order.ID, err.Error())
+		}
+	}
+}
+
+// ExpireOrder cancels an order whose maker did not deposit in time. When the
+// taker did not deposit in time the order is made active again so it can be
+// taken by someone else. A fill whose taker did not deposit in time is
+// cancelled and gives its amount back to the order.
+func (s *TimeoutScheduler) ExpireOrder(ctx context.Context, order *database.OrderData) error {
+	status := database.OrderStatusType4
+	if order.Status == database.OrderStatusType3 && len(order.ParentID) == 0 {
+		status = database.OrderStatusType2
+	}
+	var expiredEvent *events.Event
+	err := s.store.WithTransaction(ctx, func(ctx context.Context) error {
+		expiredEvent = nil
+		expiredData, err := database.TransitionOrder(ctx, s.store.Orders, &database.OrderFilter{ID: order.ID, Status: order.Status}, status)
+		if err != nil {
+			return err
+		}
+		err = s.store.Orders.SetDepositDeadline(ctx, order.ID, nil)
+		if err != nil {
+			return err
+		}
+		parentUsers := []string{}
+		if len(order.ParentID) > 0 && order.Status == database.OrderStatusType3 {
+			parentData, err := database.FillOrder(ctx, s.store.Orders, &database.OrderFilter{ID: order.ParentID}, decimal.Zero(), order.Amount)
+			if err != nil {
+				return err
+			}
+			parentUsers = append(parentUsers, parentData.UserUUID)
+		}
+		if status == database.OrderStatusType4 {
+			expiredEvent = events.NewOrderEvent(events.OrderCancelled, expiredData, parentUsers...)
+		}
+		// Send back what arrived for the failed deposit, only the taker's
+		// when the order is made active again
+		refundRole := ""
+		if status == database.OrderStatusType2 {
+			refundRole = database.ParticipantRoleTaker
+		}
+		err = refund.QueueOrderRefunds(ctx, s.store, order.ID, refundRole)
+		if err != nil {
+			return err
+		}
+		if expiredEvent != nil {
+			err = webhook.QueueEvents(ctx, s.store, expiredEvent)
+			if err != nil {
+				return err
+			}
+		}
+		log.Infof("Order %s has timed out in %s and is now %s.", order.ID, order.Status, status)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if expiredEvent != nil {
+		s.bus.Publish(expiredEvent)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package settlement
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+const (
+	erc20TransferABI = `[{"constant":false,"inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"type":"function"}]`
+)
+
+// ERC20Token is a token contract the ERC20Sender can pay out.
+type ERC20Token struct {
+	Contract string
+	Decimals int
+}
+
+// ERC20Sender pays out ERC-20 tokens from the service wallet of an EVM chain.
+type ERC20Sender struct {
+	client     *ethclient.Client
+	privateKey *ecdsa.PrivateKey
+	chainID    *big.Int
+	contract   abi.ABI
+	tokens     map[string]ERC20Token
+}
+
+func NewERC20Sender(ctx context.Context, rpcURL, privateKeyHex string, tokens map[string]ERC20Token) (*ERC20Sender, error) {
+	client, err := ethclient.Dial(rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	contract, err := abi.JSON(strings.NewReader(erc20TransferABI))
+	if err != nil {
+		return nil, err
+	}
+	return &ERC20Sender{
+		client:     client,
+		privateKey: privateKey,
+		chainID:    chainID,
+		contract:   contract,
+		tokens:     tokens,
+	}, nil
+}
+
+func (s *ERC20Sender) Send(ctx context.Context, token, toAddress string, amount decimal.Amount) (string, error) {
+	tokenContract, ok := s.tokens[token]
+	if !ok {
+		return "", fmt.Errorf("token %s is not supported", token)
+	}
+	units, err := amount.Units(tokenContract.Decimals)
+	if err != nil {
+		return "", err
+	}
+	opts, err := bind.NewKeyedTransactorWithChainID(s.privateKey, s.chainID)
+	if err != nil {
+		return "", err
+	}
+	opts.Context = ctx
+	contract := bind.NewBoundContract(common.HexToAddress(tokenContract.Contract), s.contract, s.client, s.client, s.client)
+	tx, err := contract.Transact(opts, "transfer", common.HexToAddress(toAddress), units)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
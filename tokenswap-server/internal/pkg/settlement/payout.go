@@ -0,0 +1,121 @@
+package settlement
+
+import (
+	"fmt"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+// ComputePayouts works out what each side of a completed order receives. The
+// fees were deposited on top of the traded amounts, so each side receives what
+// the other traded, rounded down to the decimals of its token. The fee revenue
+// is the deposited fees and the remainders of the rounding. The maker fee of a
+// fill is left out, it is kept once its order is settled.
+func ComputePayouts(order *database.OrderData, wallets []*database.OrdererParticipantWallet, tokens *registry.Registry) ([]*database.Payout, []*database.FeeRevenue, error) {
+	if order.Order == nil {
+		return nil, nil, fmt.Errorf("order %s has no order data", order.ID)
+	}
+	quote, err := fee.Compute(order.Order, order.FeeRate, tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	payoutWallets := map[string]string{}
+	for _, wallet := range wallets {
+		payoutWallets[wallet.Role] = wallet.PayoutWalletAddress
+	}
+	feeRevenue := quote.Revenue()
+	if len(order.ParentID) > 0 {
+		feeRevenue = fee.AddRevenue(nil, quote.Taker.DepositToken, quote.Taker.Fee)
+	}
+	payouts := []*database.Payout{}
+	for _, orderLeg := range []*fee.Leg{quote.Maker, quote.Taker} {
+		walletAddress := payoutWallets[orderLeg.Role]
+		if len(walletAddress) == 0 {
+			return nil, nil, fmt.Errorf("order %s has no %s payout wallet address", order.ID, orderLeg.Role)
+		}
+		payout, err := newPayout(orderLeg.Role, orderLeg.PayoutToken, walletAddress, orderLeg.PayoutAmount, order, tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		payouts = append(payouts, payout)
+		feeRevenue = fee.AddRevenue(feeRevenue, payout.Token, payout.Fee)
+	}
+	return payouts, feeRevenue, nil
+}
+
+// ComputeTradePayouts works out what the maker of an order crossed by the
+// matching engine receives from its trades. The counterparty of a trade is
+// another order which pays its own maker, so only the maker is paid here. A
+// buyer whose trades ran below the order price gets the difference back, in the
+// token it deposited, to the wallet it deposited from. The fee deposited by the
+// maker is kept in full, except by a fill whose order keeps it once settled.
+func ComputeTradePayouts(order *database.OrderData, trades []*database.Trade, wallets []*database.OrdererParticipantWallet, tokens *registry.Registry) ([]*database.Payout, []*database.FeeRevenue, error) {
+	if order.Order == nil {
+		return nil, nil, fmt.Errorf("order %s has no order data", order.ID)
+	}
+	quote, err := fee.Compute(order.Order, order.FeeRate, tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	maker := quote.Maker
+	var makerWallet *database.OrdererParticipantWallet
+	for _, wallet := range wallets {
+		if wallet.Role == database.ParticipantRoleMaker {
+			makerWallet = wallet
+		}
+	}
+	if makerWallet == nil || len(makerWallet.PayoutWalletAddress) == 0 {
+		return nil, nil, fmt.Errorf("order %s has no %s payout wallet address", order.ID, database.ParticipantRoleMaker)
+	}
+	payoutAmount := decimal.Zero()
+	priceImprovement := decimal.Zero()
+	for _, trade := range trades {
+		if maker.Buyer {
+			payoutAmount = payoutAmount.Add(trade.Amount)
+			priceImprovement = priceImprovement.Add(trade.Amount.Mul(order.Price.Sub(trade.Price)))
+		} else {
+			payoutAmount = payoutAmount.Add(trade.Amount.Mul(trade.Price))
+		}
+	}
+	var feeRevenue []*database.FeeRevenue
+	if len(order.ParentID) == 0 {
+		feeRevenue = fee.AddRevenue(feeRevenue, maker.DepositToken, maker.Fee)
+	}
+	payout, err := newPayout(database.ParticipantRoleMaker, maker.PayoutToken, makerWallet.PayoutWalletAddress, payoutAmount, order, tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	payouts := []*database.Payout{payout}
+	feeRevenue = fee.AddRevenue(feeRevenue, payout.Token, payout.Fee)
+	if priceImprovement.Sign() > 0 {
+		payout, err := newPayout(database.ParticipantRoleMaker, maker.DepositToken, makerWallet.OrdererParticipantWalletAddress, priceImprovement, order, tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		payouts = append(payouts, payout)
+		feeRevenue = fee.AddRevenue(feeRevenue, payout.Token, payout.Fee)
+	}
+	return payouts, feeRevenue, nil
+}
+
+// newPayout returns a payout of amount rounded down to the decimals of the
+// token. The remainder is kept as fee.
+func newPayout(role, symbol, walletAddress string, amount decimal.Amount, order *database.OrderData, tokens *registry.Registry) (*database.Payout, error) {
+	payoutToken, err := tokens.OrderToken(symbol, order.Chain, order.Network)
+	if err != nil {
+		return nil, err
+	}
+	payoutAmount := amount.Truncate(payoutToken.Decimals)
+	return &database.Payout{
+		Role:          role,
+		Token:         symbol,
+		Chain:         payoutToken.Chain,
+		Network:       payoutToken.Network,
+		WalletAddress: walletAddress,
+		Amount:        payoutAmount,
+		Fee:           amount.Sub(payoutAmount),
+	}, nil
+}
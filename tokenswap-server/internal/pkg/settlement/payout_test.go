@@ -0,0 +1,135 @@
+package settlement
+
+import (
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+func testRegistry(t *testing.T) *registry.Registry {
+	t.Helper()
+	tokens, err := registry.New([]*database.Token{
+		{Symbol: "XEL", Chain: "xelis", Network: "testnet", Standard: registry.StandardXelis, Decimals: 8, AddressPattern: "^xel:"},
+		{Symbol: "USDT", Chain: "ethereum", Network: "testnet", Standard: registry.StandardERC20, Decimals: 6, AddressPattern: "^0x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// testPayout is a payout as role, token, wallet address, amount and fee.
+type testPayout [5]string
+
+func checkPayouts(t *testing.T, payouts []*database.Payout, feeRevenue []*database.FeeRevenue, wantPayouts []testPayout, wantRevenue map[string]string) {
+	t.Helper()
+	if len(payouts) != len(wantPayouts) {
+		t.Fatalf("%d payouts, want %d", len(payouts), len(wantPayouts))
+	}
+	for i, payout := range payouts {
+		got := testPayout{payout.Role, payout.Token, payout.WalletAddress, payout.Amount.String(), payout.Fee.String()}
+		if got != wantPayouts[i] {
+			t.Errorf("payout %d = %v, want %v", i, got, wantPayouts[i])
+		}
+	}
+	if len(feeRevenue) != len(wantRevenue) {
+		t.Errorf("fee revenue in %d tokens, want %d", len(feeRevenue), len(wantRevenue))
+	}
+	for _, tokenRevenue := range feeRevenue {
+		if want := wantRevenue[tokenRevenue.Token]; tokenRevenue.Amount.String() != want {
+			t.Errorf("%s fee revenue = %s, want %s", tokenRevenue.Token, tokenRevenue.Amount, want)
+		}
+	}
+}
+
+func TestComputePayouts(t *testing.T) {
+	tokens := testRegistry(t)
+	wallets := []*database.OrdererParticipantWallet{
+		{Role: database.ParticipantRoleMaker, OrdererParticipantWalletAddress: "xel:maker", PayoutWalletAddress: "0xmaker"},
+		{Role: database.ParticipantRoleTaker, OrdererParticipantWalletAddress: "0xtaker", PayoutWalletAddress: "xel:taker"},
+	}
+	// 1.23456789 XEL at 0.1234567 is 0.152415677625363 USDT, more decimals than USDT has
+	order := &database.Order{
+		Type:         "sell",
+		Pair:         "XEL/USDT",
+		Amount:       decimal.MustParse("1.23456789"),
+		Price:        decimal.MustParse("0.1234567"),
+		Chain:        "ethereum",
+		Network:      "testnet",
+		FeePayerType: "split",
+	}
+	for _, test := range []struct {
+		name     string
+		parentID string
+		revenue  map[string]string
+	}{
+		{
+			// The maker fee of 0.00617283945 XEL and the taker fee of 0.000762078... USDT
+			// are rounded up, the USDT paid to the maker is rounded down
+			name:    "order",
+			revenue: map[string]string{"XEL": "0.00617284", "USDT": "0.000763677625363"},
+		},
+		{
+			name:     "fill",
+			parentID: "order",
+			revenue:  map[string]string{"USDT": "0.000763677625363"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			orderData := &database.OrderData{ID: "order-fill", ParentID: test.parentID, Order: order, FeeRate: decimal.NewFromInt(1)}
+			payouts, feeRevenue, err := ComputePayouts(orderData, wallets, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPayouts(t, payouts, feeRevenue, []testPayout{
+				{database.ParticipantRoleMaker, "USDT", "0xmaker", "0.152415", "0.000000677625363"},
+				{database.ParticipantRoleTaker, "XEL", "xel:taker", "1.23456789", "0"},
+			}, test.revenue)
+		})
+	}
+}
+
+func TestComputeTradePayouts(t *testing.T) {
+	tokens := testRegistry(t)
+	wallets := []*database.OrdererParticipantWallet{
+		{Role: database.ParticipantRoleMaker, OrdererParticipantWalletAddress: "0xmaker", PayoutWalletAddress: "xel:maker"},
+	}
+	// The buyer deposited 20 USDT and a fee of 0.2 USDT
+	order := &database.Order{
+		Type:         "buy",
+		Pair:         "XEL/USDT",
+		Amount:       decimal.MustParse("10"),
+		Price:        decimal.MustParse("2"),
+		Chain:        "ethereum",
+		Network:      "testnet",
+		FeePayerType: "buyer",
+	}
+	trades := []*database.Trade{
+		{Amount: decimal.MustParse("4"), Price: decimal.MustParse("1.5")},
+		{Amount: decimal.MustParse("6"), Price: decimal.MustParse("1.9999999")},
+	}
+	// It paid 4 * 1.5 + 6 * 1.9999999 = 17.9999994 USDT, the 2.0000006 USDT left
+	// go back to the wallet it deposited from, rounded down
+	for _, test := range []struct {
+		name     string
+		parentID string
+		revenue  map[string]string
+	}{
+		{name: "order", revenue: map[string]string{"USDT": "0.2000006"}},
+		{name: "fill", parentID: "order", revenue: map[string]string{"USDT": "0.0000006"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			orderData := &database.OrderData{ID: "order-fill", ParentID: test.parentID, Order: order, FeeRate: decimal.NewFromInt(1)}
+			payouts, feeRevenue, err := ComputeTradePayouts(orderData, trades, wallets, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPayouts(t, payouts, feeRevenue, []testPayout{
+				{database.ParticipantRoleMaker, "XEL", "xel:maker", "10", "0"},
+				{database.ParticipantRoleMaker, "USDT", "0xmaker", "2", "0.0000006"},
+			}, test.revenue)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/fee"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/ledger"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+)
+
+var (
+	ErrNoWalletSender = errors.New("no wallet sender for the chain")
+)
+
+const (
+	settlementCheckTermSeconds = 10
+)
+
+// WalletSender sends funds out of a service wallet on one chain.
+type WalletSender interface {
+	Send(ctx context.Context, token, toAddress string, amount decimal.Amount) (string, error)
+}
+
+// SenderKey is the key of the wallet sender of a chain on a network. Mainnet
+// and testnet funds are sent from different service wallets.
+func SenderKey(chain, network string) string {
+	return fmt.Sprintf("%s:%s", chain, network)
+}
+
+// Engine pays out both sides of completed orders. An order taken in fills is
+// paid out fill by fill.
+type Engine struct {
+	store   *database.Store
+	senders map[string]WalletSender
+}
+
+// NewEngine returns an engine using the given senders keyed by SenderKey.
+func NewEngine(store *database.Store, senders map[string]WalletSender) *Engine {
+	return &Engine{
+		store:   store,
+		senders: senders,
+	}
+}
+
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(settlementCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.SettleCompletedOrders(ctx)
+		case <-ctx.Done():
+			log.Printf("Stopping order settlement.")
+			return
+		}
+	}
+}
+
+func (e *Engine) SettleCompletedOrders(ctx context.Context) {
+	orders, err := e.store.Orders.Find(ctx, &database.OrderFilter{Status: database.OrderStatusType6})
+	if err != nil {
+		log.Errorf("error while finding completed orders: %s", err.Error())
+		return
+	}
+	for _, order := range orders {
+		err := e.SettleOrder(ctx, order)
+		if err != nil {
+			if err == database.ErrNonUpdated || err == database.ErrNoDocuments {
+				log.Infof("order %s is already being settled", order.ID)
+				continue
+			}
+			log.Errorf("error while settling order %s: %s", order.ID, err.Error())
+			continue
+		}
+	}
+}
+
+// SettleOrder sends the payouts of a completed order and records them on the
+// order with the settled or settlement_failed status. Payouts which failed are
+// not retried automatically to avoid paying the same side twice.
+func (e *Engine) SettleOrder(ctx context.Context, order *database.OrderData) error {
+	orderCommonInfo, err := e.store.CommonInfo.Get(ctx)
+	if err != nil {
+		return err
+	}
+	tokens, err := registry.New(orderCommonInfo.Tokens)
+	if err != nil {
+		return err
+	}
+	wallets, err := e.store.ParticipantWallets.FindByOrderID(ctx, order.ID)
+	if err != nil {
+		return err
+	}
+	// A fill pays out to the maker of its order
+	if len(order.ParentID) > 0 {
+		parentWallets, err := e.store.ParticipantWallets.FindByOrderID(ctx, order.ParentID)
+		if err != nil {
+			return err
+		}
+		for _, wallet := range parentWallets {
+			if wallet.Role == database.ParticipantRoleMaker {
+				wallets = append(wallets, wallet)
+			}
+		}
+	}
+	// Orders crossed by the matching engine are paid from their trades
+	trades, err := e.store.Trades.Find(ctx, &database.TradeFilter{OrderID: order.ID})
+	if err != nil {
+		return err
+	}
+	if len(trades) == 0 {
+		fills, err := e.store.Orders.Find(ctx, &database.OrderFilter{ParentID: order.ID})
+		if err != nil {
+			return err
+		}
+		if len(fills) > 0 {
+			return e.settleFilledOrder(ctx, order, fills, tokens)
+		}
+	}
+	// Claim the order so that only one settlement runs for it
+	_, err = database.TransitionOrder(ctx, e.store.Orders, &database.OrderFilter{ID: order.ID, Status: database.OrderStatusType6}, database.OrderStatusType7)
+	if err != nil {
+		return err
+	}
+	var payouts []*database.Payout
+	var feeRevenue []*database.FeeRevenue
+	if len(trades) > 0 {
+		payouts, feeRevenue, err = ComputeTradePayouts(order, trades, wallets, tokens)
+	} else {
+		payouts, feeRevenue, err = ComputePayouts(order, wallets, tokens)
+	}
+	if err != nil {
+		log.Errorf("error while computing payouts of order %s: %s", order.ID, err.Error())
+		return e.store.Orders.UpdatePayouts(ctx, order.ID, database.OrderStatusType7, nil, nil, database.OrderStatusType9)
+	}
+	status := database.OrderStatusType8
+	for _, payout := range payouts {
+		txHash, err := e.send(ctx, payout)
+		if err != nil {
+			log.Errorf("error while paying out %s of order %s: %s", payout.Role, order.ID, err.Error())
+			payout.Error = err.Error()
+			status = database.OrderStatusType9
+			continue
+		}
+		payout.TxHash = txHash
+		payout.PayoutDateTime = time.Now().Format(database.TimeFormat)
+		log.Infof("order %s %s payout %s %s sent: %s", order.ID, payout.Role, payout.Amount, payout.Token, txHash)
+	}
+	return e.recordPayouts(ctx, order, payouts, feeRevenue, status, tokens)
+}
+
+// recordPayouts records the payouts and the fee revenue of a settling order,
+// together with their journal entry.
+func (e *Engine) recordPayouts(ctx context.Context, order *database.OrderData, payouts []*database.Payout, feeRevenue []*database.FeeRevenue, status string, tokens *registry.Registry) error {
+	return e.store.WithTransaction(ctx, func(ctx context.Context) error {
+		err := e.store.Orders.UpdatePayouts(ctx, order.ID, database.OrderStatusType7, payouts, feeRevenue, status)
+		if err != nil {
+			return err
+		}
+		return ledger.RecordSettlement(ctx, e.store.Journal, order, payouts, feeRevenue, tokens)
+	})
+}
+
+// settleFilledOrder settles an order which was taken in fills. The fills are
+// paid out on their own, so the order is settled once all of them are, and
+// fails when one of them failed. Its fee revenue is the one of its fills and
+// the maker fee, which is kept here as the maker deposited it for the whole
+// order.
+func (e *Engine) settleFilledOrder(ctx context.Context, order *database.OrderData, fills []*database.OrderData, tokens *registry.Registry) error {
+	if order.Order == nil {
+		return fmt.Errorf("order %s has no order data", order.ID)
+	}
+	quote, err := fee.Compute(order.Order, order.FeeRate, tokens)
+	if err != nil {
+		return err
+	}
+	status := database.OrderStatusType8
+	var feeRevenue []*database.FeeRevenue
+	for _, fill := range fills {
+		if fill.Order == nil {
+			continue
+		}
+		switch fill.Status {
+		case database.OrderStatusType6, database.OrderStatusType7:
+			// Checked again on the next run
+			return nil
+		case database.OrderStatusType9:
+			status = database.OrderStatusType9
+		}
+		for _, tokenRevenue := range fill.FeeRevenue {
+			feeRevenue = fee.AddRevenue(feeRevenue, tokenRevenue.Token, tokenRevenue.Amount)
+		}
+	}
+	makerFee := fee.AddRevenue(nil, quote.Maker.DepositToken, quote.Maker.Fee)
+	feeRevenue = fee.AddRevenue(feeRevenue, quote.Maker.DepositToken, quote.Maker.Fee)
+	_, err = database.TransitionOrder(ctx, e.store.Orders, &database.OrderFilter{ID: order.ID, Status: database.OrderStatusType6}, database.OrderStatusType7)
+	if err != nil {
+		return err
+	}
+	log.Infof("order %s is settled with its fills as %s", order.ID, status)
+	return e.store.WithTransaction(ctx, func(ctx context.Context) error {
+		err := e.store.Orders.UpdatePayouts(ctx, order.ID, database.OrderStatusType7, nil, feeRevenue, status)
+		if err != nil {
+			return err
+		}
+		// The fees of the fills were recorded when they were settled
+		return ledger.RecordSettlement(ctx, e.store.Journal, order, nil, makerFee, tokens)
+	})
+}
+
+func (e *Engine) send(ctx context.Context, payout *database.Payout) (string, error) {
+	sender, ok := e.senders[SenderKey(payout.Chain, payout.Network)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s %s", ErrNoWalletSender, payout.Chain, payout.Network)
+	}
+	return sender.Send(ctx, payout.Token, payout.WalletAddress, payout.Amount)
+}
@@ -0,0 +1,53 @@
+package settlement
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xelis-project/xelis-go-sdk/wallet"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+const (
+	XelisChain = "xelis"
+	XelisToken = "XEL"
+
+	xelisAsset    = "0000000000000000000000000000000000000000000000000000000000000000"
+	xelisDecimals = 8
+)
+
+// XelisSender pays out XEL from the service wallet through the Xelis wallet RPC.
+type XelisSender struct {
+	wallet *wallet.RPC
+}
+
+func NewXelisSender(ctx context.Context, endpoint, username, password string) (*XelisSender, error) {
+	xelisWallet, err := wallet.NewRPC(ctx, endpoint, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &XelisSender{wallet: xelisWallet}, nil
+}
+
+func (s *XelisSender) Send(ctx context.Context, token, toAddress string, amount decimal.Amount) (string, error) {
+	if token != XelisToken {
+		return "", fmt.Errorf("token %s is not supported on %s", token, XelisChain)
+	}
+	units, err := amount.Units(xelisDecimals)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.wallet.BuildTransaction(wallet.BuildTransactionParams{
+		Transfers: []wallet.TransferOut{{
+			Amount:      units.Uint64(),
+			Asset:       xelisAsset,
+			Destination: toAddress,
+		}},
+		Broadcast: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.Hash, nil
+}
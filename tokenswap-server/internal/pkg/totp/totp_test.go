@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// The RFC 6238 SHA-1 vectors, cut to 6 digits
+	for _, test := range []struct {
+		unixTime int64
+		code     string
+	}{
+		{unixTime: 59, code: "287082"},
+		{unixTime: 1111111109, code: "081804"},
+		{unixTime: 1111111111, code: "050471"},
+		{unixTime: 1234567890, code: "005924"},
+		{unixTime: 2000000000, code: "279037"},
+		{unixTime: 20000000000, code: "353130"},
+	} {
+		for _, secret := range []string{rfcSecret, strings.ToLower(rfcSecret)} {
+			code, err := Code(secret, Step(time.Unix(test.unixTime, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != test.code {
+				t.Errorf("code at %d = %s, want %s", test.unixTime, code, test.code)
+			}
+		}
+	}
+
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("code of an invalid secret, want an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// The code of time 59, step 1
+	code := "287082"
+	for _, test := range []struct {
+		unixTime int64
+		valid    bool
+	}{
+		{unixTime: 59, valid: true},
+		{unixTime: 30, valid: true},
+		{unixTime: 0, valid: true},
+		{unixTime: 89, valid: true},
+		{unixTime: 90, valid: false},
+		{unixTime: 60 + 2*Period, valid: false},
+	} {
+		step, valid := Validate(rfcSecret, code, time.Unix(test.unixTime, 0))
+		if valid != test.valid {
+			t.Errorf("valid at %d = %t, want %t", test.unixTime, valid, test.valid)
+			continue
+		}
+		if valid && step != 1 {
+			t.Errorf("step at %d = %d, want 1", test.unixTime, step)
+		}
+	}
+
+	for _, code := range []string{"287083", "28708", "2870820", ""} {
+		if _, valid := Validate(rfcSecret, code, time.Unix(59, 0)); valid {
+			t.Errorf("code %q is valid, want it refused", code)
+		}
+	}
+	if _, valid := Validate(rfcSecret, " 287082 ", time.Unix(59, 0)); !valid {
+		t.Error("code with spaces around is refused, want it valid")
+	}
+}
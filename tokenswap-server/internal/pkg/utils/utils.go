@@ -3,9 +3,11 @@ package utils
 import (
 	"crypto/rand"
 	"math/big"
-	"regexp"
-	"strconv"
 	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
 )
 
 const (
@@ -25,31 +27,15 @@ func GenerateID() (string, error) {
 	return string(id), nil
 }
 
-func ValidateDecimal1or2Places(value float64) bool {
-	numDecPlaces := func(v float64) int {
-		s := strconv.FormatFloat(v, 'f', -1, 64)
-		i := strings.IndexByte(s, '.')
-		if i > -1 {
-			return len(s) - i - 1
-		}
-		return 0
-	}
-
-	decimalPlaces := numDecPlaces(value)
-	if value == float64(int(value)) {
-		return true
-	} else {
-		return decimalPlaces == 1 || decimalPlaces == 2
-	}
+func ValidateDecimal1or2Places(value decimal.Amount) bool {
+	return value.DecimalPlaces() <= 2
 }
 
-func ValidateTokenAddress(token, address string) bool {
-	var re *regexp.Regexp
-	if token == "XEL" {
-		re = regexp.MustCompile(`^xet:[a-z0-9]{59}$`)
-	}
-	if token == "USDC" || token == "USDT" {
-		re = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+// NormalizeWalletAddress returns EVM addresses in their checksummed form so they
+// match the addresses reported by the chain watchers. Other addresses are returned as is.
+func NormalizeWalletAddress(address string) string {
+	if strings.HasPrefix(address, "0x") && common.IsHexAddress(address) {
+		return common.HexToAddress(address).Hex()
 	}
-	return re.MatchString(address)
+	return address
 }
@@ -0,0 +1,333 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	erc20DecimalsABI  = `[{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"}]`
+	erc20BalanceOfABI = `[{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"type":"function"}]`
+
+	erc20MaxBlockRange = 1000
+)
+
+var (
+	transferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+)
+
+func init() {
+	Register(registry.StandardERC20, NewERC20Watcher)
+}
+
+// ERC20Backend is the part of an EVM client the ERC20Watcher needs. Both
+// ethclient.Client and the client of go-ethereum's simulated backend satisfy it.
+type ERC20Backend interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// ERC20Watcher follows the Transfer event logs of a token contract sent to the
+// service wallet.
+type ERC20Watcher struct {
+	cfg       *Config
+	backend   ERC20Backend
+	contract  common.Address
+	target    common.Address
+	decimals  int
+	lastBlock uint64
+	deposits  chan *Deposit
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewERC20Watcher connects to cfg.RPC and builds a watcher for cfg.Contract.
+func NewERC20Watcher(ctx context.Context, cfg *Config) (ChainWatcher, error) {
+	client, err := ethclient.Dial(cfg.RPC)
+	if err != nil {
+		return nil, err
+	}
+	return NewERC20WatcherWithBackend(ctx, cfg, client)
+}
+
+// NewERC20WatcherWithBackend builds a watcher on an existing backend. The token
+// decimals are read from the contract when cfg.Decimals is not set. Scanning
+// starts after the last confirmed block unless a saved cursor is found on
+// Start, so the blocks still waiting for their confirmations are scanned.
+func NewERC20WatcherWithBackend(ctx context.Context, cfg *Config, backend ERC20Backend) (*ERC20Watcher, error) {
+	if !common.IsHexAddress(cfg.Contract) {
+		return nil, fmt.Errorf("invalid %s contract address: %s", cfg.Token, cfg.Contract)
+	}
+	if !common.IsHexAddress(cfg.TargetAddress) {
+		return nil, fmt.Errorf("invalid %s wallet address: %s", cfg.Token, cfg.TargetAddress)
+	}
+	w := &ERC20Watcher{
+		cfg:      cfg,
+		backend:  backend,
+		contract: common.HexToAddress(cfg.Contract),
+		target:   common.HexToAddress(cfg.TargetAddress),
+		decimals: cfg.Decimals,
+		deposits: make(chan *Deposit),
+		done:     make(chan struct{}),
+	}
+	if w.decimals == 0 {
+		decimals, err := w.readDecimals(ctx)
+		if err != nil {
+			return nil, err
+		}
+		w.decimals = decimals
+	}
+	head, err := backend.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.lastBlock = confirmedHeight(head, cfg.Confirmations)
+	return w, nil
+}
+
+func (w *ERC20Watcher) readDecimals(ctx context.Context) (int, error) {
+	contractABI, err := abi.JSON(strings.NewReader(erc20DecimalsABI))
+	if err != nil {
+		return 0, err
+	}
+	input, err := contractABI.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+	output, err := w.backend.CallContract(ctx, ethereum.CallMsg{To: &w.contract, Data: input}, nil)
+	if err != nil {
+		return 0, err
+	}
+	values, err := contractABI.Unpack("decimals", output)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != 1 {
+		return 0, fmt.Errorf("unexpected %s decimals output", w.cfg.Token)
+	}
+	decimals, ok := values[0].(uint8)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s decimals type %T", w.cfg.Token, values[0])
+	}
+	return int(decimals), nil
+}
+
+func (w *ERC20Watcher) Start(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		return ErrAlreadyStarted
+	}
+	err := w.resume(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	go w.run(ctx)
+	return nil
+}
+
+// resume restores the saved cursor, or saves the block the watcher starts
+// after as the first one.
+func (w *ERC20Watcher) resume(ctx context.Context) error {
+	cursor, err := loadCursor(ctx, w.cfg)
+	if err != nil {
+		return err
+	}
+	if cursor != nil {
+		w.lastBlock = cursor.Height
+		return nil
+	}
+	return saveCursor(ctx, w.cfg, &database.ChainCursor{ID: cursorID(w.cfg), Height: w.lastBlock, TxHashes: []string{}})
+}
+
+func (w *ERC20Watcher) run(ctx context.Context) {
+	defer close(w.done)
+	defer close(w.deposits)
+	ticker := time.NewTicker(depositCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := w.Poll(ctx)
+			if err != nil {
+				log.Errorf("error while finding the %s transfer logs: %s", w.cfg.Token, err.Error())
+			}
+		case <-ctx.Done():
+			log.Printf("Stopping %s deposit checking.", w.cfg.Token)
+			return
+		}
+	}
+}
+
+// Poll sends the deposits of the confirmed blocks after the last processed
+// block, and moves past a block range once its deposits are stored. It is
+// called periodically once the watcher is started and can be called directly
+// with a backend that mines blocks on demand.
+func (w *ERC20Watcher) Poll(ctx context.Context) error {
+	head, err := w.backend.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	lastConfirmedBlock := confirmedHeight(head, w.cfg.Confirmations)
+	for w.lastBlock < lastConfirmedBlock {
+		fromBlock := w.lastBlock + 1
+		toBlock := lastConfirmedBlock
+		if toBlock-fromBlock+1 > erc20MaxBlockRange {
+			toBlock = fromBlock + erc20MaxBlockRange - 1
+		}
+		logs, err := w.backend.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(fromBlock),
+			ToBlock:   new(big.Int).SetUint64(toBlock),
+			Addresses: []common.Address{w.contract},
+			Topics:    [][]common.Hash{{transferEventTopic}, nil, {common.BytesToHash(w.target.Bytes())}},
+		})
+		if err != nil {
+			return err
+		}
+		for _, transferLog := range logs {
+			deposit, ok := w.toDeposit(transferLog, head)
+			if !ok {
+				continue
+			}
+			err = send(ctx, w.deposits, deposit)
+			if err != nil {
+				return err
+			}
+		}
+		w.lastBlock = toBlock
+		err = saveCursor(ctx, w.cfg, &database.ChainCursor{ID: cursorID(w.cfg), Height: toBlock, TxHashes: []string{}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ERC20Watcher) toDeposit(transferLog types.Log, head uint64) (*Deposit, bool) {
+	if transferLog.Removed || len(transferLog.Topics) != 3 || len(transferLog.Data) == 0 {
+		return nil, false
+	}
+	return &Deposit{
+		Token:         w.cfg.Token,
+		Chain:         w.cfg.Chain,
+		Network:       w.cfg.Network,
+		From:          common.BytesToAddress(transferLog.Topics[1].Bytes()).Hex(),
+		Amount:        decimal.FromUnits(new(big.Int).SetBytes(transferLog.Data), w.decimals),
+		TxHash:        transferLog.TxHash.Hex(),
+		Index:         transferLog.Index,
+		Confirmations: head - transferLog.BlockNumber + 1,
+	}, true
+}
+
+// Balance returns the token balance of the service wallet.
+func (w *ERC20Watcher) Balance(ctx context.Context) (decimal.Amount, error) {
+	contractABI, err := abi.JSON(strings.NewReader(erc20BalanceOfABI))
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	input, err := contractABI.Pack("balanceOf", w.target)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	output, err := w.backend.CallContract(ctx, ethereum.CallMsg{To: &w.contract, Data: input}, nil)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	values, err := contractABI.Unpack("balanceOf", output)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	if len(values) != 1 {
+		return decimal.Zero(), fmt.Errorf("unexpected %s balance output", w.cfg.Token)
+	}
+	balance, ok := values[0].(*big.Int)
+	if !ok {
+		return decimal.Zero(), fmt.Errorf("unexpected %s balance type %T", w.cfg.Token, values[0])
+	}
+	return decimal.FromUnits(balance, w.decimals), nil
+}
+
+// RecentTransfers returns the token transfers received and sent by the service
+// wallet in the last depth confirmed blocks.
+func (w *ERC20Watcher) RecentTransfers(ctx context.Context, depth uint64) ([]*Transfer, error) {
+	head, err := w.backend.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lastConfirmedBlock := confirmedHeight(head, w.cfg.Confirmations)
+	targetTopic := common.BytesToHash(w.target.Bytes())
+	transfers := []*Transfer{}
+	for fromBlock := recentHeight(lastConfirmedBlock, depth); fromBlock <= lastConfirmedBlock; fromBlock += erc20MaxBlockRange {
+		toBlock := lastConfirmedBlock
+		if toBlock-fromBlock+1 > erc20MaxBlockRange {
+			toBlock = fromBlock + erc20MaxBlockRange - 1
+		}
+		// Incoming transfers have the wallet as third topic, outgoing ones as second
+		for _, topics := range [][][]common.Hash{{{transferEventTopic}, nil, {targetTopic}}, {{transferEventTopic}, {targetTopic}}} {
+			logs, err := w.backend.FilterLogs(ctx, ethereum.FilterQuery{
+				FromBlock: new(big.Int).SetUint64(fromBlock),
+				ToBlock:   new(big.Int).SetUint64(toBlock),
+				Addresses: []common.Address{w.contract},
+				Topics:    topics,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, transferLog := range logs {
+				if transferLog.Removed || len(transferLog.Topics) != 3 || len(transferLog.Data) == 0 {
+					continue
+				}
+				to := common.BytesToAddress(transferLog.Topics[2].Bytes())
+				transfers = append(transfers, &Transfer{
+					Token:    w.cfg.Token,
+					Chain:    w.cfg.Chain,
+					Network:  w.cfg.Network,
+					From:     common.BytesToAddress(transferLog.Topics[1].Bytes()).Hex(),
+					To:       to.Hex(),
+					Amount:   decimal.FromUnits(new(big.Int).SetBytes(transferLog.Data), w.decimals),
+					TxHash:   transferLog.TxHash.Hex(),
+					Index:    transferLog.Index,
+					Outgoing: to != w.target,
+				})
+			}
+		}
+	}
+	return transfers, nil
+}
+
+// LastBlock returns the last block whose logs have been processed.
+func (w *ERC20Watcher) LastBlock() uint64 {
+	return w.lastBlock
+}
+
+func (w *ERC20Watcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+func (w *ERC20Watcher) Deposits() <-chan *Deposit {
+	return w.deposits
+}
@@ -0,0 +1,238 @@
+package watcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+)
+
+// transferEmitterCode returns the creation code of a contract which stands in
+// for an ERC-20 token: every call emits a Transfer event from the caller, to
+// the address and of the amount in the call data, 32 bytes each.
+func transferEmitterCode() []byte {
+	runtime := []byte{
+		0x60, 0x20, 0x60, 0x20, 0x60, 0x00, 0x37, // CALLDATACOPY the amount to memory
+		0x60, 0x00, 0x35, // CALLDATALOAD the recipient topic
+		0x33, // CALLER is the sender topic
+		0x7f, // PUSH32 the Transfer topic
+	}
+	runtime = append(runtime, transferEventTopic.Bytes()...)
+	runtime = append(runtime, 0x60, 0x20, 0x60, 0x00, 0xa3, 0x00) // LOG3 the amount, STOP
+	// CODECOPY the runtime code after these 12 bytes and RETURN it
+	creation := []byte{0x60, byte(len(runtime)), 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, byte(len(runtime)), 0x60, 0x00, 0xf3}
+	return append(creation, runtime...)
+}
+
+// simulatedChain is a simulated backend with the transfer emitter deployed.
+type simulatedChain struct {
+	backend  *simulated.Backend
+	client   simulated.Client
+	key      *ecdsa.PrivateKey
+	from     common.Address
+	chainID  *big.Int
+	contract common.Address
+}
+
+func newSimulatedChain(t *testing.T) *simulatedChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	backend := simulated.NewBackend(types.GenesisAlloc{from: {Balance: big.NewInt(1e18)}})
+	t.Cleanup(func() { backend.Close() })
+	chain := &simulatedChain{
+		backend: backend,
+		client:  backend.Client(),
+		key:     key,
+		from:    from,
+	}
+	chain.chainID, err = chain.client.ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.send(t, nil, transferEmitterCode())
+	chain.contract = crypto.CreateAddress(from, 0)
+	return chain
+}
+
+// send signs and mines a transaction of the chain account.
+func (c *simulatedChain) send(t *testing.T, to *common.Address, data []byte) {
+	t.Helper()
+	ctx := context.Background()
+	nonce, err := c.client.PendingNonceAt(ctx, c.from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasPrice, err := c.client.SuggestGasPrice(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Gas:      300000,
+		GasPrice: gasPrice,
+		Data:     data,
+	}), types.LatestSignerForChainID(c.chainID), c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.client.SendTransaction(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.backend.Commit()
+}
+
+// transfer mines a Transfer of the token units to the address.
+func (c *simulatedChain) transfer(t *testing.T, to common.Address, units int64) {
+	t.Helper()
+	data := append(common.LeftPadBytes(to.Bytes(), 32), common.LeftPadBytes(big.NewInt(units).Bytes(), 32)...)
+	c.send(t, &c.contract, data)
+}
+
+func (c *simulatedChain) head(t *testing.T) uint64 {
+	t.Helper()
+	head, err := c.client.BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return head
+}
+
+func newTestERC20Watcher(t *testing.T, chain *simulatedChain, target common.Address, confirmations uint64, cursors database.ChainCursorStore) *ERC20Watcher {
+	t.Helper()
+	w, err := NewERC20WatcherWithBackend(context.Background(), &Config{
+		Token:         "USDT",
+		Chain:         "ethereum",
+		Network:       "testnet",
+		TargetAddress: target.Hex(),
+		Contract:      chain.contract.Hex(),
+		Decimals:      6,
+		Confirmations: confirmations,
+		Cursors:       cursors,
+	}, chain.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.resume(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// pollERC20 polls the watcher once and returns the deposits it sent, reported
+// stored.
+func pollERC20(t *testing.T, w *ERC20Watcher) []*Deposit {
+	t.Helper()
+	deposits := []*Deposit{}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case deposit := <-w.deposits:
+				deposits = append(deposits, deposit)
+				deposit.Stored(nil)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err := w.Poll(context.Background())
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("poll: %s", err)
+	}
+	return deposits
+}
+
+func TestERC20WatcherConfirmations(t *testing.T) {
+	chain := newSimulatedChain(t)
+	target := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	other := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	// Mined before the watcher starts, without its confirmations yet
+	chain.transfer(t, target, 1500000)
+	chain.transfer(t, other, 2000000)
+	head := chain.head(t)
+
+	w := newTestERC20Watcher(t, chain, target, 3, database.NewMemoryStore().ChainCursors)
+	if w.LastBlock() != confirmedHeight(head, 3) {
+		t.Fatalf("last block = %d, want the last confirmed block %d", w.LastBlock(), confirmedHeight(head, 3))
+	}
+	deposits := pollERC20(t, w)
+	if len(deposits) != 0 {
+		t.Fatalf("%d deposits sent before their confirmations", len(deposits))
+	}
+	chain.backend.Commit()
+	deposits = pollERC20(t, w)
+	if len(deposits) != 1 {
+		t.Fatalf("%d deposits, want the transfer to the wallet", len(deposits))
+	}
+	deposit := deposits[0]
+	if deposit.From != chain.from.Hex() {
+		t.Errorf("from = %s, want %s", deposit.From, chain.from.Hex())
+	}
+	if deposit.Amount.String() != "1.5" {
+		t.Errorf("amount = %s, want 1.5", deposit.Amount)
+	}
+	if deposit.Confirmations != 3 {
+		t.Errorf("confirmations = %d, want 3", deposit.Confirmations)
+	}
+	if deposit.Token != "USDT" || deposit.Network != "testnet" || len(deposit.TxHash) == 0 {
+		t.Errorf("deposit = %+v", deposit)
+	}
+	// The transfer to the other address is in the next block
+	chain.backend.Commit()
+	deposits = pollERC20(t, w)
+	if len(deposits) != 0 {
+		t.Fatalf("%d deposits of a transfer to another address", len(deposits))
+	}
+}
+
+func TestERC20WatcherRestart(t *testing.T) {
+	chain := newSimulatedChain(t)
+	target := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	cursors := database.NewMemoryStore().ChainCursors
+
+	w := newTestERC20Watcher(t, chain, target, 1, cursors)
+	cursor, err := cursors.Get(context.Background(), cursorID(w.cfg))
+	if err != nil {
+		t.Fatalf("the first cursor is not saved: %s", err)
+	}
+	if cursor.Height != chain.head(t) {
+		t.Fatalf("first cursor = %d, want the head %d", cursor.Height, chain.head(t))
+	}
+	chain.transfer(t, target, 1000000)
+	deposits := pollERC20(t, w)
+	if len(deposits) != 1 {
+		t.Fatalf("%d deposits, want 1", len(deposits))
+	}
+	firstTxHash := deposits[0].TxHash
+
+	// Sent while the watcher is down
+	chain.transfer(t, target, 2000000)
+	chain.transfer(t, target, 3000000)
+	restarted := newTestERC20Watcher(t, chain, target, 1, cursors)
+	deposits = pollERC20(t, restarted)
+	if len(deposits) != 2 {
+		t.Fatalf("%d deposits after the restart, want 2", len(deposits))
+	}
+	for i, amount := range []string{"2", "3"} {
+		if deposits[i].Amount.String() != amount || deposits[i].TxHash == firstTxHash {
+			t.Errorf("deposit %d = %s %s, want %s", i, deposits[i].TxHash, deposits[i].Amount, amount)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeWatcher replays scripted deposits. It is meant for tests and local demos
+// where no chain node is available.
+type FakeWatcher struct {
+	mu       sync.Mutex
+	script   []*Deposit
+	deposits chan *Deposit
+	pushes   chan *Deposit
+	started  bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewFakeWatcher returns a watcher sending the given deposits in order once started.
+func NewFakeWatcher(script ...*Deposit) *FakeWatcher {
+	return &FakeWatcher{
+		script:   script,
+		deposits: make(chan *Deposit),
+		pushes:   make(chan *Deposit),
+		done:     make(chan struct{}),
+	}
+}
+
+func (w *FakeWatcher) Start(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return ErrAlreadyStarted
+	}
+	w.started = true
+	ctx, w.cancel = context.WithCancel(ctx)
+	go w.run(ctx)
+	return nil
+}
+
+func (w *FakeWatcher) run(ctx context.Context) {
+	defer close(w.done)
+	defer close(w.deposits)
+	for _, deposit := range w.script {
+		select {
+		case w.deposits <- deposit:
+		case <-ctx.Done():
+			return
+		}
+	}
+	for {
+		select {
+		case deposit := <-w.pushes:
+			select {
+			case w.deposits <- deposit:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Push sends another deposit after the scripted ones. It must be called after
+// Start and blocks until the deposit is picked up or the watcher is stopped.
+func (w *FakeWatcher) Push(deposit *Deposit) {
+	select {
+	case w.pushes <- deposit:
+	case <-w.done:
+	}
+}
+
+func (w *FakeWatcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+func (w *FakeWatcher) Deposits() <-chan *Deposit {
+	return w.deposits
+}
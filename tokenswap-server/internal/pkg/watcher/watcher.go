@@ -0,0 +1,244 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+)
+
+var (
+	ErrNoFactory      = errors.New("no chain watcher registered for the token standard")
+	ErrAlreadyStarted = errors.New("the chain watcher has already started")
+)
+
+// Deposit is an incoming transfer to a service wallet, normalized across chains.
+// Amount is in whole token units. Index tells apart the transfers of one
+// transaction. Reference is the order reference carried by the transfer, if
+// the chain supports one.
+type Deposit struct {
+	Token         string
+	Chain         string
+	Network       string
+	From          string
+	Amount        decimal.Amount
+	TxHash        string
+	Index         uint
+	Reference     string
+	Confirmations uint64
+	// stored is where the consumer reports whether the deposit is stored, so
+	// the watcher moves its cursor only past stored deposits.
+	stored chan error
+}
+
+// Stored reports to the watcher that sent the deposit whether it was stored.
+// A watcher does not move its cursor past a deposit until it is reported
+// stored, and scans it again after an error. Only the first report counts.
+func (d *Deposit) Stored(err error) {
+	if d.stored == nil {
+		return
+	}
+	select {
+	case d.stored <- err:
+	default:
+	}
+}
+
+// ChainWatcher watches the service wallet of one token for deposits.
+type ChainWatcher interface {
+	// Start begins watching. Deposits are sent on the Deposits channel until
+	// Stop is called or ctx is done. The consumer reports every deposit it
+	// receives with Deposit.Stored.
+	Start(ctx context.Context) error
+	Stop()
+	Deposits() <-chan *Deposit
+}
+
+// Transfer is a confirmed transfer in or out of a service wallet. Index tells
+// apart the transfers of one transaction, as for a Deposit.
+type Transfer struct {
+	Token    string
+	Chain    string
+	Network  string
+	From     string
+	To       string
+	Amount   decimal.Amount
+	TxHash   string
+	Index    uint
+	Outgoing bool
+}
+
+// WalletReader reads back the service wallet a chain watcher follows. It is
+// implemented by the watchers which can, to reconcile the wallets with what
+// the service recorded.
+type WalletReader interface {
+	// Balance returns the balance of the token in the service wallet.
+	Balance(ctx context.Context) (decimal.Amount, error)
+	// RecentTransfers returns the confirmed transfers of the token in and out
+	// of the service wallet in the last depth blocks.
+	RecentTransfers(ctx context.Context, depth uint64) ([]*Transfer, error)
+}
+
+// Config is what a Factory needs to build a watcher for one token. RPC,
+// Username and Password are the node or wallet endpoint of the chain. Contract
+// is only used by token contract watchers. Deposits are sent once they have
+// Confirmations blocks. Without Cursors the scan position is lost on restart.
+type Config struct {
+	Token         string
+	Chain         string
+	Network       string
+	Standard      string
+	TargetAddress string
+	RPC           string
+	Username      string
+	Password      string
+	Contract      string
+	Decimals      int
+	Confirmations uint64
+	Cursors       database.ChainCursorStore
+}
+
+// Factory builds the chain watcher of a token standard.
+type Factory func(ctx context.Context, cfg *Config) (ChainWatcher, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a watcher factory available for a token standard. It is meant
+// to be called from the init function of the file implementing the watcher.
+func Register(standard string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, exists := factories[standard]; exists {
+		panic(fmt.Sprintf("chain watcher for %s is already registered", standard))
+	}
+	factories[standard] = factory
+}
+
+// Registered returns the token standards which have a watcher factory, sorted by name.
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	standards := make([]string, 0, len(factories))
+	for standard := range factories {
+		standards = append(standards, standard)
+	}
+	sort.Strings(standards)
+	return standards
+}
+
+// New builds the chain watcher registered for cfg.Standard.
+func New(ctx context.Context, cfg *Config) (ChainWatcher, error) {
+	factoriesMu.RLock()
+	factory, exists := factories[cfg.Standard]
+	factoriesMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s of %s", ErrNoFactory, cfg.Standard, cfg.Token)
+	}
+	return factory(ctx, cfg)
+}
+
+// Merge fans the deposits of every watcher into one channel. The returned
+// channel is closed once all watcher channels are closed or ctx is done.
+func Merge(ctx context.Context, watchers ...ChainWatcher) <-chan *Deposit {
+	merged := make(chan *Deposit)
+	wg := sync.WaitGroup{}
+	for _, chainWatcher := range watchers {
+		wg.Add(1)
+		go func(deposits <-chan *Deposit) {
+			defer wg.Done()
+			for {
+				select {
+				case deposit, ok := <-deposits:
+					if !ok {
+						return
+					}
+					select {
+					case merged <- deposit:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(chainWatcher.Deposits())
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged
+}
+
+// send hands the deposit to the consumer and waits until it is reported
+// stored.
+func send(ctx context.Context, deposits chan<- *Deposit, deposit *Deposit) error {
+	deposit.stored = make(chan error, 1)
+	select {
+	case deposits <- deposit:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-deposit.stored:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// confirmedHeight returns the last height whose transfers have the required
+// confirmations when the chain is at head.
+func confirmedHeight(head, confirmations uint64) uint64 {
+	if confirmations <= 1 {
+		return head
+	}
+	if head < confirmations-1 {
+		return 0
+	}
+	return head - (confirmations - 1)
+}
+
+// recentHeight returns the first height of the last depth heights up to height.
+func recentHeight(height, depth uint64) uint64 {
+	if height < depth {
+		return 0
+	}
+	return height - depth + 1
+}
+
+func cursorID(cfg *Config) string {
+	return fmt.Sprintf("%s:%s:%s", cfg.Token, cfg.Chain, cfg.Network)
+}
+
+// loadCursor returns the saved scan position of the watcher, or nil when it
+// has never saved one.
+func loadCursor(ctx context.Context, cfg *Config) (*database.ChainCursor, error) {
+	if cfg.Cursors == nil {
+		return nil, nil
+	}
+	cursor, err := cfg.Cursors.Get(ctx, cursorID(cfg))
+	if err != nil {
+		if err == database.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func saveCursor(ctx context.Context, cfg *Config, cursor *database.ChainCursor) error {
+	if cfg.Cursors == nil {
+		return nil
+	}
+	cursor.UpdateDateTime = time.Now().Format(database.TimeFormat)
+	return cfg.Cursors.Save(ctx, cursor)
+}
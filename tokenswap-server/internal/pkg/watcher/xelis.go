@@ -0,0 +1,300 @@
+package watcher
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/decimal"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/reference"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/registry"
+	log "github.com/sirupsen/logrus"
+	"github.com/xelis-project/xelis-go-sdk/wallet"
+)
+
+const (
+	xelisAsset    = "0000000000000000000000000000000000000000000000000000000000000000"
+	xelisDecimals = 8
+
+	depositCheckTermSeconds = 10
+)
+
+func init() {
+	Register(registry.StandardXelis, NewXelisWatcher)
+}
+
+// XelisWallet is the part of the Xelis wallet RPC the XelisWatcher needs.
+type XelisWallet interface {
+	GetTopoheight() (uint64, error)
+	ListTransactions(params wallet.ListTransactionsParams) ([]wallet.TransactionEntry, error)
+	GetBalance(params wallet.GetBalanceParams) (uint64, error)
+}
+
+// XelisWatcher polls the Xelis wallet RPC for incoming XEL transfers.
+type XelisWatcher struct {
+	cfg      *Config
+	wallet   XelisWallet
+	decimals int
+	deposits chan *Deposit
+	cursor   *database.ChainCursor
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewXelisWatcher(ctx context.Context, cfg *Config) (ChainWatcher, error) {
+	xelisWallet, err := wallet.NewRPC(ctx, cfg.RPC, cfg.Username, cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+	return NewXelisWatcherWithWallet(cfg, xelisWallet), nil
+}
+
+// NewXelisWatcherWithWallet builds a watcher on an existing wallet client.
+// Scanning starts at the current confirmed topoheight unless a saved cursor is
+// found on Start.
+func NewXelisWatcherWithWallet(cfg *Config, xelisWallet XelisWallet) *XelisWatcher {
+	decimals := cfg.Decimals
+	if decimals == 0 {
+		decimals = xelisDecimals
+	}
+	return &XelisWatcher{
+		cfg:      cfg,
+		wallet:   xelisWallet,
+		decimals: decimals,
+		deposits: make(chan *Deposit),
+		done:     make(chan struct{}),
+	}
+}
+
+func (w *XelisWatcher) Start(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		return ErrAlreadyStarted
+	}
+	err := w.resume(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	go w.run(ctx)
+	return nil
+}
+
+// resume restores the saved cursor. A watcher which never saved one starts at
+// the current confirmed topoheight and saves it, so the transfers the wallet
+// received before are not credited or refunded again.
+func (w *XelisWatcher) resume(ctx context.Context) error {
+	cursor, err := loadCursor(ctx, w.cfg)
+	if err != nil {
+		return err
+	}
+	if cursor != nil {
+		w.cursor = cursor
+		return nil
+	}
+	topoheight, err := w.wallet.GetTopoheight()
+	if err != nil {
+		return err
+	}
+	maxTopoheight := confirmedHeight(topoheight, w.cfg.Confirmations)
+	txs, err := w.wallet.ListTransactions(wallet.ListTransactionsParams{
+		MinTopoheight:  &maxTopoheight,
+		MaxTopoheight:  &maxTopoheight,
+		AcceptOutgoing: false,
+		AcceptIncoming: true,
+		AcceptCoinbase: false,
+		AcceptBurn:     false,
+	})
+	if err != nil {
+		return err
+	}
+	// The transactions at the topoheight are skipped like those at a saved cursor
+	cursor = &database.ChainCursor{ID: cursorID(w.cfg), Height: maxTopoheight, TxHashes: []string{}}
+	for _, tx := range txs {
+		if tx.Topoheight == maxTopoheight {
+			cursor.TxHashes = append(cursor.TxHashes, tx.Hash)
+		}
+	}
+	err = saveCursor(ctx, w.cfg, cursor)
+	if err != nil {
+		return err
+	}
+	w.cursor = cursor
+	return nil
+}
+
+func (w *XelisWatcher) run(ctx context.Context) {
+	defer close(w.done)
+	defer close(w.deposits)
+	ticker := time.NewTicker(depositCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := w.poll(ctx)
+			if err != nil {
+				log.Errorf("error while finding the %s wallet transactions: %s", w.cfg.Token, err.Error())
+			}
+		case <-ctx.Done():
+			log.Printf("Stopping %s deposit checking.", w.cfg.Token)
+			return
+		}
+	}
+}
+
+// poll sends the incoming transfers since the cursor and moves the cursor to
+// the last confirmed topoheight once they are all stored. Transactions at the cursor topoheight are listed
+// again and skipped when the cursor already holds their hash.
+func (w *XelisWatcher) poll(ctx context.Context) error {
+	topoheight, err := w.wallet.GetTopoheight()
+	if err != nil {
+		return err
+	}
+	// Transactions are only listed once they have the required confirmations
+	maxTopoheight := confirmedHeight(topoheight, w.cfg.Confirmations)
+	if w.cursor != nil && maxTopoheight < w.cursor.Height {
+		return nil
+	}
+	params := wallet.ListTransactionsParams{
+		MaxTopoheight:  &maxTopoheight,
+		AcceptOutgoing: false,
+		AcceptIncoming: true,
+		AcceptCoinbase: false,
+		AcceptBurn:     false,
+	}
+	processed := map[string]struct{}{}
+	if w.cursor != nil {
+		params.MinTopoheight = &w.cursor.Height
+		for _, txHash := range w.cursor.TxHashes {
+			processed[txHash] = struct{}{}
+		}
+	}
+	txs, err := w.wallet.ListTransactions(params)
+	if err != nil {
+		return err
+	}
+	nextCursor := &database.ChainCursor{ID: cursorID(w.cfg), Height: maxTopoheight, TxHashes: []string{}}
+	for _, tx := range txs {
+		if tx.Topoheight == maxTopoheight {
+			nextCursor.TxHashes = append(nextCursor.TxHashes, tx.Hash)
+		}
+		if _, exists := processed[tx.Hash]; exists && tx.Topoheight == w.cursor.Height {
+			continue
+		}
+		if tx.Incoming == nil {
+			continue
+		}
+		confirmations := uint64(0)
+		if topoheight >= tx.Topoheight {
+			confirmations = topoheight - tx.Topoheight + 1
+		}
+		for idx, transfer := range tx.Incoming.Transfers {
+			if transfer.Asset != xelisAsset {
+				continue
+			}
+			deposit := &Deposit{
+				Token:         w.cfg.Token,
+				Chain:         w.cfg.Chain,
+				Network:       w.cfg.Network,
+				From:          tx.Incoming.From,
+				Amount:        decimal.FromUnits(new(big.Int).SetUint64(transfer.Amount), w.decimals),
+				TxHash:        tx.Hash,
+				Index:         uint(idx),
+				Reference:     reference.FromExtraData(transfer.ExtraData),
+				Confirmations: confirmations,
+			}
+			err = send(ctx, w.deposits, deposit)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	w.cursor = nextCursor
+	return saveCursor(ctx, w.cfg, nextCursor)
+}
+
+// Balance returns the XEL balance of the service wallet.
+func (w *XelisWatcher) Balance(ctx context.Context) (decimal.Amount, error) {
+	balance, err := w.wallet.GetBalance(wallet.GetBalanceParams{Asset: xelisAsset})
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	return decimal.FromUnits(new(big.Int).SetUint64(balance), w.decimals), nil
+}
+
+// RecentTransfers returns the XEL transfers received and sent by the service
+// wallet in the last depth confirmed topoheights.
+func (w *XelisWatcher) RecentTransfers(ctx context.Context, depth uint64) ([]*Transfer, error) {
+	topoheight, err := w.wallet.GetTopoheight()
+	if err != nil {
+		return nil, err
+	}
+	maxTopoheight := confirmedHeight(topoheight, w.cfg.Confirmations)
+	minTopoheight := recentHeight(maxTopoheight, depth)
+	txs, err := w.wallet.ListTransactions(wallet.ListTransactionsParams{
+		MinTopoheight:  &minTopoheight,
+		MaxTopoheight:  &maxTopoheight,
+		AcceptOutgoing: true,
+		AcceptIncoming: true,
+		AcceptCoinbase: false,
+		AcceptBurn:     false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	transfers := []*Transfer{}
+	for _, tx := range txs {
+		if tx.Incoming != nil {
+			for idx, transfer := range tx.Incoming.Transfers {
+				if transfer.Asset != xelisAsset {
+					continue
+				}
+				transfers = append(transfers, w.newTransfer(tx.Hash, uint(idx), tx.Incoming.From, w.cfg.TargetAddress, transfer.Amount, false))
+			}
+		}
+		if tx.Outgoing != nil {
+			for idx, transfer := range tx.Outgoing.Transfers {
+				if transfer.Asset != xelisAsset {
+					continue
+				}
+				transfers = append(transfers, w.newTransfer(tx.Hash, uint(idx), w.cfg.TargetAddress, transfer.Destination, transfer.Amount, true))
+			}
+		}
+	}
+	return transfers, nil
+}
+
+func (w *XelisWatcher) newTransfer(txHash string, index uint, from, to string, amount uint64, outgoing bool) *Transfer {
+	return &Transfer{
+		Token:    w.cfg.Token,
+		Chain:    w.cfg.Chain,
+		Network:  w.cfg.Network,
+		From:     from,
+		To:       to,
+		Amount:   decimal.FromUnits(new(big.Int).SetUint64(amount), w.decimals),
+		TxHash:   txHash,
+		Index:    index,
+		Outgoing: outgoing,
+	}
+}
+
+func (w *XelisWatcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+func (w *XelisWatcher) Deposits() <-chan *Deposit {
+	return w.deposits
+}
@@ -0,0 +1,187 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/xelis-project/xelis-go-sdk/wallet"
+)
+
+// testXelisWallet is a wallet whose transactions and topoheight are set by the
+// test.
+type testXelisWallet struct {
+	mu         sync.Mutex
+	topoheight uint64
+	txs        []wallet.TransactionEntry
+}
+
+func (w *testXelisWallet) GetTopoheight() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.topoheight, nil
+}
+
+func (w *testXelisWallet) ListTransactions(params wallet.ListTransactionsParams) ([]wallet.TransactionEntry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	txs := []wallet.TransactionEntry{}
+	for _, tx := range w.txs {
+		if params.MinTopoheight != nil && tx.Topoheight < *params.MinTopoheight {
+			continue
+		}
+		if params.MaxTopoheight != nil && tx.Topoheight > *params.MaxTopoheight {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+func (w *testXelisWallet) GetBalance(params wallet.GetBalanceParams) (uint64, error) {
+	return 0, nil
+}
+
+// receive adds an incoming XEL transfer at the topoheight and moves the wallet
+// to it.
+func (w *testXelisWallet) receive(hash string, topoheight, amount uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.txs = append(w.txs, wallet.TransactionEntry{
+		Hash:       hash,
+		Topoheight: topoheight,
+		Incoming: &wallet.IncomingEntry{
+			From:      "xel:sender",
+			Transfers: []wallet.TransferIn{{Asset: xelisAsset, Amount: amount}},
+		},
+	})
+	if topoheight > w.topoheight {
+		w.topoheight = topoheight
+	}
+}
+
+// pollXelis polls the watcher once and returns the deposits it sent, reported
+// stored.
+func pollXelis(t *testing.T, w *XelisWatcher) []*Deposit {
+	t.Helper()
+	deposits := []*Deposit{}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case deposit := <-w.deposits:
+				deposits = append(deposits, deposit)
+				deposit.Stored(nil)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err := w.poll(context.Background())
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("poll: %s", err)
+	}
+	return deposits
+}
+
+func newTestXelisWatcher(xelisWallet *testXelisWallet, cursors database.ChainCursorStore) *XelisWatcher {
+	return NewXelisWatcherWithWallet(&Config{
+		Token:         "XEL",
+		Chain:         "xelis",
+		Network:       "testnet",
+		Confirmations: 2,
+		Cursors:       cursors,
+	}, xelisWallet)
+}
+
+func TestXelisWatcherFirstStart(t *testing.T) {
+	ctx := context.Background()
+	cursors := database.NewMemoryStore().ChainCursors
+	xelisWallet := &testXelisWallet{}
+	// Received before the watcher ever ran, the last one is not confirmed yet
+	xelisWallet.receive("old-1", 5, 100000000)
+	xelisWallet.receive("old-2", 9, 100000000)
+	xelisWallet.receive("unconfirmed", 10, 200000000)
+
+	w := newTestXelisWatcher(xelisWallet, cursors)
+	err := w.resume(ctx)
+	if err != nil {
+		t.Fatalf("resume: %s", err)
+	}
+	cursor, err := cursors.Get(ctx, cursorID(w.cfg))
+	if err != nil {
+		t.Fatalf("the first cursor is not saved: %s", err)
+	}
+	if cursor.Height != 9 || len(cursor.TxHashes) != 1 || cursor.TxHashes[0] != "old-2" {
+		t.Fatalf("first cursor = %d %v, want 9 [old-2]", cursor.Height, cursor.TxHashes)
+	}
+
+	// Only the transfers after the confirmed topoheight at start are sent
+	xelisWallet.receive("new", 11, 300000000)
+	deposits := pollXelis(t, w)
+	if len(deposits) != 1 || deposits[0].TxHash != "unconfirmed" {
+		t.Fatalf("deposits = %v, want the unconfirmed transfer only", depositHashes(deposits))
+	}
+	if deposits[0].Amount.String() != "2" || deposits[0].Confirmations != 2 {
+		t.Fatalf("deposit = %s with %d confirmations, want 2 with 2", deposits[0].Amount, deposits[0].Confirmations)
+	}
+
+	// A restarted watcher resumes from the saved cursor
+	xelisWallet.receive("newer", 12, 400000000)
+	restarted := newTestXelisWatcher(xelisWallet, cursors)
+	err = restarted.resume(ctx)
+	if err != nil {
+		t.Fatalf("resume: %s", err)
+	}
+	deposits = pollXelis(t, restarted)
+	if len(deposits) != 1 || deposits[0].TxHash != "new" {
+		t.Fatalf("deposits = %v, want the new transfer only", depositHashes(deposits))
+	}
+}
+
+func TestXelisWatcherUnstoredDeposit(t *testing.T) {
+	ctx := context.Background()
+	cursors := database.NewMemoryStore().ChainCursors
+	xelisWallet := &testXelisWallet{topoheight: 3}
+	w := newTestXelisWatcher(xelisWallet, cursors)
+	err := w.resume(ctx)
+	if err != nil {
+		t.Fatalf("resume: %s", err)
+	}
+	xelisWallet.receive("lost", 4, 100000000)
+	xelisWallet.topoheight = 5
+
+	// The consumer fails to store the deposit
+	go func() {
+		deposit := <-w.deposits
+		deposit.Stored(database.ErrNonUpdated)
+	}()
+	err = w.poll(ctx)
+	if err != database.ErrNonUpdated {
+		t.Fatalf("poll error = %v, want the store error", err)
+	}
+	cursor, err := cursors.Get(ctx, cursorID(w.cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Height != 2 {
+		t.Fatalf("cursor moved to %d past an unstored deposit", cursor.Height)
+	}
+	deposits := pollXelis(t, w)
+	if len(deposits) != 1 || deposits[0].TxHash != "lost" {
+		t.Fatalf("deposits = %v, want the unstored transfer again", depositHashes(deposits))
+	}
+}
+
+func depositHashes(deposits []*Deposit) []string {
+	hashes := []string{}
+	for _, deposit := range deposits {
+		hashes = append(hashes, deposit.TxHash)
+	}
+	return hashes
+}
@@ -0,0 +1,356 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+)
+
+var (
+	ErrPrivateAddress = errors.New("webhooks cannot be delivered to the local host or a private network")
+)
+
+const (
+	// EventTest is the event of the deliveries fired to test a webhook.
+	EventTest = "webhook.test"
+
+	EventHeader     = "X-Tokenswap-Event"
+	DeliveryHeader  = "X-Tokenswap-Delivery"
+	TimestampHeader = "X-Tokenswap-Timestamp"
+	SignatureHeader = "X-Tokenswap-Signature"
+
+	deliveryCheckTermSeconds = 10
+	maxDeliveryAttempts      = 8
+	firstRetryDelay          = 30 * time.Second
+	maxRetryDelay            = 6 * time.Hour
+	deliveryTimeout          = 10 * time.Second
+	secretLength             = 32
+	maxErrorLength           = 512
+)
+
+// Payload is the body of a delivery. Data is the order event, see events.Event.
+type Payload struct {
+	ID       string      `json:"id"`
+	Event    string      `json:"event"`
+	DateTime string      `json:"date_time"`
+	Data     interface{} `json:"data"`
+}
+
+// Dispatcher sends the deliveries of the order events to the webhooks. The
+// deliveries are recorded by QueueEvents in the transaction of the order
+// change, and the dispatcher sends them from the delivery log, so a delivery
+// which fails is retried with an exponential backoff until it succeeds or has
+// failed maxDeliveryAttempts times.
+type Dispatcher struct {
+	store  *database.Store
+	client *http.Client
+}
+
+func NewDispatcher(store *database.Store) *Dispatcher {
+	return &Dispatcher{
+		store:  store,
+		client: newClient(),
+	}
+}
+
+// Run sends the deliveries due until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(deliveryCheckTermSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.DeliverDue(ctx)
+		case <-ctx.Done():
+			log.Printf("Stopping webhook deliveries.")
+			return
+		}
+	}
+}
+
+// QueueEvents records a delivery of the events for every webhook of the users
+// they concern which is subscribed to their type. It is called in the
+// transaction of the order change, so the deliveries are recorded if and only
+// if the change is.
+func QueueEvents(ctx context.Context, store *database.Store, orderEvents ...*events.Event) error {
+	for _, event := range orderEvents {
+		users := []string{}
+		for _, user := range event.Users {
+			if len(user) > 0 && !slices.Contains(users, user) {
+				users = append(users, user)
+			}
+		}
+		for _, user := range users {
+			webhooks, err := store.Webhooks.Find(ctx, &database.WebhookFilter{UserUUID: user})
+			if err != nil {
+				return err
+			}
+			for _, webhook := range webhooks {
+				if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Type) {
+					continue
+				}
+				delivery, err := newDelivery(webhook, event.Type, event.OrderID, event)
+				if err != nil {
+					return err
+				}
+				err = store.WebhookDeliveries.Insert(ctx, delivery)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TestFire records a test delivery to the webhook and sends it right away.
+func (d *Dispatcher) TestFire(ctx context.Context, webhook *database.Webhook) (*database.WebhookDelivery, error) {
+	delivery, err := newDelivery(webhook, EventTest, "", map[string]string{
+		"webhook_id": webhook.ID,
+		"message":    "This is a test delivery of the webhook",
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = d.store.WebhookDeliveries.Insert(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+	return d.Deliver(ctx, delivery)
+}
+
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	deliveries, err := d.store.WebhookDeliveries.FindDue(ctx, time.Now())
+	if err != nil {
+		log.Errorf("error while finding the webhook deliveries due: %s", err.Error())
+		return
+	}
+	for _, delivery := range deliveries {
+		_, err := d.Deliver(ctx, delivery)
+		if err != nil {
+			if err == database.ErrNonUpdated {
+				log.Infof("webhook delivery %s is already being attempted", delivery.ID)
+				continue
+			}
+			log.Errorf("error while delivering %s to webhook %s: %s", delivery.ID, delivery.WebhookID, err.Error())
+		}
+	}
+}
+
+// Deliver attempts a pending delivery once and records the outcome. The next
+// attempt is scheduled before sending, so a delivery cut short by a restart is
+// attempted again too.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery *database.WebhookDelivery) (*database.WebhookDelivery, error) {
+	attempts := delivery.Attempts + 1
+	err := d.store.WebhookDeliveries.ClaimAttempt(ctx, delivery.ID, delivery.Attempts, time.Now().Add(retryDelay(attempts)))
+	if err != nil {
+		return nil, err
+	}
+	status, responseStatus, attemptError := database.WebhookDeliveryStatusDelivered, 0, ""
+	lastAttempt := attempts >= maxDeliveryAttempts
+	webhook, err := d.store.Webhooks.FindOne(ctx, &database.WebhookFilter{ID: delivery.WebhookID})
+	if err == nil {
+		responseStatus, err = d.send(ctx, webhook, delivery)
+	} else if err == database.ErrNoDocuments {
+		err, lastAttempt = fmt.Errorf("the webhook was deleted"), true
+	}
+	if err != nil {
+		status, attemptError = database.WebhookDeliveryStatusPending, err.Error()
+		if len(attemptError) > maxErrorLength {
+			attemptError = attemptError[:maxErrorLength]
+		}
+		if lastAttempt {
+			status = database.WebhookDeliveryStatusFailed
+		}
+	}
+	err = d.store.WebhookDeliveries.RecordAttempt(ctx, delivery.ID, attempts, status, responseStatus, attemptError)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Attempts = attempts
+	delivery.Status = status
+	delivery.ResponseStatus = responseStatus
+	delivery.Error = attemptError
+	return delivery, nil
+}
+
+// send posts the payload of the delivery to the webhook and returns the
+// status of the response. Responses other than 2xx are errors.
+func (d *Dispatcher) send(ctx context.Context, webhook *database.Webhook, delivery *database.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(webhook.Secret, timestamp, []byte(delivery.Payload)))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("the webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp and the payload
+// joined by a dot, keyed with the secret of the webhook. Receivers compute it
+// from the timestamp header and the body to check the signature header.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random secret to sign the payloads of a webhook with.
+func NewSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// ValidateURL checks that the webhook URL is an absolute http or https URL
+// which does not point at the local host or a private network. Host names are
+// checked again at every delivery, once they are resolved.
+func ValidateURL(rawURL string) error {
+	webhookURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("the webhook url is invalid: %w", err)
+	}
+	if (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || len(webhookURL.Hostname()) == 0 {
+		return fmt.Errorf("the webhook url must be an absolute http or https url")
+	}
+	host := strings.ToLower(strings.TrimSuffix(webhookURL.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// newClient returns the client the deliveries are sent with. It connects only
+// to public addresses, checked on the resolved address of every connection so
+// a host name cannot be pointed at an internal service later, and it does not
+// follow redirects, which are responses other than 2xx to the delivery.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the webhook host
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   deliveryTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// nonPublicPrefixes are the special purpose ranges besides the loopback,
+// private, link-local and multicast ones, which the netip methods cover.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isPublicAddr reports whether a webhook can be delivered to the address.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// retryDelay returns how long after the attempt the delivery is attempted
+// again, doubling from firstRetryDelay up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func newDelivery(webhook *database.Webhook, event, orderID string, data interface{}) (*database.WebhookDelivery, error) {
+	deliveryID := uuid.New().String()
+	now := time.Now()
+	payload, err := json.Marshal(&Payload{
+		ID:       deliveryID,
+		Event:    event,
+		DateTime: now.Format(database.TimeFormat),
+		Data:     data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &database.WebhookDelivery{
+		ID:               deliveryID,
+		WebhookID:        webhook.ID,
+		UserUUID:         webhook.UserUUID,
+		Event:            event,
+		OrderID:          orderID,
+		Payload:          string(payload),
+		Status:           database.WebhookDeliveryStatusPending,
+		NextAttemptTime:  &now,
+		CreationDateTime: now.Format(database.TimeFormat),
+		UpdateDateTime:   now.Format(database.TimeFormat),
+	}, nil
+}
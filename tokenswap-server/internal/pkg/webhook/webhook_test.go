@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/database"
+	"github.com/rocky2015aaa/tokenswap-server/internal/pkg/events"
+)
+
+func TestQueueEvents(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	for _, webhook := range []*database.Webhook{
+		{ID: "all", UserUUID: "maker"},
+		{ID: "cancelled", UserUUID: "maker", Events: []string{events.OrderCancelled}},
+		{ID: "taker", UserUUID: "taker"},
+		{ID: "other", UserUUID: "other"},
+	} {
+		err := store.Webhooks.Insert(ctx, webhook)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	takenEvent := events.NewOrderEvent(events.OrderTaken, &database.OrderData{ID: "fill", Order: &database.Order{Pair: "XEL/USDT"}, UserUUID: "taker"}, "maker")
+
+	// The deliveries are not recorded when the order change is rolled back
+	changeErr := errors.New("the order changed in the meantime")
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		err := QueueEvents(ctx, store, takenEvent)
+		if err != nil {
+			return err
+		}
+		return changeErr
+	})
+	if err != changeErr {
+		t.Fatalf("transaction error = %v", err)
+	}
+	deliveries, err := store.WebhookDeliveries.FindDue(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Fatalf("%d deliveries recorded for a rolled back change", len(deliveries))
+	}
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		return QueueEvents(ctx, store, takenEvent)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err = store.WebhookDeliveries.FindDue(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookIDs := map[string]bool{}
+	for _, delivery := range deliveries {
+		webhookIDs[delivery.WebhookID] = true
+		if delivery.Event != events.OrderTaken || delivery.OrderID != "fill" {
+			t.Errorf("delivery = %s of %s, want %s of fill", delivery.Event, delivery.OrderID, events.OrderTaken)
+		}
+	}
+	if len(deliveries) != 2 || !webhookIDs["all"] || !webhookIDs["taker"] {
+		t.Fatalf("deliveries to %v, want the webhooks of the maker and the taker subscribed to the event", webhookIDs)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for rawURL, want := range map[string]error{
+		"https://hooks.example.com/tokenswap": nil,
+		"http://203.0.113.10:8080/hook":       nil,
+		"http://localhost:8080/hook":          ErrPrivateAddress,
+		"http://api.localhost/hook":           ErrPrivateAddress,
+		"http://127.0.0.1/hook":               ErrPrivateAddress,
+		"http://10.0.0.5/hook":                ErrPrivateAddress,
+		"http://169.254.169.254/latest":       ErrPrivateAddress,
+		"http://100.64.0.1/hook":              ErrPrivateAddress,
+		"http://[::1]/hook":                   ErrPrivateAddress,
+		"http://[fd00::1]/hook":               ErrPrivateAddress,
+		"http://[fe80::1]/hook":               ErrPrivateAddress,
+		"http://[::ffff:127.0.0.1]/hook":      ErrPrivateAddress,
+	} {
+		err := ValidateURL(rawURL)
+		if !errors.Is(err, want) {
+			t.Errorf("ValidateURL(%s) = %v, want %v", rawURL, err, want)
+		}
+	}
+	for _, rawURL := range []string{"ftp://hooks.example.com", "/hook", "https://"} {
+		if ValidateURL(rawURL) == nil {
+			t.Errorf("ValidateURL(%s) accepted", rawURL)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// The server listens on the loopback address, as an internal service would
+	_, err := newClient().Post(server.URL, "application/json", nil)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("delivery to %s: %v, want %v", server.URL, err, ErrPrivateAddress)
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	client := newClient()
+	// Dialing is checked by the test above, this one is about the redirect
+	client.Transport = http.DefaultTransport
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the redirect was followed")
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	resp, err := client.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("status = %d, want the redirect response", resp.StatusCode)
+	}
+}